@@ -0,0 +1,298 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+)
+
+// attachmentKind はビューアでの添付ファイルの表示方法です。
+type attachmentKind string
+
+const (
+	attachmentKindImage attachmentKind = "image"
+	attachmentKindVideo attachmentKind = "video"
+	attachmentKindAudio attachmentKind = "audio"
+	attachmentKindPDF   attachmentKind = "pdf"
+	attachmentKindText  attachmentKind = "text"
+	attachmentKindFile  attachmentKind = "file"
+
+	// maxInlineTextBytes はビューアに本文を埋め込むテキスト添付ファイルの上限サイズです。
+	maxInlineTextBytes = 64 * 1024
+	slackSnippetMode   = "snippet"
+)
+
+var (
+	// 拡張子の一覧はMIMEタイプを持たない旧形式の添付ファイルの種類の判定に使います。
+	imageFileExtList       = []string{".png", ".jpg", ".jpeg", ".gif", ".webp", ".bmp"}
+	videoFileExtList       = []string{".mp4", ".mov", ".webm", ".m4v"}
+	audioFileExtList       = []string{".mp3", ".m4a", ".wav", ".ogg", ".aac", ".flac"}
+	textFileExtList        = []string{".txt", ".log", ".md", ".csv"}
+	attachmentNameUnsafeRe = regexp.MustCompile(`[\\/:*?"<>|\x00-\x1f]`)
+)
+
+// Attachment はエントリに添付されたファイルです。
+// Path は base_dir からの保存先の相対パス（blobs/ または旧形式の images/ 配下）、Name はSlack上の元のファイル名、AltText は画像の代替テキストです。
+type Attachment struct {
+	Path string `json:"path"`
+	// Hash は blobs/ に保存した添付ファイルの内容のハッシュ（SHA-256）です。images/ に保存した旧形式では空です。
+	Hash        string `json:"sha256,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Title       string `json:"title,omitempty"`
+	MimeType    string `json:"mimetype,omitempty"`
+	FileType    string `json:"filetype,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	AltText     string `json:"alt_text,omitempty"`
+	SlackFileID string `json:"slack_file_id,omitempty"`
+	// Mode はSlackのファイルのモードです（スニペットは snippet）。
+	Mode string `json:"mode,omitempty"`
+	// Width と Height は画像の元のサイズ、Variants はビューア用の縮小版です。
+	Width    int            `json:"width,omitempty"`
+	Height   int            `json:"height,omitempty"`
+	Variants []ImageVariant `json:"variants,omitempty"`
+
+	// text は出力時に設定するテキスト添付ファイルの本文です。
+	text *string
+}
+
+// UnmarshalJSON は保存先のパスだけを文字列で持つ旧形式の files も読み込みます。
+func (a *Attachment) UnmarshalJSON(b []byte) error {
+	var legacyPath string
+	if err := json.Unmarshal(b, &legacyPath); err == nil {
+		*a = Attachment{Path: legacyPath}
+		return nil
+	}
+	type attachment Attachment
+	var decoded attachment
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return fmt.Errorf("添付ファイル情報のパースに失敗: %w", err)
+	}
+	*a = Attachment(decoded)
+	return nil
+}
+
+// newAttachment はSlackのファイル情報から添付ファイルの記録を作成します。
+func newAttachment(file slack.File, relPath string) Attachment {
+	return Attachment{
+		Path:        relPath,
+		Name:        file.Name,
+		Title:       file.Title,
+		MimeType:    file.Mimetype,
+		FileType:    file.Filetype,
+		Size:        int64(file.Size),
+		SlackFileID: file.ID,
+		Mode:        file.Mode,
+	}
+}
+
+// Kind は添付ファイルの表示方法を返す。MIMEタイプがない旧形式は拡張子で判定する。
+func (a Attachment) Kind() attachmentKind {
+	if a.Mode == slackSnippetMode {
+		return attachmentKindText
+	}
+	mimeType := strings.ToLower(a.MimeType)
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return attachmentKindImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return attachmentKindVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return attachmentKindAudio
+	case mimeType == "application/pdf":
+		return attachmentKindPDF
+	case strings.HasPrefix(mimeType, "text/"):
+		return attachmentKindText
+	case mimeType != "":
+		if _, lang := lookupCodeLanguage(a.FileType, a.Name); lang != nil {
+			return attachmentKindText
+		}
+		return attachmentKindFile
+	}
+
+	ext := strings.ToLower(path.Ext(filepath.ToSlash(a.Path)))
+	switch {
+	case slices.Contains(imageFileExtList, ext):
+		return attachmentKindImage
+	case slices.Contains(videoFileExtList, ext):
+		return attachmentKindVideo
+	case slices.Contains(audioFileExtList, ext):
+		return attachmentKindAudio
+	case ext == ".pdf":
+		return attachmentKindPDF
+	case slices.Contains(textFileExtList, ext):
+		return attachmentKindText
+	}
+	if _, lang := lookupCodeLanguage(a.FileType, path.Base(filepath.ToSlash(a.Path))); lang != nil {
+		return attachmentKindText
+	}
+	return attachmentKindFile
+}
+
+// IsImage は画像として表示できる添付ファイルかを判定する。
+func (a Attachment) IsImage() bool {
+	return a.Kind() == attachmentKindImage
+}
+
+// HasInlineText はビューアに本文を埋め込めるテキスト添付ファイルかを判定する。
+func (a Attachment) HasInlineText() bool {
+	return a.text != nil
+}
+
+// CodeLanguage はテキスト添付ファイルのハイライトに使う言語名を返す。対応していない場合は空文字を返す。
+func (a Attachment) CodeLanguage() string {
+	name, _ := lookupCodeLanguage(a.FileType, firstNonEmpty(a.Name, path.Base(filepath.ToSlash(a.Path))))
+	return name
+}
+
+// InlineText はテキスト添付ファイルの本文を、対応する言語の場合は色付けしたHTMLとして返す。
+func (a Attachment) InlineText() template.HTML {
+	if a.text == nil {
+		return ""
+	}
+	_, lang := lookupCodeLanguage(a.FileType, firstNonEmpty(a.Name, path.Base(filepath.ToSlash(a.Path))))
+	return highlightCode(*a.text, lang)
+}
+
+// DisplayName は表示用のファイル名を返す。元のファイル名がない場合は保存先のファイル名を返す。
+func (a Attachment) DisplayName() string {
+	return firstNonEmpty(a.Name, a.Title, path.Base(filepath.ToSlash(a.Path)))
+}
+
+// Alt は画像の代替テキストを返す。代替テキストがない場合はタイトル、元のファイル名の順に使う。
+func (a Attachment) Alt() string {
+	return firstNonEmpty(a.AltText, a.Title, a.Name)
+}
+
+// SizeString はファイルサイズを表示用の文字列にする。サイズが不明な場合は空文字を返す。
+func (a Attachment) SizeString() string {
+	return formatFileSize(a.Size)
+}
+
+// URL は html/ からの相対パスで添付ファイルを参照するURLを返す。
+func (a Attachment) URL() string {
+	return "../" + filepath.ToSlash(a.Path)
+}
+
+// PreviewURL はビューアに表示する画像のURLを返す。縮小版がある場合は最も大きい縮小版を使う。
+func (a Attachment) PreviewURL() string {
+	if len(a.Variants) == 0 {
+		return a.URL()
+	}
+	return "../" + filepath.ToSlash(a.Variants[len(a.Variants)-1].Path)
+}
+
+// SrcSet は img の srcset 属性の値を返す。縮小版がない場合は空文字を返す。
+func (a Attachment) SrcSet() string {
+	if len(a.Variants) == 0 {
+		return ""
+	}
+	candidates := make([]string, 0, len(a.Variants)+1)
+	for _, v := range a.Variants {
+		candidates = append(candidates, fmt.Sprintf("../%s %dw", filepath.ToSlash(v.Path), v.Width))
+	}
+	if a.Width > 0 {
+		candidates = append(candidates, fmt.Sprintf("%s %dw", a.URL(), a.Width))
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// ArchivePath はMarkdownエクスポートのzip内 attachments/ 配下のパスを返す。
+// 元のファイル名がある場合は、保存先のファイル名に続けて元のファイル名を付ける。
+func (a Attachment) ArchivePath() string {
+	rel := filepath.ToSlash(filepath.Clean(a.Path))
+	name := strings.TrimSpace(attachmentNameUnsafeRe.ReplaceAllString(a.Name, "_"))
+	if name == "" || name == "." || name == ".." {
+		return rel
+	}
+	dir, base := path.Split(rel)
+	return dir + strings.TrimSuffix(base, path.Ext(base)) + "_" + name
+}
+
+func formatFileSize(size int64) string {
+	switch {
+	case size <= 0:
+		return ""
+	case size < 1024:
+		return fmt.Sprintf("%d B", size)
+	case size < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	case size < 1024*1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+	}
+	return fmt.Sprintf("%.1f GB", float64(size)/(1024*1024*1024))
+}
+
+// slackFileAltTexts はイベントの生のペイロードからファイルIDごとの代替テキスト（alt_txt）を取り出します。
+// slack.File は alt_txt を持たないため、イベントのJSONから直接読み取ります。
+func slackFileAltTexts(payload json.RawMessage) map[string]string {
+	if len(payload) == 0 {
+		return nil
+	}
+	type file struct {
+		ID     string `json:"id"`
+		AltTxt string `json:"alt_txt"`
+	}
+	var callback struct {
+		Event struct {
+			Files   []file `json:"files"`
+			Message struct {
+				Files []file `json:"files"`
+			} `json:"message"`
+		} `json:"event"`
+	}
+	if err := json.Unmarshal(payload, &callback); err != nil {
+		return nil
+	}
+	altTexts := make(map[string]string)
+	for _, f := range append(callback.Event.Files, callback.Event.Message.Files...) {
+		if f.ID != "" && f.AltTxt != "" {
+			altTexts[f.ID] = f.AltTxt
+		}
+	}
+	return altTexts
+}
+
+// attachInlineTexts は描画用に、テキスト添付ファイルの本文を読み込んでエントリへ設定します。
+// 読み込めないファイルや上限サイズを超えるファイルはファイルカードとして表示します。
+func (c *Channels) attachInlineTexts(entries []Entry) []Entry {
+	for i := range entries {
+		for j, file := range entries[i].Files {
+			if file.Kind() != attachmentKindText {
+				continue
+			}
+			localPath, err := c.safeJoinUnderBase(file.Path)
+			if err != nil {
+				continue
+			}
+			info, err := os.Stat(localPath)
+			if err != nil || info.Size() > maxInlineTextBytes {
+				continue
+			}
+			b, err := os.ReadFile(localPath)
+			if err != nil || !utf8.Valid(b) {
+				continue
+			}
+			text := strings.TrimRight(string(b), "\n")
+			entries[i].Files[j].text = &text
+		}
+	}
+	return entries
+}
+
+// applyAltTexts は添付ファイルにSlackのファイルIDに対応する代替テキストを設定します。
+func applyAltTexts(files []Attachment, altTexts map[string]string) {
+	for i := range files {
+		if alt, ok := altTexts[files[i].SlackFileID]; ok {
+			files[i].AltText = alt
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/api/drive/v3"
+)
+
+func TestAttachment_UnmarshalJSON(t *testing.T) {
+	var files []Attachment
+	raw := `["images/general/1.0_0.png",{"path":"images/general/1.0_1.pdf","name":"report.pdf","mimetype":"application/pdf","size":2048,"slack_file_id":"F1"}]`
+	if err := json.Unmarshal([]byte(raw), &files); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := []Attachment{
+		{Path: "images/general/1.0_0.png"},
+		{Path: "images/general/1.0_1.pdf", Name: "report.pdf", MimeType: "application/pdf", Size: 2048, SlackFileID: "F1"},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("Unmarshal() = %+v, want %+v", files, want)
+	}
+	if err := json.Unmarshal([]byte(`[1]`), &files); err == nil {
+		t.Fatal("Unmarshal(number) error = nil, want error")
+	}
+}
+
+func TestAttachment_Display(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        Attachment
+		isImage     bool
+		displayName string
+		alt         string
+		archivePath string
+		size        string
+	}{
+		{
+			name:        "legacy image",
+			file:        Attachment{Path: "images/general/1.0_0.PNG"},
+			isImage:     true,
+			displayName: "1.0_0.PNG",
+			archivePath: "images/general/1.0_0.PNG",
+		},
+		{
+			name:        "legacy non-image",
+			file:        Attachment{Path: "images/general/1.0_0.pdf"},
+			displayName: "1.0_0.pdf",
+			archivePath: "images/general/1.0_0.pdf",
+		},
+		{
+			name:        "image with alt text",
+			file:        Attachment{Path: "images/general/1.0_0.jpg", Name: "cat.jpg", Title: "My cat", MimeType: "image/jpeg", AltText: "A cat on a sofa", Size: 1536},
+			isImage:     true,
+			displayName: "cat.jpg",
+			alt:         "A cat on a sofa",
+			archivePath: "images/general/1.0_0_cat.jpg",
+			size:        "1.5 KB",
+		},
+		{
+			name:        "file with unsafe name",
+			file:        Attachment{Path: "images/general/1.0_1.pdf", Name: "a/b:c.pdf", MimeType: "application/pdf", Size: 10},
+			displayName: "a/b:c.pdf",
+			alt:         "a/b:c.pdf",
+			archivePath: "images/general/1.0_1_a_b_c.pdf",
+			size:        "10 B",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.file.IsImage(); got != tt.isImage {
+				t.Errorf("IsImage() = %v, want %v", got, tt.isImage)
+			}
+			if got := tt.file.DisplayName(); got != tt.displayName {
+				t.Errorf("DisplayName() = %q, want %q", got, tt.displayName)
+			}
+			if got := tt.file.Alt(); got != tt.alt {
+				t.Errorf("Alt() = %q, want %q", got, tt.alt)
+			}
+			if got := tt.file.ArchivePath(); got != tt.archivePath {
+				t.Errorf("ArchivePath() = %q, want %q", got, tt.archivePath)
+			}
+			if got := tt.file.SizeString(); got != tt.size {
+				t.Errorf("SizeString() = %q, want %q", got, tt.size)
+			}
+		})
+	}
+}
+
+func TestSlackFileAltTexts(t *testing.T) {
+	payload := json.RawMessage(`{"type":"event_callback","event":{"type":"message","files":[{"id":"F1","alt_txt":"a cat"},{"id":"F2"}],"message":{"files":[{"id":"F3","alt_txt":"a dog"}]}}}`)
+	got := slackFileAltTexts(payload)
+	want := map[string]string{"F1": "a cat", "F3": "a dog"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("slackFileAltTexts() = %v, want %v", got, want)
+	}
+
+	files := []Attachment{{Path: "a.png", SlackFileID: "F1"}, {Path: "b.png", SlackFileID: "F2"}}
+	applyAltTexts(files, got)
+	if files[0].AltText != "a cat" || files[1].AltText != "" {
+		t.Fatalf("applyAltTexts() = %+v", files)
+	}
+}
+
+func TestDownloadImageFiles_RecordsMetadata(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	channels := &Channels{basedir: t.TempDir()}
+	gdrive := &GDrive{
+		createBlobFileFn: func(ctx context.Context, name, parent, filepath string) error {
+			return nil
+		},
+	}
+	files := []slack.File{
+		{ID: "F1", Name: "report.pdf", Title: "Q1 report", Mimetype: "application/pdf", Filetype: "pdf", Size: 2048, URLPrivateDownload: "https://example.com/1"},
+	}
+
+	got, err := downloadImageFiles(context.Background(), stubFileContextGetter{}, "general", channels, files, "1711670400.000000", gdrive)
+	if err != nil {
+		t.Fatalf("downloadImageFiles() error = %v", err)
+	}
+	// "ok" のSHA-256
+	hash := "2689367b205c16ce32ed4200942b8b8b1e262dfc70d9bc9fbc77c49699a4f1df"
+	want := []Attachment{{
+		Path:        filepath.Join(BlobDir, "26", hash+".pdf"),
+		Hash:        hash,
+		Name:        "report.pdf",
+		Title:       "Q1 report",
+		MimeType:    "application/pdf",
+		FileType:    "pdf",
+		Size:        2048,
+		SlackFileID: "F1",
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("downloadImageFiles() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCreateHtmlFile_RendersAttachmentsByType(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	jsonl := `{"timestamp":"1775088000.000000","message":"files","channel":{"id":"C1","name":"general"},"files":[` +
+		`{"path":"images/general/1775088000.000000_0.jpg","name":"cat.jpg","mimetype":"image/jpeg","alt_text":"A cat on a sofa"},` +
+		`{"path":"images/general/1775088000.000000_1.pdf","name":"report.pdf","mimetype":"application/pdf","size":2048}]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	g := &GDrive{
+		htmlDir: &drive.File{Id: "html-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			return nil
+		},
+	}
+
+	if err := c.CreateHtmlFile(context.Background(), "general", g, nil); err != nil {
+		t.Fatalf("CreateHtmlFile() error = %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(baseDir, "html", "general.html"))
+	if err != nil {
+		t.Fatalf("read html: %v", err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		`<img src="../images/general/1775088000.000000_0.jpg" class="aspect-video w-full object-cover" alt="A cat on a sofa" title="cat.jpg" loading="lazy" decoding="async" />`,
+		`<a href="../images/general/1775088000.000000_1.pdf" download="report.pdf"`,
+		`2.0 KB`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("CreateHtmlFile() output missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `<img src="../images/general/1775088000.000000_1.pdf"`) {
+		t.Fatal("CreateHtmlFile() rendered non-image attachment as <img>")
+	}
+}
+
+func TestCreateMarkdownZip_UsesOriginalNames(t *testing.T) {
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	jsonl := `{"timestamp":"1775001600.123456","message":"hello","channel":{"id":"C1","name":"general"},"files":[` +
+		`{"path":"images/general/1775001600.123456_0.png","name":"diagram.png","mimetype":"image/png","alt_text":"Architecture diagram"},` +
+		`{"path":"images/general/1775001600.123456_1.pdf","name":"report.pdf","mimetype":"application/pdf","size":2048}]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	for _, name := range []string{"1775001600.123456_0.png", "1775001600.123456_1.pdf"} {
+		p := filepath.Join(baseDir, "images", "general", name)
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte("data"), 0644); err != nil {
+			t.Fatalf("write attachment: %v", err)
+		}
+	}
+
+	result, err := c.CreateMarkdownZip(context.Background(), "general", nil)
+	if err != nil {
+		t.Fatalf("CreateMarkdownZip() error = %v", err)
+	}
+	if result.AttachmentCount != 2 {
+		t.Fatalf("AttachmentCount = %d, want 2", result.AttachmentCount)
+	}
+	readZipEntry(t, result.ZipPath, "attachments/images/general/1775001600.123456_0_diagram.png")
+	readZipEntry(t, result.ZipPath, "attachments/images/general/1775001600.123456_1_report.pdf")
+	md := readZipEntry(t, result.ZipPath, "index.md")
+	for _, want := range []string{
+		"![Architecture diagram](<attachments/images/general/1775001600.123456_0_diagram.png>)",
+		"[report.pdf](<attachments/images/general/1775001600.123456_1_report.pdf>) (2.0 KB)",
+	} {
+		if !strings.Contains(md, want) {
+			t.Fatalf("index.md missing %q:\n%s", want, md)
+		}
+	}
+}
+
+func TestCreateHtmlFile_RendersMediaAndSnippets(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	jsonl := `{"timestamp":"1775088000.000000","message":"media","channel":{"id":"C1","name":"general"},"files":[` +
+		`{"path":"images/general/1775088000.000000_0.mp4","name":"clip.mp4","mimetype":"video/mp4"},` +
+		`{"path":"images/general/1775088000.000000_1.m4a","name":"memo.m4a","mimetype":"audio/mp4"},` +
+		`{"path":"images/general/1775088000.000000_2.pdf","name":"slides.pdf","mimetype":"application/pdf"},` +
+		`{"path":"images/general/1775088000.000000_3.go","name":"main.go","mimetype":"text/plain","filetype":"go","mode":"snippet"},` +
+		`{"path":"images/general/1775088000.000000_4.txt","name":"missing.txt","mimetype":"text/plain"}]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	snippet := filepath.Join(baseDir, "images", "general", "1775088000.000000_3.go")
+	if err := os.MkdirAll(filepath.Dir(snippet), os.ModePerm); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(snippet, []byte("func main() {}\n"), 0644); err != nil {
+		t.Fatalf("write snippet: %v", err)
+	}
+	g := &GDrive{
+		htmlDir: &drive.File{Id: "html-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			return nil
+		},
+	}
+
+	if err := c.CreateHtmlFile(context.Background(), "general", g, nil); err != nil {
+		t.Fatalf("CreateHtmlFile() error = %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(baseDir, "html", "general.html"))
+	if err != nil {
+		t.Fatalf("read html: %v", err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		`<video src="../images/general/1775088000.000000_0.mp4" controls`,
+		`<audio src="../images/general/1775088000.000000_1.m4a" controls`,
+		`<object data="../images/general/1775088000.000000_2.pdf" type="application/pdf"`,
+		`<code class="language-go"><span class="font-medium text-purple-700">func</span> main() {}</code>`,
+		`<a href="../images/general/1775088000.000000_4.txt" download="missing.txt"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("CreateHtmlFile() output missing %q:\n%s", want, got)
+		}
+	}
+}
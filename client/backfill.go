@@ -0,0 +1,237 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const backfillPageLimit = 200
+
+type conversationHistoryGetter interface {
+	GetConversationHistoryContext(ctx context.Context, params *slack.GetConversationHistoryParameters) (*slack.GetConversationHistoryResponse, error)
+	GetConversationRepliesContext(ctx context.Context, params *slack.GetConversationRepliesParameters) ([]slack.Message, bool, string, error)
+}
+
+type backfillClient interface {
+	conversationHistoryGetter
+	fileContextGetter
+	slackNameClient
+}
+
+// BackfillResult は /backfill で取り込んだ結果の情報です。
+type BackfillResult struct {
+	Fetched  int
+	Added    int
+	Skipped  int
+	Warnings []string
+}
+
+// Backfill は conversations.history とスレッド返信を遡って取得し、記録対象ユーザーの未記録メッセージをJSONLへ取り込みます。
+// since が nil の場合はチャンネルの全履歴が対象です。
+func (c *Channels) Backfill(ctx context.Context, client backfillClient, channel Channel, since *time.Time, botMention string, gdrive *GDrive) (BackfillResult, error) {
+	ctx, span := tracer.Start(ctx, "Backfill")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("slack.channel.id", channel.ID),
+		attribute.String("slack.channel.name", channel.Name),
+	)
+
+	oldest := ""
+	if since != nil {
+		oldest = fmt.Sprintf("%d.%06d", since.Unix(), since.Nanosecond()/1000)
+	}
+	messages, err := fetchChannelMessages(ctx, client, channel.ID, oldest)
+	if err != nil {
+		return BackfillResult{}, err
+	}
+
+	recorded, err := c.entryTimestamps(channel.Name)
+	if err != nil {
+		return BackfillResult{}, err
+	}
+
+	result := BackfillResult{Fetched: len(messages), Warnings: make([]string, 0)}
+	userNames := make(map[string]string)
+	entries := make([]Entry, 0)
+	for _, msg := range messages {
+		if recorded[msg.Timestamp] || skipBackfillMessage(msg, botMention, channel.ID, c) {
+			result.Skipped++
+			continue
+		}
+		recorded[msg.Timestamp] = true
+
+		userName, ok := userNames[msg.User]
+		if !ok {
+			userName = resolveUserDisplayName(ctx, client, msg.User)
+			userNames[msg.User] = userName
+		}
+		entry := Entry{
+			Timestamp: msg.Timestamp,
+			Message:   msg.Text,
+			Mentions:  c.resolveMentionNames(ctx, client, msg.Text),
+			Channel:   channel,
+			User:      msg.User,
+			UserName:  userName,
+			Unfurls:   slackUnfurls(msg.Attachments),
+		}
+		if msg.ThreadTimestamp != msg.Timestamp {
+			entry.ThreadTimestamp = msg.ThreadTimestamp
+		}
+		if msg.Edited != nil {
+			entry.EditedAt = msg.Edited.Timestamp
+		}
+		if len(msg.Files) > 0 {
+			files, err := downloadImageFiles(ctx, client, channel.Name, c, msg.Files, msg.Timestamp, gdrive)
+			if err != nil {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("ts=%s %v", msg.Timestamp, err))
+			}
+			entry.Files = files
+		}
+		entries = append(entries, entry)
+	}
+
+	added, err := c.MergeEntries(ctx, channel.Name, entries, gdrive)
+	result.Added = added
+	if err != nil {
+		return result, err
+	}
+	span.SetAttributes(attribute.Int("backfill.added", added))
+	c.archiveLinks(ctx, entries)
+	return result, nil
+}
+
+func skipBackfillMessage(msg slack.Message, botMention string, channelID string, channels *Channels) bool {
+	if msg.SubType != "" && msg.SubType != fileShareSubType && msg.SubType != threadBroadcastSubType {
+		return true
+	}
+	if !channels.isAuthor(channelID, msg.User) {
+		return true
+	}
+	if strings.HasPrefix(msg.Text, botMention) {
+		return true
+	}
+	return len(msg.Files) == 0 && len(strings.TrimSpace(msg.Text)) == 0
+}
+
+// fetchChannelMessages はチャンネルのメッセージとスレッド返信を全ページ取得します。
+// oldest を指定した場合、期間より前の親メッセージのスレッドに期間内に投稿された返信も取得します。
+// そのため期間より前の履歴も遡り、latest_reply が期間内のスレッドだけ返信を取得します。
+func fetchChannelMessages(ctx context.Context, client conversationHistoryGetter, channelID, oldest string) ([]slack.Message, error) {
+	messages := make([]slack.Message, 0)
+	err := forEachConversationHistory(ctx, client, &slack.GetConversationHistoryParameters{ChannelID: channelID, Oldest: oldest}, func(msg slack.Message) error {
+		messages = append(messages, msg)
+		if msg.ReplyCount == 0 {
+			return nil
+		}
+		replies, err := fetchThreadReplies(ctx, client, channelID, msg.Timestamp, oldest)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, replies...)
+		return nil
+	})
+	if err != nil || oldest == "" {
+		return messages, err
+	}
+
+	err = forEachConversationHistory(ctx, client, &slack.GetConversationHistoryParameters{ChannelID: channelID, Latest: oldest, Inclusive: true}, func(msg slack.Message) error {
+		if msg.ReplyCount == 0 || compareSlackTimestamps(msg.LatestReply, oldest) < 0 {
+			return nil
+		}
+		replies, err := fetchThreadReplies(ctx, client, channelID, msg.Timestamp, oldest)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, replies...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// forEachConversationHistory は params の条件で conversations.history を全ページ取得し、メッセージごとに fn を呼びます。
+func forEachConversationHistory(ctx context.Context, client conversationHistoryGetter, params *slack.GetConversationHistoryParameters, fn func(msg slack.Message) error) error {
+	cursor := ""
+	for {
+		var resp *slack.GetConversationHistoryResponse
+		err := retryOnRateLimit(ctx, func() error {
+			page := *params
+			page.Cursor = cursor
+			page.Limit = backfillPageLimit
+			var err error
+			resp, err = client.GetConversationHistoryContext(ctx, &page)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("conversations.history の取得に失敗: %w", err)
+		}
+		for _, msg := range resp.Messages {
+			if err := fn(msg); err != nil {
+				return err
+			}
+		}
+		cursor = resp.ResponseMetaData.NextCursor
+		if !resp.HasMore || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// fetchThreadReplies は親メッセージを除いたスレッド返信を全ページ取得します。
+func fetchThreadReplies(ctx context.Context, client conversationHistoryGetter, channelID, threadTimestamp, oldest string) ([]slack.Message, error) {
+	replies := make([]slack.Message, 0)
+	cursor := ""
+	for {
+		var msgs []slack.Message
+		var hasMore bool
+		var next string
+		err := retryOnRateLimit(ctx, func() error {
+			var err error
+			msgs, hasMore, next, err = client.GetConversationRepliesContext(ctx, &slack.GetConversationRepliesParameters{
+				ChannelID: channelID,
+				Timestamp: threadTimestamp,
+				Cursor:    cursor,
+				Limit:     backfillPageLimit,
+				Oldest:    oldest,
+			})
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("conversations.replies の取得に失敗: ts=%s %w", threadTimestamp, err)
+		}
+		for _, msg := range msgs {
+			if msg.Timestamp == threadTimestamp {
+				continue
+			}
+			replies = append(replies, msg)
+		}
+		cursor = next
+		if !hasMore || cursor == "" {
+			return replies, nil
+		}
+	}
+}
+
+// retryOnRateLimit はSlack APIのレート制限エラーの場合に Retry-After だけ待って再試行します。
+func retryOnRateLimit(ctx context.Context, fn func() error) error {
+	for {
+		err := fn()
+		var rateLimited *slack.RateLimitedError
+		if !errors.As(err, &rateLimited) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(rateLimited.RetryAfter):
+		}
+	}
+}
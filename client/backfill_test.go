@@ -0,0 +1,251 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/api/drive/v3"
+)
+
+type stubBackfillClient struct {
+	stubFileContextGetter
+	stubSlackNameClient
+	pages        []slack.GetConversationHistoryResponse
+	replies      map[string][]slack.Message
+	historyCalls int
+	rateLimitErr int
+	// olderPages は Latest を指定した（期間より前の）conversations.history の結果です。
+	olderPages    []slack.GetConversationHistoryResponse
+	olderCalls    int
+	repliesCalled []string
+}
+
+func (s *stubBackfillClient) GetConversationHistoryContext(_ context.Context, params *slack.GetConversationHistoryParameters) (*slack.GetConversationHistoryResponse, error) {
+	if s.rateLimitErr > 0 {
+		s.rateLimitErr--
+		return nil, &slack.RateLimitedError{RetryAfter: time.Millisecond}
+	}
+	if params.Latest != "" {
+		page := s.olderPages[s.olderCalls]
+		s.olderCalls++
+		return &page, nil
+	}
+	page := s.pages[s.historyCalls]
+	s.historyCalls++
+	if params.Cursor == "" && s.historyCalls != 1 {
+		return nil, errors.New("cursor must be passed for later pages")
+	}
+	return &page, nil
+}
+
+func (s *stubBackfillClient) GetConversationRepliesContext(_ context.Context, params *slack.GetConversationRepliesParameters) ([]slack.Message, bool, string, error) {
+	s.repliesCalled = append(s.repliesCalled, params.Timestamp)
+	var msgs []slack.Message
+	for _, msg := range s.replies[params.Timestamp] {
+		if params.Oldest == "" || compareSlackTimestamps(msg.Timestamp, params.Oldest) > 0 {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, false, "", nil
+}
+
+func newMessage(ts, user, text string) slack.Message {
+	return slack.Message{Msg: slack.Msg{Timestamp: ts, User: user, Text: text}}
+}
+
+func TestChannels_Backfill_MergesInTimestampOrder(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir, authorIDs: []string{"U1"}}
+	existing := `{"timestamp":"1775001600.000002","message":"already","channel":{"id":"C1","name":"general"},"files":[]}` + "\n"
+	filePath := filepath.Join(baseDir, "general.jsonl")
+	if err := os.WriteFile(filePath, []byte(existing), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+
+	parent := newMessage("1775001600.000001", "U1", "parent")
+	parent.ReplyCount = 1
+	withFile := newMessage("1775001600.000004", "U1", "")
+	withFile.SubType = "file_share"
+	withFile.Files = []slack.File{{URLPrivateDownload: "https://example.com/f", Filetype: "png"}}
+	reply := newMessage("1775001600.000003", "U1", "reply")
+	reply.ThreadTimestamp = "1775001600.000001"
+	threadParent := parent
+	threadParent.ThreadTimestamp = "1775001600.000001"
+
+	client := &stubBackfillClient{
+		stubSlackNameClient: stubSlackNameClient{users: map[string]*slack.User{"U1": {Name: "alice"}}},
+		rateLimitErr:        1,
+		pages: []slack.GetConversationHistoryResponse{
+			{
+				HasMore:  true,
+				Messages: []slack.Message{withFile, newMessage("1775001600.000002", "U1", "already")},
+				ResponseMetaData: struct {
+					NextCursor string `json:"next_cursor"`
+				}{NextCursor: "next"},
+			},
+			{
+				Messages: []slack.Message{parent, newMessage("1775001600.000005", "U2", "not author")},
+			},
+		},
+		replies: map[string][]slack.Message{
+			"1775001600.000001": {threadParent, reply},
+		},
+	}
+
+	uploads := 0
+	blobUploads := 0
+	g := &GDrive{
+		targetDir: &drive.File{Id: "target-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return &drive.File{Id: "file-id"}, nil
+		},
+		updateFileFn: func(ctx context.Context, name, id, filePath string) error {
+			uploads++
+			return nil
+		},
+		createBlobFileFn: func(ctx context.Context, name, parent, filepath string) error {
+			blobUploads++
+			return nil
+		},
+	}
+
+	result, err := c.Backfill(context.Background(), client, Channel{ID: "C1", Name: "general"}, nil, "<@B1>", g)
+	if err != nil {
+		t.Fatalf("Backfill() error = %v", err)
+	}
+	if result.Fetched != 5 || result.Added != 3 || result.Skipped != 2 {
+		t.Fatalf("Backfill() result = %+v, want fetched=5 added=3 skipped=2", result)
+	}
+	if uploads != 1 || blobUploads != 1 {
+		t.Fatalf("uploads = %d, blobUploads = %d, want 1, 1", uploads, blobUploads)
+	}
+
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("read jsonl: %v", err)
+	}
+	entries, err := parseEntriesFromJSONL(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatalf("parseEntriesFromJSONL() error = %v", err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Timestamp)
+	}
+	want := "1775001600.000001,1775001600.000002,1775001600.000003,1775001600.000004"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("timestamps = %v, want %s", got, want)
+	}
+	if entries[2].ThreadTimestamp != "1775001600.000001" || entries[2].UserName != "alice" {
+		t.Fatalf("reply entry = %+v", entries[2])
+	}
+	if len(entries[3].Files) != 1 {
+		t.Fatalf("file entry files = %v, want 1 file", entries[3].Files)
+	}
+
+	again, err := c.Backfill(context.Background(), &stubBackfillClient{pages: client.pages, replies: client.replies}, Channel{ID: "C1", Name: "general"}, nil, "<@B1>", g)
+	if err != nil {
+		t.Fatalf("Backfill(again) error = %v", err)
+	}
+	if again.Added != 0 {
+		t.Fatalf("Backfill(again) added = %d, want 0", again.Added)
+	}
+}
+
+func TestChannels_Backfill_FetchesRepliesInPeriodForOlderParents(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir, authorIDs: []string{"U1"}}
+
+	oldParent := newMessage("1775000000.000000", "U1", "old parent")
+	oldParent.ReplyCount = 2
+	oldParent.LatestReply = "1775001800.000000"
+	quietParent := newMessage("1775000100.000000", "U1", "quiet parent")
+	quietParent.ReplyCount = 1
+	quietParent.LatestReply = "1775000200.000000"
+	oldReply := newMessage("1775000500.000000", "U1", "old reply")
+	oldReply.ThreadTimestamp = oldParent.Timestamp
+	lateReply := newMessage("1775001800.000000", "U1", "late reply")
+	lateReply.ThreadTimestamp = oldParent.Timestamp
+
+	client := &stubBackfillClient{
+		stubSlackNameClient: stubSlackNameClient{users: map[string]*slack.User{"U1": {Name: "alice"}}},
+		pages: []slack.GetConversationHistoryResponse{
+			{Messages: []slack.Message{newMessage("1775001700.000000", "U1", "new")}},
+		},
+		olderPages: []slack.GetConversationHistoryResponse{
+			{Messages: []slack.Message{quietParent, oldParent}},
+		},
+		replies: map[string][]slack.Message{
+			oldParent.Timestamp: {oldParent, oldReply, lateReply},
+		},
+	}
+	g := &GDrive{
+		targetDir: &drive.File{Id: "target-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return &drive.File{Id: "file-id"}, nil
+		},
+		updateFileFn: func(ctx context.Context, name, id, filePath string) error {
+			return nil
+		},
+	}
+
+	since := time.Unix(1775001600, 0)
+	result, err := c.Backfill(context.Background(), client, Channel{ID: "C1", Name: "general"}, &since, "<@B1>", g)
+	if err != nil {
+		t.Fatalf("Backfill() error = %v", err)
+	}
+	if result.Added != 2 {
+		t.Fatalf("Backfill() result = %+v, want added=2", result)
+	}
+	// 期間内に返信がないスレッドの返信は取得しない
+	if strings.Join(client.repliesCalled, ",") != oldParent.Timestamp {
+		t.Fatalf("replies requested = %v, want only %s", client.repliesCalled, oldParent.Timestamp)
+	}
+
+	b, err := os.ReadFile(filepath.Join(baseDir, "general.jsonl"))
+	if err != nil {
+		t.Fatalf("read jsonl: %v", err)
+	}
+	entries, err := parseEntriesFromJSONL(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatalf("parseEntriesFromJSONL() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Message != "new" || entries[1].Message != "late reply" || entries[1].ThreadTimestamp != oldParent.Timestamp {
+		t.Fatalf("entries = %+v, want the new message and the late reply", entries)
+	}
+}
+
+func TestSkipBackfillMessage(t *testing.T) {
+	c := &Channels{authorIDs: []string{"U1"}}
+	join := newMessage("1.0", "U1", "joined")
+	join.SubType = "channel_join"
+	tests := []struct {
+		name string
+		msg  slack.Message
+		want bool
+	}{
+		{name: "author message", msg: newMessage("1.0", "U1", "hello"), want: false},
+		{name: "other user", msg: newMessage("1.0", "U2", "hello"), want: true},
+		{name: "bot mention", msg: newMessage("1.0", "U1", "<@B1> hi"), want: true},
+		{name: "empty text", msg: newMessage("1.0", "U1", " "), want: true},
+		{name: "channel_join subtype", msg: join, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := skipBackfillMessage(tt.msg, "<@B1>", "C1", c); got != tt.want {
+				t.Fatalf("skipBackfillMessage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
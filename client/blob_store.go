@@ -0,0 +1,227 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// BlobDir は添付ファイルを内容のハッシュ（SHA-256）で保存する base_dir 配下のディレクトリです。
+// blobs/<ハッシュの先頭2文字>/<ハッシュ><拡張子> に保存し、同じ内容のファイルは1つだけ保存します。
+const BlobDir = "blobs"
+
+var blobExtPattern = regexp.MustCompile(`^\.[A-Za-z0-9]{1,16}$`)
+
+// AttachmentMigrationResult は /migrate-attachments の結果です。
+type AttachmentMigrationResult struct {
+	Channels     int
+	Migrated     int
+	Deduplicated int
+	Warnings     []string
+}
+
+// blobPath は内容のハッシュに対応する base_dir からの相対パスを返します。
+func blobPath(hash, ext string) string {
+	ext = strings.ToLower(ext)
+	if !blobExtPattern.MatchString(ext) {
+		ext = ""
+	}
+	return filepath.Join(BlobDir, hash[:2], hash+ext)
+}
+
+func hashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// storeBlob は base_dir 配下の rel のファイルを内容のハッシュで blobs/ に移動し、保存先の相対パスとハッシュを返します。
+// 同じ内容が保存済みの場合は rel を削除して保存済みのファイルを使い、created は false になります。
+// originals/ に元画像がある場合は、保存先に対応する originals/ 配下へ移動します。
+func (c *Channels) storeBlob(rel string) (blobRel, hash string, created bool, err error) {
+	localPath, err := c.safeJoinUnderBase(rel)
+	if err != nil {
+		return "", "", false, err
+	}
+	hash, err = hashFile(localPath)
+	if err != nil {
+		return "", "", false, err
+	}
+	blobRel = blobPath(hash, filepath.Ext(rel))
+	dstPath, err := c.safeJoinUnderBase(blobRel)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	c.blobMu.Lock()
+	defer c.blobMu.Unlock()
+
+	if _, err := os.Stat(dstPath); err == nil {
+		if err := os.Remove(localPath); err != nil {
+			return "", "", false, fmt.Errorf("重複した添付ファイルの削除に失敗: %w", err)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(dstPath), os.ModePerm); err != nil {
+			return "", "", false, fmt.Errorf("添付ファイル保存先の作成に失敗: %w", err)
+		}
+		if err := os.Rename(localPath, dstPath); err != nil {
+			return "", "", false, fmt.Errorf("添付ファイルの移動に失敗: %w", err)
+		}
+		created = true
+	}
+
+	if original, err := c.safeJoinUnderBase(originalImagePath(rel)); err == nil {
+		if _, err := os.Stat(original); err == nil {
+			if err := c.moveOriginal(original, originalImagePath(blobRel)); err != nil {
+				log.Printf("元画像の移動に失敗: %s: %v", original, err)
+			}
+		}
+	}
+	return blobRel, hash, created, nil
+}
+
+func (c *Channels) moveOriginal(src, dstRel string) error {
+	dst, err := c.safeJoinUnderBase(dstRel)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return os.Remove(src)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
+
+// uploadBlob は新しく保存した blobs/ 配下のファイルをGoogle Driveの blobs/<ハッシュの先頭2文字>/ にアップロードします。
+func (c *Channels) uploadBlob(ctx context.Context, blobRel string, gdrive *GDrive) error {
+	localPath, err := c.safeJoinUnderBase(blobRel)
+	if err != nil {
+		return err
+	}
+	return gdrive.CreateBlobFile(ctx, filepath.Base(blobRel), filepath.Base(filepath.Dir(blobRel)), localPath)
+}
+
+// blobReferenced はいずれかのチャンネルのエントリが hash の添付ファイルを参照しているかを判定します。
+func (c *Channels) blobReferenced(hash string) (bool, error) {
+	files, err := filepath.Glob(filepath.Join(c.basedir, "*.jsonl"))
+	if err != nil {
+		return false, err
+	}
+	for _, file := range files {
+		lines, err := readLines(file)
+		if err != nil {
+			return false, err
+		}
+		for _, line := range lines {
+			if !strings.Contains(line, hash) {
+				continue
+			}
+			entry, err := ParseEntry(line)
+			if err != nil {
+				continue
+			}
+			for _, f := range entry.Files {
+				if f.Hash == hash {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// MigrateAttachments は images/<チャンネル名>/ に保存された既存の添付ファイルを blobs/ に移し、エントリの参照をハッシュに書き換えます。
+// channelName が空の場合はすべてのチャンネルを対象にします。ローカルにないファイルは警告に記録して元の参照のまま残します。
+func (c *Channels) MigrateAttachments(ctx context.Context, channelName string, gdrive *GDrive) (AttachmentMigrationResult, error) {
+	ctx, span := tracer.Start(ctx, "MigrateAttachments")
+	defer span.End()
+
+	result := AttachmentMigrationResult{Warnings: make([]string, 0)}
+	channelNames := []string{channelName}
+	if channelName == "" {
+		files, err := filepath.Glob(filepath.Join(c.basedir, "*.jsonl"))
+		if err != nil {
+			return result, err
+		}
+		channelNames = channelNames[:0]
+		for _, f := range files {
+			channelNames = append(channelNames, strings.TrimSuffix(filepath.Base(f), ".jsonl"))
+		}
+	}
+
+	legacyPrefix := "images" + string(filepath.Separator)
+	for _, name := range channelNames {
+		var created, replaced []string
+		changed, err := c.rewriteEntries(name, func(entry *Entry) bool {
+			updated := false
+			for j := range entry.Files {
+				file := &entry.Files[j]
+				if file.Hash != "" || !strings.HasPrefix(filepath.Clean(file.Path), legacyPrefix) {
+					continue
+				}
+				blobRel, hash, isNew, err := c.storeBlob(file.Path)
+				if err != nil {
+					if errors.Is(err, os.ErrNotExist) {
+						result.Warnings = append(result.Warnings, fmt.Sprintf("attachment not found: %s", file.Path))
+					} else {
+						result.Warnings = append(result.Warnings, fmt.Sprintf("attachment migration failed: %s (%v)", file.Path, err))
+					}
+					continue
+				}
+				if isNew {
+					created = append(created, blobRel)
+				} else {
+					result.Deduplicated++
+				}
+				replaced = append(replaced, file.Path)
+				file.Path = blobRel
+				file.Hash = hash
+				updated = true
+				result.Migrated++
+			}
+			return updated
+		})
+		if err != nil {
+			return result, fmt.Errorf("%s の移行に失敗: %w", name, err)
+		}
+		if !changed {
+			continue
+		}
+		result.Channels++
+
+		for _, blobRel := range created {
+			if err := c.uploadBlob(ctx, blobRel, gdrive); err != nil {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("drive upload failed: %s (%v)", blobRel, err))
+			}
+		}
+		for _, rel := range replaced {
+			if err := gdrive.DeleteImageFile(ctx, filepath.Base(rel), filepath.Base(filepath.Dir(rel))); err != nil {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("drive delete failed: %s (%v)", rel, err))
+			}
+		}
+		channelFileName := c.createChannelFileName(name)
+		if err := gdrive.UploadFile(ctx, channelFileName, c.createChannelFilePath(channelFileName)); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
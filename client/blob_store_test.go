@@ -0,0 +1,218 @@
+package client
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/api/drive/v3"
+)
+
+// "ok" のSHA-256
+const okContentHash = "2689367b205c16ce32ed4200942b8b8b1e262dfc70d9bc9fbc77c49699a4f1df"
+
+func writeTestFile(t *testing.T, baseDir, rel, content string) {
+	t.Helper()
+	p := filepath.Join(baseDir, rel)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatalf("write %s: %v", rel, err)
+	}
+}
+
+func readTestEntries(t *testing.T, baseDir, channelName string) []Entry {
+	t.Helper()
+	f, err := os.Open(filepath.Join(baseDir, channelName+".jsonl"))
+	if err != nil {
+		t.Fatalf("open jsonl: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	entries, err := parseEntriesFromJSONL(f)
+	if err != nil {
+		t.Fatalf("parseEntriesFromJSONL() error = %v", err)
+	}
+	return entries
+}
+
+func TestDownloadImageFiles_DeduplicatesAcrossChannels(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	channels := &Channels{basedir: baseDir}
+	var uploads []string
+	gdrive := &GDrive{
+		createBlobFileFn: func(ctx context.Context, name, parent, filepath string) error {
+			uploads = append(uploads, parent+"/"+name)
+			return nil
+		},
+	}
+	files := []slack.File{{URLPrivateDownload: "https://example.com/1", Filetype: "pdf", Mimetype: "application/pdf"}}
+
+	first, err := downloadImageFiles(context.Background(), stubFileContextGetter{}, "general", channels, files, "1.0", gdrive)
+	if err != nil {
+		t.Fatalf("downloadImageFiles(general) error = %v", err)
+	}
+	second, err := downloadImageFiles(context.Background(), stubFileContextGetter{}, "random", channels, files, "2.0", gdrive)
+	if err != nil {
+		t.Fatalf("downloadImageFiles(random) error = %v", err)
+	}
+
+	wantPath := filepath.Join(BlobDir, "26", okContentHash+".pdf")
+	if first[0].Path != wantPath || second[0].Path != wantPath || second[0].Hash != okContentHash {
+		t.Fatalf("paths = %q, %q, want %q", first[0].Path, second[0].Path, wantPath)
+	}
+	if !slices.Equal(uploads, []string{"26/" + okContentHash + ".pdf"}) {
+		t.Fatalf("uploads = %v, want one upload", uploads)
+	}
+	for _, rel := range []string{filepath.Join("images", "general", "1.0_0.pdf"), filepath.Join("images", "random", "2.0_0.pdf")} {
+		if _, err := os.Stat(filepath.Join(baseDir, rel)); !os.IsNotExist(err) {
+			t.Fatalf("%s should be moved into the blob store: %v", rel, err)
+		}
+	}
+}
+
+func TestChannels_deleteAttachmentFiles_KeepsSharedBlob(t *testing.T) {
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	blob := filepath.Join(BlobDir, "26", okContentHash+".png")
+	writeTestFile(t, baseDir, blob, "ok")
+	other := `{"timestamp":"2.0","message":"repost","channel":{"id":"C2","name":"random"},"files":[{"path":"` +
+		filepath.ToSlash(blob) + `","sha256":"` + okContentHash + `"}]}` + "\n"
+	writeTestFile(t, baseDir, "random.jsonl", other)
+
+	var deletes []string
+	g := &GDrive{
+		deleteBlobFileFn: func(ctx context.Context, name, parent string) error {
+			deletes = append(deletes, parent+"/"+name)
+			return nil
+		},
+	}
+	file := Attachment{Path: blob, Hash: okContentHash}
+
+	if warnings := c.deleteAttachmentFiles(context.Background(), []Attachment{file}, g); len(warnings) != 0 {
+		t.Fatalf("deleteAttachmentFiles(shared) warnings = %v", warnings)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, blob)); err != nil || len(deletes) != 0 {
+		t.Fatalf("shared blob should be kept: err=%v deletes=%v", err, deletes)
+	}
+
+	if err := os.Remove(filepath.Join(baseDir, "random.jsonl")); err != nil {
+		t.Fatalf("remove jsonl: %v", err)
+	}
+	if warnings := c.deleteAttachmentFiles(context.Background(), []Attachment{file}, g); len(warnings) != 0 {
+		t.Fatalf("deleteAttachmentFiles(unreferenced) warnings = %v", warnings)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, blob)); !os.IsNotExist(err) {
+		t.Fatalf("unreferenced blob should be removed: %v", err)
+	}
+	if !slices.Equal(deletes, []string{"26/" + okContentHash + ".png"}) {
+		t.Fatalf("drive deletes = %v", deletes)
+	}
+}
+
+func TestChannels_MigrateAttachments(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	writeTestFile(t, baseDir, "general.jsonl",
+		`{"timestamp":"1.0","message":"a","channel":{"id":"C1","name":"general"},"files":["images/general/1.0_0.png","images/general/1.0_1.png"]}`+"\n")
+	writeTestFile(t, baseDir, "random.jsonl",
+		`{"timestamp":"2.0","message":"b","channel":{"id":"C2","name":"random"},"files":[{"path":"images/random/2.0_0.png","name":"same.png"}]}`+"\n")
+	writeTestFile(t, baseDir, filepath.Join("images", "general", "1.0_0.png"), "ok")
+	writeTestFile(t, baseDir, filepath.Join("images", "random", "2.0_0.png"), "ok")
+
+	var created, deleted, uploaded []string
+	g := &GDrive{
+		createBlobFileFn: func(ctx context.Context, name, parent, filepath string) error {
+			created = append(created, name)
+			return nil
+		},
+		deleteImageFileFn: func(ctx context.Context, name, parent string) error {
+			deleted = append(deleted, parent+"/"+name)
+			return nil
+		},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		targetDir: &drive.File{Id: "target-dir-id"},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			uploaded = append(uploaded, name)
+			return nil
+		},
+	}
+
+	result, err := c.MigrateAttachments(context.Background(), "", g)
+	if err != nil {
+		t.Fatalf("MigrateAttachments() error = %v", err)
+	}
+	if result.Channels != 2 || result.Migrated != 2 || result.Deduplicated != 1 || len(result.Warnings) != 1 {
+		t.Fatalf("MigrateAttachments() result = %+v", result)
+	}
+	if len(created) != 1 || !slices.Equal(deleted, []string{"general/1.0_0.png", "random/2.0_0.png"}) {
+		t.Fatalf("drive created = %v, deleted = %v", created, deleted)
+	}
+	slices.Sort(uploaded)
+	if !slices.Equal(uploaded, []string{"general.jsonl", "random.jsonl"}) {
+		t.Fatalf("uploaded = %v", uploaded)
+	}
+
+	entries := readTestEntries(t, baseDir, "random")
+	want := Attachment{Path: filepath.Join(BlobDir, "26", okContentHash+".png"), Hash: okContentHash, Name: "same.png"}
+	if got := entries[0].Files[0]; got.Path != want.Path || got.Hash != want.Hash || got.Name != want.Name {
+		t.Fatalf("migrated attachment = %+v, want %+v", got, want)
+	}
+	general := readTestEntries(t, baseDir, "general")
+	// ローカルにないファイルは元の参照のまま残す
+	if general[0].Files[1].Path != filepath.Join("images", "general", "1.0_1.png") || general[0].Files[1].Hash != "" {
+		t.Fatalf("missing attachment = %+v", general[0].Files[1])
+	}
+
+	// 移行済みの場合は何もしない
+	again, err := c.MigrateAttachments(context.Background(), "", g)
+	if err != nil || again.Migrated != 0 || again.Channels != 0 {
+		t.Fatalf("MigrateAttachments(again) = %+v, %v", again, err)
+	}
+}
+
+func TestCreateMarkdownZip_SharedBlobWithDifferentNames(t *testing.T) {
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	blob := filepath.ToSlash(filepath.Join(BlobDir, "26", okContentHash+".png"))
+	writeTestFile(t, baseDir, blob, "ok")
+	writeTestFile(t, baseDir, "general.jsonl",
+		`{"timestamp":"1.0","message":"a","channel":{"id":"C1","name":"general"},"files":[`+
+			`{"path":"`+blob+`","sha256":"`+okContentHash+`","name":"first.png"},`+
+			`{"path":"`+blob+`","sha256":"`+okContentHash+`","name":"second.png"}]}`+"\n")
+
+	result, err := c.CreateMarkdownZip(context.Background(), "general", nil)
+	if err != nil {
+		t.Fatalf("CreateMarkdownZip() error = %v", err)
+	}
+	zr, err := zip.OpenReader(result.ZipPath)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	defer func() {
+		_ = zr.Close()
+	}()
+	var names []string
+	for _, f := range zr.File {
+		if strings.HasPrefix(f.Name, "attachments/") {
+			names = append(names, f.Name)
+		}
+	}
+	if len(names) != 2 || result.AttachmentCount != 2 {
+		t.Fatalf("zip attachments = %v (count %d), want both names", names, result.AttachmentCount)
+	}
+}
@@ -1,30 +1,47 @@
 package client
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
 	"github.com/slack-go/slack/socketmode"
-	"io"
 	"log"
 	"os"
 	"path"
+	"slices"
 	"strings"
 	"time"
 )
 
 type Config struct {
-	AppToken                   string `json:"app_token"`
-	BotToken                   string `json:"bot_token"`
-	Debug                      bool   `json:"debug"`
-	BaseDir                    string `json:"base_dir"`
-	AuthorID                   string `json:"author_id"`
-	LinkPreviewCacheTTLHours   int    `json:"link_preview_cache_ttl_hours"`
-	LinkPreviewCacheMaxEntries int    `json:"link_preview_cache_max_entries"`
+	AppToken                         string              `json:"app_token"`
+	BotToken                         string              `json:"bot_token"`
+	Debug                            bool                `json:"debug"`
+	BaseDir                          string              `json:"base_dir"`
+	AuthorID                         string              `json:"author_id"`
+	AuthorIDs                        []string            `json:"author_ids"`
+	ChannelAuthorIDs                 map[string][]string `json:"channel_author_ids"`
+	LinkPreviewCacheTTLHours         int                 `json:"link_preview_cache_ttl_hours"`
+	LinkPreviewCacheMaxEntries       int                 `json:"link_preview_cache_max_entries"`
+	LinkPreviewMaxPerMessage         int                 `json:"link_preview_max_per_message"`
+	DeleteAttachmentsOnMessageDelete bool                `json:"delete_attachments_on_message_delete"`
+	// ImageMetadata は添付画像のメタデータの扱いです（keep / strip / strip_keep_original）。
+	ImageMetadata string `json:"image_metadata"`
+	// LinkPreviewOEmbedProviders は組み込みのプロバイダーより優先して使うoEmbedのプロバイダーです。
+	LinkPreviewOEmbedProviders []OEmbedProvider `json:"link_preview_oembed_providers"`
+	// LinkArchive はリンクだけのメッセージのリンク先ページを base_dir/archive に保存するかです。
+	LinkArchive bool `json:"link_archive"`
+	// LinkPreviewFetch はリンク先ページを並行して取得する際の同時接続数・間隔・制限時間です。
+	LinkPreviewFetch LinkPreviewFetchConfig `json:"link_preview_fetch"`
+	// LinkPreviewPolicy はリンク先を取得するドメインの許可・拒否リストと robots.txt の確認です。
+	LinkPreviewPolicy LinkPreviewPolicyConfig `json:"link_preview_policy"`
 }
 
 const ConfigDir = "./config"
@@ -60,8 +77,8 @@ func (c Config) validate() error {
 	if c.BaseDir == "" {
 		errs = append(errs, "base_dir must be set.")
 	}
-	if c.AuthorID == "" {
-		errs = append(errs, "author_id must be set.")
+	if len(c.authorIDs()) == 0 && len(c.ChannelAuthorIDs) == 0 {
+		errs = append(errs, "author_id or author_ids must be set.")
 	}
 	if c.LinkPreviewCacheTTLHours < 0 {
 		errs = append(errs, "link_preview_cache_ttl_hours must be >= 0.")
@@ -69,6 +86,21 @@ func (c Config) validate() error {
 	if c.LinkPreviewCacheMaxEntries < 0 {
 		errs = append(errs, "link_preview_cache_max_entries must be >= 0.")
 	}
+	if c.LinkPreviewMaxPerMessage < 0 {
+		errs = append(errs, "link_preview_max_per_message must be >= 0.")
+	}
+	errs = append(errs, c.LinkPreviewFetch.validate()...)
+	errs = append(errs, c.LinkPreviewPolicy.validate()...)
+	switch imageMetadataMode(c.ImageMetadata) {
+	case "", imageMetadataKeep, imageMetadataStrip, imageMetadataStripKeepOriginal:
+	default:
+		errs = append(errs, "image_metadata must be one of \"keep\", \"strip\" or \"strip_keep_original\".")
+	}
+	for i, p := range c.LinkPreviewOEmbedProviders {
+		if err := validateOEmbedProvider(p); err != nil {
+			errs = append(errs, fmt.Sprintf("link_preview_oembed_providers[%d]: %v.", i, err))
+		}
+	}
 
 	if len(errs) > 0 {
 		return fmt.Errorf("%s", strings.Join(errs, "\n"))
@@ -76,6 +108,19 @@ func (c Config) validate() error {
 	return nil
 }
 
+// authorIDs は author_id と author_ids をまとめた記録対象ユーザーIDを返します。
+func (c Config) authorIDs() []string {
+	ids := make([]string, 0, len(c.AuthorIDs)+1)
+	for _, id := range append([]string{c.AuthorID}, c.AuthorIDs...) {
+		id = strings.TrimSpace(id)
+		if id == "" || slices.Contains(ids, id) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 func (c Config) linkPreviewCacheTTL() time.Duration {
 	if c.LinkPreviewCacheTTLHours <= 0 {
 		return defaultLinkPreviewCacheTTL
@@ -90,6 +135,20 @@ func (c Config) linkPreviewCacheMaxEntries() int {
 	return c.LinkPreviewCacheMaxEntries
 }
 
+func (c Config) linkPreviewMaxPerMessage() int {
+	if c.LinkPreviewMaxPerMessage <= 0 {
+		return defaultLinkPreviewMaxPerMessage
+	}
+	return c.LinkPreviewMaxPerMessage
+}
+
+func (c Config) imageMetadataMode() imageMetadataMode {
+	if c.ImageMetadata == "" {
+		return imageMetadataKeep
+	}
+	return imageMetadataMode(c.ImageMetadata)
+}
+
 func loadConfigFromFile(configPath string) (Config, error) {
 	file, err := os.Open(configPath)
 	if err != nil {
@@ -143,32 +202,44 @@ func initHtml(config Config) error {
 	return ensureCSSFile(config.BaseDir, os.Stat)
 }
 
+// previousCSSHashes は過去のバージョンで埋め込んでいた output.css の SHA-256 です。
+// html/output.css がこれらと一致する（運用者が編集していない）場合だけ、現在の output.css に置き換えます。
+var previousCSSHashes = map[string]bool{
+	"381314991a52c2e42b04932cafa0953cc03b624aa822a3deec292574de7ae9a8": true,
+}
+
 func ensureCSSFile(baseDir string, statFn func(string) (os.FileInfo, error)) error {
 	cssPath := path.Join(baseDir, HtmlDir, CSSFile)
-	// CSSFileコピー（未存在の場合のみ）
+	// CSSFileコピー（未存在の場合、または過去のバージョンのまま編集されていない場合）
+	exists := true
 	if _, err := statFn(cssPath); err != nil {
 		if !os.IsNotExist(err) {
 			return fmt.Errorf("CSS %s の状態確認に失敗： %v", CSSFile, err)
 		}
+		exists = false
+	}
 
-		src, err := templateFiles.Open(path.Join(TemplateDir, CSSFile))
+	want, err := templateFiles.ReadFile(path.Join(TemplateDir, CSSFile))
+	if err != nil {
+		return fmt.Errorf("CSS %s のオープンに失敗： %v", CSSFile, err)
+	}
+	if exists {
+		got, err := os.ReadFile(cssPath)
 		if err != nil {
-			return fmt.Errorf("CSS %s のオープンに失敗： %v", CSSFile, err)
+			return fmt.Errorf("CSS %s の読み込みに失敗： %v", CSSFile, err)
 		}
-		defer func() {
-			_ = src.Close()
-		}()
-		dst, err := os.Create(cssPath)
-		if err != nil {
-			return fmt.Errorf("CSS %s の作成に失敗： %v", CSSFile, err)
+		if bytes.Equal(got, want) {
+			return nil
 		}
-		defer func() {
-			_ = dst.Close()
-		}()
-		_, err = io.Copy(dst, src)
-		if err != nil {
-			return fmt.Errorf("CSS %s へのコピーに失敗： %v", CSSFile, err)
+		sum := sha256.Sum256(got)
+		if !previousCSSHashes[hex.EncodeToString(sum[:])] {
+			log.Printf("CSS %s は編集されているため置き換えません。新しいバージョンは %s/%s です", cssPath, TemplateDir, CSSFile)
+			return nil
 		}
+		log.Printf("CSS %s を新しいバージョンに置き換えます", cssPath)
+	}
+	if err := writeFileAtomic(cssPath, want); err != nil {
+		return fmt.Errorf("CSS %s へのコピーに失敗： %v", CSSFile, err)
 	}
 	return nil
 }
@@ -189,12 +260,7 @@ func Run(ctx context.Context) error {
 	}
 
 	// 既存のチャンネルデータを読み込む
-	channels, err := NewChannels(
-		config.BaseDir,
-		config.AuthorID,
-		config.linkPreviewCacheTTL(),
-		config.linkPreviewCacheMaxEntries(),
-	)
+	channels, err := NewChannels(config)
 	if err != nil {
 		return err
 	}
@@ -210,6 +276,13 @@ func Run(ctx context.Context) error {
 	}
 	botID := resp.UserID
 
+	// カスタム絵文字の取得（保存済みの画像は再取得しない）
+	go func() {
+		if err := channels.customEmoji.Sync(ctx, api); err != nil {
+			log.Printf("カスタム絵文字の同期に失敗: %v", err)
+		}
+	}()
+
 	// SocketMode ハンドラ登録
 	socketClient := socketmode.New(
 		api,
@@ -224,12 +297,23 @@ func Run(ctx context.Context) error {
 		return fmt.Errorf("google drive クライアントの初期化に失敗: %w", err)
 	}
 
+	// Google Driveへのアップロードはキュー経由でバックグラウンド実行する
+	go gdrive.RunUploadWorker(ctx)
+
 	// メッセージイベントハンドラ登録
 	socketModeHandler.HandleEvents(slackevents.Message, MessageEventHandler(channels, botID, gdrive))
 	// チャンネルジョインイベントハンドラ登録
-	socketModeHandler.HandleEvents(slackevents.MemberJoinedChannel, BotJoinedEventHandler(botID))
+	socketModeHandler.HandleEvents(slackevents.MemberJoinedChannel, BotJoinedEventHandler(channels, botID))
+	// ボットの退出イベントハンドラ登録
+	socketModeHandler.HandleEvents(slackevents.MemberLeftChannel, MemberLeftChannelHandler(channels, botID))
 	socketModeHandler.Handle(socketmode.EventTypeSlashCommand, SlashCommandHandler(channels, gdrive, config.BaseDir))
 	socketModeHandler.HandleEvents(slackevents.ChannelArchive, ChannelArchiveHandler(channels, gdrive))
+	socketModeHandler.HandleEvents(slackevents.ChannelUnarchive, ChannelUnarchiveHandler(channels))
+	socketModeHandler.HandleEvents(slackevents.ChannelRename, ChannelRenameHandler(channels, gdrive))
 
-	return socketModeHandler.RunEventLoopContext(ctx)
+	err = socketModeHandler.RunEventLoopContext(ctx)
+	if !channels.waitArchives(linkArchiveShutdownTimeout) {
+		log.Printf("リンク先のアーカイブの完了を待たずに終了します（%v経過）", linkArchiveShutdownTimeout)
+	}
+	return err
 }
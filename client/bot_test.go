@@ -1,8 +1,11 @@
 package client
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"os"
 	"path"
+	"regexp"
 	"strings"
 	"testing"
 )
@@ -48,3 +51,137 @@ func TestEnsureCSSFile_ReturnsErrorWhenStatErrorIsNotNotExist(t *testing.T) {
 		t.Fatalf("CSS file should not be created when stat fails: %v", statErr)
 	}
 }
+
+func TestConfig_authorIDs(t *testing.T) {
+	c := Config{AuthorID: "U1", AuthorIDs: []string{"U2", " U1 ", ""}}
+	got := c.authorIDs()
+	if strings.Join(got, ",") != "U1,U2" {
+		t.Fatalf("authorIDs() = %v, want [U1 U2]", got)
+	}
+}
+
+func TestConfig_validate_Authors(t *testing.T) {
+	base := Config{AppToken: "xapp-1", BotToken: "xoxb-1", BaseDir: "/tmp"}
+
+	if err := base.validate(); err == nil || !strings.Contains(err.Error(), "author_id or author_ids") {
+		t.Fatalf("validate() error = %v, want author error", err)
+	}
+
+	withList := base
+	withList.AuthorIDs = []string{"U1"}
+	if err := withList.validate(); err != nil {
+		t.Fatalf("validate() with author_ids error = %v", err)
+	}
+
+	withChannel := base
+	withChannel.ChannelAuthorIDs = map[string][]string{"C1": {"U1"}}
+	if err := withChannel.validate(); err != nil {
+		t.Fatalf("validate() with channel_author_ids error = %v", err)
+	}
+}
+
+func TestNewChannels_AppliesConfig(t *testing.T) {
+	c, err := NewChannels(Config{
+		BaseDir:                          t.TempDir(),
+		AuthorID:                         "U1",
+		AuthorIDs:                        []string{"U2"},
+		DeleteAttachmentsOnMessageDelete: true,
+		ImageMetadata:                    string(imageMetadataStrip),
+		LinkPreviewFetch:                 LinkPreviewFetchConfig{Concurrency: 2},
+	})
+	if err != nil {
+		t.Fatalf("NewChannels() error = %v", err)
+	}
+	if strings.Join(c.authorIDs, ",") != "U1,U2" || !c.deleteAttachments || c.imageMetadata != imageMetadataStrip {
+		t.Fatalf("NewChannels() = %+v", c)
+	}
+	if c.previewMaxPerMessage != defaultLinkPreviewMaxPerMessage || c.previewFetch.Concurrency != 2 || c.linkArchive != nil {
+		t.Fatalf("NewChannels() preview settings = %d %+v %v", c.previewMaxPerMessage, c.previewFetch, c.linkArchive)
+	}
+}
+
+func TestEnsureCSSFile_ReplacesPreviousVersion(t *testing.T) {
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(path.Join(baseDir, HtmlDir), os.ModePerm); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	cssPath := path.Join(baseDir, HtmlDir, CSSFile)
+	old := []byte(".mx-auto{}")
+	if err := os.WriteFile(cssPath, old, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	sum := sha256.Sum256(old)
+	previousCSSHashes[hex.EncodeToString(sum[:])] = true
+	t.Cleanup(func() {
+		delete(previousCSSHashes, hex.EncodeToString(sum[:]))
+	})
+
+	if err := ensureCSSFile(baseDir, os.Stat); err != nil {
+		t.Fatalf("ensureCSSFile() error = %v", err)
+	}
+
+	got, err := os.ReadFile(cssPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	want, err := templateFiles.ReadFile(path.Join(TemplateDir, CSSFile))
+	if err != nil {
+		t.Fatalf("ReadFile(template) error = %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("previous version of CSS file was not replaced")
+	}
+}
+
+func TestEnsureCSSFile_KeepsEditedFile(t *testing.T) {
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(path.Join(baseDir, HtmlDir), os.ModePerm); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	cssPath := path.Join(baseDir, HtmlDir, CSSFile)
+	if err := os.WriteFile(cssPath, []byte("body{color:red}"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if err := ensureCSSFile(baseDir, os.Stat); err != nil {
+		t.Fatalf("ensureCSSFile() error = %v", err)
+	}
+
+	got, err := os.ReadFile(cssPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(got) != "body{color:red}" {
+		t.Fatalf("edited CSS file was overwritten: %q", got)
+	}
+}
+
+func TestOutputCSS_CoversUsedClasses(t *testing.T) {
+	css, err := templateFiles.ReadFile(path.Join(TemplateDir, CSSFile))
+	if err != nil {
+		t.Fatalf("ReadFile(css) error = %v", err)
+	}
+	html, err := templateFiles.ReadFile(path.Join(TemplateDir, TemplateFile))
+	if err != nil {
+		t.Fatalf("ReadFile(template) error = %v", err)
+	}
+	var classes []string
+	for _, m := range regexp.MustCompile(`class="([^"]*)"`).FindAllStringSubmatch(string(html), -1) {
+		// テンプレートの条件分岐を取り除いてクラス名だけを集める
+		value := regexp.MustCompile(`\{\{[^}]*\}\}`).ReplaceAllString(m[1], " ")
+		classes = append(classes, strings.Fields(value)...)
+	}
+	// Goのコードから出力するクラス
+	for _, c := range []string{"inline-block h-5 w-5 align-text-bottom", codeCommentClass, codeStringClass, codeNumberClass, codeKeywordClass} {
+		classes = append(classes, strings.Fields(c)...)
+	}
+	for _, c := range classes {
+		if strings.HasPrefix(c, "language-") {
+			continue
+		}
+		selector := "." + strings.ReplaceAll(c, ":", `\:`)
+		if !regexp.MustCompile(regexp.QuoteMeta(selector) + `(:hover)? \{`).Match(css) {
+			t.Errorf("output.css has no rule for class %q", c)
+		}
+	}
+}
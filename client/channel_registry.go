@@ -0,0 +1,443 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	channelRegistryFileName = "channels.json"
+	channelRegistryVersion  = 1
+)
+
+type channelState string
+
+const (
+	channelStateJoined   channelState = "joined"
+	channelStateLeft     channelState = "left"
+	channelStateArchived channelState = "archived"
+)
+
+var (
+	// channelIDPattern はSlackのチャンネルIDです（パブリック: C、プライベート: G）。
+	channelIDPattern = regexp.MustCompile(`^[CG][A-Z0-9]+$`)
+	// channelLinkPattern はSlackがコマンド引数の #channel を変換した <#C123|name> 形式です。
+	channelLinkPattern = regexp.MustCompile(`^<#([CG][A-Z0-9]+)(?:\|([^>]*))?>$`)
+)
+
+// channelRecord はチャンネルIDごとの現在の名前、過去の名前、ボットの参加状態です。
+type channelRecord struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	PreviousNames []string `json:"previous_names,omitempty"`
+	// PendingRenames は保存ファイルを現在の名前へまだ移行できていない過去の名前です。
+	PendingRenames []string     `json:"pending_renames,omitempty"`
+	State          channelState `json:"state,omitempty"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+type channelRegistryFile struct {
+	Version  int                      `json:"version"`
+	Channels map[string]channelRecord `json:"channels"`
+}
+
+// channelRegistry はチャンネルIDをキーにしたチャンネル一覧を base_dir 配下に保存します。
+// 保存ファイルはチャンネル名で管理しているため、名前の変更を検出してファイルを移行するために使います。
+type channelRegistry struct {
+	filePath string
+
+	mu       sync.Mutex
+	channels map[string]channelRecord
+}
+
+func newChannelRegistry(baseDir string) (*channelRegistry, error) {
+	r := &channelRegistry{
+		filePath: filepath.Join(baseDir, "cache", channelRegistryFileName),
+		channels: map[string]channelRecord{},
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Get はチャンネルIDの登録内容を返します。
+func (r *channelRegistry) Get(id string) (channelRecord, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record, ok := r.channels[id]
+	return record, ok
+}
+
+// Observe はイベントで受け取ったチャンネル名を登録します。
+// 登録済みの名前と異なる場合は過去の名前と移行待ちの名前に追加し、変更前の名前と true を返します。
+// 移行待ちの名前は FinishRename で移行の完了を記録するまで残ります。
+func (r *channelRegistry) Observe(id, name string, now time.Time) (string, bool) {
+	if id == "" || name == "" {
+		return "", false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.channels[id]
+	if ok && record.Name == name {
+		return "", false
+	}
+	previous := record.Name
+	if previous != "" {
+		record.PreviousNames = append(slices.DeleteFunc(record.PreviousNames, func(n string) bool {
+			return n == previous || n == name
+		}), previous)
+		record.PendingRenames = append(slices.DeleteFunc(record.PendingRenames, func(n string) bool {
+			return n == previous || n == name
+		}), previous)
+	}
+	record.ID = id
+	record.Name = name
+	if record.State == "" {
+		record.State = channelStateJoined
+	}
+	record.UpdatedAt = now.UTC()
+	r.channels[id] = record
+	if err := r.saveLocked(); err != nil {
+		log.Printf("チャンネル一覧の保存失敗: %v", err)
+	}
+	return previous, previous != ""
+}
+
+// FinishRename は oldName の保存ファイルを現在の名前へ移行できたことを記録します。
+func (r *channelRegistry) FinishRename(id, oldName string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.channels[id]
+	if !ok || !slices.Contains(record.PendingRenames, oldName) {
+		return
+	}
+	record.PendingRenames = slices.DeleteFunc(record.PendingRenames, func(n string) bool {
+		return n == oldName
+	})
+	record.UpdatedAt = now.UTC()
+	r.channels[id] = record
+	if err := r.saveLocked(); err != nil {
+		log.Printf("チャンネル一覧の保存失敗: %v", err)
+	}
+}
+
+// SetState はボットの参加状態を更新します。未登録のチャンネルは名前なしで登録します。
+func (r *channelRegistry) SetState(id string, state channelState, now time.Time) {
+	if id == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record := r.channels[id]
+	if record.State == state {
+		return
+	}
+	record.ID = id
+	record.State = state
+	record.UpdatedAt = now.UTC()
+	r.channels[id] = record
+	if err := r.saveLocked(); err != nil {
+		log.Printf("チャンネル一覧の保存失敗: %v", err)
+	}
+}
+
+// IDByName はチャンネル名からIDを返します。現在の名前を優先し、見つからない場合は過去の名前から探します。
+func (r *channelRegistry) IDByName(name string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, record := range r.channels {
+		if record.Name == name {
+			return id
+		}
+	}
+	for id, record := range r.channels {
+		if slices.Contains(record.PreviousNames, name) {
+			return id
+		}
+	}
+	return ""
+}
+
+func (r *channelRegistry) load() error {
+	b, err := os.ReadFile(r.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("チャンネル一覧読込失敗: %w", err)
+	}
+	var disk channelRegistryFile
+	if err := json.Unmarshal(b, &disk); err != nil {
+		r.handleCorruptedFile(err)
+		return nil
+	}
+	for id, record := range disk.Channels {
+		record.ID = id
+		r.channels[id] = record
+	}
+	return nil
+}
+
+func (r *channelRegistry) handleCorruptedFile(parseErr error) {
+	brokenPath := fmt.Sprintf("%s.broken.%s", r.filePath, time.Now().UTC().Format("20060102150405"))
+	if err := os.Rename(r.filePath, brokenPath); err != nil {
+		log.Printf("破損ファイルの退避失敗: file=%s err=%v", r.filePath, err)
+		return
+	}
+	log.Printf("破損したチャンネル一覧を退避: src=%s dst=%s err=%v", r.filePath, brokenPath, parseErr)
+}
+
+func (r *channelRegistry) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(r.filePath), os.ModePerm); err != nil {
+		return fmt.Errorf("チャンネル一覧ディレクトリ作成失敗: %w", err)
+	}
+	out, err := json.MarshalIndent(channelRegistryFile{
+		Version:  channelRegistryVersion,
+		Channels: r.channels,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("チャンネル一覧JSON化失敗: %w", err)
+	}
+	return writeLines(r.filePath, []string{string(out)})
+}
+
+// observeChannel はチャンネルIDと現在の名前を登録し、名前が変わっていた場合は保存ファイルを新しい名前へ移行します。
+// 一覧に未登録のチャンネルは、記録済みJSONLのチャンネル情報から変更前の名前を探します。
+// 移行に失敗した名前は一覧に移行待ちとして残し、次のイベントで再び移行します。
+func (c *Channels) observeChannel(ctx context.Context, channel Channel, gdrive *GDrive) error {
+	if c.registry == nil || channel.ID == "" || channel.Name == "" {
+		return nil
+	}
+	if _, ok := c.registry.Get(channel.ID); !ok {
+		if legacy := c.channelNameByID(channel.ID); legacy != "" && legacy != channel.Name {
+			c.registry.Observe(channel.ID, legacy, time.Now())
+		}
+	}
+	if previous, renamed := c.registry.Observe(channel.ID, channel.Name, time.Now()); renamed {
+		log.Printf("チャンネル名の変更を検出: id=%s %s -> %s", channel.ID, previous, channel.Name)
+	}
+	record, _ := c.registry.Get(channel.ID)
+	for _, oldName := range record.PendingRenames {
+		if err := c.RenameChannel(ctx, oldName, record.Name, gdrive); err != nil {
+			return fmt.Errorf("チャンネル %s -> %s の移行に失敗（次のイベントで再試行）: %w", oldName, record.Name, err)
+		}
+		c.registry.FinishRename(channel.ID, oldName, time.Now())
+	}
+	return nil
+}
+
+// setChannelState はボットの参加状態を更新します。
+func (c *Channels) setChannelState(channelID string, state channelState) {
+	if c.registry == nil {
+		return
+	}
+	c.registry.SetState(channelID, state, time.Now())
+}
+
+// channelNameByID は記録済みJSONLのエントリからチャンネルIDに対応するファイル名（チャンネル名）を探します。
+func (c *Channels) channelNameByID(channelID string) string {
+	matches, err := filepath.Glob(filepath.Join(c.basedir, "*.jsonl"))
+	if err != nil {
+		return ""
+	}
+	for _, match := range matches {
+		name := strings.TrimSuffix(filepath.Base(match), ".jsonl")
+		if c.channelIDFromEntries(name) == channelID {
+			return name
+		}
+	}
+	return ""
+}
+
+// resolveChannelArg はコマンド引数のチャンネル指定（名前、ID、<#ID|name> 形式）を保存ファイルのチャンネル名に変換します。
+// 過去の名前は現在の名前に変換します。解決できない場合は引数をそのまま返します。
+func (c *Channels) resolveChannelArg(arg string) string {
+	arg = strings.TrimSpace(arg)
+	id := ""
+	if m := channelLinkPattern.FindStringSubmatch(arg); m != nil {
+		id = m[1]
+		if m[2] != "" {
+			arg = m[2]
+		}
+	} else if channelIDPattern.MatchString(arg) {
+		id = arg
+	}
+	if c.registry != nil {
+		if id == "" {
+			id = c.registry.IDByName(arg)
+		}
+		if record, ok := c.registry.Get(id); ok && record.Name != "" {
+			return record.Name
+		}
+	}
+	if id != "" {
+		if name := c.channelNameByID(id); name != "" {
+			return name
+		}
+	}
+	return arg
+}
+
+// RenameChannel はチャンネル名の変更に合わせて、JSONL・添付ファイル・HTMLを新しい名前へ移行します。
+// 新しい名前のJSONLが既にある場合は、変更前のエントリを先頭に結合します。
+// Google Drive上のファイルとフォルダも移行し、書き換えたJSONLを再アップロードします。
+// Driveの移行は移行済みの場合は何もしないため、失敗した移行をそのまま再実行できます。
+func (c *Channels) RenameChannel(ctx context.Context, oldName, newName string, gdrive *GDrive) error {
+	ctx, span := tracer.Start(ctx, "RenameChannel")
+	defer span.End()
+
+	if oldName == newName {
+		return nil
+	}
+	for _, name := range []string{oldName, newName} {
+		if err := c.validateStoredChannelName(name); err != nil {
+			return err
+		}
+	}
+	if _, err := c.migrateChannelFiles(oldName, newName); err != nil {
+		return err
+	}
+	if err := gdrive.RenameChannel(ctx, oldName, newName); err != nil {
+		return fmt.Errorf("Google Drive上のチャンネル移行に失敗: %w", err)
+	}
+	channelFileName := c.createChannelFileName(newName)
+	filePath := c.createChannelFilePath(channelFileName)
+	if _, err := os.Stat(filePath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return gdrive.UploadFile(ctx, channelFileName, filePath)
+}
+
+// validateStoredChannelName は保存ファイルのパスに使うチャンネル名が base_dir の外を指さないことを確認します。
+// Slackのチャンネル名は日本語なども使えるため、文字種の制限はしません（コマンド引数のチャンネル名もこれで検証します）。
+func (c *Channels) validateStoredChannelName(name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid channel name: must not be empty")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid channel name %q", name)
+	}
+	for _, rel := range []string{
+		c.createChannelFileName(name),
+		filepath.Join("images", name),
+		filepath.Join(ThumbnailDir, name),
+		filepath.Join(OriginalImageDir, name),
+		filepath.Join(HtmlDir, name+".html"),
+	} {
+		if _, err := c.safeJoinUnderBase(rel); err != nil {
+			return fmt.Errorf("invalid channel name %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// migrateChannelFiles はローカルの保存ファイルを移行します。変更前の名前のJSONLがない場合は false を返します。
+func (c *Channels) migrateChannelFiles(oldName, newName string) (bool, error) {
+	c.fileMu.Lock()
+	defer c.fileMu.Unlock()
+
+	oldPath := c.createChannelFilePath(c.createChannelFileName(oldName))
+	newPath := c.createChannelFilePath(c.createChannelFileName(newName))
+	oldLines, err := readLines(oldPath)
+	if err != nil {
+		return false, err
+	}
+	if oldLines == nil {
+		return false, nil
+	}
+
+	oldImageDir := filepath.Join("images", oldName) + string(filepath.Separator)
+	newImageDir := filepath.Join("images", newName) + string(filepath.Separator)
+	oldThumbDir := filepath.Join(ThumbnailDir, oldName) + string(filepath.Separator)
+	newThumbDir := filepath.Join(ThumbnailDir, newName) + string(filepath.Separator)
+	for i, line := range oldLines {
+		entry, err := ParseEntry(line)
+		if err != nil {
+			continue
+		}
+		entry.Channel.Name = newName
+		for j, file := range entry.Files {
+			if rest, ok := strings.CutPrefix(file.Path, oldImageDir); ok {
+				entry.Files[j].Path = newImageDir + rest
+			}
+			for k, v := range file.Variants {
+				if rest, ok := strings.CutPrefix(v.Path, oldThumbDir); ok {
+					entry.Files[j].Variants[k].Path = newThumbDir + rest
+				}
+			}
+		}
+		jsonData, err := json.Marshal(entry)
+		if err != nil {
+			return false, fmt.Errorf("JSON 変換エラー: %w", err)
+		}
+		oldLines[i] = string(jsonData)
+	}
+	newLines, err := readLines(newPath)
+	if err != nil {
+		return false, err
+	}
+	if err := moveDirContents(filepath.Join(c.basedir, oldImageDir), filepath.Join(c.basedir, newImageDir)); err != nil {
+		return false, err
+	}
+	if err := moveDirContents(filepath.Join(c.basedir, oldThumbDir), filepath.Join(c.basedir, newThumbDir)); err != nil {
+		return false, err
+	}
+	if err := moveDirContents(filepath.Join(c.basedir, OriginalImageDir, oldName), filepath.Join(c.basedir, OriginalImageDir, newName)); err != nil {
+		return false, err
+	}
+	if err := writeLines(newPath, append(oldLines, newLines...)); err != nil {
+		return false, err
+	}
+	if err := os.Remove(oldPath); err != nil {
+		return false, fmt.Errorf("ファイル %s の削除に失敗： %w", oldPath, err)
+	}
+
+	oldHTML := filepath.Join(c.basedir, HtmlDir, oldName+".html")
+	if _, err := os.Stat(oldHTML); err == nil {
+		if err := os.Rename(oldHTML, filepath.Join(c.basedir, HtmlDir, newName+".html")); err != nil {
+			return true, fmt.Errorf("HTMLファイルの移行に失敗： %w", err)
+		}
+	}
+	return true, nil
+}
+
+// moveDirContents は src 配下のファイルを dst へ移動し、src を削除します。src がない場合は何もしません。
+func moveDirContents(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if _, err := os.Stat(dst); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Clean(dst)), os.ModePerm); err != nil {
+			return err
+		}
+		return os.Rename(src, dst)
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return fmt.Errorf("添付ファイルの移行に失敗： %w", err)
+		}
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,328 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"google.golang.org/api/drive/v3"
+)
+
+func TestChannelRegistry_ObserveAndState(t *testing.T) {
+	baseDir := t.TempDir()
+	r, err := newChannelRegistry(baseDir)
+	if err != nil {
+		t.Fatalf("newChannelRegistry() error = %v", err)
+	}
+	now := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, renamed := r.Observe("C1", "general", now); renamed {
+		t.Fatal("Observe(first) renamed = true, want false")
+	}
+	if _, renamed := r.Observe("C1", "general", now); renamed {
+		t.Fatal("Observe(same) renamed = true, want false")
+	}
+	previous, renamed := r.Observe("C1", "announce", now)
+	if !renamed || previous != "general" {
+		t.Fatalf("Observe(rename) = %q, %v, want general, true", previous, renamed)
+	}
+	r.SetState("C1", channelStateArchived, now)
+
+	reloaded, err := newChannelRegistry(baseDir)
+	if err != nil {
+		t.Fatalf("newChannelRegistry(reload) error = %v", err)
+	}
+	record, ok := reloaded.Get("C1")
+	if !ok || record.Name != "announce" || record.State != channelStateArchived || !slices.Equal(record.PreviousNames, []string{"general"}) {
+		t.Fatalf("Get(C1) = %+v, %v", record, ok)
+	}
+	if got := reloaded.IDByName("general"); got != "C1" {
+		t.Fatalf("IDByName(previous) = %q, want C1", got)
+	}
+
+	// 過去の名前を別のチャンネルが使っている場合は現在の名前を優先する
+	reloaded.Observe("C2", "general", now)
+	if got := reloaded.IDByName("general"); got != "C2" {
+		t.Fatalf("IDByName(reused) = %q, want C2", got)
+	}
+}
+
+func TestChannels_resolveChannelArg(t *testing.T) {
+	baseDir := t.TempDir()
+	r, err := newChannelRegistry(baseDir)
+	if err != nil {
+		t.Fatalf("newChannelRegistry() error = %v", err)
+	}
+	now := time.Now()
+	r.Observe("C111", "general", now)
+	r.Observe("C111", "announce", now)
+	jsonl := `{"timestamp":"1.0","message":"m","channel":{"id":"C222","name":"dev-team"},"files":[]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "dev-team.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	c := &Channels{basedir: baseDir, registry: r}
+
+	tests := map[string]string{
+		"announce":          "announce",
+		"general":           "announce",
+		"C111":              "announce",
+		"<#C111|general>":   "announce",
+		"<#C999|unknown>":   "unknown",
+		"C222":              "dev-team",
+		"not-registered":    "not-registered",
+		" announce ":        "announce",
+		"<#C222>":           "dev-team",
+		"C999":              "C999",
+		"dev-team":          "dev-team",
+		"<#C111|announce> ": "announce",
+	}
+	for arg, want := range tests {
+		if got := c.resolveChannelArg(arg); got != want {
+			t.Errorf("resolveChannelArg(%q) = %q, want %q", arg, got, want)
+		}
+	}
+}
+
+func TestChannels_RenameChannel_MigratesLocalFilesAndDrive(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	oldLines := `{"timestamp":"1.0","message":"old","channel":{"id":"C1","name":"general"},"files":["images/general/1.0_0.png"]}` + "\n"
+	newLines := `{"timestamp":"2.0","message":"new","channel":{"id":"C1","name":"announce"},"files":["images/announce/2.0_0.png"]}` + "\n"
+	files := map[string]string{
+		"general.jsonl":             oldLines,
+		"announce.jsonl":            newLines,
+		"images/general/1.0_0.png":  "old-image",
+		"images/announce/2.0_0.png": "new-image",
+		"html/general.html":         "<html></html>",
+	}
+	for name, content := range files {
+		p := filepath.Join(baseDir, name)
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	var renamed []string
+	uploaded := ""
+	g := &GDrive{
+		targetDir: &drive.File{Id: "target-dir-id"},
+		renameChannelFn: func(ctx context.Context, oldName, newName string) error {
+			renamed = append(renamed, oldName+"->"+newName)
+			return nil
+		},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return &drive.File{Id: "file-id"}, nil
+		},
+		updateFileFn: func(ctx context.Context, name, id, filePath string) error {
+			uploaded = name
+			return nil
+		},
+	}
+	c := &Channels{basedir: baseDir}
+
+	if err := c.RenameChannel(context.Background(), "general", "announce", g); err != nil {
+		t.Fatalf("RenameChannel() error = %v", err)
+	}
+	if !slices.Equal(renamed, []string{"general->announce"}) || uploaded != "announce.jsonl" {
+		t.Fatalf("drive renamed = %v, uploaded = %q", renamed, uploaded)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "general.jsonl")); !os.IsNotExist(err) {
+		t.Fatalf("old jsonl should be removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "images", "general")); !os.IsNotExist(err) {
+		t.Fatalf("old image dir should be removed: %v", err)
+	}
+	for _, name := range []string{"images/announce/1.0_0.png", "images/announce/2.0_0.png", "html/announce.html"} {
+		if _, err := os.Stat(filepath.Join(baseDir, name)); err != nil {
+			t.Fatalf("%s should exist: %v", name, err)
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(baseDir, "announce.jsonl"))
+	if err != nil {
+		t.Fatalf("read jsonl: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines = %v", lines)
+	}
+	first, err := ParseEntry(lines[0])
+	if err != nil {
+		t.Fatalf("ParseEntry() error = %v", err)
+	}
+	if first.Message != "old" || first.Channel.Name != "announce" || !reflect.DeepEqual(first.Files, []Attachment{{Path: filepath.Join("images", "announce", "1.0_0.png")}}) {
+		t.Fatalf("migrated entry = %+v", first)
+	}
+	if lines[1] != strings.TrimSpace(newLines) {
+		t.Fatalf("second line = %q", lines[1])
+	}
+
+	// 移行済みの場合はローカルのファイルを変更せず、Driveの移行だけを冪等に再実行する
+	renamed = nil
+	if err := c.RenameChannel(context.Background(), "general", "announce", g); err != nil || !slices.Equal(renamed, []string{"general->announce"}) {
+		t.Fatalf("RenameChannel(again) err = %v, renamed = %v", err, renamed)
+	}
+	if b2, err := os.ReadFile(filepath.Join(baseDir, "announce.jsonl")); err != nil || string(b2) != string(b) {
+		t.Fatalf("announce.jsonl should be unchanged: %q, %v", b2, err)
+	}
+}
+
+func TestChannels_observeChannel_DetectsRenameFromEntries(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	jsonl := `{"timestamp":"1.0","message":"m","channel":{"id":"C1","name":"general"},"files":[]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	r, err := newChannelRegistry(baseDir)
+	if err != nil {
+		t.Fatalf("newChannelRegistry() error = %v", err)
+	}
+	c := &Channels{basedir: baseDir, registry: r}
+	g := &GDrive{
+		targetDir: &drive.File{Id: "target-dir-id"},
+		renameChannelFn: func(ctx context.Context, oldName, newName string) error {
+			return nil
+		},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			return nil
+		},
+	}
+
+	if err := c.observeChannel(context.Background(), Channel{ID: "C1", Name: "announce"}, g); err != nil {
+		t.Fatalf("observeChannel() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "announce.jsonl")); err != nil {
+		t.Fatalf("announce.jsonl should exist: %v", err)
+	}
+	if got := c.resolveChannelArg("general"); got != "announce" {
+		t.Fatalf("resolveChannelArg(general) = %q, want announce", got)
+	}
+}
+
+func TestUploadOutbox_RenameChannel(t *testing.T) {
+	baseDir := t.TempDir()
+	o, err := newUploadOutbox(baseDir)
+	if err != nil {
+		t.Fatalf("newUploadOutbox() error = %v", err)
+	}
+	now := time.Now()
+	imagePath := filepath.Join(baseDir, "images", "general", "1.0_0.png")
+	for _, item := range []struct {
+		kind         uploadKind
+		name, parent string
+		path         string
+	}{
+		{uploadKindFile, "general.jsonl", "", filepath.Join(baseDir, "general.jsonl")},
+		{uploadKindHTML, "general.html", "", filepath.Join(baseDir, "html", "general.html")},
+		{uploadKindImage, "1.0_0.png", "general", imagePath},
+		{uploadKindThumbnail, "1.0_0_w320.png", "general", filepath.Join(baseDir, ThumbnailDir, "general", "1.0_0_w320.png")},
+		{uploadKindFile, "random.jsonl", "", filepath.Join(baseDir, "random.jsonl")},
+	} {
+		if err := o.Enqueue(item.kind, item.name, item.parent, item.path, now); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	o.RenameChannel("general", "announce", now)
+
+	if o.Pending() != 3 {
+		t.Fatalf("Pending() = %d, want 3", o.Pending())
+	}
+	image := o.items[0]
+	if image.Parent != "announce" || image.Path != filepath.Join(baseDir, "images", "announce", "1.0_0.png") {
+		t.Fatalf("image item = %+v", image)
+	}
+	thumb := o.items[1]
+	if thumb.Parent != "announce" || thumb.Path != filepath.Join(baseDir, ThumbnailDir, "announce", "1.0_0_w320.png") {
+		t.Fatalf("thumbnail item = %+v", thumb)
+	}
+}
+
+func TestChannels_observeChannel_RetriesFailedRename(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	jsonl := `{"timestamp":"1.0","message":"m","channel":{"id":"C1","name":"雑談"},"files":[]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "雑談.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	r, err := newChannelRegistry(baseDir)
+	if err != nil {
+		t.Fatalf("newChannelRegistry() error = %v", err)
+	}
+	c := &Channels{basedir: baseDir, registry: r}
+	driveErr := errors.New("drive unavailable")
+	var uploaded []string
+	g := &GDrive{
+		targetDir: &drive.File{Id: "target-dir-id"},
+		renameChannelFn: func(ctx context.Context, oldName, newName string) error {
+			return driveErr
+		},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			uploaded = append(uploaded, name)
+			return nil
+		},
+	}
+
+	if err := c.observeChannel(context.Background(), Channel{ID: "C1", Name: "雑談"}, g); err != nil {
+		t.Fatalf("observeChannel(first) error = %v", err)
+	}
+	if err := c.observeChannel(context.Background(), Channel{ID: "C1", Name: "雑談2"}, g); err == nil {
+		t.Fatal("observeChannel(rename) error = nil, want drive failure")
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "雑談2.jsonl")); err != nil {
+		t.Fatalf("non-ASCII rename should migrate the local file: %v", err)
+	}
+	if record, _ := r.Get("C1"); !slices.Equal(record.PendingRenames, []string{"雑談"}) {
+		t.Fatalf("PendingRenames = %v, want the failed rename kept", record.PendingRenames)
+	}
+
+	// 次のイベントで移行を再試行し、成功したら移行待ちから外す
+	driveErr = nil
+	if err := c.observeChannel(context.Background(), Channel{ID: "C1", Name: "雑談2"}, g); err != nil {
+		t.Fatalf("observeChannel(retry) error = %v", err)
+	}
+	reloaded, err := newChannelRegistry(baseDir)
+	if err != nil {
+		t.Fatalf("newChannelRegistry(reload) error = %v", err)
+	}
+	if record, _ := reloaded.Get("C1"); len(record.PendingRenames) != 0 || record.Name != "雑談2" {
+		t.Fatalf("record = %+v, want rename finished", record)
+	}
+	if !slices.Equal(uploaded, []string{"雑談2.jsonl"}) {
+		t.Fatalf("uploaded = %v, want the merged jsonl after the drive rename", uploaded)
+	}
+}
+
+func TestChannels_validateStoredChannelName(t *testing.T) {
+	c := &Channels{basedir: t.TempDir()}
+	for _, name := range []string{"general", "雑談2", "dev_team-1"} {
+		if err := c.validateStoredChannelName(name); err != nil {
+			t.Errorf("validateStoredChannelName(%q) error = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "../etc", `a\b`} {
+		if err := c.validateStoredChannelName(name); err == nil {
+			t.Errorf("validateStoredChannelName(%q) error = nil, want error", name)
+		}
+	}
+}
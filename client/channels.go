@@ -3,8 +3,10 @@ package client
 import (
 	"archive/zip"
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -13,17 +15,48 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Channels はチャンネルデータの管理を行います。
 type Channels struct {
-	basedir        string
-	authorID       string
-	previewFetcher linkPreviewFetchFunc
-	previewCache   *linkPreviewCache
+	basedir   string
+	authorIDs []string
+	// channelAuthorIDs はチャンネルID単位の記録対象ユーザーです。設定されたチャンネルでは authorIDs より優先します。
+	channelAuthorIDs map[string][]string
+	previewFetcher   linkPreviewFetchFunc
+	previewCache     *linkPreviewCache
+	linkArchive      *linkArchive
+	// previewPolicy はリンク先を取得するドメインの制限と robots.txt の確認です。
+	previewPolicy *previewPolicy
+	// previewFetch はリンク先ページを並行して取得する際の制限です。
+	previewFetch LinkPreviewFetchConfig
+	// previewInflight は取得中のURLの結果を同時に生成している処理の間で共有します。
+	previewInflight inflightPreviews
+	// previewMaxPerMessage は1メッセージあたりにプレビューを作成するリンクの上限です。
+	previewMaxPerMessage int
+	nameCache            *slackNameCache
+	customEmoji          *customEmojiStore
+	seenMessages         *seenMessageSet
+	registry             *channelRegistry
+	// deleteAttachments が true の場合、メッセージ削除時に添付ファイルもローカルとDriveから削除します。
+	deleteAttachments bool
+	// imageMetadata は添付画像のメタデータ（EXIF/XMPの位置情報など）の扱いです。
+	imageMetadata imageMetadataMode
+
+	// fileMu はJSONLへの追記と書き換えを直列化します。
+	fileMu sync.Mutex
+	// blobMu は blobs/ への添付ファイルの保存を直列化します。
+	blobMu sync.Mutex
+	// archiveMu はリンク先ページのアーカイブを直列化します。
+	archiveMu sync.Mutex
+	// archiveWG は archiveLinksInBackground が開始したアーカイブを待つためのものです。
+	archiveWG sync.WaitGroup
 }
 
 // MarkdownExportResult は /make-md で生成した成果物の情報です。
@@ -40,27 +73,109 @@ const (
 	maxJSONLLineBytes         = 1024 * 1024
 )
 
-// NewChannels は Channels 構造体の新しいインスタンスを作成します。
-func NewChannels(basedir, authorID string, previewCacheTTL time.Duration, previewCacheMaxEntries int) (*Channels, error) {
-	previewCache, err := newLinkPreviewCache(basedir, previewCacheTTL, previewCacheMaxEntries)
+// NewChannels は設定から Channels 構造体の新しいインスタンスを作成します。
+func NewChannels(config Config) (*Channels, error) {
+	basedir := config.BaseDir
+	previewCache, err := newLinkPreviewCache(basedir, config.linkPreviewCacheTTL(), config.linkPreviewCacheMaxEntries())
 	if err != nil {
 		log.Printf("リンクプレビューキャッシュを無効化して継続: %v", err)
 		previewCache = nil
 	}
+	nameCache, err := newSlackNameCache(basedir, defaultSlackNameCacheTTL)
+	if err != nil {
+		log.Printf("名前キャッシュを無効化して継続: %v", err)
+		nameCache = nil
+	}
+	seenMessages, err := newSeenMessageSet(basedir, defaultSeenMessageLimit)
+	if err != nil {
+		log.Printf("取り込み済みメッセージの重複チェックを無効化して継続: %v", err)
+		seenMessages = nil
+	}
+	registry, err := newChannelRegistry(basedir)
+	if err != nil {
+		log.Printf("チャンネル一覧を無効化して継続: %v", err)
+		registry = nil
+	}
+	var robots *robotsCache
+	if config.LinkPreviewPolicy.RespectRobotsTxt {
+		robots, err = newRobotsCache(basedir)
+		if err != nil {
+			log.Printf("robots.txtの確認を無効化して継続: %v", err)
+			robots = nil
+		}
+	}
+	policy := newPreviewPolicy(config.LinkPreviewPolicy, robots)
+	if robots != nil {
+		robots.getFn = policy.getRobotsResource
+	}
+	var archive *linkArchive
+	if config.LinkArchive {
+		archive, err = newLinkArchive(basedir, policy)
+		if err != nil {
+			log.Printf("リンク先のアーカイブを無効化して継続: %v", err)
+			archive = nil
+		}
+	}
 	return &Channels{
-		basedir:        basedir,
-		authorID:       authorID,
-		previewFetcher: defaultLinkPreviewFetcher,
-		previewCache:   previewCache,
+		basedir:              basedir,
+		authorIDs:            config.authorIDs(),
+		channelAuthorIDs:     config.ChannelAuthorIDs,
+		previewFetcher:       newLinkPreviewFetcher(config.LinkPreviewOEmbedProviders, policy).Fetch,
+		previewPolicy:        policy,
+		previewCache:         previewCache,
+		linkArchive:          archive,
+		previewMaxPerMessage: config.linkPreviewMaxPerMessage(),
+		previewFetch:         config.LinkPreviewFetch,
+		nameCache:            nameCache,
+		customEmoji:          newCustomEmojiStore(basedir),
+		seenMessages:         seenMessages,
+		registry:             registry,
+		deleteAttachments:    config.DeleteAttachmentsOnMessageDelete,
+		imageMetadata:        config.imageMetadataMode(),
 	}, nil
 }
 
+// isAuthor は userID が channelID の記録対象ユーザーかを判定します。
+func (c *Channels) isAuthor(channelID, userID string) bool {
+	if userID == "" {
+		return false
+	}
+	authorIDs := c.authorIDs
+	if perChannel, ok := c.channelAuthorIDs[channelID]; ok {
+		authorIDs = perChannel
+	}
+	return slices.Contains(authorIDs, userID)
+}
+
+// fillLegacyAuthor は投稿者を持たない旧形式のエントリに、記録対象ユーザーが1人だけの場合そのIDを補完します。
+func (c *Channels) fillLegacyAuthor(entries []Entry) []Entry {
+	if len(c.authorIDs) != 1 {
+		return entries
+	}
+	for i := range entries {
+		if entries[i].User == "" {
+			entries[i].User = c.authorIDs[0]
+		}
+	}
+	return entries
+}
+
 func (c *Channels) AppendMessage(ctx context.Context, channelName, jsonstring string, gdrive *GDrive) error {
 	ctx, span := tracer.Start(ctx, "AppendMessage")
 	defer span.End()
 
 	channelFileName := c.createChannelFileName(channelName)
 	filePath := c.createChannelFilePath(channelFileName)
+	if err := c.appendLine(filePath, jsonstring); err != nil {
+		return err
+	}
+	return gdrive.UploadFile(ctx, channelFileName, filePath)
+}
+
+func (c *Channels) appendLine(filePath, line string) error {
+	c.fileMu.Lock()
+	defer c.fileMu.Unlock()
+
 	f, err := os.OpenFile(filePath,
 		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -69,10 +184,394 @@ func (c *Channels) AppendMessage(ctx context.Context, channelName, jsonstring st
 	defer func() {
 		_ = f.Close()
 	}()
-	if _, err := fmt.Fprintf(f, "%s\n", jsonstring); err != nil {
+	if _, err := fmt.Fprintf(f, "%s\n", line); err != nil {
 		return fmt.Errorf("ファイル %s のオープンに失敗： %w", filePath, err)
 	}
-	return gdrive.UploadFile(ctx, channelFileName, filePath)
+	return nil
+}
+
+// UpdateMessage は timestamp が一致するエントリの本文を message に置き換え、編集前の本文を履歴に残します。
+// mentions は編集後の本文に含まれるメンションの名前です。
+// 書き換えが発生しなかった場合（該当エントリなし、本文が同一）は false を返します。
+func (c *Channels) UpdateMessage(ctx context.Context, channelName, timestamp, message string, mentions map[string]string, editedAt string, gdrive *GDrive) (bool, error) {
+	ctx, span := tracer.Start(ctx, "UpdateMessage")
+	defer span.End()
+
+	updated, err := c.rewriteEntries(channelName, func(entry *Entry) bool {
+		if entry.Timestamp != timestamp || entry.Message == message {
+			return false
+		}
+		entry.Revisions = append(entry.Revisions, Revision{
+			Message:  entry.Message,
+			EditedAt: editedAt,
+			Mentions: entry.Mentions,
+		})
+		entry.Message = message
+		entry.Mentions = mentions
+		entry.EditedAt = editedAt
+		return true
+	})
+	if err != nil || !updated {
+		return updated, err
+	}
+	channelFileName := c.createChannelFileName(channelName)
+	return true, gdrive.UploadFile(ctx, channelFileName, c.createChannelFilePath(channelFileName))
+}
+
+// UpdateUnfurls は timestamp が一致するエントリにSlackのunfurlを保存します。
+// 保存済みの内容と同じ場合やエントリがない場合は false を返します。
+func (c *Channels) UpdateUnfurls(ctx context.Context, channelName, timestamp string, unfurls []LinkPreview, gdrive *GDrive) (bool, error) {
+	ctx, span := tracer.Start(ctx, "UpdateUnfurls")
+	defer span.End()
+
+	updated, err := c.rewriteEntries(channelName, func(entry *Entry) bool {
+		if entry.Timestamp != timestamp || entry.IsDeleted() || slices.Equal(entry.Unfurls, unfurls) {
+			return false
+		}
+		entry.Unfurls = unfurls
+		return true
+	})
+	if err != nil || !updated {
+		return updated, err
+	}
+	channelFileName := c.createChannelFileName(channelName)
+	return true, gdrive.UploadFile(ctx, channelFileName, c.createChannelFilePath(channelFileName))
+}
+
+// DeleteMessage は timestamp が一致するエントリを削除済み（tombstone）に書き換えます。
+// 本文と編集履歴は消去され、deleteAttachments が有効な場合は添付ファイルもローカルとDriveから削除します。
+// 書き換えが発生しなかった場合（該当エントリなし、削除済み）は false を返します。
+func (c *Channels) DeleteMessage(ctx context.Context, channelName, timestamp, deletedAt string, gdrive *GDrive) (bool, error) {
+	ctx, span := tracer.Start(ctx, "DeleteMessage")
+	defer span.End()
+
+	var files []Attachment
+	deleted, err := c.rewriteEntries(channelName, func(entry *Entry) bool {
+		if entry.Timestamp != timestamp || entry.IsDeleted() {
+			return false
+		}
+		files = entry.Files
+		tombstone := Entry{
+			Timestamp: entry.Timestamp,
+			Channel:   entry.Channel,
+			DeletedAt: deletedAt,
+		}
+		if !c.deleteAttachments {
+			tombstone.Files = entry.Files
+		}
+		*entry = tombstone
+		return true
+	})
+	if err != nil || !deleted {
+		return deleted, err
+	}
+
+	if c.deleteAttachments {
+		for _, warning := range c.deleteAttachmentFiles(ctx, files, gdrive) {
+			log.Printf("添付ファイル削除をスキップ: %s", warning)
+		}
+	}
+	channelFileName := c.createChannelFileName(channelName)
+	return true, gdrive.UploadFile(ctx, channelFileName, c.createChannelFilePath(channelFileName))
+}
+
+// deleteAttachmentFiles は添付ファイルと縮小版・元画像をローカルとDriveから削除します。
+// blobs/ に保存した添付ファイルは、他のエントリから参照されていない場合だけ削除します。
+func (c *Channels) deleteAttachmentFiles(ctx context.Context, files []Attachment, gdrive *GDrive) []string {
+	warnings := make([]string, 0)
+	for _, file := range files {
+		deleteDriveFile := gdrive.DeleteImageFile
+		if file.Hash != "" {
+			referenced, err := c.blobReferenced(file.Hash)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("reference check failed: %s (%v)", file.Path, err))
+				continue
+			}
+			if referenced {
+				continue
+			}
+			deleteDriveFile = gdrive.DeleteBlobFile
+		}
+		warnings = append(warnings, c.deleteLocalAndDriveFile(ctx, file.Path, deleteDriveFile)...)
+		if original, err := c.safeJoinUnderBase(originalImagePath(file.Path)); err == nil {
+			if err := os.Remove(original); err != nil && !errors.Is(err, os.ErrNotExist) {
+				warnings = append(warnings, fmt.Sprintf("local delete failed: %s (%v)", originalImagePath(file.Path), err))
+			}
+		}
+		for _, v := range file.Variants {
+			warnings = append(warnings, c.deleteLocalAndDriveFile(ctx, v.Path, gdrive.DeleteThumbnailFile)...)
+		}
+	}
+	return warnings
+}
+
+// deleteLocalAndDriveFile は base_dir 配下の rel と、Drive上の同じフォルダ名（チャンネル名やハッシュの先頭2文字）配下のファイルを削除します。
+func (c *Channels) deleteLocalAndDriveFile(ctx context.Context, rel string, deleteDriveFile func(ctx context.Context, name, parent string) error) []string {
+	localPath, err := c.safeJoinUnderBase(rel)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid attachment path: %s (%v)", rel, err)}
+	}
+	warnings := make([]string, 0)
+	if err := os.Remove(localPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		warnings = append(warnings, fmt.Sprintf("local delete failed: %s (%v)", rel, err))
+	}
+	if err := deleteDriveFile(ctx, filepath.Base(localPath), filepath.Base(filepath.Dir(localPath))); err != nil {
+		warnings = append(warnings, fmt.Sprintf("drive delete failed: %s (%v)", rel, err))
+	}
+	return warnings
+}
+
+// rewriteEntries はチャンネルのJSONLを読み込み、update が true を返したエントリだけを書き換えて保存します。
+// パースできない行はそのまま残します。1件でも書き換えた場合に true を返します。
+func (c *Channels) rewriteEntries(channelName string, update func(entry *Entry) bool) (bool, error) {
+	filePath, err := c.safeJoinUnderBase(c.createChannelFileName(channelName))
+	if err != nil {
+		return false, fmt.Errorf("invalid channel path: %w", err)
+	}
+
+	c.fileMu.Lock()
+	defer c.fileMu.Unlock()
+
+	lines, err := readLines(filePath)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for i, line := range lines {
+		entry, err := ParseEntry(line)
+		if err != nil {
+			continue
+		}
+		if !update(&entry) {
+			continue
+		}
+		jsonData, err := json.Marshal(entry)
+		if err != nil {
+			return false, fmt.Errorf("JSON 変換エラー: %w", err)
+		}
+		lines[i] = string(jsonData)
+		changed = true
+	}
+	if !changed {
+		return false, nil
+	}
+	if err := writeLines(filePath, lines); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// markMessageSeen はメッセージを取り込み済みとして登録し、初めて受信したメッセージの場合に true を返します。
+func (c *Channels) markMessageSeen(channelID, timestamp string) bool {
+	if c.seenMessages == nil {
+		return true
+	}
+	return c.seenMessages.MarkIfNew(channelID, timestamp)
+}
+
+// forgetMessageSeen は取り込みに失敗したメッセージの登録を取り消します。
+func (c *Channels) forgetMessageSeen(channelID, timestamp string) {
+	if c.seenMessages == nil {
+		return
+	}
+	c.seenMessages.Forget(channelID, timestamp)
+}
+
+// RemoveDuplicateEntries はJSONL内で timestamp が重複するエントリを、最初の1行だけ残して削除します。
+// パースできない行はそのまま残します。削除した件数を返します。
+func (c *Channels) RemoveDuplicateEntries(ctx context.Context, channelName string, gdrive *GDrive) (int, error) {
+	ctx, span := tracer.Start(ctx, "RemoveDuplicateEntries")
+	defer span.End()
+
+	channelFileName := c.createChannelFileName(channelName)
+	filePath, err := c.safeJoinUnderBase(channelFileName)
+	if err != nil {
+		return 0, fmt.Errorf("invalid channel path: %w", err)
+	}
+
+	removed, err := c.removeDuplicateLines(filePath)
+	if err != nil || removed == 0 {
+		return 0, err
+	}
+	return removed, gdrive.UploadFile(ctx, channelFileName, filePath)
+}
+
+func (c *Channels) removeDuplicateLines(filePath string) (int, error) {
+	c.fileMu.Lock()
+	defer c.fileMu.Unlock()
+
+	lines, err := readLines(filePath)
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[string]bool)
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if entry, err := ParseEntry(line); err == nil {
+			if seen[entry.Timestamp] {
+				continue
+			}
+			seen[entry.Timestamp] = true
+		}
+		kept = append(kept, line)
+	}
+	removed := len(lines) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, writeLines(filePath, kept)
+}
+
+// MergeEntries はJSONLに存在しないタイムスタンプのエントリだけを、タイムスタンプ順になる位置へ挿入します。
+// 既存の行（削除済みエントリやパースできない行を含む）はそのまま残します。追加した件数を返します。
+func (c *Channels) MergeEntries(ctx context.Context, channelName string, entries []Entry, gdrive *GDrive) (int, error) {
+	ctx, span := tracer.Start(ctx, "MergeEntries")
+	defer span.End()
+
+	filePath, err := c.safeJoinUnderBase(c.createChannelFileName(channelName))
+	if err != nil {
+		return 0, fmt.Errorf("invalid channel path: %w", err)
+	}
+
+	c.fileMu.Lock()
+	lines, err := readLines(filePath)
+	if err != nil {
+		c.fileMu.Unlock()
+		return 0, err
+	}
+	existing := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		if entry, err := ParseEntry(line); err == nil {
+			existing[entry.Timestamp] = true
+		}
+	}
+	additions := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		if existing[entry.Timestamp] {
+			continue
+		}
+		existing[entry.Timestamp] = true
+		additions = append(additions, entry)
+	}
+	if len(additions) == 0 {
+		c.fileMu.Unlock()
+		return 0, nil
+	}
+	sort.SliceStable(additions, func(i, j int) bool {
+		return compareSlackTimestamps(additions[i].Timestamp, additions[j].Timestamp) < 0
+	})
+
+	merged := make([]string, 0, len(lines)+len(additions))
+	next := 0
+	for _, line := range lines {
+		if entry, err := ParseEntry(line); err == nil {
+			for next < len(additions) && compareSlackTimestamps(additions[next].Timestamp, entry.Timestamp) < 0 {
+				jsonData, err := json.Marshal(additions[next])
+				if err != nil {
+					c.fileMu.Unlock()
+					return 0, fmt.Errorf("JSON 変換エラー: %w", err)
+				}
+				merged = append(merged, string(jsonData))
+				next++
+			}
+		}
+		merged = append(merged, line)
+	}
+	for ; next < len(additions); next++ {
+		jsonData, err := json.Marshal(additions[next])
+		if err != nil {
+			c.fileMu.Unlock()
+			return 0, fmt.Errorf("JSON 変換エラー: %w", err)
+		}
+		merged = append(merged, string(jsonData))
+	}
+	err = writeLines(filePath, merged)
+	c.fileMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	channelFileName := c.createChannelFileName(channelName)
+	return len(additions), gdrive.UploadFile(ctx, channelFileName, filePath)
+}
+
+// entryTimestamps はチャンネルのJSONLに記録済みのタイムスタンプ（削除済みを含む）を返します。
+// JSONLが存在しない場合は空のマップを返します。
+func (c *Channels) entryTimestamps(channelName string) (map[string]bool, error) {
+	filePath, err := c.safeJoinUnderBase(c.createChannelFileName(channelName))
+	if err != nil {
+		return nil, fmt.Errorf("invalid channel path: %w", err)
+	}
+	c.fileMu.Lock()
+	lines, err := readLines(filePath)
+	c.fileMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	timestamps := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		if entry, err := ParseEntry(line); err == nil {
+			timestamps[entry.Timestamp] = true
+		}
+	}
+	return timestamps, nil
+}
+
+// channelIDByName はチャンネル一覧、または記録済みJSONLのエントリからチャンネルIDを探します。見つからない場合は空文字を返します。
+func (c *Channels) channelIDByName(channelName string) string {
+	if c.registry != nil {
+		if id := c.registry.IDByName(channelName); id != "" {
+			return id
+		}
+	}
+	return c.channelIDFromEntries(channelName)
+}
+
+// channelIDFromEntries は記録済みJSONLのエントリからチャンネルIDを探します。
+func (c *Channels) channelIDFromEntries(channelName string) string {
+	filePath, err := c.safeJoinUnderBase(c.createChannelFileName(channelName))
+	if err != nil {
+		return ""
+	}
+	c.fileMu.Lock()
+	lines, err := readLines(filePath)
+	c.fileMu.Unlock()
+	if err != nil {
+		return ""
+	}
+	for _, line := range lines {
+		if entry, err := ParseEntry(line); err == nil && entry.Channel.ID != "" {
+			return entry.Channel.ID
+		}
+	}
+	return ""
+}
+
+// readLines はファイルを行単位で読み込みます。ファイルが存在しない場合は空を返します。
+func readLines(filePath string) ([]string, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ファイル %s のオープンに失敗： %w", filePath, err)
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n"), nil
+}
+
+// writeLines は一時ファイルへ書き込んでから置き換えることで、行単位のファイルを安全に上書きします。
+func writeLines(filePath string, lines []string) error {
+	tmpPath := filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("ファイル %s の一時保存に失敗： %w", filePath, err)
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return fmt.Errorf("ファイル %s の置換に失敗： %w", filePath, err)
+	}
+	return nil
 }
 
 func (c *Channels) createChannelFilePath(channelFileName string) string {
@@ -135,8 +634,8 @@ func (c *Channels) CreateHtmlFile(ctx context.Context, channelName string, gdriv
 	if err != nil {
 		return err
 	}
-	contents = filterEntriesSince(contents, since)
-	contents = c.attachLinkPreviews(ctx, contents)
+	contents = c.attachCustomEmoji(c.fillMentionNames(c.fillLegacyAuthor(filterEntriesSince(visibleEntries(contents), since))))
+	contents = nestThreadReplies(c.attachLinkPreviews(ctx, c.attachInlineTexts(contents)))
 
 	// テンプレートエンジンに適用
 	values := map[string]interface{}{
@@ -173,7 +672,7 @@ func (c *Channels) CreateHtmlFile(ctx context.Context, channelName string, gdriv
 }
 
 // CreateMarkdownZip はチャンネルのJSONLからMarkdownと添付ファイルZIPを生成します。
-func (c *Channels) CreateMarkdownZip(channelName string, authorID string, since *time.Time) (MarkdownExportResult, error) {
+func (c *Channels) CreateMarkdownZip(ctx context.Context, channelName string, since *time.Time) (MarkdownExportResult, error) {
 	filePath, err := c.safeJoinUnderBase(c.createChannelFileName(channelName))
 	if err != nil {
 		return MarkdownExportResult{}, fmt.Errorf("invalid channel path: %w", err)
@@ -191,7 +690,8 @@ func (c *Channels) CreateMarkdownZip(channelName string, authorID string, since
 	if err != nil {
 		return MarkdownExportResult{}, err
 	}
-	filtered := filterEntriesSince(entries, since)
+	filtered := c.attachCustomEmoji(c.fillMentionNames(c.fillLegacyAuthor(filterEntriesSince(visibleEntries(entries), since))))
+	filtered = c.attachLinkPreviews(ctx, filtered)
 
 	if err := os.MkdirAll(filepath.Join(c.basedir, "exports"), os.ModePerm); err != nil {
 		return MarkdownExportResult{}, fmt.Errorf("エクスポートディレクトリの作成に失敗: %w", err)
@@ -208,7 +708,7 @@ func (c *Channels) CreateMarkdownZip(channelName string, authorID string, since
 	}()
 
 	zw := zip.NewWriter(out)
-	md, err := renderMarkdown(channelName, authorID, filtered, now, since)
+	md, err := renderMarkdown(channelName, filtered, now, since)
 	if err != nil {
 		_ = zw.Close()
 		return MarkdownExportResult{}, err
@@ -237,6 +737,48 @@ func (c *Channels) CreateMarkdownZip(channelName string, authorID string, since
 	}, nil
 }
 
+// visibleEntries は削除済み（tombstone）のエントリを除外します。
+func visibleEntries(entries []Entry) []Entry {
+	visible := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDeleted() {
+			continue
+		}
+		visible = append(visible, entry)
+	}
+	return visible
+}
+
+// nestThreadReplies はスレッド返信を親エントリの Replies にまとめます。
+// 親エントリが含まれない返信はそのままトップレベルに残します。
+func nestThreadReplies(entries []Entry) []Entry {
+	parents := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.IsReply() {
+			parents[entry.Timestamp] = true
+		}
+	}
+	replies := make(map[string][]Entry)
+	for _, entry := range entries {
+		if entry.IsReply() && parents[entry.ThreadTimestamp] {
+			replies[entry.ThreadTimestamp] = append(replies[entry.ThreadTimestamp], entry)
+		}
+	}
+
+	nested := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsReply() {
+			if parents[entry.ThreadTimestamp] {
+				continue
+			}
+		} else {
+			entry.Replies = replies[entry.Timestamp]
+		}
+		nested = append(nested, entry)
+	}
+	return nested
+}
+
 func filterEntriesSince(entries []Entry, since *time.Time) []Entry {
 	if since == nil {
 		return entries
@@ -255,6 +797,13 @@ func filterEntriesSince(entries []Entry, since *time.Time) []Entry {
 	return filtered
 }
 
+// compareSlackTimestamps はSlackのタイムスタンプを時刻として比較します。パースできない値は最も古いものとして扱います。
+func compareSlackTimestamps(a, b string) int {
+	ta, _ := parseEntryTimestamp(a)
+	tb, _ := parseEntryTimestamp(b)
+	return ta.Compare(tb)
+}
+
 func parseEntryTimestamp(raw string) (time.Time, bool) {
 	splits := strings.Split(raw, ".")
 	if len(splits) < 1 {
@@ -281,7 +830,7 @@ func parseEntryTimestamp(raw string) (time.Time, bool) {
 	return time.Unix(sec, nano).UTC(), true
 }
 
-func renderMarkdown(channelName string, authorID string, entries []Entry, generatedAt time.Time, since *time.Time) (string, error) {
+func renderMarkdown(channelName string, entries []Entry, generatedAt time.Time, since *time.Time) (string, error) {
 	var b strings.Builder
 	_, _ = fmt.Fprintf(&b, "# %s\n\n", channelName)
 	_, _ = fmt.Fprintf(&b, "- generated_at_utc: %s\n", generatedAt.Format(time.RFC3339))
@@ -290,41 +839,90 @@ func renderMarkdown(channelName string, authorID string, entries []Entry, genera
 	}
 	_, _ = fmt.Fprintf(&b, "- entries: %d\n\n", len(entries))
 
-	for _, entry := range entries {
-		b.WriteString("## Entry\n\n")
-		_, _ = fmt.Fprintf(&b, "- datetime_utc: %s\n", entry.Timestamp2String())
-		_, _ = fmt.Fprintf(&b, "- author: %s\n\n", authorID)
-		fence := markdownFenceFor(entry.Message)
-		b.WriteString(fence)
-		b.WriteString("\n")
-		b.WriteString(entry.Message)
-		if !strings.HasSuffix(entry.Message, "\n") {
-			b.WriteString("\n")
+	for _, entry := range nestThreadReplies(entries) {
+		writeMarkdownEntry(&b, 2, "Entry", entry)
+		for _, reply := range entry.Replies {
+			writeMarkdownEntry(&b, 3, "Reply", reply)
 		}
-		b.WriteString(fence)
-		b.WriteString("\n\n")
 	}
 
 	return b.String(), nil
 }
 
-func markdownFenceFor(message string) string {
-	maxTicks := 0
-	current := 0
-	for _, r := range message {
-		if r == '`' {
-			current++
-			if current > maxTicks {
-				maxTicks = current
-			}
-			continue
+// writeMarkdownEntry は level で指定した見出しレベルで1エントリ分のMarkdownを書き込みます。
+func writeMarkdownEntry(b *strings.Builder, level int, title string, entry Entry) {
+	_, _ = fmt.Fprintf(b, "%s %s\n\n", strings.Repeat("#", level), title)
+	_, _ = fmt.Fprintf(b, "- datetime_utc: %s\n", entry.Timestamp2String())
+	_, _ = fmt.Fprintf(b, "- author: %s\n", entry.AuthorName())
+	if entry.UserName != "" && entry.User != "" {
+		_, _ = fmt.Fprintf(b, "- author_id: %s\n", entry.User)
+	}
+	if entry.IsReply() {
+		_, _ = fmt.Fprintf(b, "- thread_parent_utc: %s\n", entry.ThreadTimestamp2String())
+	}
+	if entry.IsEdited() {
+		_, _ = fmt.Fprintf(b, "- edited_at_utc: %s\n", entry.EditedAt2String())
+	}
+	b.WriteString("\n")
+	writeMarkdownBody(b, entry.Message, entry.Mentions, entry.customEmoji)
+	writeMarkdownAttachments(b, entry.Files)
+	writeMarkdownLinks(b, entry.Links)
+
+	if len(entry.Revisions) > 0 {
+		_, _ = fmt.Fprintf(b, "%s History\n\n", strings.Repeat("#", level+1))
+		for _, rev := range entry.Revisions {
+			_, _ = fmt.Fprintf(b, "- replaced_at_utc: %s\n\n", rev.EditedAt2String())
+			writeMarkdownBody(b, rev.Message, rev.Mentions, rev.customEmoji)
 		}
-		current = 0
 	}
-	if maxTicks < 3 {
-		return "```text"
+}
+
+func writeMarkdownBody(b *strings.Builder, message string, names map[string]string, emoji map[string]customEmoji) {
+	body := mrkdwnToMarkdown(message, names, emoji)
+	if body == "" {
+		return
+	}
+	b.WriteString(body)
+	b.WriteString("\n\n")
+}
+
+// writeMarkdownLinks はリンクプレビューをタイトル付きのリンクの一覧として書き込みます。
+func writeMarkdownLinks(b *strings.Builder, links []EntryLink) {
+	if len(links) == 0 {
+		return
+	}
+	for _, link := range links {
+		title := link.URL
+		if link.Preview != nil {
+			title = firstNonEmpty(link.Preview.Title, link.URL)
+		}
+		_, _ = fmt.Fprintf(b, "- [%s](<%s>)", escapeMarkdownText(title, false), strings.ReplaceAll(link.URL, ">", "%3E"))
+		switch {
+		case link.Preview != nil && link.Preview.SiteName != "":
+			_, _ = fmt.Fprintf(b, " - %s", escapeMarkdownText(link.Preview.SiteName, false))
+		case link.Dead:
+			b.WriteString(" - dead link")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+}
+
+// writeMarkdownAttachments は添付ファイルをzip内 attachments/ 配下へのリンクとして書き込みます。
+// 画像は代替テキスト付きの画像として、それ以外はファイル名のリンクとして出力します。
+func writeMarkdownAttachments(b *strings.Builder, files []Attachment) {
+	for _, file := range files {
+		target := "attachments/" + file.ArchivePath()
+		if file.IsImage() {
+			_, _ = fmt.Fprintf(b, "![%s](<%s>)\n\n", escapeMarkdownText(file.Alt(), false), target)
+			continue
+		}
+		_, _ = fmt.Fprintf(b, "[%s](<%s>)", escapeMarkdownText(file.DisplayName(), false), target)
+		if size := file.SizeString(); size != "" {
+			_, _ = fmt.Fprintf(b, " (%s)", size)
+		}
+		b.WriteString("\n\n")
 	}
-	return strings.Repeat("`", maxTicks+1) + "text"
 }
 
 func (c *Channels) addAttachmentsToZip(zw *zip.Writer, entries []Entry) ([]string, int, int) {
@@ -334,53 +932,77 @@ func (c *Channels) addAttachmentsToZip(zw *zip.Writer, entries []Entry) ([]strin
 	failedCount := 0
 
 	for _, entry := range entries {
-		for _, rel := range entry.Files {
-			normalized := filepath.Clean(rel)
-			if filepath.IsAbs(normalized) || normalized == ".." || strings.HasPrefix(normalized, ".."+string(filepath.Separator)) {
-				warnings = append(warnings, fmt.Sprintf("skip invalid attachment path: %s", rel))
-				failedCount++
-				continue
-			}
-			if seen[normalized] {
-				continue
-			}
-			seen[normalized] = true
-
-			srcPath, err := c.safeJoinUnderBase(normalized)
-			if err != nil {
-				warnings = append(warnings, fmt.Sprintf("attachment path resolution failed: %s (%v)", rel, err))
-				failedCount++
-				continue
-			}
-			src, err := os.Open(srcPath)
-			if err != nil {
-				warnings = append(warnings, fmt.Sprintf("attachment open failed: %s (%v)", rel, err))
-				failedCount++
-				continue
-			}
-
-			archivePath := path.Join("attachments", filepath.ToSlash(normalized))
-			dst, err := zw.Create(archivePath)
+		for _, file := range entry.Files {
+			added, err := c.addFileToZip(zw, file.Path, file.ArchivePath(), seen)
 			if err != nil {
-				_ = src.Close()
-				warnings = append(warnings, fmt.Sprintf("attachment zip entry failed: %s (%v)", rel, err))
+				warnings = append(warnings, err.Error())
 				failedCount++
 				continue
 			}
-			if _, err := io.Copy(dst, src); err != nil {
-				_ = src.Close()
-				warnings = append(warnings, fmt.Sprintf("attachment copy failed: %s (%v)", rel, err))
-				failedCount++
-				continue
+			if added {
+				successCount++
 			}
-			_ = src.Close()
-			successCount++
+		}
+	}
+	// カスタム絵文字の画像は添付ファイルの件数には含めない
+	for _, rel := range customEmojiFiles(entries) {
+		if _, err := c.addFileToZip(zw, rel, filepath.ToSlash(rel), seen); err != nil {
+			warnings = append(warnings, err.Error())
 		}
 	}
 
 	return warnings, successCount, failedCount
 }
 
+// addFileToZip は base_dir 配下の rel を attachments/<archiveRel> として追加します。追加済みの場合は false を返します。
+func (c *Channels) addFileToZip(zw *zip.Writer, rel, archiveRel string, seen map[string]bool) (bool, error) {
+	normalized := filepath.Clean(rel)
+	if filepath.IsAbs(normalized) || normalized == ".." || strings.HasPrefix(normalized, ".."+string(filepath.Separator)) {
+		return false, fmt.Errorf("skip invalid attachment path: %s", rel)
+	}
+	// 同じ内容の添付ファイルも、元のファイル名が異なれば別の名前で格納する
+	if seen[archiveRel] {
+		return false, nil
+	}
+	seen[archiveRel] = true
+
+	srcPath, err := c.safeJoinUnderBase(normalized)
+	if err != nil {
+		return false, fmt.Errorf("attachment path resolution failed: %s (%v)", rel, err)
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return false, fmt.Errorf("attachment open failed: %s (%v)", rel, err)
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	var content io.Reader = src
+	if c.imageMetadata.strips() && isStrippableImagePath(normalized) {
+		// メタデータ除去の設定前に保存した画像もあるため、zipに入れる時点でも取り除く
+		b, err := io.ReadAll(src)
+		if err != nil {
+			return false, fmt.Errorf("attachment read failed: %s (%v)", rel, err)
+		}
+		stripped, err := stripImageMetadata(b)
+		if err != nil {
+			return false, fmt.Errorf("attachment metadata strip failed: %s (%v)", rel, err)
+		}
+		content = bytes.NewReader(stripped)
+	}
+
+	archivePath := path.Join("attachments", archiveRel)
+	dst, err := zw.Create(archivePath)
+	if err != nil {
+		return false, fmt.Errorf("attachment zip entry failed: %s (%v)", rel, err)
+	}
+	if _, err := io.Copy(dst, content); err != nil {
+		return false, fmt.Errorf("attachment copy failed: %s (%v)", rel, err)
+	}
+	return true, nil
+}
+
 func (c *Channels) safeJoinUnderBase(relPath string) (string, error) {
 	cleaned := filepath.Clean(relPath)
 	if filepath.IsAbs(cleaned) {
@@ -427,12 +1049,88 @@ func parseEntriesFromJSONL(r io.Reader) ([]Entry, error) {
 }
 
 // Entry jsonlファイルのデータ読み込み用構造体
+// User は投稿者のユーザーID、UserName は記録時点の表示名です。
+// ThreadTimestamp はスレッド返信の場合の親メッセージの ts、Replies は表示用に親の下へまとめた返信です。
+// Mentions は本文中のメンションのID（ユーザー・チャンネル・ユーザーグループ）から記録時点の名前への対応です。
+// Unfurls はSlackがメッセージ内のリンクを展開したプレビューで、Previews の作成時にページの取得より優先します。
+// DeadLinks はプレビューの取得に失敗し続けてリンク切れとみなしたリンク、BlockedLinks は設定や robots.txt でプレビューを作成しなかったリンクです。
+// Links はプレビューの対象にしたリンクの全件で、プレビューを作成できなかったリンクも含みます。
 type Entry struct {
-	Timestamp string       `json:"timestamp"`
-	Message   string       `json:"message"`
-	Channel   Channel      `json:"channel"`
-	Files     []string     `json:"files"`
-	Preview   *LinkPreview `json:"-"`
+	Timestamp       string            `json:"timestamp"`
+	Message         string            `json:"message"`
+	Mentions        map[string]string `json:"mentions,omitempty"`
+	Channel         Channel           `json:"channel"`
+	User            string            `json:"user,omitempty"`
+	UserName        string            `json:"user_name,omitempty"`
+	Files           []Attachment      `json:"files"`
+	ThreadTimestamp string            `json:"thread_ts,omitempty"`
+	EditedAt        string            `json:"edited_at,omitempty"`
+	Revisions       []Revision        `json:"revisions,omitempty"`
+	DeletedAt       string            `json:"deleted_at,omitempty"`
+	Unfurls         []LinkPreview     `json:"unfurls,omitempty"`
+	Previews        []LinkPreview     `json:"-"`
+	DeadLinks       []DeadLink        `json:"-"`
+	BlockedLinks    []BlockedLink     `json:"-"`
+	Links           []EntryLink       `json:"-"`
+	Archive         *ArchivedPage     `json:"-"`
+	Replies         []Entry           `json:"-"`
+
+	// customEmoji は出力時に設定する描画用のカスタム絵文字です。
+	customEmoji map[string]customEmoji
+}
+
+// Revision は編集によって置き換えられる前のメッセージ本文とメンションの名前です。
+type Revision struct {
+	Message  string            `json:"message"`
+	EditedAt string            `json:"edited_at"`
+	Mentions map[string]string `json:"mentions,omitempty"`
+
+	customEmoji map[string]customEmoji
+}
+
+// HasMultiplePreviews はリンクプレビューが複数あるかを判定する。
+func (e Entry) HasMultiplePreviews() bool {
+	return len(e.Previews) > 1
+}
+
+// IsEdited はメッセージが編集済みかを判定する。
+func (e Entry) IsEdited() bool {
+	return e.EditedAt != ""
+}
+
+// AuthorName は投稿者の表示名を返す。表示名がない場合はユーザーIDを返す。
+func (e Entry) AuthorName() string {
+	return firstNonEmpty(e.UserName, e.User)
+}
+
+// IsReply はスレッド返信かを判定する。
+func (e Entry) IsReply() bool {
+	return e.ThreadTimestamp != "" && e.ThreadTimestamp != e.Timestamp
+}
+
+// ThreadTimestamp2String スレッドの親メッセージの日時を文字列に成形
+func (e Entry) ThreadTimestamp2String() string {
+	return slackTimestampToString(e.ThreadTimestamp)
+}
+
+// IsDeleted はメッセージが削除済み（tombstone）かを判定する。
+func (e Entry) IsDeleted() bool {
+	return e.DeletedAt != ""
+}
+
+// EditedAt2String 最終編集日時を文字列に成形
+func (e Entry) EditedAt2String() string {
+	return slackTimestampToString(e.EditedAt)
+}
+
+// MessageWithLinkTag 編集前の本文に含まれるリンクをHTMLタグに変換
+func (r Revision) MessageWithLinkTag() template.HTML {
+	return mrkdwnToHTML(r.Message, r.Mentions, r.customEmoji)
+}
+
+// EditedAt2String この本文が置き換えられた日時を文字列に成形
+func (r Revision) EditedAt2String() string {
+	return slackTimestampToString(r.EditedAt)
 }
 
 // Channel はメッセージが投稿されたチャンネル情報です。
@@ -444,23 +1142,9 @@ type Channel struct {
 // Slack形式のリンクトークン(<https://example.com|label>)を抽出する。
 var slackLinkTokenRe = regexp.MustCompile(`<https?://[^>\s]+(?:\|[^>\n]+)?>`)
 
-// MessageWithLinkTag メッセージに含まれるリンクをHTMLタグに変換
+// MessageWithLinkTag メッセージのmrkdwn（リンク・太字・コードブロック・引用・リストなど）をHTMLに変換
 func (e Entry) MessageWithLinkTag() template.HTML {
-	matches := slackLinkTokenRe.FindAllStringIndex(e.Message, -1)
-	if len(matches) == 0 {
-		return template.HTML(template.HTMLEscapeString(e.Message))
-	}
-
-	var b strings.Builder
-	last := 0
-	for _, match := range matches {
-		b.WriteString(template.HTMLEscapeString(e.Message[last:match[0]]))
-		token := e.Message[match[0]:match[1]]
-		b.WriteString(slackLinkTokenToHTML(token))
-		last = match[1]
-	}
-	b.WriteString(template.HTMLEscapeString(e.Message[last:]))
-	return template.HTML(b.String())
+	return mrkdwnToHTML(e.Message, e.Mentions, e.customEmoji)
 }
 
 // LinkURLs はメッセージ中のSlackリンクトークンからURLを抽出する。
@@ -501,19 +1185,6 @@ func (e Entry) IsLinkOnlyMessage() bool {
 	return strings.TrimSpace(message[last:]) == ""
 }
 
-func slackLinkTokenToHTML(token string) string {
-	parsed := parseSlackLinkToken(token)
-	linkText := parsed.URL
-	if strings.TrimSpace(parsed.Label) != "" {
-		linkText = parsed.Label
-	}
-	return fmt.Sprintf(
-		"<a href=\"%s\" target=\"_blank\" rel=\"noopener noreferrer\">%s</a>",
-		template.HTMLEscapeString(parsed.URL),
-		template.HTMLEscapeString(linkText),
-	)
-}
-
 type slackLinkToken struct {
 	URL   string
 	Label string
@@ -530,7 +1201,11 @@ func parseSlackLinkToken(token string) slackLinkToken {
 
 // Timestamp2String Slackから取得した日付データを文字列に成形
 func (e Entry) Timestamp2String() string {
-	splits := strings.Split(e.Timestamp, ".")
+	return slackTimestampToString(e.Timestamp)
+}
+
+func slackTimestampToString(raw string) string {
+	splits := strings.Split(raw, ".")
 	if len(splits) < 2 {
 		return ""
 	}
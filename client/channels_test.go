@@ -5,9 +5,11 @@ import (
 	"context"
 	"fmt"
 	"html/template"
+	"io"
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -21,7 +23,7 @@ func TestEntry_MessageWithLinkTag(t *testing.T) {
 		Timestamp string
 		Message   string
 		Channel   Channel
-		Files     []string
+		Files     []Attachment
 	}
 	tests := []struct {
 		name   string
@@ -119,7 +121,7 @@ func TestParseEntry(t *testing.T) {
 					ID:   "C123",
 					Name: "general",
 				},
-				Files: []string{"a.png"},
+				Files: []Attachment{{Path: "a.png"}},
 			},
 			wantErr: false,
 		},
@@ -133,7 +135,7 @@ func TestParseEntry(t *testing.T) {
 					ID:   "C999",
 					Name: "legacy",
 				},
-				Files: []string{"a.png"},
+				Files: []Attachment{{Path: "a.png"}},
 			},
 			wantErr: false,
 		},
@@ -147,7 +149,21 @@ func TestParseEntry(t *testing.T) {
 					ID:   "C123",
 					Name: "general",
 				},
-				Files: []string{"a.png"},
+				Files: []Attachment{{Path: "a.png"}},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "thread reply",
+			jsonl: `{"timestamp":"1633024800.223456","message":"reply","channel":{"id":"C123","name":"general"},"files":null,"thread_ts":"1633024800.123456"}`,
+			want: Entry{
+				Timestamp: "1633024800.223456",
+				Message:   "reply",
+				Channel: Channel{
+					ID:   "C123",
+					Name: "general",
+				},
+				ThreadTimestamp: "1633024800.123456",
 			},
 			wantErr: false,
 		},
@@ -242,7 +258,7 @@ func TestRenderMarkdown_PreserveNewlinesAndBackticks(t *testing.T) {
 		Timestamp: "1775001600.123456",
 		Message:   "line1\n```go\nfmt.Println(\"x\")\n```\nline2",
 	}
-	md, err := renderMarkdown("general", "U123", []Entry{entry}, now, nil)
+	md, err := renderMarkdown("general", []Entry{entry}, now, nil)
 	if err != nil {
 		t.Fatalf("renderMarkdown() error = %v", err)
 	}
@@ -270,7 +286,7 @@ func TestCreateMarkdownZip_IncludesIndexAndAttachments(t *testing.T) {
 		t.Fatalf("write image: %v", err)
 	}
 
-	result, err := c.CreateMarkdownZip("general", "U123", nil)
+	result, err := c.CreateMarkdownZip(context.Background(), "general", nil)
 	if err != nil {
 		t.Fatalf("CreateMarkdownZip() error = %v", err)
 	}
@@ -310,7 +326,7 @@ func TestCreateMarkdownZip_MissingAttachmentContinues(t *testing.T) {
 		t.Fatalf("write jsonl: %v", err)
 	}
 
-	result, err := c.CreateMarkdownZip("general", "U123", nil)
+	result, err := c.CreateMarkdownZip(context.Background(), "general", nil)
 	if err != nil {
 		t.Fatalf("CreateMarkdownZip() error = %v", err)
 	}
@@ -366,3 +382,558 @@ func TestCreateHtmlFile_WithSinceFiltersEntries(t *testing.T) {
 		t.Fatalf("CreateHtmlFile() output missing new message")
 	}
 }
+
+func TestChannels_UpdateMessage_RecordsRevision(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	jsonl := strings.Join([]string{
+		`{"timestamp":"1775001600.000001","message":"helo","channel":{"id":"C1","name":"general"},"files":[]}`,
+		`not-json`,
+		`{"timestamp":"1775001600.000002","message":"other","channel":{"id":"C1","name":"general"},"files":[]}`,
+	}, "\n") + "\n"
+	filePath := filepath.Join(baseDir, "general.jsonl")
+	if err := os.WriteFile(filePath, []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+
+	uploads := 0
+	g := &GDrive{
+		targetDir: &drive.File{Id: "target-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return &drive.File{Id: "file-id"}, nil
+		},
+		updateFileFn: func(ctx context.Context, name, id, filePath string) error {
+			uploads++
+			return nil
+		},
+	}
+
+	updated, err := c.UpdateMessage(context.Background(), "general", "1775001600.000001", "hello", nil, "1775001700.000000", g)
+	if err != nil {
+		t.Fatalf("UpdateMessage() error = %v", err)
+	}
+	if !updated {
+		t.Fatal("UpdateMessage() updated = false, want true")
+	}
+	if uploads != 1 {
+		t.Fatalf("upload calls = %d, want 1", uploads)
+	}
+
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("read jsonl: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 3 || lines[1] != "not-json" {
+		t.Fatalf("unexpected lines after rewrite: %q", lines)
+	}
+	entries, err := parseEntriesFromJSONL(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatalf("parseEntriesFromJSONL() error = %v", err)
+	}
+	if entries[0].Message != "hello" || !entries[0].IsEdited() {
+		t.Fatalf("entry[0] = %+v, want edited hello", entries[0])
+	}
+	want := []Revision{{Message: "helo", EditedAt: "1775001700.000000"}}
+	if !reflect.DeepEqual(entries[0].Revisions, want) {
+		t.Fatalf("entry[0].Revisions = %+v, want %+v", entries[0].Revisions, want)
+	}
+	if entries[1].IsEdited() {
+		t.Fatalf("entry[1] should not be edited: %+v", entries[1])
+	}
+}
+
+func TestChannels_UpdateMessage_NotFound(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	jsonl := `{"timestamp":"1775001600.000001","message":"hello","channel":{"id":"C1","name":"general"},"files":[]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	g := &GDrive{
+		updateFileFn: func(ctx context.Context, name, id, filePath string) error {
+			t.Fatal("upload should not be called when nothing is updated")
+			return nil
+		},
+	}
+
+	for _, ts := range []string{"1775001600.999999", "1775001600.000001"} {
+		updated, err := c.UpdateMessage(context.Background(), "general", ts, "hello", nil, "1775001700.000000", g)
+		if err != nil {
+			t.Fatalf("UpdateMessage(%s) error = %v", ts, err)
+		}
+		if updated {
+			t.Fatalf("UpdateMessage(%s) updated = true, want false", ts)
+		}
+	}
+}
+
+func TestChannels_UpdateUnfurls(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	jsonl := `{"timestamp":"1775001600.000001","message":"<https://example.com>","channel":{"id":"C1","name":"general"},"files":[]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	uploads := 0
+	g := &GDrive{
+		targetDir: &drive.File{Id: "target-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return &drive.File{Id: "file-id"}, nil
+		},
+		updateFileFn: func(ctx context.Context, name, id, filePath string) error {
+			uploads++
+			return nil
+		},
+	}
+	unfurls := []LinkPreview{{URL: "https://example.com", Title: "Example", SiteName: "Example Site"}}
+
+	for i, want := range []bool{true, false} {
+		updated, err := c.UpdateUnfurls(context.Background(), "general", "1775001600.000001", unfurls, g)
+		if err != nil || updated != want {
+			t.Fatalf("UpdateUnfurls() call %d = %v, %v, want %v", i, updated, err, want)
+		}
+	}
+	if uploads != 1 {
+		t.Fatalf("upload calls = %d, want 1", uploads)
+	}
+	entries := readTestEntries(t, baseDir, "general")
+	if !slices.Equal(entries[0].Unfurls, unfurls) {
+		t.Fatalf("Unfurls = %+v, want %+v", entries[0].Unfurls, unfurls)
+	}
+}
+
+func TestRenderMarkdown_EditedEntryIncludesHistory(t *testing.T) {
+	now := time.Date(2026, 4, 9, 12, 0, 0, 0, time.UTC)
+	entry := Entry{
+		Timestamp: "1775001600.123456",
+		Message:   "hello",
+		EditedAt:  "1775001700.000000",
+		Revisions: []Revision{{Message: "helo", EditedAt: "1775001700.000000"}},
+	}
+	md, err := renderMarkdown("general", []Entry{entry}, now, nil)
+	if err != nil {
+		t.Fatalf("renderMarkdown() error = %v", err)
+	}
+	if !strings.Contains(md, "- edited_at_utc: 2026-04-01 00:01:40") {
+		t.Fatalf("renderMarkdown() missing edited marker: %s", md)
+	}
+	if !strings.Contains(md, "### History") || !strings.Contains(md, "helo") {
+		t.Fatalf("renderMarkdown() missing history: %s", md)
+	}
+}
+
+func TestRenderMarkdown_IncludesLinkList(t *testing.T) {
+	now := time.Date(2026, 4, 9, 12, 0, 0, 0, time.UTC)
+	entry := Entry{
+		Timestamp: "1775001600.123456",
+		Message:   "great read <https://a.example> and <https://b.example> <https://gone.example> <https://denied.example>",
+		Links: []EntryLink{
+			{URL: "https://a.example", Preview: &LinkPreview{URL: "https://a.example/", Title: "A [draft]", SiteName: "Example"}},
+			{URL: "https://b.example", Preview: &LinkPreview{URL: "https://b.example"}},
+			{URL: "https://gone.example", Dead: true},
+			{URL: "https://denied.example"},
+		},
+	}
+	md, err := renderMarkdown("general", []Entry{entry}, now, nil)
+	if err != nil {
+		t.Fatalf("renderMarkdown() error = %v", err)
+	}
+	want := "- [A \\[draft\\]](<https://a.example>) - Example\n- [https://b.example](<https://b.example>)\n" +
+		"- [https://gone.example](<https://gone.example>) - dead link\n- [https://denied.example](<https://denied.example>)\n\n"
+	if !strings.Contains(md, want) {
+		t.Fatalf("renderMarkdown() missing link list %q:\n%s", want, md)
+	}
+}
+
+func TestCreateHtmlFile_ShowsEditedMarkerAndHistory(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	jsonl := `{"timestamp":"1775088000.000000","message":"new-text","channel":{"id":"C1","name":"general"},"files":[],"edited_at":"1775088100.000000","revisions":[{"message":"old-text","edited_at":"1775088100.000000"}]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	g := &GDrive{
+		htmlDir: &drive.File{Id: "html-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			return nil
+		},
+	}
+
+	if err := c.CreateHtmlFile(context.Background(), "general", g, nil); err != nil {
+		t.Fatalf("CreateHtmlFile() error = %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(baseDir, "html", "general.html"))
+	if err != nil {
+		t.Fatalf("read html: %v", err)
+	}
+	got := string(b)
+	for _, want := range []string{"new-text", "(edited", "history (1)", "old-text"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("CreateHtmlFile() output missing %q", want)
+		}
+	}
+}
+
+func TestChannels_DeleteMessage_WritesTombstone(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	tests := []struct {
+		name              string
+		deleteAttachments bool
+		wantFiles         []Attachment
+		wantImageExists   bool
+		wantDriveDeletes  int
+	}{
+		{name: "keep attachments", deleteAttachments: false, wantFiles: []Attachment{{Path: "images/general/1775001600.000001_0.png"}}, wantImageExists: true, wantDriveDeletes: 0},
+		{name: "delete attachments", deleteAttachments: true, wantFiles: nil, wantImageExists: false, wantDriveDeletes: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseDir := t.TempDir()
+			c := &Channels{basedir: baseDir, deleteAttachments: tt.deleteAttachments}
+			jsonl := `{"timestamp":"1775001600.000001","message":"secret","channel":{"id":"C1","name":"general"},"files":["images/general/1775001600.000001_0.png"],"revisions":[{"message":"secre","edited_at":"1775001650.000000"}]}` + "\n"
+			filePath := filepath.Join(baseDir, "general.jsonl")
+			if err := os.WriteFile(filePath, []byte(jsonl), 0644); err != nil {
+				t.Fatalf("write jsonl: %v", err)
+			}
+			imgPath := filepath.Join(baseDir, "images", "general", "1775001600.000001_0.png")
+			if err := os.MkdirAll(filepath.Dir(imgPath), os.ModePerm); err != nil {
+				t.Fatalf("mkdir image dir: %v", err)
+			}
+			if err := os.WriteFile(imgPath, []byte("png-data"), 0644); err != nil {
+				t.Fatalf("write image: %v", err)
+			}
+
+			uploads := 0
+			driveDeletes := 0
+			g := &GDrive{
+				targetDir: &drive.File{Id: "target-dir-id"},
+				getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+					return &drive.File{Id: "file-id"}, nil
+				},
+				updateFileFn: func(ctx context.Context, name, id, filePath string) error {
+					uploads++
+					return nil
+				},
+				deleteImageFileFn: func(ctx context.Context, name, parent string) error {
+					driveDeletes++
+					if name != "1775001600.000001_0.png" || parent != "general" {
+						t.Fatalf("DeleteImageFile(%q, %q) unexpected args", name, parent)
+					}
+					return nil
+				},
+			}
+
+			deleted, err := c.DeleteMessage(context.Background(), "general", "1775001600.000001", "1775001700.000000", g)
+			if err != nil {
+				t.Fatalf("DeleteMessage() error = %v", err)
+			}
+			if !deleted {
+				t.Fatal("DeleteMessage() deleted = false, want true")
+			}
+			if uploads != 1 {
+				t.Fatalf("upload calls = %d, want 1", uploads)
+			}
+			if driveDeletes != tt.wantDriveDeletes {
+				t.Fatalf("drive delete calls = %d, want %d", driveDeletes, tt.wantDriveDeletes)
+			}
+			if _, err := os.Stat(imgPath); (err == nil) != tt.wantImageExists {
+				t.Fatalf("image exists = %v, want %v", err == nil, tt.wantImageExists)
+			}
+
+			b, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatalf("read jsonl: %v", err)
+			}
+			if strings.Contains(string(b), "secre") {
+				t.Fatalf("tombstone still contains message body: %s", b)
+			}
+			entries, err := parseEntriesFromJSONL(strings.NewReader(string(b)))
+			if err != nil {
+				t.Fatalf("parseEntriesFromJSONL() error = %v", err)
+			}
+			if len(entries) != 1 || !entries[0].IsDeleted() {
+				t.Fatalf("entries = %+v, want one tombstone", entries)
+			}
+			if !reflect.DeepEqual(entries[0].Files, tt.wantFiles) {
+				t.Fatalf("tombstone files = %v, want %v", entries[0].Files, tt.wantFiles)
+			}
+
+			again, err := c.DeleteMessage(context.Background(), "general", "1775001600.000001", "1775001800.000000", g)
+			if err != nil {
+				t.Fatalf("DeleteMessage(again) error = %v", err)
+			}
+			if again {
+				t.Fatal("DeleteMessage(again) deleted = true, want false")
+			}
+		})
+	}
+}
+
+func TestCreateMarkdownZip_HidesDeletedEntries(t *testing.T) {
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+
+	jsonl := strings.Join([]string{
+		`{"timestamp":"1775001600.000001","message":"","channel":{"id":"C1","name":"general"},"files":["images/general/a.png"],"deleted_at":"1775001700.000000"}`,
+		`{"timestamp":"1775001600.000002","message":"alive","channel":{"id":"C1","name":"general"},"files":[]}`,
+	}, "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+
+	result, err := c.CreateMarkdownZip(context.Background(), "general", nil)
+	if err != nil {
+		t.Fatalf("CreateMarkdownZip() error = %v", err)
+	}
+	if result.EntryCount != 1 {
+		t.Fatalf("CreateMarkdownZip() EntryCount = %d, want 1", result.EntryCount)
+	}
+	if result.AttachmentFailed != 0 {
+		t.Fatalf("CreateMarkdownZip() AttachmentFailed = %d, want 0 (deleted entry attachments must be skipped)", result.AttachmentFailed)
+	}
+}
+
+func TestNestThreadReplies(t *testing.T) {
+	entries := []Entry{
+		{Timestamp: "100.000001", Message: "parent"},
+		{Timestamp: "100.000002", Message: "reply1", ThreadTimestamp: "100.000001"},
+		{Timestamp: "100.000003", Message: "other"},
+		{Timestamp: "100.000004", Message: "reply2", ThreadTimestamp: "100.000001"},
+		{Timestamp: "100.000005", Message: "orphan", ThreadTimestamp: "99.000000"},
+	}
+
+	got := nestThreadReplies(entries)
+	if len(got) != 3 {
+		t.Fatalf("nestThreadReplies() len = %d, want 3", len(got))
+	}
+	if got[0].Message != "parent" || len(got[0].Replies) != 2 {
+		t.Fatalf("parent = %+v, want 2 replies", got[0])
+	}
+	if got[0].Replies[0].Message != "reply1" || got[0].Replies[1].Message != "reply2" {
+		t.Fatalf("replies order = %+v", got[0].Replies)
+	}
+	if got[1].Message != "other" || len(got[1].Replies) != 0 {
+		t.Fatalf("entry[1] = %+v, want other without replies", got[1])
+	}
+	if got[2].Message != "orphan" || !got[2].IsReply() {
+		t.Fatalf("entry[2] = %+v, want orphan reply kept at top level", got[2])
+	}
+}
+
+func TestRenderMarkdown_NestsReplies(t *testing.T) {
+	now := time.Date(2026, 4, 9, 12, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{Timestamp: "1775001600.000001", Message: "parent"},
+		{Timestamp: "1775001600.000003", Message: "other"},
+		{Timestamp: "1775001600.000002", Message: "reply", ThreadTimestamp: "1775001600.000001"},
+	}
+	md, err := renderMarkdown("general", entries, now, nil)
+	if err != nil {
+		t.Fatalf("renderMarkdown() error = %v", err)
+	}
+	parent := strings.Index(md, "parent")
+	reply := strings.Index(md, "### Reply")
+	other := strings.Index(md, "other")
+	if parent < 0 || reply < 0 || other < 0 || !(parent < reply && reply < other) {
+		t.Fatalf("renderMarkdown() reply not nested under parent: %s", md)
+	}
+	if !strings.Contains(md, "- thread_parent_utc: 2026-04-01 00:00:00") {
+		t.Fatalf("renderMarkdown() missing thread parent: %s", md)
+	}
+	if !strings.Contains(md, "- entries: 3") {
+		t.Fatalf("renderMarkdown() entries count should include replies: %s", md)
+	}
+}
+
+func TestCreateHtmlFile_NestsReplies(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	jsonl := strings.Join([]string{
+		`{"timestamp":"1775088000.000001","message":"parent-message","channel":{"id":"C1","name":"general"},"files":[]}`,
+		`{"timestamp":"1775088000.000002","message":"reply-message","channel":{"id":"C1","name":"general"},"files":[],"thread_ts":"1775088000.000001"}`,
+	}, "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	g := &GDrive{
+		htmlDir: &drive.File{Id: "html-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			return nil
+		},
+	}
+
+	if err := c.CreateHtmlFile(context.Background(), "general", g, nil); err != nil {
+		t.Fatalf("CreateHtmlFile() error = %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(baseDir, "html", "general.html"))
+	if err != nil {
+		t.Fatalf("read html: %v", err)
+	}
+	got := string(b)
+	if strings.Count(got, `<hr class="my-8`) != 1 {
+		t.Fatalf("CreateHtmlFile() should render one card for the thread")
+	}
+	if !strings.Contains(got, "1 replies") || !strings.Contains(got, "reply-message") {
+		t.Fatalf("CreateHtmlFile() output missing nested reply")
+	}
+}
+
+func TestChannels_isAuthor(t *testing.T) {
+	c := &Channels{
+		authorIDs: []string{"U1", "U2"},
+		channelAuthorIDs: map[string][]string{
+			"C2": {"U3"},
+		},
+	}
+	tests := []struct {
+		name      string
+		channelID string
+		userID    string
+		want      bool
+	}{
+		{name: "global author", channelID: "C1", userID: "U2", want: true},
+		{name: "not author", channelID: "C1", userID: "U3", want: false},
+		{name: "per channel author", channelID: "C2", userID: "U3", want: true},
+		{name: "per channel overrides global", channelID: "C2", userID: "U1", want: false},
+		{name: "empty user", channelID: "C1", userID: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.isAuthor(tt.channelID, tt.userID); got != tt.want {
+				t.Fatalf("isAuthor(%q, %q) = %v, want %v", tt.channelID, tt.userID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMarkdown_UsesEntryAuthor(t *testing.T) {
+	now := time.Date(2026, 4, 9, 12, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{Timestamp: "1775001600.000001", Message: "a", User: "U1", UserName: "alice"},
+		{Timestamp: "1775001600.000002", Message: "b", User: "U2"},
+	}
+	md, err := renderMarkdown("general", entries, now, nil)
+	if err != nil {
+		t.Fatalf("renderMarkdown() error = %v", err)
+	}
+	if !strings.Contains(md, "- author: alice\n- author_id: U1\n") {
+		t.Fatalf("renderMarkdown() missing named author: %s", md)
+	}
+	if !strings.Contains(md, "- author: U2\n") {
+		t.Fatalf("renderMarkdown() missing author id fallback: %s", md)
+	}
+}
+
+func TestChannels_fillLegacyAuthor(t *testing.T) {
+	entries := []Entry{{Timestamp: "1.0"}, {Timestamp: "2.0", User: "U9"}}
+
+	single := &Channels{authorIDs: []string{"U1"}}
+	got := single.fillLegacyAuthor(append([]Entry(nil), entries...))
+	if got[0].User != "U1" || got[1].User != "U9" {
+		t.Fatalf("fillLegacyAuthor() single = %+v", got)
+	}
+
+	multi := &Channels{authorIDs: []string{"U1", "U2"}}
+	got = multi.fillLegacyAuthor(append([]Entry(nil), entries...))
+	if got[0].User != "" {
+		t.Fatalf("fillLegacyAuthor() multi should not guess author: %+v", got)
+	}
+}
+
+func readZipEntry(t *testing.T, zipPath, name string) string {
+	t.Helper()
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	defer func() {
+		_ = zr.Close()
+	}()
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open zip entry %s: %v", name, err)
+		}
+		defer func() {
+			_ = rc.Close()
+		}()
+		b, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatalf("read zip entry %s: %v", name, err)
+		}
+		return string(b)
+	}
+	t.Fatalf("zip missing %s", name)
+	return ""
+}
+
+func TestChannels_RemoveDuplicateEntries(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	jsonl := strings.Join([]string{
+		`{"timestamp":"1775001600.000001","message":"first","channel":{"id":"C1","name":"general"},"files":[]}`,
+		`{"timestamp":"1775001600.000001","message":"first","channel":{"id":"C1","name":"general"},"files":[]}`,
+		`not-json`,
+		`{"timestamp":"1775001600.000002","message":"second","channel":{"id":"C1","name":"general"},"files":[]}`,
+		`{"timestamp":"1775001600.000001","message":"first","channel":{"id":"C1","name":"general"},"files":[]}`,
+	}, "\n") + "\n"
+	filePath := filepath.Join(baseDir, "general.jsonl")
+	if err := os.WriteFile(filePath, []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	uploads := 0
+	g := &GDrive{
+		targetDir: &drive.File{Id: "target-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return &drive.File{Id: "file-id"}, nil
+		},
+		updateFileFn: func(ctx context.Context, name, id, filePath string) error {
+			uploads++
+			return nil
+		},
+	}
+
+	removed, err := c.RemoveDuplicateEntries(context.Background(), "general", g)
+	if err != nil {
+		t.Fatalf("RemoveDuplicateEntries() error = %v", err)
+	}
+	if removed != 2 || uploads != 1 {
+		t.Fatalf("RemoveDuplicateEntries() removed = %d, uploads = %d, want 2, 1", removed, uploads)
+	}
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("read jsonl: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 || lines[1] != "not-json" || !strings.Contains(lines[2], "second") {
+		t.Fatalf("lines = %v", lines)
+	}
+
+	removed, err = c.RemoveDuplicateEntries(context.Background(), "general", g)
+	if err != nil || removed != 0 || uploads != 1 {
+		t.Fatalf("RemoveDuplicateEntries(again) removed = %d, err = %v, uploads = %d", removed, err, uploads)
+	}
+}
@@ -0,0 +1,320 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// EmojiDir はカスタム絵文字の画像を保存する base_dir 配下のディレクトリです。
+	EmojiDir                = "emoji"
+	customEmojiIndexName    = "emoji.json"
+	customEmojiSyncInterval = 10 * time.Minute
+)
+
+// slackEmojiAliases はgemojiに含まれないSlack独自のショートコードです。
+var slackEmojiAliases = map[string]string{
+	"simple_smile": "🙂",
+	"skin-tone-2":  "\U0001F3FB",
+	"skin-tone-3":  "\U0001F3FC",
+	"skin-tone-4":  "\U0001F3FD",
+	"skin-tone-5":  "\U0001F3FE",
+	"skin-tone-6":  "\U0001F3FF",
+}
+
+var (
+	emojiShortcodeRe   = regexp.MustCompile(`:([a-z0-9_+'-]+):`)
+	emojiFileNameRe    = regexp.MustCompile(`[^a-z0-9_+-]`)
+	customEmojiExtList = []string{".png", ".gif", ".jpg", ".jpeg", ".webp"}
+)
+
+type customEmojiClient interface {
+	fileContextGetter
+	GetEmojiContext(ctx context.Context) (map[string]string, error)
+}
+
+// customEmoji はワークスペースのカスタム絵文字です。
+// File は base_dir からの画像の相対パス、Alias は標準絵文字への別名の場合の別名先ショートコードです。
+type customEmoji struct {
+	File  string `json:"file,omitempty"`
+	Alias string `json:"alias,omitempty"`
+}
+
+// customEmojiStore は emoji.list で取得したカスタム絵文字を base_dir/emoji に保存し、名前から引けるようにします。
+type customEmojiStore struct {
+	baseDir string
+
+	// syncMu は Sync を直列化し、mu は emoji と lastSync を保護します。
+	syncMu   sync.Mutex
+	mu       sync.Mutex
+	emoji    map[string]customEmoji
+	lastSync time.Time
+	// syncWG は SyncIfUnknown がバックグラウンドで開始した Sync を待つためのものです。
+	syncWG sync.WaitGroup
+}
+
+func newCustomEmojiStore(baseDir string) *customEmojiStore {
+	s := &customEmojiStore{
+		baseDir: baseDir,
+		emoji:   map[string]customEmoji{},
+	}
+	if err := s.load(); err != nil {
+		log.Printf("カスタム絵文字の読み込みをスキップ: %v", err)
+	}
+	return s
+}
+
+func (s *customEmojiStore) indexPath() string {
+	return filepath.Join(s.baseDir, EmojiDir, customEmojiIndexName)
+}
+
+func (s *customEmojiStore) load() error {
+	b, err := os.ReadFile(s.indexPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("カスタム絵文字一覧読込失敗: %w", err)
+	}
+	var index map[string]customEmoji
+	if err := json.Unmarshal(b, &index); err != nil {
+		return fmt.Errorf("カスタム絵文字一覧のパースに失敗: %w", err)
+	}
+	s.emoji = index
+	return nil
+}
+
+// Snapshot は描画用にカスタム絵文字の一覧を返します。
+func (s *customEmojiStore) Snapshot() map[string]customEmoji {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.emoji
+}
+
+// Sync は emoji.list を取得し、未保存のカスタム絵文字画像をダウンロードして一覧を保存します。
+// 保存済みの画像は再ダウンロードしません。
+func (s *customEmojiStore) Sync(ctx context.Context, client customEmojiClient) error {
+	ctx, span := tracer.Start(ctx, "SyncCustomEmoji")
+	defer span.End()
+
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	s.mu.Lock()
+	s.lastSync = time.Now()
+	s.mu.Unlock()
+
+	list, err := client.GetEmojiContext(ctx)
+	if err != nil {
+		return fmt.Errorf("emoji.list の取得に失敗: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(s.baseDir, EmojiDir), os.ModePerm); err != nil {
+		return fmt.Errorf("絵文字ディレクトリの作成に失敗: %w", err)
+	}
+
+	index := make(map[string]customEmoji, len(list))
+	failures := make([]string, 0)
+	for name, value := range list {
+		if strings.HasPrefix(value, "alias:") {
+			continue
+		}
+		rel, err := s.downloadEmoji(ctx, client, name, value)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		index[name] = customEmoji{File: rel}
+	}
+	for name, value := range list {
+		target, ok := strings.CutPrefix(value, "alias:")
+		if !ok {
+			continue
+		}
+		if e, ok := index[target]; ok {
+			index[name] = e
+		} else if _, ok := standardEmoji(target); ok {
+			index[name] = customEmoji{Alias: target}
+		}
+	}
+
+	out, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("カスタム絵文字一覧のJSON化に失敗: %w", err)
+	}
+	if err := writeLines(s.indexPath(), []string{string(out)}); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.emoji = index
+	s.mu.Unlock()
+	if len(failures) > 0 {
+		return fmt.Errorf("カスタム絵文字のダウンロードに失敗: %s", strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+// SyncIfUnknown は message に未知の絵文字ショートコードが含まれ、前回の取得から一定時間経過している場合に
+// バックグラウンドで Sync します。イベント処理を止めないよう、取得の完了は待ちません。
+func (s *customEmojiStore) SyncIfUnknown(ctx context.Context, client customEmojiClient, message string) {
+	if s == nil || client == nil {
+		return
+	}
+	known := s.Snapshot()
+	unknown := false
+	for _, m := range emojiShortcodeRe.FindAllStringSubmatch(message, -1) {
+		if _, ok := standardEmoji(m[1]); ok {
+			continue
+		}
+		if _, ok := known[m[1]]; !ok {
+			unknown = true
+			break
+		}
+	}
+	if !unknown {
+		return
+	}
+	s.mu.Lock()
+	recent := time.Since(s.lastSync) < customEmojiSyncInterval
+	if !recent {
+		// 同期の完了前に届いたメッセージで重ねて同期しないよう、開始時点で記録する
+		s.lastSync = time.Now()
+	}
+	s.mu.Unlock()
+	if recent {
+		return
+	}
+	s.syncWG.Add(1)
+	go func() {
+		defer s.syncWG.Done()
+		if err := s.Sync(context.WithoutCancel(ctx), client); err != nil {
+			log.Printf("カスタム絵文字の同期に失敗: %v", err)
+		}
+	}()
+}
+
+func (s *customEmojiStore) downloadEmoji(ctx context.Context, client customEmojiClient, name, imageURL string) (string, error) {
+	ext := strings.ToLower(path.Ext(strings.SplitN(imageURL, "?", 2)[0]))
+	if !slices.Contains(customEmojiExtList, ext) {
+		ext = ".png"
+	}
+	rel := filepath.Join(EmojiDir, emojiFileNameRe.ReplaceAllString(name, "_")+ext)
+	localPath := filepath.Join(s.baseDir, rel)
+	if _, err := os.Stat(localPath); err == nil {
+		return rel, nil
+	}
+
+	tmpPath := localPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("emoji=%s stage=create_local_file: %w", name, err)
+	}
+	if err := client.GetFileContext(ctx, imageURL, f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return "", fmt.Errorf("emoji=%s stage=download url=%s: %w", name, imageURL, err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", fmt.Errorf("emoji=%s stage=close: %w", name, err)
+	}
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		return "", fmt.Errorf("emoji=%s stage=rename: %w", name, err)
+	}
+	return rel, nil
+}
+
+// standardEmoji はショートコードに対応する標準絵文字のUnicode文字列を返します。
+func standardEmoji(name string) (string, bool) {
+	if e, ok := emojiShortcodes[name]; ok {
+		return e, true
+	}
+	e, ok := slackEmojiAliases[name]
+	return e, ok
+}
+
+// customEmojiFiles はエントリの本文と編集履歴で使われているカスタム絵文字の画像パスを返します。
+func customEmojiFiles(entries []Entry) []string {
+	files := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(message string, emoji map[string]customEmoji) {
+		for _, m := range emojiShortcodeRe.FindAllStringSubmatch(message, -1) {
+			e, ok := emoji[m[1]]
+			if !ok || e.File == "" || seen[e.File] {
+				continue
+			}
+			seen[e.File] = true
+			files = append(files, e.File)
+		}
+	}
+	for _, entry := range entries {
+		add(entry.Message, entry.customEmoji)
+		for _, rev := range entry.Revisions {
+			add(rev.Message, rev.customEmoji)
+		}
+	}
+	return files
+}
+
+// attachCustomEmoji は描画用にカスタム絵文字の一覧をエントリへ設定します。
+func (c *Channels) attachCustomEmoji(entries []Entry) []Entry {
+	emoji := c.customEmoji.Snapshot()
+	if len(emoji) == 0 {
+		return entries
+	}
+	for i := range entries {
+		entries[i].customEmoji = emoji
+		for j := range entries[i].Revisions {
+			entries[i].Revisions[j].customEmoji = emoji
+		}
+	}
+	return entries
+}
+
+// emojiText はショートコードを絵文字に変換する。未知のショートコードの場合は false を返す。
+// カスタム絵文字はHTMLでは html/ からの相対パス、Markdownではzip内の attachments/ 配下のパスで画像として出力する。
+func (r mrkdwnRenderer) emojiText(name string) (string, bool) {
+	if e, ok := standardEmoji(name); ok {
+		if r.html {
+			return template.HTMLEscapeString(e), true
+		}
+		return e, true
+	}
+	custom, ok := r.emoji[name]
+	if !ok {
+		return "", false
+	}
+	if custom.Alias != "" {
+		if e, ok := standardEmoji(custom.Alias); ok {
+			return e, true
+		}
+		return "", false
+	}
+	if custom.File == "" {
+		return "", false
+	}
+	src := filepath.ToSlash(custom.File)
+	shortcode := ":" + name + ":"
+	if r.html {
+		return fmt.Sprintf(
+			"<img src=\"../%s\" alt=\"%s\" title=\"%s\" class=\"inline-block h-5 w-5 align-text-bottom\" />",
+			template.HTMLEscapeString(src),
+			template.HTMLEscapeString(shortcode),
+			template.HTMLEscapeString(shortcode),
+		), true
+	}
+	return fmt.Sprintf("![%s](<attachments/%s>)", escapeMarkdownText(shortcode, false), src), true
+}
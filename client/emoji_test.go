@@ -0,0 +1,153 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+type stubCustomEmojiClient struct {
+	list      map[string]string
+	downloads int
+}
+
+func (s *stubCustomEmojiClient) GetEmojiContext(_ context.Context) (map[string]string, error) {
+	return s.list, nil
+}
+
+func (s *stubCustomEmojiClient) GetFileContext(_ context.Context, downloadURL string, writer io.Writer) error {
+	if strings.Contains(downloadURL, "broken") {
+		return errors.New("download failed")
+	}
+	s.downloads++
+	_, err := writer.Write([]byte("image:" + downloadURL))
+	return err
+}
+
+func TestMrkdwnToHTML_Emoji(t *testing.T) {
+	emoji := map[string]customEmoji{
+		"party-parrot": {File: filepath.Join(EmojiDir, "party-partyparrot.gif")},
+		"thumbs":       {Alias: "+1"},
+	}
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "standard", message: ":o: :heavy_multiplication_x:", want: "⭕ ✖️"},
+		{name: "skin tone", message: ":wave::skin-tone-2:", want: "👋🏻"},
+		{name: "custom", message: "yay :party-parrot:", want: `yay <img src="../emoji/party-partyparrot.gif" alt=":party-parrot:" title=":party-parrot:" class="inline-block h-5 w-5 align-text-bottom" />`},
+		{name: "alias to standard", message: ":thumbs:", want: "👍"},
+		{name: "unknown", message: ":no_such_emoji:", want: ":no_such_emoji:"},
+		{name: "time is not emoji", message: "10:30:45", want: "10:30:45"},
+		{name: "inline code kept", message: "`:o:`", want: "<code>:o:</code>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(mrkdwnToHTML(tt.message, nil, emoji)); got != tt.want {
+				t.Fatalf("mrkdwnToHTML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := mrkdwnToMarkdown(":o: :party-parrot:", nil, emoji); got != "⭕ ![:party-parrot:](<attachments/emoji/party-partyparrot.gif>)" {
+		t.Fatalf("mrkdwnToMarkdown() = %q", got)
+	}
+}
+
+func TestCustomEmojiStore_SyncDownloadsOnce(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	client := &stubCustomEmojiClient{list: map[string]string{
+		"partyparrot":  "https://emoji.example.com/partyparrot.gif",
+		"partyparrot2": "alias:partyparrot",
+		"yes":          "alias:white_check_mark",
+		"broken":       "https://emoji.example.com/broken.png",
+	}}
+	s := newCustomEmojiStore(baseDir)
+	if err := s.Sync(context.Background(), client); err == nil {
+		t.Fatal("Sync() error = nil, want download failure")
+	}
+	got := s.Snapshot()
+	if got["partyparrot"].File != filepath.Join(EmojiDir, "partyparrot.gif") || got["partyparrot2"] != got["partyparrot"] || got["yes"].Alias != "white_check_mark" {
+		t.Fatalf("Snapshot() = %+v", got)
+	}
+	if _, ok := got["broken"]; ok {
+		t.Fatalf("Snapshot() should not contain failed emoji: %+v", got)
+	}
+
+	delete(client.list, "broken")
+	reloaded := newCustomEmojiStore(baseDir)
+	if err := reloaded.Sync(context.Background(), client); err != nil {
+		t.Fatalf("Sync(again) error = %v", err)
+	}
+	if client.downloads != 1 {
+		t.Fatalf("downloads = %d, want 1", client.downloads)
+	}
+	if reloaded.Snapshot()["partyparrot"].File == "" {
+		t.Fatalf("reloaded Snapshot() = %+v", reloaded.Snapshot())
+	}
+}
+
+func TestCustomEmojiStore_SyncIfUnknown(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	client := &stubCustomEmojiClient{list: map[string]string{"partyparrot": "https://emoji.example.com/partyparrot.gif"}}
+	s := newCustomEmojiStore(t.TempDir())
+	s.SyncIfUnknown(context.Background(), client, ":o: only standard")
+	s.syncWG.Wait()
+	if client.downloads != 0 {
+		t.Fatalf("downloads = %d, want 0 for standard emoji", client.downloads)
+	}
+	s.SyncIfUnknown(context.Background(), client, ":partyparrot:")
+	s.SyncIfUnknown(context.Background(), client, ":partyparrot: again")
+	s.syncWG.Wait()
+	if client.downloads != 1 {
+		t.Fatalf("downloads = %d, want 1", client.downloads)
+	}
+	client.list["new"] = "https://emoji.example.com/new.png"
+	s.SyncIfUnknown(context.Background(), client, ":new:")
+	s.syncWG.Wait()
+	if client.downloads != 1 {
+		t.Fatalf("downloads = %d, want no sync within interval", client.downloads)
+	}
+}
+
+func TestCreateMarkdownZip_IncludesCustomEmoji(t *testing.T) {
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(baseDir, EmojiDir), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(baseDir, EmojiDir, "partyparrot.gif"), []byte("gif"), 0644); err != nil {
+		t.Fatalf("write emoji: %v", err)
+	}
+	store := newCustomEmojiStore(baseDir)
+	store.emoji = map[string]customEmoji{"partyparrot": {File: filepath.Join(EmojiDir, "partyparrot.gif")}}
+	c := &Channels{basedir: baseDir, customEmoji: store}
+	jsonl := `{"timestamp":"1775001600.000001","message":"yay :partyparrot: :o:","channel":{"id":"C1","name":"general"},"files":[]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+
+	result, err := c.CreateMarkdownZip(context.Background(), "general", nil)
+	if err != nil {
+		t.Fatalf("CreateMarkdownZip() error = %v", err)
+	}
+	if result.AttachmentCount != 0 {
+		t.Fatalf("AttachmentCount = %d, want 0", result.AttachmentCount)
+	}
+	md := readZipEntry(t, result.ZipPath, "index.md")
+	if !strings.Contains(md, "yay ![:partyparrot:](<attachments/emoji/partyparrot.gif>) ⭕") {
+		t.Fatalf("index.md does not render emoji:\n%s", md)
+	}
+	if got := readZipEntry(t, result.ZipPath, "attachments/emoji/partyparrot.gif"); got != "gif" {
+		t.Fatalf("emoji image = %q", got)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
@@ -17,11 +18,21 @@ type GDrive struct {
 	baseDir           string
 	targetDir         *drive.File
 	imageDir          *drive.File
+	thumbDir          *drive.File
+	blobDir           *drive.File
 	htmlDir           *drive.File
 	getTargetFileFn   func(ctx context.Context, filename, dirid string) (*drive.File, error)
 	createImageFileFn func(ctx context.Context, name, parent, filepath string) error
+	createThumbFileFn func(ctx context.Context, name, parent, filepath string) error
+	createBlobFileFn  func(ctx context.Context, name, parent, filepath string) error
 	createFileFn      func(ctx context.Context, name, parent, filepath string) error
 	updateFileFn      func(ctx context.Context, name, id, filepath string) error
+	deleteImageFileFn func(ctx context.Context, name, parent string) error
+	deleteThumbFileFn func(ctx context.Context, name, parent string) error
+	deleteBlobFileFn  func(ctx context.Context, name, parent string) error
+	renameChannelFn   func(ctx context.Context, oldName, newName string) error
+	// outbox が設定されている場合、アップロードはキューに登録され RunUploadWorker で実行されます。
+	outbox *uploadOutbox
 }
 
 func (g GDrive) htmlCreateParentID() string {
@@ -80,6 +91,30 @@ func NewGDrive(basedir string, credentialsJSON string, credentialsFilePath strin
 		}
 	}
 
+	// thumbs フォルダを取得、なければ作成
+	thumbDir, err := getTargetDirWithParent(ctx, ThumbnailDir, targetDir.Id, client)
+	if err != nil {
+		return nil, fmt.Errorf("thumbs フォルダ検索に失敗: %w", err)
+	}
+	if thumbDir == nil {
+		thumbDir, err = createFolder(ctx, ThumbnailDir, targetDir.Id, client)
+		if err != nil {
+			return nil, fmt.Errorf("thumbs フォルダの作成に失敗: %w", err)
+		}
+	}
+
+	// blobs フォルダを取得、なければ作成
+	blobDir, err := getTargetDirWithParent(ctx, BlobDir, targetDir.Id, client)
+	if err != nil {
+		return nil, fmt.Errorf("blobs フォルダ検索に失敗: %w", err)
+	}
+	if blobDir == nil {
+		blobDir, err = createFolder(ctx, BlobDir, targetDir.Id, client)
+		if err != nil {
+			return nil, fmt.Errorf("blobs フォルダの作成に失敗: %w", err)
+		}
+	}
+
 	// html フォルダを取得、なければ作成
 	htmlDir, err := getTargetDirWithParent(ctx, "html", targetDir.Id, client)
 	if err != nil {
@@ -92,12 +127,21 @@ func NewGDrive(basedir string, credentialsJSON string, credentialsFilePath strin
 		}
 	}
 
+	outbox, err := newUploadOutbox(basedir)
+	if err != nil {
+		log.Printf("アップロードキューを無効化して継続（直接アップロード）: %v", err)
+		outbox = nil
+	}
+
 	return &GDrive{
 		client:    client,
 		baseDir:   basedir,
 		targetDir: targetDir,
 		imageDir:  imageDir,
+		thumbDir:  thumbDir,
+		blobDir:   blobDir,
 		htmlDir:   htmlDir,
+		outbox:    outbox,
 	}, nil
 }
 
@@ -209,8 +253,15 @@ func (g GDrive) createDir(ctx context.Context, name string, parentId string) (*d
 	}
 }
 
-// CreateImageFile 画像ファイルをimageDirにアップロードする
+// CreateImageFile 画像ファイルをimageDirにアップロードする（アップロードキューが有効な場合は登録のみ）
 func (g GDrive) CreateImageFile(ctx context.Context, name string, parent string, filepath string) error {
+	if g.outbox != nil {
+		return g.outbox.Enqueue(uploadKindImage, name, parent, filepath, time.Now())
+	}
+	return g.createImageFileNow(ctx, name, parent, filepath)
+}
+
+func (g GDrive) createImageFileNow(ctx context.Context, name string, parent string, filepath string) error {
 	if g.createImageFileFn != nil {
 		return g.createImageFileFn(ctx, name, parent, filepath)
 	}
@@ -222,27 +273,186 @@ func (g GDrive) CreateImageFile(ctx context.Context, name string, parent string,
 	ctx, span := tracer.Start(ctx, "GDrive.CreateImageFile")
 	defer span.End()
 
-	local, err := os.Open(filepath)
+	driveFile, err := g.createFileInChannelDir(ctx, name, parent, g.imageDir.Id, filepath)
+	if err != nil {
+		return err
+	}
+	log.Printf("File uploaded(CreateImageFile): %s %s", driveFile.Id, driveFile.Name)
+	return nil
+}
+
+// CreateThumbnailFile 画像の縮小版をthumbDirにアップロードする（アップロードキューが有効な場合は登録のみ）
+func (g GDrive) CreateThumbnailFile(ctx context.Context, name string, parent string, filepath string) error {
+	if g.outbox != nil {
+		return g.outbox.Enqueue(uploadKindThumbnail, name, parent, filepath, time.Now())
+	}
+	return g.createThumbnailFileNow(ctx, name, parent, filepath)
+}
+
+func (g GDrive) createThumbnailFileNow(ctx context.Context, name string, parent string, filepath string) error {
+	if g.createThumbFileFn != nil {
+		return g.createThumbFileFn(ctx, name, parent, filepath)
+	}
+
+	if g.thumbDir == nil {
+		return fmt.Errorf("thumbDir が初期化されていません。Google Drive上に thumbs フォルダが存在するか確認してください")
+	}
+
+	ctx, span := tracer.Start(ctx, "GDrive.CreateThumbnailFile")
+	defer span.End()
+
+	driveFile, err := g.createFileInChannelDir(ctx, name, parent, g.thumbDir.Id, filepath)
+	if err != nil {
+		return err
+	}
+	log.Printf("File uploaded(CreateThumbnailFile): %s %s", driveFile.Id, driveFile.Name)
+	return nil
+}
+
+// CreateBlobFile 内容のハッシュで保存した添付ファイルをblobDirにアップロードする（アップロードキューが有効な場合は登録のみ）
+func (g GDrive) CreateBlobFile(ctx context.Context, name string, parent string, filepath string) error {
+	if g.outbox != nil {
+		return g.outbox.Enqueue(uploadKindBlob, name, parent, filepath, time.Now())
+	}
+	return g.createBlobFileNow(ctx, name, parent, filepath)
+}
+
+func (g GDrive) createBlobFileNow(ctx context.Context, name string, parent string, filepath string) error {
+	if g.createBlobFileFn != nil {
+		return g.createBlobFileFn(ctx, name, parent, filepath)
+	}
+
+	if g.blobDir == nil {
+		return fmt.Errorf("blobDir が初期化されていません。Google Drive上に blobs フォルダが存在するか確認してください")
+	}
+
+	ctx, span := tracer.Start(ctx, "GDrive.CreateBlobFile")
+	defer span.End()
+
+	// 同じ内容のファイルは同じ名前になるため、アップロード済みの場合は何もしない
+	channel, err := g.createDir(ctx, parent, g.blobDir.Id)
+	if err != nil {
+		return err
+	}
+	existing, err := g.targetFile(ctx, name, channel.Id)
+	if err != nil {
+		return fmt.Errorf("target blob file 検索に失敗: %w", err)
+	}
+	if existing != nil {
+		return nil
+	}
+	driveFile, err := g.createFileInChannelDir(ctx, name, parent, g.blobDir.Id, filepath)
 	if err != nil {
 		return err
 	}
+	log.Printf("File uploaded(CreateBlobFile): %s %s", driveFile.Id, driveFile.Name)
+	return nil
+}
+
+// createFileInChannelDir は rootID/<parent> フォルダ（なければ作成）にファイルをアップロードする
+func (g GDrive) createFileInChannelDir(ctx context.Context, name, parent, rootID, filepath string) (*drive.File, error) {
+	local, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
 	defer func() {
 		_ = local.Close()
 	}()
-	channel, err := g.createDir(ctx, parent, g.imageDir.Id)
+	channel, err := g.createDir(ctx, parent, rootID)
+	if err != nil {
+		return nil, err
+	}
+	return g.client.Files.Create(&drive.File{Name: name, Parents: []string{channel.Id}}).Media(local).Context(ctx).Do()
+}
+
+// DeleteImageFile imageDir/<parent> 配下の画像ファイルを削除する。対象が存在しない場合は何もしない
+func (g GDrive) DeleteImageFile(ctx context.Context, name string, parent string) error {
+	if g.outbox != nil {
+		g.outbox.Remove(uploadKindImage, name, parent)
+	}
+	if g.deleteImageFileFn != nil {
+		return g.deleteImageFileFn(ctx, name, parent)
+	}
+
+	if g.imageDir == nil {
+		return fmt.Errorf("imageDir が初期化されていません。Google Drive上に images フォルダが存在するか確認してください")
+	}
+
+	ctx, span := tracer.Start(ctx, "GDrive.DeleteImageFile")
+	defer span.End()
+
+	return g.deleteFileInChannelDir(ctx, name, parent, g.imageDir.Id, "DeleteImageFile")
+}
+
+// DeleteThumbnailFile thumbDir/<parent> 配下の縮小版を削除する。対象が存在しない場合は何もしない
+func (g GDrive) DeleteThumbnailFile(ctx context.Context, name string, parent string) error {
+	if g.outbox != nil {
+		g.outbox.Remove(uploadKindThumbnail, name, parent)
+	}
+	if g.deleteThumbFileFn != nil {
+		return g.deleteThumbFileFn(ctx, name, parent)
+	}
+
+	if g.thumbDir == nil {
+		return fmt.Errorf("thumbDir が初期化されていません。Google Drive上に thumbs フォルダが存在するか確認してください")
+	}
+
+	ctx, span := tracer.Start(ctx, "GDrive.DeleteThumbnailFile")
+	defer span.End()
+
+	return g.deleteFileInChannelDir(ctx, name, parent, g.thumbDir.Id, "DeleteThumbnailFile")
+}
+
+// DeleteBlobFile blobDir/<parent> 配下の添付ファイルを削除する。対象が存在しない場合は何もしない
+func (g GDrive) DeleteBlobFile(ctx context.Context, name string, parent string) error {
+	if g.outbox != nil {
+		g.outbox.Remove(uploadKindBlob, name, parent)
+	}
+	if g.deleteBlobFileFn != nil {
+		return g.deleteBlobFileFn(ctx, name, parent)
+	}
+
+	if g.blobDir == nil {
+		return fmt.Errorf("blobDir が初期化されていません。Google Drive上に blobs フォルダが存在するか確認してください")
+	}
+
+	ctx, span := tracer.Start(ctx, "GDrive.DeleteBlobFile")
+	defer span.End()
+
+	return g.deleteFileInChannelDir(ctx, name, parent, g.blobDir.Id, "DeleteBlobFile")
+}
+
+func (g GDrive) deleteFileInChannelDir(ctx context.Context, name, parent, rootID, op string) error {
+	channel, err := getTargetDirWithParent(ctx, parent, rootID, g.client)
 	if err != nil {
 		return err
 	}
-	driveFile, err := g.client.Files.Create(&drive.File{Name: name, Parents: []string{channel.Id}}).Media(local).Context(ctx).Do()
+	if channel == nil {
+		return nil
+	}
+	f, err := g.targetFile(ctx, name, channel.Id)
 	if err != nil {
+		return fmt.Errorf("target image file 検索に失敗: %w", err)
+	}
+	if f == nil {
+		return nil
+	}
+	if err := g.client.Files.Delete(f.Id).Context(ctx).Do(); err != nil {
 		return err
 	}
-	log.Printf("File uploaded(CreateImageFile): %s %s", driveFile.Id, driveFile.Name)
+	log.Printf("File deleted(%s): %s %s", op, f.Id, name)
 	return nil
 }
 
-// UploadFile ファイルをtargetDirにアップロードする
+// UploadFile ファイルをtargetDirにアップロードする（アップロードキューが有効な場合は登録のみ）
 func (g GDrive) UploadFile(ctx context.Context, name string, filepath string) error {
+	if g.outbox != nil {
+		return g.outbox.Enqueue(uploadKindFile, name, "", filepath, time.Now())
+	}
+	return g.uploadFileNow(ctx, name, filepath)
+}
+
+func (g GDrive) uploadFileNow(ctx context.Context, name string, filepath string) error {
 	if g.targetDir == nil {
 		return fmt.Errorf("targetDir が初期化されていません。Google Drive上に happeninghound フォルダが存在するか確認してください")
 	}
@@ -261,8 +471,15 @@ func (g GDrive) UploadFile(ctx context.Context, name string, filepath string) er
 	}
 }
 
-// UploadHtmlFile HTMLファイルをhtmlDirにアップロードする
+// UploadHtmlFile HTMLファイルをhtmlDirにアップロードする（アップロードキューが有効な場合は登録のみ）
 func (g GDrive) UploadHtmlFile(ctx context.Context, name string, filepath string) error {
+	if g.outbox != nil {
+		return g.outbox.Enqueue(uploadKindHTML, name, "", filepath, time.Now())
+	}
+	return g.uploadHtmlFileNow(ctx, name, filepath)
+}
+
+func (g GDrive) uploadHtmlFileNow(ctx context.Context, name string, filepath string) error {
 	if g.htmlDir == nil {
 		return fmt.Errorf("htmlDir が初期化されていません。Google Drive上に html フォルダが存在するか確認してください")
 	}
@@ -281,6 +498,94 @@ func (g GDrive) UploadHtmlFile(ctx context.Context, name string, filepath string
 	}
 }
 
+// RenameChannel はチャンネル名の変更に合わせて、Drive上のJSONL・画像フォルダ・HTMLの名前を変更します。
+// 新しい名前のファイルやフォルダが既にある場合は、JSONLは古い方を削除し、画像は新しいフォルダへ移動します。
+func (g GDrive) RenameChannel(ctx context.Context, oldName, newName string) error {
+	if g.outbox != nil {
+		g.outbox.RenameChannel(oldName, newName, time.Now())
+	}
+	if g.renameChannelFn != nil {
+		return g.renameChannelFn(ctx, oldName, newName)
+	}
+	if g.client == nil {
+		return fmt.Errorf("google drive クライアントが初期化されていません")
+	}
+
+	ctx, span := tracer.Start(ctx, "GDrive.RenameChannel")
+	defer span.End()
+
+	if g.targetDir != nil {
+		if err := g.renameOrDelete(ctx, oldName+".jsonl", newName+".jsonl", g.targetDir.Id); err != nil {
+			return fmt.Errorf("JSONLの移行に失敗: %w", err)
+		}
+	}
+	if g.htmlDir != nil {
+		if err := g.renameOrDelete(ctx, oldName+".html", newName+".html", g.htmlDir.Id); err != nil {
+			return fmt.Errorf("HTMLの移行に失敗: %w", err)
+		}
+	}
+	if g.imageDir != nil {
+		if err := g.moveChannelFolder(ctx, oldName, newName, g.imageDir.Id); err != nil {
+			return fmt.Errorf("画像フォルダの移行に失敗: %w", err)
+		}
+	}
+	if g.thumbDir != nil {
+		if err := g.moveChannelFolder(ctx, oldName, newName, g.thumbDir.Id); err != nil {
+			return fmt.Errorf("縮小版フォルダの移行に失敗: %w", err)
+		}
+	}
+	return nil
+}
+
+// renameOrDelete は dirid 配下の oldName を newName に変更します。newName が既にある場合は oldName を削除します。
+func (g GDrive) renameOrDelete(ctx context.Context, oldName, newName, dirid string) error {
+	old, err := g.getTargetFile(ctx, oldName, dirid)
+	if err != nil || old == nil {
+		return err
+	}
+	existing, err := g.getTargetFile(ctx, newName, dirid)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return g.client.Files.Delete(old.Id).Context(ctx).Do()
+	}
+	_, err = g.client.Files.Update(old.Id, &drive.File{Name: newName}).Context(ctx).Do()
+	return err
+}
+
+// moveChannelFolder は rootID 配下の <oldName> フォルダ（images/ や thumbs/）を <newName> に変更します。
+// <newName> が既にある場合は中のファイルを移動し、空になった古いフォルダを削除します。
+func (g GDrive) moveChannelFolder(ctx context.Context, oldName, newName, rootID string) error {
+	oldDir, err := getTargetDirWithParent(ctx, oldName, rootID, g.client)
+	if err != nil || oldDir == nil {
+		return err
+	}
+	newDir, err := getTargetDirWithParent(ctx, newName, rootID, g.client)
+	if err != nil {
+		return err
+	}
+	if newDir == nil {
+		_, err = g.client.Files.Update(oldDir.Id, &drive.File{Name: newName}).Context(ctx).Do()
+		return err
+	}
+	err = g.client.Files.List().Q(fmt.Sprintf("'%s' in parents and trashed = false", oldDir.Id)).
+		Fields("nextPageToken, files(id,name)").Context(ctx).
+		Pages(ctx, func(r *drive.FileList) error {
+			for _, f := range r.Files {
+				if _, err := g.client.Files.Update(f.Id, &drive.File{}).
+					AddParents(newDir.Id).RemoveParents(oldDir.Id).Context(ctx).Do(); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	return g.client.Files.Delete(oldDir.Id).Context(ctx).Do()
+}
+
 func (g GDrive) targetFile(ctx context.Context, filename, dirid string) (*drive.File, error) {
 	if g.getTargetFileFn != nil {
 		return g.getTargetFileFn(ctx, filename, dirid)
@@ -300,3 +605,37 @@ func (g GDrive) createOrUpdateFile(ctx context.Context, name, parent, id, filepa
 	}
 	return g.updateFile(ctx, name, id, filepath)
 }
+
+// RunUploadWorker はアップロードキューのアップロードを実行し続けます。失敗したものは指数バックオフで再試行します。
+// ctx が終了すると戻ります。
+func (g GDrive) RunUploadWorker(ctx context.Context) {
+	if g.outbox == nil {
+		return
+	}
+	if pending := g.outbox.Pending(); pending > 0 {
+		log.Printf("前回から引き継いだアップロード待ち: %d件", pending)
+	}
+	g.outbox.run(ctx, g.uploadNow)
+}
+
+func (g GDrive) uploadNow(ctx context.Context, item uploadItem) error {
+	ctx, span := tracer.Start(ctx, "GDrive.uploadNow")
+	defer span.End()
+
+	if _, err := os.Stat(item.Path); err != nil {
+		return err
+	}
+	switch item.Kind {
+	case uploadKindImage:
+		return g.createImageFileNow(ctx, item.Name, item.Parent, item.Path)
+	case uploadKindThumbnail:
+		return g.createThumbnailFileNow(ctx, item.Name, item.Parent, item.Path)
+	case uploadKindBlob:
+		return g.createBlobFileNow(ctx, item.Name, item.Parent, item.Path)
+	case uploadKindHTML:
+		return g.uploadHtmlFileNow(ctx, item.Name, item.Path)
+	case uploadKindFile:
+		return g.uploadFileNow(ctx, item.Name, item.Path)
+	}
+	return fmt.Errorf("unknown upload kind: %s", item.Kind)
+}
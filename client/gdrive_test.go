@@ -153,3 +153,13 @@ func TestGDrive_UploadHtmlFile_TargetFileSearchError(t *testing.T) {
 		t.Fatal("expected error when targetFile search fails, got nil")
 	}
 }
+
+func TestGDrive_DeleteImageFile_NilImageDir(t *testing.T) {
+	g := GDrive{
+		imageDir: nil,
+	}
+	err := g.DeleteImageFile(context.Background(), "test.jpg", "channel")
+	if err == nil {
+		t.Fatal("expected error when imageDir is nil, got nil")
+	}
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -22,14 +23,23 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 )
 
-var channelNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
-
 const showFilesTimeLayout = "2006-01-02 15:04:05 MST"
 
+const (
+	fileShareSubType       = "file_share"
+	messageChangedSubType  = "message_changed"
+	messageDeletedSubType  = "message_deleted"
+	threadBroadcastSubType = "thread_broadcast"
+)
+
 type fileContextGetter interface {
 	GetFileContext(ctx context.Context, downloadURL string, writer io.Writer) error
 }
 
+type userInfoGetter interface {
+	GetUserInfoContext(ctx context.Context, user string) (*slack.User, error)
+}
+
 type showFileEntry struct {
 	name      string
 	hasHTML   bool
@@ -81,66 +91,208 @@ func MessageEventHandler(channels *Channels, botID string, gdrive *GDrive) socke
 		}
 		span.SetAttributes(attribute.String("slack.channel.name", channel.Name))
 
+		// 前回の記録から名前が変わっていた場合は、書き込む前に保存ファイルを移行する
+		if err := channels.observeChannel(ctx, Channel{ID: channelID, Name: channel.Name}, gdrive); err != nil {
+			client.Debugf("チャンネル移行エラー: %v", err)
+		}
+
+		switch p.SubType {
+		case messageChangedSubType:
+			handleMessageChanged(ctx, p, channel.Name, channels, client, gdrive)
+			return
+		case messageDeletedSubType:
+			handleMessageDeleted(ctx, p, channel.Name, channels, client, gdrive)
+			return
+		}
+
+		// 再送・リプレイされたイベントは添付ファイルのダウンロードと追記の前に除外する
+		if !channels.markMessageSeen(channelID, p.EventTimeStamp) {
+			client.Debugf("skipped message / already recorded: channel=%s ts=%s", channelID, p.EventTimeStamp)
+			return
+		}
+
+		channels.customEmoji.SyncIfUnknown(ctx, client, p.Text)
+
 		// JSON データ作成
 		data := Entry{
 			Timestamp: p.EventTimeStamp,
 			Message:   p.Text,
+			Mentions:  channels.resolveMentionNames(ctx, client, p.Text),
 			Channel: Channel{
 				ID:   channelID,
 				Name: channel.Name,
 			},
+			User:     p.User,
+			UserName: resolveUserDisplayName(ctx, client, p.User),
+		}
+		if p.ThreadTimeStamp != p.EventTimeStamp {
+			data.ThreadTimestamp = p.ThreadTimeStamp
+		}
+		if p.Message != nil {
+			data.Unfurls = slackUnfurls(p.Message.Attachments)
 		}
 
 		// filesの保存
-		if p.SubType == "file_share" {
+		if p.SubType == fileShareSubType {
 			files, err := downloadImageFiles(ctx, client, channel.Name, channels, p.Message.Files, p.EventTimeStamp, gdrive)
 			if err != nil {
 				client.Debugf("ファイルダウンロードエラー: %v", err)
 			}
+			if event.Request != nil {
+				applyAltTexts(files, slackFileAltTexts(event.Request.Payload))
+			}
 			data.Files = files
-
 		}
 
 		jsonData, err := json.Marshal(data)
 		if err != nil {
 			client.Debugf("JSON 変換エラー: %v", err)
+			channels.forgetMessageSeen(channelID, p.EventTimeStamp)
 			return
 		}
 
 		if err := channels.AppendMessage(ctx, channel.Name, string(jsonData), gdrive); err != nil {
 			client.Debugf("ファイル更新エラー: %v", err)
+			channels.forgetMessageSeen(channelID, p.EventTimeStamp)
 			if _, _, err := client.PostMessage(channelID, slack.MsgOptionText(fmt.Sprintf("ファイル更新エラー: %v", err), false)); err != nil {
 				fmt.Printf("######### : failed posting message: %v\n", err)
 			}
 			return
 		}
+		channels.archiveLinksInBackground(ctx, []Entry{data})
 
 		client.Debugf("ファイル保存完了")
 	}
 }
 
+// handleMessageChanged は編集されたメッセージの本文をJSONL上の元エントリに反映します。
+func handleMessageChanged(ctx context.Context, p *slackevents.MessageEvent, channelName string, channels *Channels, client *socketmode.Client, gdrive *GDrive) {
+	editedAt := p.EventTimeStamp
+	if p.Message.Edited != nil && p.Message.Edited.Timestamp != "" {
+		editedAt = p.Message.Edited.Timestamp
+	}
+	updated := false
+	if p.PreviousMessage == nil || p.PreviousMessage.Text != p.Message.Text {
+		mentions := channels.resolveMentionNames(ctx, client, p.Message.Text)
+		changed, err := channels.UpdateMessage(ctx, channelName, p.Message.Timestamp, p.Message.Text, mentions, editedAt, gdrive)
+		if err != nil {
+			postFileUpdateError(client, p.Channel, err)
+			return
+		}
+		updated = changed
+	}
+	// Slackはリンクの展開（unfurl）を投稿後に message_changed で追加するため、本文とは別に反映する
+	if unfurls := slackUnfurls(p.Message.Attachments); len(unfurls) > 0 {
+		changed, err := channels.UpdateUnfurls(ctx, channelName, p.Message.Timestamp, unfurls, gdrive)
+		if err != nil {
+			postFileUpdateError(client, p.Channel, err)
+			return
+		}
+		updated = updated || changed
+	}
+	if !updated {
+		client.Debugf("skipped message_changed / original entry not found or unchanged: ts=%s", p.Message.Timestamp)
+		return
+	}
+	client.Debugf("編集内容の反映完了")
+}
+
+func postFileUpdateError(client *socketmode.Client, channelID string, err error) {
+	client.Debugf("ファイル更新エラー: %v", err)
+	if _, _, err := client.PostMessage(channelID, slack.MsgOptionText(fmt.Sprintf("ファイル更新エラー: %v", err), false)); err != nil {
+		fmt.Printf("######### : failed posting message: %v\n", err)
+	}
+}
+
+// handleMessageDeleted は削除されたメッセージをJSONL上でtombstoneに置き換えます。
+// 既にHTMLが生成済みのチャンネルはHTMLも再生成し、削除内容をDrive上のHTMLにも反映します。
+func handleMessageDeleted(ctx context.Context, p *slackevents.MessageEvent, channelName string, channels *Channels, client *socketmode.Client, gdrive *GDrive) {
+	deleted, err := channels.DeleteMessage(ctx, channelName, p.DeletedTimeStamp, p.EventTimeStamp, gdrive)
+	if err != nil {
+		postFileUpdateError(client, p.Channel, err)
+		return
+	}
+	if !deleted {
+		client.Debugf("skipped message_deleted / original entry not found or already deleted: ts=%s", p.DeletedTimeStamp)
+		return
+	}
+	if htmlFileNames(channels.basedir)[channelName] {
+		if err := channels.CreateHtmlFile(ctx, channelName, gdrive, nil); err != nil {
+			fmt.Printf("######### : Got error %v\n", err)
+		}
+	}
+	client.Debugf("削除内容の反映完了")
+}
+
 func skipMessage(p *slackevents.MessageEvent, botMention string, client *socketmode.Client, channels *Channels) bool {
+	switch p.SubType {
+	case messageChangedSubType:
+		return skipMessageChanged(p, botMention, client, channels)
+	case messageDeletedSubType:
+		return skipMessageDeleted(p, client, channels)
+	}
 	if strings.HasPrefix(p.Text, botMention) {
 		client.Debugf("skipped message / bot mention")
 		return true
-	} else if p.User != channels.authorID {
+	} else if !channels.isAuthor(p.Channel, p.User) {
 		client.Debugf("skipped message / not author message")
 		return true
-	} else if p.SubType != "" && p.SubType != "file_share" {
+	} else if p.SubType != "" && p.SubType != fileShareSubType && p.SubType != threadBroadcastSubType {
 		client.Debugf("skipped message / subtype[%s]", p.SubType)
 		return true
-	} else if p.SubType != "file_share" && len(strings.TrimSpace(p.Text)) == 0 {
+	} else if p.SubType != fileShareSubType && len(strings.TrimSpace(p.Text)) == 0 {
 		client.Debugf("skipped message / empty message without file_share type")
 		return true
 	}
 	return false
 }
 
-func downloadImageFiles(ctx context.Context, client fileContextGetter, channelName string, channels *Channels, files []slack.File, timestamp string, gdrive *GDrive) ([]string, error) {
+func skipMessageChanged(p *slackevents.MessageEvent, botMention string, client *socketmode.Client, channels *Channels) bool {
+	if p.Message == nil || p.Message.Timestamp == "" {
+		client.Debugf("skipped message_changed / no message")
+		return true
+	} else if !channels.isAuthor(p.Channel, p.Message.User) {
+		client.Debugf("skipped message_changed / not author message")
+		return true
+	} else if strings.HasPrefix(p.Message.Text, botMention) {
+		client.Debugf("skipped message_changed / bot mention")
+		return true
+	} else if p.PreviousMessage != nil && p.PreviousMessage.Text == p.Message.Text && len(slackUnfurls(p.Message.Attachments)) == 0 {
+		client.Debugf("skipped message_changed / text not changed")
+		return true
+	}
+	return false
+}
+
+func skipMessageDeleted(p *slackevents.MessageEvent, client *socketmode.Client, channels *Channels) bool {
+	if p.DeletedTimeStamp == "" {
+		client.Debugf("skipped message_deleted / no deleted_ts")
+		return true
+	} else if p.PreviousMessage == nil || !channels.isAuthor(p.Channel, p.PreviousMessage.User) {
+		client.Debugf("skipped message_deleted / not author message")
+		return true
+	}
+	return false
+}
+
+// resolveUserDisplayName はユーザーの表示名を取得します。取得できない場合は空文字を返します。
+func resolveUserDisplayName(ctx context.Context, client userInfoGetter, userID string) string {
+	user, err := client.GetUserInfoContext(ctx, userID)
+	if err != nil {
+		log.Printf("ユーザー情報取得をスキップ: user=%s err=%v", userID, err)
+		return ""
+	}
+	if user == nil {
+		return ""
+	}
+	return firstNonEmpty(user.Profile.DisplayName, user.RealName, user.Name)
+}
+
+func downloadImageFiles(ctx context.Context, client fileContextGetter, channelName string, channels *Channels, files []slack.File, timestamp string, gdrive *GDrive) ([]Attachment, error) {
 	ctx, span := tracer.Start(ctx, "downloadImageFiles")
 	defer span.End()
 
-	filenames := make([]string, 0)
+	filenames := make([]Attachment, 0)
 	errors := make([]string, 0)
 	for i, file := range files {
 		if len(file.URLPrivateDownload) > 0 {
@@ -160,32 +312,74 @@ func downloadImageFiles(ctx context.Context, client fileContextGetter, channelNa
 	return filenames, nil
 }
 
-func downloadSingleImageFile(ctx context.Context, client fileContextGetter, channelName string, channels *Channels, file slack.File, timestamp string, index int, gdrive *GDrive) (string, error) {
+func downloadSingleImageFile(ctx context.Context, client fileContextGetter, channelName string, channels *Channels, file slack.File, timestamp string, index int, gdrive *GDrive) (Attachment, error) {
 	localFile, err := channels.CreateLocalFile(channelName, timestamp, index, file.Filetype)
 	if err != nil {
-		return "", fmt.Errorf("attachment index=%d stage=create_local_file: %w", index, err)
+		return Attachment{}, fmt.Errorf("attachment index=%d stage=create_local_file: %w", index, err)
 	}
 	defer func() {
 		_ = localFile.Close()
 	}()
 
 	if err := client.GetFileContext(ctx, file.URLPrivateDownload, localFile); err != nil {
-		return "", fmt.Errorf("attachment index=%d stage=download url=%s: %w", index, file.URLPrivateDownload, err)
+		return Attachment{}, fmt.Errorf("attachment index=%d stage=download url=%s: %w", index, file.URLPrivateDownload, err)
+	}
+	if err := localFile.Close(); err != nil {
+		return Attachment{}, fmt.Errorf("attachment index=%d stage=close_local_file: %w", index, err)
+	}
+	// 位置情報などを含んだままGoogle Driveへアップロードしないよう、アップロード前に取り除く
+	if err := channels.stripAttachmentMetadata(channels.CreateFilePathForMessage(channelName, timestamp, index, file.Filetype)); err != nil {
+		return Attachment{}, fmt.Errorf("attachment index=%d stage=strip_metadata: %w", index, err)
 	}
 
-	if err := gdrive.CreateImageFile(
-		ctx,
-		channels.CreateImageFileName(timestamp, index, file.Filetype),
-		channelName,
-		channels.CreateImageFilePath(channelName, timestamp, index, file.Filetype),
-	); err != nil {
-		return "", fmt.Errorf("attachment index=%d stage=upload: %w", index, err)
+	// 同じ内容のファイルはローカルにもGoogle Driveにも1つだけ保存する
+	blobRel, hash, created, err := channels.storeBlob(channels.CreateFilePathForMessage(channelName, timestamp, index, file.Filetype))
+	if err != nil {
+		return Attachment{}, fmt.Errorf("attachment index=%d stage=store: %w", index, err)
+	}
+	if created {
+		if err := channels.uploadBlob(ctx, blobRel, gdrive); err != nil {
+			return Attachment{}, fmt.Errorf("attachment index=%d stage=upload: %w", index, err)
+		}
 	}
 
-	return channels.CreateFilePathForMessage(channelName, timestamp, index, file.Filetype), nil
+	attachment := newAttachment(file, blobRel)
+	attachment.Hash = hash
+	if attachment.IsImage() {
+		createAttachmentVariants(ctx, channels, &attachment, gdrive, created)
+	}
+	return attachment, nil
+}
+
+// createAttachmentVariants は画像の縮小版を作成し、upload が true の場合はアップロードします。
+// 保存済みの画像と同じ内容の場合、縮小版もアップロード済みのため upload は false にします。
+// 縮小版はビューアの表示を軽くするためのものなので、失敗してもログに残して元画像だけで続行します。
+func createAttachmentVariants(ctx context.Context, channels *Channels, attachment *Attachment, gdrive *GDrive, upload bool) {
+	if err := channels.createImageVariants(attachment); err != nil {
+		if !errors.Is(err, errImageVariantSkipped) {
+			log.Printf("縮小版の作成に失敗: %s: %v", attachment.Path, err)
+		}
+		return
+	}
+	if !upload {
+		return
+	}
+	uploaded := make([]ImageVariant, 0, len(attachment.Variants))
+	for _, v := range attachment.Variants {
+		localPath, err := channels.safeJoinUnderBase(v.Path)
+		if err == nil {
+			err = gdrive.CreateThumbnailFile(ctx, filepath.Base(v.Path), filepath.Base(filepath.Dir(v.Path)), localPath)
+		}
+		if err != nil {
+			log.Printf("縮小版のアップロードに失敗: %s: %v", v.Path, err)
+			continue
+		}
+		uploaded = append(uploaded, v)
+	}
+	attachment.Variants = uploaded
 }
 
-func BotJoinedEventHandler(botID string) socketmode.SocketmodeHandlerFunc {
+func BotJoinedEventHandler(channels *Channels, botID string) socketmode.SocketmodeHandlerFunc {
 	return func(event *socketmode.Event, client *socketmode.Client) {
 		if tracer == nil {
 			tracer = otel.GetTracerProvider().Tracer("client")
@@ -209,6 +403,7 @@ func BotJoinedEventHandler(botID string) socketmode.SocketmodeHandlerFunc {
 			client.Debugf("%s != bot id, skipped message.", p.User)
 			return
 		} else {
+			channels.setChannelState(p.Channel, channelStateJoined)
 			if _, _, err := client.PostMessage(p.Channel, slack.MsgOptionText("Start recording by happeninghound!", false)); err != nil {
 				fmt.Printf("######### : failed posting message: %v\n", err)
 			}
@@ -265,7 +460,8 @@ func executeCommand(ctx context.Context, ev slack.SlashCommand, channels *Channe
 		if err != nil {
 			return fmt.Sprintf("%v\nError: %v", msg, err.Error())
 		}
-		if err := validateChannelName(channelName); err != nil {
+		channelName = channels.resolveChannelArg(channelName)
+		if err := channels.validateStoredChannelName(channelName); err != nil {
 			msg = fmt.Sprintf("%v\nError: %v", msg, err.Error())
 			return msg
 		}
@@ -288,11 +484,12 @@ func executeCommand(ctx context.Context, ev slack.SlashCommand, channels *Channe
 		if err != nil {
 			return fmt.Sprintf("%v\nError: %v", msg, err.Error())
 		}
-		if err := validateChannelName(channelName); err != nil {
+		channelName = channels.resolveChannelArg(channelName)
+		if err := channels.validateStoredChannelName(channelName); err != nil {
 			return fmt.Sprintf("%v\nError: %v", msg, err.Error())
 		}
 
-		result, err := channels.CreateMarkdownZip(channelName, channels.authorID, since)
+		result, err := channels.CreateMarkdownZip(ctx, channelName, since)
 		if err != nil {
 			fmt.Printf("######### : Got error %v\n", err)
 			return fmt.Sprintf("%v\nError: %v", msg, err.Error())
@@ -318,12 +515,130 @@ func executeCommand(ctx context.Context, ev slack.SlashCommand, channels *Channe
 		} else {
 			msg = fmt.Sprintf("%s\nSaved to: %s", msg, result.ZipPath)
 		}
+	} else if strings.HasPrefix(ev.Command, "/backfill") {
+		msg = "Backfilled messages"
+		channelName, since, err := resolveBackfillParams(ev)
+		if err != nil {
+			return fmt.Sprintf("%v\nError: %v", msg, err.Error())
+		}
+		channelName = channels.resolveChannelArg(channelName)
+		if err := channels.validateStoredChannelName(channelName); err != nil {
+			return fmt.Sprintf("%v\nError: %v", msg, err.Error())
+		}
+		if client == nil {
+			return fmt.Sprintf("%v\nError: slack client is not available", msg)
+		}
+		channelID, err := resolveBackfillChannelID(ev, channelName, channels)
+		if err != nil {
+			return fmt.Sprintf("%v\nError: %v", msg, err.Error())
+		}
+		auth, err := client.AuthTestContext(ctx)
+		if err != nil {
+			return fmt.Sprintf("%v\nError: %v", msg, err.Error())
+		}
+
+		result, err := channels.Backfill(ctx, client, Channel{ID: channelID, Name: channelName}, since, fmt.Sprintf("<@%s>", auth.UserID), gdrive)
+		for _, warning := range result.Warnings {
+			log.Printf("[backfill] %s", warning)
+		}
+		if err != nil {
+			fmt.Printf("######### : Got error %v\n", err)
+			return fmt.Sprintf("%v\nError: %v", msg, err.Error())
+		}
+		msg = fmt.Sprintf("%s\n%s: %d added, %d skipped (fetched %d)", msg, channelName, result.Added, result.Skipped, result.Fetched)
+		if len(result.Warnings) > 0 {
+			msg = fmt.Sprintf("%s\nWarnings: %d (see log)", msg, len(result.Warnings))
+		}
+	} else if strings.HasPrefix(ev.Command, "/dedupe") {
+		msg = "Removed duplicate entries"
+		channelName := channels.resolveChannelArg(resolvedChannelName(ev))
+		if err := channels.validateStoredChannelName(channelName); err != nil {
+			return fmt.Sprintf("%v\nError: %v", msg, err.Error())
+		}
+		removed, err := channels.RemoveDuplicateEntries(ctx, channelName, gdrive)
+		if err != nil {
+			fmt.Printf("######### : Got error %v\n", err)
+			return fmt.Sprintf("%v\nError: %v", msg, err.Error())
+		}
+		msg = fmt.Sprintf("%s\n%s: %d removed", msg, channelName, removed)
+	} else if strings.HasPrefix(ev.Command, "/migrate-attachments") {
+		msg = "Migrated attachments"
+		channelName := ""
+		if raw := strings.TrimSpace(ev.Text); raw != "" {
+			channelName = channels.resolveChannelArg(strings.TrimSuffix(raw, ".jsonl"))
+			if err := channels.validateStoredChannelName(channelName); err != nil {
+				return fmt.Sprintf("%v\nError: %v", msg, err.Error())
+			}
+		}
+		result, err := channels.MigrateAttachments(ctx, channelName, gdrive)
+		for _, warning := range result.Warnings {
+			log.Printf("[migrate-attachments] %s", warning)
+		}
+		if err != nil {
+			fmt.Printf("######### : Got error %v\n", err)
+			return fmt.Sprintf("%v\nError: %v", msg, err.Error())
+		}
+		msg = fmt.Sprintf("%s\n%d channels, %d attachments (%d deduplicated)", msg, result.Channels, result.Migrated, result.Deduplicated)
+		if len(result.Warnings) > 0 {
+			msg = fmt.Sprintf("%s\nWarnings: %d (see log)", msg, len(result.Warnings))
+		}
+	} else if strings.HasPrefix(ev.Command, "/preview-cache") {
+		msg = "Link preview cache"
+		result, err := channels.previewCacheCommand(ctx, strings.Fields(ev.Text), strings.TrimSpace(ev.ChannelName))
+		if err != nil {
+			fmt.Printf("######### : Got error %v\n", err)
+			return fmt.Sprintf("%v\nError: %v", msg, err.Error())
+		}
+		msg = fmt.Sprintf("%s\n%s", msg, result)
 	} else {
 		msg = "Unknown command..."
 	}
 	return msg
 }
 
+func resolveBackfillParams(ev slack.SlashCommand) (string, *time.Time, error) {
+	const usage = "usage: /backfill [channel] [period] or /backfill [period]"
+
+	channelName := strings.TrimSpace(ev.ChannelName)
+	args := strings.Fields(strings.TrimSpace(ev.Text))
+	if len(args) == 0 {
+		return channelName, nil, nil
+	}
+	if len(args) > 2 {
+		return "", nil, fmt.Errorf("invalid args (%s)", usage)
+	}
+
+	if len(args) == 1 {
+		if since, ok, err := parseRelativePeriod(args[0]); err != nil {
+			return "", nil, fmt.Errorf("%w. %s", err, usage)
+		} else if ok {
+			return channelName, since, nil
+		}
+		return strings.TrimSpace(strings.TrimSuffix(args[0], ".jsonl")), nil, nil
+	}
+
+	since, ok, err := parseRelativePeriod(args[1])
+	if err != nil {
+		return "", nil, fmt.Errorf("%w. %s", err, usage)
+	}
+	if !ok {
+		return "", nil, fmt.Errorf("invalid period: %q (e.g. 30d). %s", args[1], usage)
+	}
+	return strings.TrimSpace(strings.TrimSuffix(args[0], ".jsonl")), since, nil
+}
+
+// resolveBackfillChannelID はバックフィル対象チャンネルのIDを求めます。
+// コマンドを実行したチャンネル以外は、記録済みJSONLのチャンネル情報から解決します。
+func resolveBackfillChannelID(ev slack.SlashCommand, channelName string, channels *Channels) (string, error) {
+	if channelName == strings.TrimSpace(ev.ChannelName) && ev.ChannelID != "" {
+		return ev.ChannelID, nil
+	}
+	if id := channels.channelIDByName(channelName); id != "" {
+		return id, nil
+	}
+	return "", fmt.Errorf("channel id for %q not found: run /backfill in that channel", channelName)
+}
+
 func resolvedChannelName(ev slack.SlashCommand) string {
 	channelName := strings.TrimSpace(ev.ChannelName)
 	if raw := strings.TrimSpace(ev.Text); raw != "" {
@@ -367,16 +682,6 @@ func resolveMakeHTMLParams(ev slack.SlashCommand) (string, *time.Time, error) {
 	return strings.TrimSpace(strings.TrimSuffix(args[0], ".jsonl")), since, nil
 }
 
-func validateChannelName(channelName string) error {
-	if channelName == "" {
-		return fmt.Errorf("invalid channel name: must not be empty")
-	}
-	if !channelNamePattern.MatchString(channelName) {
-		return fmt.Errorf("invalid channel name %q: allowed pattern is [a-z0-9_-]+", channelName)
-	}
-	return nil
-}
-
 func resolveMakeMDParams(ev slack.SlashCommand) (string, *time.Time, error) {
 	channelName := strings.TrimSpace(ev.ChannelName)
 	args := strings.Fields(strings.TrimSpace(ev.Text))
@@ -525,6 +830,11 @@ func ChannelArchiveHandler(channels *Channels, gdrive *GDrive) socketmode.Socket
 		}
 		span.SetAttributes(attribute.String("slack.channel.name", channel.Name))
 
+		if err := channels.observeChannel(ctx, Channel{ID: channelID, Name: channel.Name}, gdrive); err != nil {
+			fmt.Printf("######### : Got error %v\n", err)
+		}
+		channels.setChannelState(channelID, channelStateArchived)
+
 		channelName := channel.Name
 		msg := "Created html file"
 		err = channels.CreateHtmlFile(ctx, channelName, gdrive, nil)
@@ -535,3 +845,91 @@ func ChannelArchiveHandler(channels *Channels, gdrive *GDrive) socketmode.Socket
 		fmt.Printf("%s - %s\n", msg, channelName)
 	}
 }
+
+// ChannelRenameHandler はチャンネル名の変更をチャンネル一覧に反映し、保存ファイルを新しい名前へ移行します。
+func ChannelRenameHandler(channels *Channels, gdrive *GDrive) socketmode.SocketmodeHandlerFunc {
+	return func(event *socketmode.Event, client *socketmode.Client) {
+		if tracer == nil {
+			tracer = otel.GetTracerProvider().Tracer("client")
+		}
+		ctx, span := tracer.Start(context.Background(), "ChannelRenameHandler")
+		defer span.End()
+
+		client.Debugf("Channel rename event handling...")
+		eventPayload, ok := event.Data.(slackevents.EventsAPIEvent)
+		if !ok {
+			client.Debugf("skipped Envelope: %v", event)
+			return
+		}
+		client.Ack(*event.Request)
+		p, ok := eventPayload.InnerEvent.Data.(*slackevents.ChannelRenameEvent)
+		if !ok {
+			client.Debugf("skipped Payload Event: %v", event)
+			return
+		}
+		span.SetAttributes(
+			attribute.String("slack.channel.id", p.Channel.ID),
+			attribute.String("slack.channel.name", p.Channel.Name),
+		)
+
+		if err := channels.observeChannel(ctx, Channel{ID: p.Channel.ID, Name: p.Channel.Name}, gdrive); err != nil {
+			fmt.Printf("######### : Got error %v\n", err)
+		}
+	}
+}
+
+// MemberLeftChannelHandler はボットがチャンネルから退出した場合に、チャンネル一覧の状態を更新します。
+func MemberLeftChannelHandler(channels *Channels, botID string) socketmode.SocketmodeHandlerFunc {
+	return func(event *socketmode.Event, client *socketmode.Client) {
+		if tracer == nil {
+			tracer = otel.GetTracerProvider().Tracer("client")
+		}
+		_, span := tracer.Start(context.Background(), "MemberLeftChannelHandler")
+		defer span.End()
+
+		client.Debugf("Member left event handling...")
+		eventPayload, ok := event.Data.(slackevents.EventsAPIEvent)
+		if !ok {
+			client.Debugf("skipped Envelope: %v", event)
+			return
+		}
+		client.Ack(*event.Request)
+		p, ok := eventPayload.InnerEvent.Data.(*slackevents.MemberLeftChannelEvent)
+		if !ok {
+			client.Debugf("skipped Payload Event: %v", event)
+			return
+		}
+		span.SetAttributes(attribute.String("slack.channel.id", p.Channel))
+		if p.User != botID {
+			client.Debugf("%s != bot id, skipped message.", p.User)
+			return
+		}
+		channels.setChannelState(p.Channel, channelStateLeft)
+	}
+}
+
+// ChannelUnarchiveHandler はアーカイブが解除されたチャンネルを記録中の状態に戻します。
+func ChannelUnarchiveHandler(channels *Channels) socketmode.SocketmodeHandlerFunc {
+	return func(event *socketmode.Event, client *socketmode.Client) {
+		if tracer == nil {
+			tracer = otel.GetTracerProvider().Tracer("client")
+		}
+		_, span := tracer.Start(context.Background(), "ChannelUnarchiveHandler")
+		defer span.End()
+
+		client.Debugf("Channel unarchive event handling...")
+		eventPayload, ok := event.Data.(slackevents.EventsAPIEvent)
+		if !ok {
+			client.Debugf("skipped Envelope: %v", event)
+			return
+		}
+		client.Ack(*event.Request)
+		p, ok := eventPayload.InnerEvent.Data.(*slackevents.ChannelUnarchiveEvent)
+		if !ok {
+			client.Debugf("skipped Payload Event: %v", event)
+			return
+		}
+		span.SetAttributes(attribute.String("slack.channel.id", p.Channel))
+		channels.setChannelState(p.Channel, channelStateJoined)
+	}
+}
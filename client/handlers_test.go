@@ -14,17 +14,25 @@ import (
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
 	"github.com/slack-go/slack/socketmode"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/api/drive/v3"
 )
 
 type stubFileContextGetter struct {
 	failByURL map[string]error
+	// body はダウンロードする内容です。空の場合は "ok" を書き込みます。
+	body string
 }
 
 func (s stubFileContextGetter) GetFileContext(_ context.Context, downloadURL string, writer io.Writer) error {
 	if err, ok := s.failByURL[downloadURL]; ok {
 		return err
 	}
-	if _, err := io.WriteString(writer, "ok"); err != nil {
+	body := s.body
+	if body == "" {
+		body = "ok"
+	}
+	if _, err := io.WriteString(writer, body); err != nil {
 		return err
 	}
 	return nil
@@ -84,14 +92,16 @@ func TestValidateChannelName(t *testing.T) {
 		{name: "invalid windows traversal", channelName: "..\\etc", wantErr: true},
 		{name: "invalid slash", channelName: "a/b", wantErr: true},
 		{name: "invalid absolute", channelName: "/tmp/test", wantErr: true},
-		{name: "invalid uppercase", channelName: "General", wantErr: true},
+		{name: "valid uppercase", channelName: "General", wantErr: false},
+		{name: "valid japanese", channelName: "雑談", wantErr: false},
 	}
 
+	c := &Channels{basedir: t.TempDir()}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateChannelName(tt.channelName)
+			err := c.validateStoredChannelName(tt.channelName)
 			if (err != nil) != tt.wantErr {
-				t.Fatalf("validateChannelName() error = %v, wantErr %v", err, tt.wantErr)
+				t.Fatalf("validateStoredChannelName() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
@@ -368,7 +378,7 @@ func TestParseRelativePeriod(t *testing.T) {
 
 func TestSkipMessage(t *testing.T) {
 	botMention := "<@B999>"
-	channels := &Channels{authorID: "U123"}
+	channels := &Channels{authorIDs: []string{"U123"}}
 	client := &socketmode.Client{}
 
 	tests := []struct {
@@ -427,6 +437,60 @@ func TestSkipMessage(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "allow thread_broadcast",
+			p: &slackevents.MessageEvent{
+				Text:            "hello",
+				User:            "U123",
+				SubType:         "thread_broadcast",
+				ThreadTimeStamp: "1.0",
+			},
+			want: false,
+		},
+		{
+			name: "allow message_changed by author",
+			p: &slackevents.MessageEvent{
+				SubType:         "message_changed",
+				Message:         &slack.Msg{User: "U123", Text: "hello", Timestamp: "1.0"},
+				PreviousMessage: &slack.Msg{User: "U123", Text: "helo", Timestamp: "1.0"},
+			},
+			want: false,
+		},
+		{
+			name: "skip message_changed by other user",
+			p: &slackevents.MessageEvent{
+				SubType: "message_changed",
+				Message: &slack.Msg{User: "U999", Text: "hello", Timestamp: "1.0"},
+			},
+			want: true,
+		},
+		{
+			name: "allow message_deleted by author",
+			p: &slackevents.MessageEvent{
+				SubType:          "message_deleted",
+				DeletedTimeStamp: "1.0",
+				PreviousMessage:  &slack.Msg{User: "U123", Text: "hello", Timestamp: "1.0"},
+			},
+			want: false,
+		},
+		{
+			name: "skip message_deleted by other user",
+			p: &slackevents.MessageEvent{
+				SubType:          "message_deleted",
+				DeletedTimeStamp: "1.0",
+				PreviousMessage:  &slack.Msg{User: "U999", Text: "hello", Timestamp: "1.0"},
+			},
+			want: true,
+		},
+		{
+			name: "skip message_changed without text change",
+			p: &slackevents.MessageEvent{
+				SubType:         "message_changed",
+				Message:         &slack.Msg{User: "U123", Text: "hello", Timestamp: "1.0"},
+				PreviousMessage: &slack.Msg{User: "U123", Text: "hello", Timestamp: "1.0"},
+			},
+			want: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -516,6 +580,39 @@ func TestExecuteCommand_ShowFilesErrorIncludesMessage(t *testing.T) {
 	}
 }
 
+func TestExecuteCommand_MakeHTMLAcceptsRenamedNonASCIIChannel(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	registry, err := newChannelRegistry(baseDir)
+	if err != nil {
+		t.Fatalf("newChannelRegistry() error = %v", err)
+	}
+	registry.Observe("C123", "雑談", time.Now())
+	jsonl := `{"timestamp":"1775088000.000000","message":"hello","channel":{"id":"C123","name":"雑談"},"files":[]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "雑談.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	c := &Channels{basedir: baseDir, registry: registry}
+	g := &GDrive{
+		htmlDir: &drive.File{Id: "html-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			return nil
+		},
+	}
+
+	msg := executeCommand(context.Background(), slack.SlashCommand{Command: "/make-html", ChannelName: "general", Text: "<#C123>"}, c, g, baseDir, nil)
+	if strings.Contains(msg, "Error:") {
+		t.Fatalf("executeCommand() = %q, want success", msg)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, HtmlDir, "雑談.html")); err != nil {
+		t.Fatalf("html file should be created: %v", err)
+	}
+}
+
 func TestParseRelativePeriodPatternOnlyD(t *testing.T) {
 	_, found, err := parseRelativePeriod("15w")
 	if err != nil {
@@ -547,7 +644,7 @@ func TestDownloadImageFiles_AllSuccess(t *testing.T) {
 	getter := stubFileContextGetter{}
 	uploadCalls := 0
 	gdrive := &GDrive{
-		createImageFileFn: func(ctx context.Context, name, parent, filepath string) error {
+		createBlobFileFn: func(ctx context.Context, name, parent, filepath string) error {
 			uploadCalls++
 			return nil
 		},
@@ -578,7 +675,7 @@ func TestDownloadImageFiles_PartialFailureKeepsSuccesses(t *testing.T) {
 	}
 	uploadCalls := 0
 	gdrive := &GDrive{
-		createImageFileFn: func(ctx context.Context, name, parent, filepath string) error {
+		createBlobFileFn: func(ctx context.Context, name, parent, filepath string) error {
 			uploadCalls++
 			return nil
 		},
@@ -613,7 +710,7 @@ func TestDownloadImageFiles_AllFailure(t *testing.T) {
 	}
 	uploadCalls := 0
 	gdrive := &GDrive{
-		createImageFileFn: func(ctx context.Context, name, parent, filepath string) error {
+		createBlobFileFn: func(ctx context.Context, name, parent, filepath string) error {
 			uploadCalls++
 			return nil
 		},
@@ -637,3 +734,85 @@ func TestDownloadImageFiles_AllFailure(t *testing.T) {
 		t.Fatalf("downloadImageFiles() error = %q, want both failed indexes", err.Error())
 	}
 }
+
+type stubUserInfoGetter struct {
+	user *slack.User
+	err  error
+}
+
+func (s stubUserInfoGetter) GetUserInfoContext(_ context.Context, _ string) (*slack.User, error) {
+	return s.user, s.err
+}
+
+func TestResolveUserDisplayName(t *testing.T) {
+	tests := []struct {
+		name   string
+		getter stubUserInfoGetter
+		want   string
+	}{
+		{name: "display name", getter: stubUserInfoGetter{user: &slack.User{Name: "alice", RealName: "Alice A", Profile: slack.UserProfile{DisplayName: "ali"}}}, want: "ali"},
+		{name: "real name fallback", getter: stubUserInfoGetter{user: &slack.User{Name: "alice", RealName: "Alice A"}}, want: "Alice A"},
+		{name: "error", getter: stubUserInfoGetter{err: errors.New("user_not_found")}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveUserDisplayName(context.Background(), tt.getter, "U1"); got != tt.want {
+				t.Fatalf("resolveUserDisplayName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveBackfillParams(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		wantChannel string
+		wantSince   bool
+		wantErr     bool
+	}{
+		{name: "no args", text: "", wantChannel: "general"},
+		{name: "period only", text: "30d", wantChannel: "general", wantSince: true},
+		{name: "channel only", text: "dev-team", wantChannel: "dev-team"},
+		{name: "channel and period", text: "dev-team 7d", wantChannel: "dev-team", wantSince: true},
+		{name: "invalid period", text: "dev-team 7h", wantErr: true},
+		{name: "too many args", text: "a b c", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, since, err := resolveBackfillParams(slack.SlashCommand{ChannelName: "general", Text: tt.text})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveBackfillParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), "usage: /backfill") {
+					t.Fatalf("resolveBackfillParams() error = %v, want usage", err)
+				}
+				return
+			}
+			if ch != tt.wantChannel || (since != nil) != tt.wantSince {
+				t.Fatalf("resolveBackfillParams() = %q, %v", ch, since)
+			}
+		})
+	}
+}
+
+func TestResolveBackfillChannelID(t *testing.T) {
+	baseDir := t.TempDir()
+	jsonl := `{"timestamp":"1.0","message":"m","channel":{"id":"C222","name":"dev-team"},"files":[]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "dev-team.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	channels := &Channels{basedir: baseDir}
+	ev := slack.SlashCommand{ChannelID: "C111", ChannelName: "general"}
+
+	if got, err := resolveBackfillChannelID(ev, "general", channels); err != nil || got != "C111" {
+		t.Fatalf("resolveBackfillChannelID(general) = %q, %v", got, err)
+	}
+	if got, err := resolveBackfillChannelID(ev, "dev-team", channels); err != nil || got != "C222" {
+		t.Fatalf("resolveBackfillChannelID(dev-team) = %q, %v", got, err)
+	}
+	if _, err := resolveBackfillChannelID(ev, "unknown", channels); err == nil {
+		t.Fatal("resolveBackfillChannelID(unknown) error = nil, want error")
+	}
+}
@@ -0,0 +1,231 @@
+package client
+
+import (
+	"html/template"
+	"path"
+	"strings"
+)
+
+// codeLanguage はコードの簡易ハイライトに使う言語ごとの字句規則です。
+type codeLanguage struct {
+	lineComments  []string
+	blockComments [][2]string
+	// quotes は文字列リテラルの区切り文字です。` は複数行の文字列として扱います。
+	quotes     string
+	keywords   map[string]bool
+	ignoreCase bool
+}
+
+const (
+	codeCommentClass = "italic text-gray-400"
+	codeStringClass  = "text-green-700"
+	codeNumberClass  = "text-orange-600"
+	codeKeywordClass = "font-medium text-purple-700"
+)
+
+func keywordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+var cLikeLanguage = &codeLanguage{
+	lineComments:  []string{"//"},
+	blockComments: [][2]string{{"/*", "*/"}},
+	quotes:        `"'`,
+	keywords: keywordSet(`abstract auto bool boolean break byte case catch char class const continue default delete do double else enum
+		extends extern false final finally float for fun goto if implements import int interface long namespace new null nullptr
+		override package private protected public return short signed sizeof static struct super switch template this throw
+		true try typedef typename union unsigned using val var virtual void volatile when while`),
+}
+
+var codeLanguages = map[string]*codeLanguage{
+	"go": {
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'`",
+		keywords: keywordSet(`break case chan const continue default defer else fallthrough false for func go goto if import
+			interface map nil package range return select struct switch true type var`),
+	},
+	"python": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		keywords: keywordSet(`False None True and as assert async await break class continue def del elif else except
+			finally for from global if import in is lambda nonlocal not or pass raise return try while with yield`),
+	},
+	"javascript": {
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        "\"'`",
+		keywords: keywordSet(`async await break case catch class const continue debugger default delete do else enum export
+			extends false finally for function if implements import in instanceof interface let new null return super switch
+			this throw true try type typeof undefined var void while yield`),
+	},
+	"c": cLikeLanguage,
+	"rust": {
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"`,
+		keywords: keywordSet(`as async await break const continue crate else enum extern false fn for if impl in let loop
+			match mod move mut pub ref return self Self static struct super trait true type unsafe use where while`),
+	},
+	"ruby": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		keywords: keywordSet(`begin class def do else elsif end ensure false for if in module next nil require rescue
+			return self true unless until when while yield`),
+	},
+	"shell": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		keywords:     keywordSet(`case do done elif else esac export fi for function if in local return then until while`),
+	},
+	"sql": {
+		lineComments:  []string{"--"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `'"`,
+		ignoreCase:    true,
+		keywords: keywordSet(`alter and as asc by case create delete desc distinct drop else end from group having in index
+			inner insert into is join left like limit not null on or order outer right select set table then union update
+			values when where with`),
+	},
+	"yaml": {
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+		keywords:     keywordSet(`true false null yes no`),
+	},
+	"json": {
+		quotes:   `"`,
+		keywords: keywordSet(`true false null`),
+	},
+}
+
+// codeLanguageAliases はSlackのファイル種別（filetype）や拡張子を codeLanguages のキーに対応付けます。
+var codeLanguageAliases = map[string]string{
+	"golang": "go", "py": "python", "js": "javascript", "jsx": "javascript", "ts": "javascript",
+	"tsx": "javascript", "typescript": "javascript", "cpp": "c", "h": "c", "hpp": "c", "java": "c",
+	"kotlin": "c", "kt": "c", "csharp": "c", "cs": "c", "swift": "c", "scala": "c", "php": "c",
+	"rs": "rust", "rb": "ruby", "sh": "shell", "bash": "shell", "zsh": "shell", "yml": "yaml",
+}
+
+// lookupCodeLanguage はファイル種別、なければファイル名の拡張子から言語を探します。
+func lookupCodeLanguage(fileType, name string) (string, *codeLanguage) {
+	for _, key := range []string{fileType, strings.TrimPrefix(path.Ext(name), ".")} {
+		key = strings.ToLower(key)
+		if alias, ok := codeLanguageAliases[key]; ok {
+			key = alias
+		}
+		if lang, ok := codeLanguages[key]; ok {
+			return key, lang
+		}
+	}
+	return "", nil
+}
+
+// highlightCode はコードをHTMLエスケープし、コメント・文字列・数値・キーワードを色付けした span で囲みます。
+// 対応していない言語の場合はエスケープのみ行います。
+func highlightCode(code string, lang *codeLanguage) template.HTML {
+	if lang == nil {
+		return template.HTML(template.HTMLEscapeString(code))
+	}
+	var b strings.Builder
+	span := func(class, text string) {
+		b.WriteString(`<span class="` + class + `">`)
+		b.WriteString(template.HTMLEscapeString(text))
+		b.WriteString(`</span>`)
+	}
+	for i := 0; i < len(code); {
+		rest := code[i:]
+		if n := lang.commentLength(rest); n > 0 {
+			span(codeCommentClass, rest[:n])
+			i += n
+			continue
+		}
+		c := rest[0]
+		switch {
+		case strings.IndexByte(lang.quotes, c) >= 0:
+			n := stringLiteralLength(rest)
+			span(codeStringClass, rest[:n])
+			i += n
+		case isDigit(c) && (i == 0 || !isIdentByte(code[i-1])):
+			n := 1
+			for n < len(rest) && (isIdentByte(rest[n]) || rest[n] == '.') {
+				n++
+			}
+			span(codeNumberClass, rest[:n])
+			i += n
+		case isIdentByte(c):
+			n := 1
+			for n < len(rest) && isIdentByte(rest[n]) {
+				n++
+			}
+			word := rest[:n]
+			key := word
+			if lang.ignoreCase {
+				key = strings.ToLower(word)
+			}
+			if lang.keywords[key] {
+				span(codeKeywordClass, word)
+			} else {
+				b.WriteString(template.HTMLEscapeString(word))
+			}
+			i += n
+		default:
+			b.WriteString(template.HTMLEscapeString(rest[:1]))
+			i++
+		}
+	}
+	return template.HTML(b.String())
+}
+
+// commentLength は s の先頭がコメントの場合にその長さを返します。閉じられていないブロックコメントは末尾までとします。
+func (l *codeLanguage) commentLength(s string) int {
+	for _, prefix := range l.lineComments {
+		if strings.HasPrefix(s, prefix) {
+			if end := strings.IndexByte(s, '\n'); end >= 0 {
+				return end
+			}
+			return len(s)
+		}
+	}
+	for _, pair := range l.blockComments {
+		if strings.HasPrefix(s, pair[0]) {
+			if end := strings.Index(s[len(pair[0]):], pair[1]); end >= 0 {
+				return len(pair[0]) + end + len(pair[1])
+			}
+			return len(s)
+		}
+	}
+	return 0
+}
+
+// stringLiteralLength は s の先頭の文字列リテラルの長さを返します。
+// ` 以外の文字列は改行で打ち切り、\ によるエスケープを考慮します。
+func stringLiteralLength(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case '\n':
+			if quote != '`' {
+				return i
+			}
+		case quote:
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestHighlightCode(t *testing.T) {
+	_, goLang := lookupCodeLanguage("go", "")
+	_, sqlLang := lookupCodeLanguage("", "query.sql")
+	tests := []struct {
+		name string
+		code string
+		lang *codeLanguage
+		want template.HTML
+	}{
+		{
+			name: "unknown language only escapes",
+			code: "a < b",
+			want: "a &lt; b",
+		},
+		{
+			name: "go keywords strings numbers and comments",
+			code: "func f() { return \"<x>\" + 42 } // done",
+			lang: goLang,
+			want: `<span class="font-medium text-purple-700">func</span> f() { <span class="font-medium text-purple-700">return</span> ` +
+				`<span class="text-green-700">&#34;&lt;x&gt;&#34;</span> + <span class="text-orange-600">42</span> } ` +
+				`<span class="italic text-gray-400">// done</span>`,
+		},
+		{
+			name: "identifiers containing digits are not numbers",
+			code: "v2 := x1",
+			lang: goLang,
+			want: "v2 := x1",
+		},
+		{
+			name: "escaped quote and unterminated block comment",
+			code: "s := \"a\\\"b\" /* open",
+			lang: goLang,
+			want: `s := <span class="text-green-700">&#34;a\&#34;b&#34;</span> <span class="italic text-gray-400">/* open</span>`,
+		},
+		{
+			name: "case insensitive keywords",
+			code: "SELECT 1 -- one",
+			lang: sqlLang,
+			want: `<span class="font-medium text-purple-700">SELECT</span> <span class="text-orange-600">1</span> <span class="italic text-gray-400">-- one</span>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlightCode(tt.code, tt.lang); got != tt.want {
+				t.Errorf("highlightCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttachment_Kind(t *testing.T) {
+	tests := []struct {
+		file Attachment
+		want attachmentKind
+	}{
+		{Attachment{Path: "images/general/1.0_0.mp4", MimeType: "video/mp4"}, attachmentKindVideo},
+		{Attachment{Path: "images/general/1.0_0.m4a", MimeType: "audio/mp4"}, attachmentKindAudio},
+		{Attachment{Path: "images/general/1.0_0.pdf", MimeType: "application/pdf"}, attachmentKindPDF},
+		{Attachment{Path: "images/general/1.0_0.text", MimeType: "text/plain", Mode: "snippet", FileType: "go"}, attachmentKindText},
+		{Attachment{Path: "images/general/1.0_0.py", MimeType: "application/octet-stream", FileType: "python"}, attachmentKindText},
+		{Attachment{Path: "images/general/1.0_0.zip", MimeType: "application/zip"}, attachmentKindFile},
+		{Attachment{Path: "images/general/1.0_0.mov"}, attachmentKindVideo},
+		{Attachment{Path: "images/general/1.0_0.mp3"}, attachmentKindAudio},
+		{Attachment{Path: "images/general/1.0_0.go"}, attachmentKindText},
+		{Attachment{Path: "images/general/1.0_0.docx"}, attachmentKindFile},
+	}
+	for _, tt := range tests {
+		if got := tt.file.Kind(); got != tt.want {
+			t.Errorf("Kind(%+v) = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
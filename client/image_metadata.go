@@ -0,0 +1,240 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// imageMetadataMode は添付画像のメタデータ（EXIF/XMPの位置情報や端末情報）の扱いです。
+type imageMetadataMode string
+
+const (
+	// imageMetadataKeep は受信した画像をそのまま保存します（既定）。
+	imageMetadataKeep imageMetadataMode = "keep"
+	// imageMetadataStrip はメタデータを取り除いた画像を保存し、Google Driveと /make-md に使います。
+	imageMetadataStrip imageMetadataMode = "strip"
+	// imageMetadataStripKeepOriginal は imageMetadataStrip に加えて、元の画像をローカルの originals/ にだけ残します。
+	imageMetadataStripKeepOriginal imageMetadataMode = "strip_keep_original"
+
+	// OriginalImageDir はメタデータを取り除く前の画像を保存する base_dir 配下のディレクトリです。Google Driveにはアップロードしません。
+	OriginalImageDir = "originals"
+)
+
+var (
+	jpegSOI      = []byte{0xFF, 0xD8}
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader   = []byte("Exif\x00\x00")
+
+	// strippableImageExtList はメタデータを取り除く対象の拡張子です。
+	strippableImageExtList = []string{".jpg", ".jpeg", ".png"}
+	// pngMetadataChunks は取り除くPNGのチャンクです。XMPは iTXt に格納されます。
+	pngMetadataChunks = []string{"eXIf", "tEXt", "zTXt", "iTXt", "tIME"}
+
+	errImageMetadataTruncated = errors.New("画像の構造が途中で終わっています")
+)
+
+func (m imageMetadataMode) strips() bool {
+	return m == imageMetadataStrip || m == imageMetadataStripKeepOriginal
+}
+
+// stripImageMetadata はJPEG/PNGからメタデータを取り除いた内容を返します。それ以外の形式はそのまま返します。
+// 画像データは再エンコードしません。JPEGの向き（Orientation）だけは表示が崩れないよう最小限のEXIFとして残します。
+func stripImageMetadata(b []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(b, jpegSOI):
+		return stripJPEGMetadata(b)
+	case bytes.HasPrefix(b, pngSignature):
+		return stripPNGMetadata(b)
+	}
+	return b, nil
+}
+
+func stripJPEGMetadata(b []byte) ([]byte, error) {
+	segments := make([][]byte, 0)
+	orientation := 0
+	i := len(jpegSOI)
+	var tail []byte
+	for tail == nil {
+		if i+2 > len(b) || b[i] != 0xFF {
+			return nil, errImageMetadataTruncated
+		}
+		marker := b[i+1]
+		switch {
+		case marker == 0xFF:
+			// マーカー前の埋め草
+			i++
+			continue
+		case marker == 0xD9, marker == 0xDA:
+			// EOI、またはSOS以降の画像データはそのまま残す
+			tail = b[i:]
+			continue
+		case marker == 0x01, marker >= 0xD0 && marker <= 0xD7:
+			segments = append(segments, b[i:i+2])
+			i += 2
+			continue
+		}
+		if i+4 > len(b) {
+			return nil, errImageMetadataTruncated
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(b[i+2:i+4]))
+		if end < i+4 || end > len(b) {
+			return nil, errImageMetadataTruncated
+		}
+		payload := b[i+4 : end]
+		switch {
+		case marker == 0xE1:
+			// EXIF・XMP
+			if bytes.HasPrefix(payload, exifHeader) {
+				orientation = exifOrientation(payload[len(exifHeader):])
+			}
+		case marker == 0xFE, marker >= 0xE3 && marker <= 0xED, marker == 0xEF:
+			// コメント、IPTCなどのメーカー固有情報。APP0(JFIF)、APP2(ICCプロファイル)、APP14(Adobe)は色の解釈に使うので残す
+		default:
+			segments = append(segments, b[i:end])
+		}
+		i = end
+	}
+
+	var out bytes.Buffer
+	out.Write(jpegSOI)
+	if len(segments) > 0 && segments[0][1] == 0xE0 {
+		out.Write(segments[0])
+		segments = segments[1:]
+	}
+	if orientation > 1 {
+		out.Write(orientationEXIFSegment(orientation))
+	}
+	for _, seg := range segments {
+		out.Write(seg)
+	}
+	out.Write(tail)
+	return out.Bytes(), nil
+}
+
+// exifOrientation はEXIF（TIFF形式）のIFD0から向き（0x0112）を読み取ります。見つからない場合は0を返します。
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 && order.Uint16(tiff[entry+2:entry+4]) == 3 {
+			if v := int(order.Uint16(tiff[entry+8 : entry+10])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// orientationEXIFSegment は向き（Orientation）だけを持つAPP1セグメントを作成します。
+func orientationEXIFSegment(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // ヘッダとIFD0の位置
+		0x00, 0x01, // エントリ数
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, byte(orientation >> 8), byte(orientation), 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, // 次のIFDなし
+	}
+	payload := append(slices.Clone(exifHeader), tiff...)
+	seg := []byte{0xFF, 0xE1, 0x00, 0x00}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+func stripPNGMetadata(b []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.Write(pngSignature)
+	for i := len(pngSignature); ; {
+		if i+8 > len(b) {
+			return nil, errImageMetadataTruncated
+		}
+		length := int(binary.BigEndian.Uint32(b[i : i+4]))
+		chunkType := string(b[i+4 : i+8])
+		end := i + 12 + length
+		if end > len(b) || end < i {
+			return nil, errImageMetadataTruncated
+		}
+		if !slices.Contains(pngMetadataChunks, chunkType) {
+			out.Write(b[i:end])
+		}
+		if chunkType == "IEND" {
+			return out.Bytes(), nil
+		}
+		i = end
+	}
+}
+
+// isStrippableImagePath はメタデータを取り除く対象の画像ファイルかを拡張子で判定します。
+func isStrippableImagePath(p string) bool {
+	return slices.Contains(strippableImageExtList, strings.ToLower(filepath.Ext(p)))
+}
+
+// originalImagePath は images/ や blobs/ 配下の添付ファイルに対応する originals/ 配下の相対パスを返します。
+func originalImagePath(rel string) string {
+	_, rest, _ := strings.Cut(filepath.Clean(rel), string(filepath.Separator))
+	return filepath.Join(OriginalImageDir, rest)
+}
+
+// stripAttachmentMetadata は保存済みの添付画像からメタデータを取り除きます。
+// strip_keep_original の場合は元の画像を originals/ に移してから、取り除いた画像を書き込みます。
+func (c *Channels) stripAttachmentMetadata(rel string) error {
+	if !c.imageMetadata.strips() || !isStrippableImagePath(rel) {
+		return nil
+	}
+	localPath, err := c.safeJoinUnderBase(rel)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	stripped, err := stripImageMetadata(b)
+	if err != nil {
+		return fmt.Errorf("メタデータの除去に失敗: %w", err)
+	}
+	if bytes.Equal(stripped, b) {
+		return nil
+	}
+	if c.imageMetadata == imageMetadataStripKeepOriginal {
+		originalPath, err := c.safeJoinUnderBase(originalImagePath(rel))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(originalPath), os.ModePerm); err != nil {
+			return fmt.Errorf("元画像ディレクトリの作成に失敗: %w", err)
+		}
+		if err := os.WriteFile(originalPath, b, 0644); err != nil {
+			return fmt.Errorf("元画像の保存に失敗: %w", err)
+		}
+	}
+	tmpPath := localPath + ".tmp"
+	if err := os.WriteFile(tmpPath, stripped, 0644); err != nil {
+		return fmt.Errorf("メタデータを除去した画像の保存に失敗: %w", err)
+	}
+	return os.Rename(tmpPath, localPath)
+}
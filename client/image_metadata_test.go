@@ -0,0 +1,228 @@
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testEXIFSegment は向き（Orientation）と、位置情報の代わりになる文字列を持つAPP1セグメントを作成します。
+func testEXIFSegment(orientation uint16, secret string) []byte {
+	tiff := make([]byte, 0, 64)
+	tiff = append(tiff, 'I', 'I', 0x2A, 0x00)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 8)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	// Orientation
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+	// Make（ASCII）
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x010F)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	tiff = binary.LittleEndian.AppendUint32(tiff, uint32(len(secret)+1))
+	tiff = binary.LittleEndian.AppendUint32(tiff, 38)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	tiff = append(tiff, secret...)
+	tiff = append(tiff, 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	seg := []byte{0xFF, 0xE1}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+func testJPEGWithMetadata(t *testing.T, orientation uint16) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("jpeg.Encode() error = %v", err)
+	}
+	b := buf.Bytes()
+	comment := append([]byte{0xFF, 0xFE, 0x00, 0x0E}, "COMMENT-DATA"...)
+	xmpPayload := "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>XMP-LOCATION</x:xmpmeta>"
+	xmp := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(xmpPayload)+2))
+	xmp = append(xmp, xmpPayload...)
+
+	out := append([]byte{}, b[:2]...)
+	out = append(out, testEXIFSegment(orientation, "GPS-SECRET")...)
+	out = append(out, xmp...)
+	out = append(out, comment...)
+	return append(out, b[2:]...)
+}
+
+func testPNGWithMetadata(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+	b := buf.Bytes()
+	chunk := func(typ, data string) []byte {
+		c := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+		c = append(c, typ...)
+		c = append(c, data...)
+		return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE([]byte(typ+data)))
+	}
+	iend := len(b) - 12
+	out := append([]byte{}, b[:iend]...)
+	out = append(out, chunk("tEXt", "Comment\x00PNG-SECRET")...)
+	out = append(out, chunk("iTXt", "XML:com.adobe.xmp\x00\x00\x00\x00\x00XMP-SECRET")...)
+	return append(out, b[iend:]...)
+}
+
+func TestStripImageMetadata_JPEG(t *testing.T) {
+	src := testJPEGWithMetadata(t, 6)
+
+	got, err := stripImageMetadata(src)
+	if err != nil {
+		t.Fatalf("stripImageMetadata() error = %v", err)
+	}
+	for _, secret := range []string{"GPS-SECRET", "XMP-LOCATION", "COMMENT-DATA"} {
+		if bytes.Contains(got, []byte(secret)) {
+			t.Fatalf("stripped jpeg still contains %q", secret)
+		}
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(got)); err != nil {
+		t.Fatalf("stripped jpeg decode error = %v", err)
+	}
+	exif := bytes.Index(got, []byte("Exif\x00\x00"))
+	if exif < 0 || exifOrientation(got[exif+6:]) != 6 {
+		t.Fatalf("orientation should be kept: index=%d", exif)
+	}
+
+	// 再度取り除いても変わらない
+	again, err := stripImageMetadata(got)
+	if err != nil || !bytes.Equal(again, got) {
+		t.Fatalf("stripImageMetadata(again) changed output: err=%v", err)
+	}
+}
+
+func TestStripImageMetadata_PNGAndOthers(t *testing.T) {
+	got, err := stripImageMetadata(testPNGWithMetadata(t))
+	if err != nil {
+		t.Fatalf("stripImageMetadata(png) error = %v", err)
+	}
+	if bytes.Contains(got, []byte("PNG-SECRET")) || bytes.Contains(got, []byte("XMP-SECRET")) {
+		t.Fatal("stripped png still contains text chunks")
+	}
+	if _, err := png.Decode(bytes.NewReader(got)); err != nil {
+		t.Fatalf("stripped png decode error = %v", err)
+	}
+
+	other := []byte("GIF89a...")
+	if got, err := stripImageMetadata(other); err != nil || !bytes.Equal(got, other) {
+		t.Fatalf("stripImageMetadata(other) = %q, %v", got, err)
+	}
+	if _, err := stripImageMetadata([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x10}); err == nil {
+		t.Fatal("stripImageMetadata(truncated) error = nil, want error")
+	}
+}
+
+func TestChannels_stripAttachmentMetadata_KeepsOriginalLocally(t *testing.T) {
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir, imageMetadata: imageMetadataStripKeepOriginal}
+	rel := filepath.Join("images", "general", "1.0_0.jpg")
+	src := testJPEGWithMetadata(t, 1)
+	if err := os.MkdirAll(filepath.Join(baseDir, "images", "general"), os.ModePerm); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(baseDir, rel), src, 0644); err != nil {
+		t.Fatalf("write image: %v", err)
+	}
+
+	if err := c.stripAttachmentMetadata(rel); err != nil {
+		t.Fatalf("stripAttachmentMetadata() error = %v", err)
+	}
+	stripped, err := os.ReadFile(filepath.Join(baseDir, rel))
+	if err != nil || bytes.Contains(stripped, []byte("GPS-SECRET")) {
+		t.Fatalf("images/ copy should be stripped: err=%v", err)
+	}
+	original, err := os.ReadFile(filepath.Join(baseDir, OriginalImageDir, "general", "1.0_0.jpg"))
+	if err != nil || !bytes.Equal(original, src) {
+		t.Fatalf("original should be kept under originals/: err=%v", err)
+	}
+}
+
+func TestCreateMarkdownZip_StripsImageMetadata(t *testing.T) {
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir, imageMetadata: imageMetadataStrip}
+	jsonl := `{"timestamp":"1775001600.000000","message":"photo","channel":{"id":"C1","name":"general"},"files":["images/general/1775001600.000000_0.jpg"]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	imagePath := filepath.Join(baseDir, "images", "general", "1775001600.000000_0.jpg")
+	if err := os.MkdirAll(filepath.Dir(imagePath), os.ModePerm); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(imagePath, testJPEGWithMetadata(t, 1), 0644); err != nil {
+		t.Fatalf("write image: %v", err)
+	}
+
+	result, err := c.CreateMarkdownZip(context.Background(), "general", nil)
+	if err != nil {
+		t.Fatalf("CreateMarkdownZip() error = %v", err)
+	}
+	zr, err := zip.OpenReader(result.ZipPath)
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	defer func() {
+		_ = zr.Close()
+	}()
+	found := false
+	for _, f := range zr.File {
+		if !strings.HasSuffix(f.Name, ".jpg") {
+			continue
+		}
+		found = true
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open zip entry: %v", err)
+		}
+		b, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatalf("read zip entry: %v", err)
+		}
+		if bytes.Contains(b, []byte("GPS-SECRET")) {
+			t.Fatalf("zip entry %s still contains metadata", f.Name)
+		}
+	}
+	if !found {
+		t.Fatal("zip should contain the image")
+	}
+}
+
+func TestConfig_validate_ImageMetadata(t *testing.T) {
+	base := Config{AppToken: "xapp-1", BotToken: "xoxb-1", BaseDir: "/tmp", AuthorID: "U1"}
+	for _, mode := range []string{"", "keep", "strip", "strip_keep_original"} {
+		c := base
+		c.ImageMetadata = mode
+		if err := c.validate(); err != nil {
+			t.Fatalf("validate(%q) error = %v", mode, err)
+		}
+	}
+	c := base
+	c.ImageMetadata = "remove"
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), "image_metadata") {
+		t.Fatalf("validate(remove) error = %v, want image_metadata error", err)
+	}
+	if got := base.imageMetadataMode(); got != imageMetadataKeep {
+		t.Fatalf("imageMetadataMode() = %q, want keep", got)
+	}
+}
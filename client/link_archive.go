@@ -0,0 +1,474 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// LinkArchiveDir はリンク先ページのアーカイブを保存する base_dir 配下のディレクトリです。
+	// archive/<URLのハッシュの先頭2文字>/<URLのハッシュ>/ に本文の抜粋（index.html）とプレビュー画像を保存します。
+	LinkArchiveDir = "archive"
+
+	LinkArchiveTemplateFile  = "link-archive.html"
+	linkArchiveIndexFileName = "link_archive.json"
+	linkArchiveVersion       = 1
+	linkArchivePageFileName  = "index.html"
+	linkArchiveMaxImageBytes = 5 << 20
+	// linkArchiveGoneCheckInterval はプレビューの取得で確認できなかったリンク先（Slackのunfurlなど）を確認し直す間隔です。
+	linkArchiveGoneCheckInterval = 24 * time.Hour
+	// linkArchiveShutdownTimeout は終了時にバックグラウンドのアーカイブの完了を待つ時間です。
+	linkArchiveShutdownTimeout = 30 * time.Second
+)
+
+// ArchivedPage はアーカイブしたリンク先ページの情報です。
+// GoneAt はリンク先が削除された（404/410、ドメインが存在しない）ことを確認した時刻、
+// CheckedAt はリンク先が削除されたかを最後に確認した時刻です。
+type ArchivedPage struct {
+	URL       string    `json:"url"`
+	Dir       string    `json:"dir"`
+	Title     string    `json:"title,omitempty"`
+	ImagePath string    `json:"image_path,omitempty"`
+	Charset   string    `json:"charset,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+	GoneAt    time.Time `json:"gone_at,omitempty"`
+	CheckedAt time.Time `json:"checked_at,omitempty"`
+}
+
+// IsGone はリンク先が削除されていることを確認済みかを判定する。
+func (p ArchivedPage) IsGone() bool {
+	return !p.GoneAt.IsZero()
+}
+
+// PageURL はHTMLからアーカイブのページを参照するパスを返す。
+func (p ArchivedPage) PageURL() string {
+	return "../" + path.Join(filepath.ToSlash(p.Dir), linkArchivePageFileName)
+}
+
+// FetchedAt2String はアーカイブした時刻を返す。
+func (p ArchivedPage) FetchedAt2String() string {
+	return p.FetchedAt.UTC().Format("2006-01-02 15:04:05")
+}
+
+type linkArchiveFile struct {
+	Version int                     `json:"version"`
+	Entries map[string]ArchivedPage `json:"entries"`
+}
+
+// linkArchive はリンク先ページのアーカイブと、その一覧（cache/link_archive.json）を管理します。
+type linkArchive struct {
+	baseDir  string
+	filePath string
+	// policy はページから見つけた画像とリダイレクト先を取得する前に、ドメインの制限と robots.txt を確認するために使います。
+	policy *previewPolicy
+	getFn  func(ctx context.Context, rawURL string) (*http.Response, error)
+
+	mu      sync.Mutex
+	entries map[string]ArchivedPage
+}
+
+func newLinkArchive(baseDir string, policy *previewPolicy) (*linkArchive, error) {
+	a := &linkArchive{
+		baseDir:  baseDir,
+		filePath: filepath.Join(baseDir, "cache", linkArchiveIndexFileName),
+		policy:   policy,
+		getFn:    policy.getResource,
+		entries:  map[string]ArchivedPage{},
+	}
+	b, err := os.ReadFile(a.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return a, nil
+		}
+		return nil, fmt.Errorf("リンクアーカイブ一覧の読込失敗: %w", err)
+	}
+	var disk linkArchiveFile
+	if err := json.Unmarshal(b, &disk); err != nil {
+		brokenPath := fmt.Sprintf("%s.broken.%s", a.filePath, time.Now().UTC().Format("20060102150405"))
+		if err := os.Rename(a.filePath, brokenPath); err != nil {
+			log.Printf("破損したリンクアーカイブ一覧の退避失敗: file=%s err=%v", a.filePath, err)
+		}
+		return a, nil
+	}
+	if disk.Entries != nil {
+		a.entries = disk.Entries
+	}
+	return a, nil
+}
+
+// Get は rawURL のアーカイブを返します。
+func (a *linkArchive) Get(rawURL string) (ArchivedPage, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	page, ok := a.entries[rawURL]
+	return page, ok
+}
+
+// SetGone はリンク先の削除を確認したかどうかと確認した時刻を記録し、削除の状態が変わった場合は true を返します。
+func (a *linkArchive) SetGone(rawURL string, gone bool, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	page, ok := a.entries[rawURL]
+	if !ok {
+		return false
+	}
+	page.CheckedAt = now.UTC()
+	changed := page.IsGone() != gone
+	if !gone {
+		page.GoneAt = time.Time{}
+	} else if changed {
+		page.GoneAt = now.UTC()
+	}
+	a.entries[rawURL] = page
+	return changed
+}
+
+// goneCheckDue は rawURL のアーカイブがあり、前回の確認から linkArchiveGoneCheckInterval 以上経過しているかを判定します。
+func (a *linkArchive) goneCheckDue(rawURL string, now time.Time) bool {
+	page, ok := a.Get(rawURL)
+	return ok && now.Sub(page.CheckedAt) >= linkArchiveGoneCheckInterval
+}
+
+// CheckGone は rawURL のリンク先を取得し直し、削除されたかどうかを記録します。
+// 一時的な失敗（5xxやタイムアウトなど）では記録を変えずにエラーを返します。
+func (a *linkArchive) CheckGone(ctx context.Context, rawURL string, now time.Time) error {
+	resp, err := a.getFn(ctx, rawURL)
+	if err != nil && !isLinkGoneError(err) {
+		return err
+	}
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+	a.SetGone(rawURL, err != nil, now)
+	return nil
+}
+
+func (a *linkArchive) Save() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(a.filePath), os.ModePerm); err != nil {
+		return fmt.Errorf("リンクアーカイブ一覧ディレクトリ作成失敗: %w", err)
+	}
+	out, err := json.MarshalIndent(linkArchiveFile{Version: linkArchiveVersion, Entries: a.entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("リンクアーカイブ一覧JSON化失敗: %w", err)
+	}
+	return writeFileAtomic(a.filePath, out)
+}
+
+// Archive は rawURL のページを取得し、本文の抜粋とプレビュー画像を保存します。
+// 保存済みのURLは取得しません。
+func (a *linkArchive) Archive(ctx context.Context, rawURL string, now time.Time) (ArchivedPage, error) {
+	if page, ok := a.Get(rawURL); ok {
+		return page, nil
+	}
+
+	resp, err := a.getFn(ctx, rawURL)
+	if err != nil {
+		return ArchivedPage{}, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, linkPreviewMaxBodyBytes))
+	_ = resp.Body.Close()
+	if err != nil {
+		return ArchivedPage{}, fmt.Errorf("read body failed: %w", err)
+	}
+	decoded, charsetName := decodePreviewBody(body, resp.Header.Get("Content-Type"))
+	text, err := io.ReadAll(decoded)
+	if err != nil {
+		return ArchivedPage{}, fmt.Errorf("decode body failed: %w", err)
+	}
+	preview, _, err := parseLinkPreviewFromHTML(resp.Request.URL, strings.NewReader(string(text)))
+	if err != nil {
+		return ArchivedPage{}, err
+	}
+	paragraphs, err := extractReadableText(strings.NewReader(string(text)))
+	if err != nil {
+		return ArchivedPage{}, err
+	}
+
+	sum := sha256.Sum256([]byte(rawURL))
+	hash := hex.EncodeToString(sum[:])
+	page := ArchivedPage{
+		URL:       rawURL,
+		Dir:       filepath.Join(LinkArchiveDir, hash[:2], hash),
+		Charset:   charsetName,
+		FetchedAt: now.UTC(),
+	}
+	dir := filepath.Join(a.baseDir, page.Dir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return ArchivedPage{}, fmt.Errorf("アーカイブディレクトリ作成失敗: %w", err)
+	}
+	if preview != nil {
+		page.Title = preview.Title
+		if preview.ImageURL != "" {
+			imageName, err := a.saveImage(ctx, preview.ImageURL, dir)
+			if err != nil {
+				log.Printf("アーカイブ画像の保存をスキップ: url=%s err=%v", preview.ImageURL, err)
+			} else {
+				page.ImagePath = filepath.Join(page.Dir, imageName)
+			}
+		}
+	}
+	if err := writeLinkArchivePage(filepath.Join(dir, linkArchivePageFileName), page, paragraphs); err != nil {
+		return ArchivedPage{}, err
+	}
+
+	a.mu.Lock()
+	a.entries[rawURL] = page
+	a.mu.Unlock()
+	return page, nil
+}
+
+// saveImage はプレビュー画像を dir に image.<拡張子> として保存し、ファイル名を返します。
+func (a *linkArchive) saveImage(ctx context.Context, imageURL, dir string) (string, error) {
+	if err := a.policy.check(ctx, imageURL, time.Now()); err != nil {
+		return "", err
+	}
+	resp, err := a.getFn(ctx, imageURL)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "image/") {
+		return "", fmt.Errorf("not an image: %q", resp.Header.Get("Content-Type"))
+	}
+	ext := ".img"
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		ext = exts[0]
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, linkArchiveMaxImageBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(b) > linkArchiveMaxImageBytes {
+		return "", fmt.Errorf("image too large: > %d bytes", linkArchiveMaxImageBytes)
+	}
+	name := "image" + ext
+	if err := writeFileAtomic(filepath.Join(dir, name), b); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func writeLinkArchivePage(filePath string, page ArchivedPage, paragraphs []string) error {
+	t, err := template.ParseFS(templateFiles, path.Join(TemplateDir, LinkArchiveTemplateFile))
+	if err != nil {
+		return fmt.Errorf("テンプレートファイルのオープンに失敗： %w", err)
+	}
+	image := ""
+	if page.ImagePath != "" {
+		image = filepath.Base(page.ImagePath)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, map[string]interface{}{
+		"page":       page,
+		"image":      image,
+		"paragraphs": paragraphs,
+	}); err != nil {
+		return fmt.Errorf("テンプレートのExecuteに失敗： %w", err)
+	}
+	return writeFileAtomic(filePath, []byte(b.String()))
+}
+
+func writeFileAtomic(filePath string, b []byte) error {
+	tmpPath := filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
+		return fmt.Errorf("ファイル %s の一時保存に失敗： %w", filePath, err)
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return fmt.Errorf("ファイル %s の置換に失敗： %w", filePath, err)
+	}
+	return nil
+}
+
+// linkArchiveSkipTags は本文の抜粋に含めない要素です。
+var linkArchiveSkipTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true,
+	"head": true, "nav": true, "header": true, "footer": true, "aside": true, "form": true, "iframe": true,
+}
+
+// linkArchiveBlockTags は段落の区切りとして扱う要素です。
+var linkArchiveBlockTags = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true, "br": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"li": true, "ul": true, "ol": true, "dt": true, "dd": true, "blockquote": true, "pre": true,
+	"tr": true, "td": true, "th": true, "table": true, "figcaption": true,
+}
+
+// extractReadableText はページのナビゲーションやスクリプトを除いた本文を段落ごとに取り出します。
+func extractReadableText(r io.Reader) ([]string, error) {
+	z := html.NewTokenizer(r)
+	var paragraphs []string
+	var current strings.Builder
+	skipDepth := 0
+	flush := func() {
+		text := strings.Join(strings.Fields(current.String()), " ")
+		current.Reset()
+		if text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+	}
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return nil, fmt.Errorf("HTML parse failed: %w", err)
+			}
+			flush()
+			return paragraphs, nil
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if linkArchiveSkipTags[tag] && tt != html.SelfClosingTagToken {
+				if tt == html.StartTagToken {
+					skipDepth++
+				} else if skipDepth > 0 {
+					skipDepth--
+				}
+				continue
+			}
+			if skipDepth == 0 && linkArchiveBlockTags[tag] {
+				flush()
+			}
+		case html.TextToken:
+			if skipDepth == 0 {
+				current.Write(z.Text())
+				current.WriteByte(' ')
+			}
+		}
+	}
+}
+
+// isLinkGoneError はリンク先が削除されたことを示すエラー（404/410、存在しないドメイン）かを判定する。
+func isLinkGoneError(err error) bool {
+	var statusErr *previewStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusGone
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// archiveLinksInBackground は archiveLinks をバックグラウンドで実行します。
+// リンク先の取得に時間がかかってもSlackのイベント処理を止めないためです。
+func (c *Channels) archiveLinksInBackground(ctx context.Context, entries []Entry) {
+	if c.linkArchive == nil {
+		return
+	}
+	c.archiveWG.Add(1)
+	go func() {
+		defer c.archiveWG.Done()
+		c.archiveLinks(context.WithoutCancel(ctx), entries)
+	}()
+}
+
+// archiveLinks はリンクだけのメッセージのリンク先ページをアーカイブします。アーカイブが無効な場合は何もしません。
+// 取得に失敗したリンクと、link_preview_policy で取得しないリンクはログに記録してスキップします。
+// 同じページを並行して保存しないよう、アーカイブは1件ずつ順に行います。
+func (c *Channels) archiveLinks(ctx context.Context, entries []Entry) {
+	if c.linkArchive == nil {
+		return
+	}
+	c.archiveMu.Lock()
+	defer c.archiveMu.Unlock()
+	ctx, span := tracer.Start(ctx, "archiveLinks")
+	defer span.End()
+
+	now := time.Now()
+	for _, entry := range entries {
+		if !entry.IsLinkOnlyMessage() {
+			continue
+		}
+		for _, rawURL := range entry.LinkURLs() {
+			if _, ok := c.linkArchive.Get(rawURL); ok {
+				continue
+			}
+			if err := c.previewPolicy.checkDomain(rawURL); err != nil {
+				log.Printf("リンク先のアーカイブをスキップ: url=%s err=%v", rawURL, err)
+				continue
+			}
+			if err := c.previewPolicy.checkRobots(ctx, rawURL, now); err != nil {
+				log.Printf("リンク先のアーカイブをスキップ: url=%s err=%v", rawURL, err)
+				continue
+			}
+			if _, err := c.linkArchive.Archive(ctx, rawURL, now); err != nil {
+				log.Printf("リンク先のアーカイブをスキップ: url=%s err=%v", rawURL, err)
+				continue
+			}
+			// 途中で終了しても保存済みのページを取得し直さないよう、1件ごとに一覧を保存する
+			if err := c.linkArchive.Save(); err != nil {
+				log.Printf("リンクアーカイブ一覧の保存失敗: %v", err)
+			}
+		}
+	}
+}
+
+// waitArchives は archiveLinksInBackground が開始したアーカイブの完了を timeout まで待ち、完了した場合は true を返します。
+func (c *Channels) waitArchives(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		c.archiveWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// checkArchivedLinks はリンクだけのメッセージのうち、今回プレビューを取得しなかったアーカイブ済みのリンク
+// （Slackのunfurlやキャッシュを使ったリンク）について、リンク先が削除されたかを確認します。
+// checked は今回の取得で確認済みのURLです。確認は linkArchiveGoneCheckInterval ごとに行い、記録を変更した場合は true を返します。
+func (c *Channels) checkArchivedLinks(ctx context.Context, entries []Entry, checked map[string]bool, now time.Time) bool {
+	changed := false
+	for _, entry := range entries {
+		if !entry.IsLinkOnlyMessage() {
+			continue
+		}
+		for _, rawURL := range entry.LinkURLs() {
+			if checked[rawURL] || !c.linkArchive.goneCheckDue(rawURL, now) {
+				continue
+			}
+			checked[rawURL] = true
+			if err := c.previewPolicy.checkDomain(rawURL); err != nil {
+				log.Printf("アーカイブ済みリンクの確認をスキップ: url=%s err=%v", rawURL, err)
+				continue
+			}
+			if err := c.previewPolicy.checkRobots(ctx, rawURL, now); err != nil {
+				log.Printf("アーカイブ済みリンクの確認をスキップ: url=%s err=%v", rawURL, err)
+				continue
+			}
+			if err := c.linkArchive.CheckGone(ctx, rawURL, now); err != nil {
+				log.Printf("アーカイブ済みリンクの確認をスキップ: url=%s err=%v", rawURL, err)
+				continue
+			}
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,333 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"google.golang.org/api/drive/v3"
+)
+
+const testArchivedPage = `<html><head><title>Post</title>
+<meta property="og:image" content="/cover.png">
+<script>var tracking = "SCRIPT-TEXT";</script>
+</head><body>
+<nav>NAV-TEXT</nav>
+<article><h1>Post</h1><p>First   paragraph.</p><p>Second<br>line</p></article>
+<footer>FOOTER-TEXT</footer>
+</body></html>`
+
+func TestLinkArchive_Archive(t *testing.T) {
+	baseDir := t.TempDir()
+	a, err := newLinkArchive(baseDir, nil)
+	if err != nil {
+		t.Fatalf("newLinkArchive() error = %v", err)
+	}
+	getter := &stubPreviewGetter{
+		bodies: map[string]string{
+			"https://blog.example/post":      testArchivedPage,
+			"https://blog.example/cover.png": "png-bytes",
+		},
+		contentTypes: map[string]string{"https://blog.example/cover.png": "image/png"},
+	}
+	a.getFn = getter.get
+	now := time.Date(2026, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	page, err := a.Archive(context.Background(), "https://blog.example/post", now)
+	if err != nil {
+		t.Fatalf("Archive() error = %v", err)
+	}
+	if page.Title != "Post" || !page.FetchedAt.Equal(now) || page.ImagePath != filepath.Join(page.Dir, "image.png") {
+		t.Fatalf("Archive() = %+v", page)
+	}
+	if !strings.HasPrefix(page.Dir, LinkArchiveDir+string(filepath.Separator)) {
+		t.Fatalf("Dir = %q, want under %s/", page.Dir, LinkArchiveDir)
+	}
+	if b, err := os.ReadFile(filepath.Join(baseDir, page.ImagePath)); err != nil || string(b) != "png-bytes" {
+		t.Fatalf("image = %q, %v", b, err)
+	}
+	b, err := os.ReadFile(filepath.Join(baseDir, page.Dir, "index.html"))
+	if err != nil {
+		t.Fatalf("read index.html: %v", err)
+	}
+	index := string(b)
+	for _, want := range []string{"<p>First paragraph.</p>", "<p>Second</p>", "<p>line</p>", `<img src="image.png"`, "2026-04-01 12:00:00", `href="https://blog.example/post"`} {
+		if !strings.Contains(index, want) {
+			t.Fatalf("index.html missing %q:\n%s", want, index)
+		}
+	}
+	for _, unwanted := range []string{"SCRIPT-TEXT", "NAV-TEXT", "FOOTER-TEXT"} {
+		if strings.Contains(index, unwanted) {
+			t.Fatalf("index.html should not contain %q", unwanted)
+		}
+	}
+
+	// 保存済みのURLは再取得しない
+	if _, err := a.Archive(context.Background(), "https://blog.example/post", now); err != nil || len(getter.requested) != 2 {
+		t.Fatalf("Archive(again) err = %v, requested = %v", err, getter.requested)
+	}
+	if err := a.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	reloaded, err := newLinkArchive(baseDir, nil)
+	if err != nil {
+		t.Fatalf("newLinkArchive(reload) error = %v", err)
+	}
+	if got, ok := reloaded.Get("https://blog.example/post"); !ok || got.Dir != page.Dir {
+		t.Fatalf("reloaded Get() = %+v, %v", got, ok)
+	}
+}
+
+func TestIsLinkGoneError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: &previewStatusError{StatusCode: 404}, want: true},
+		{err: fmt.Errorf("request failed: %w", &previewStatusError{StatusCode: 410}), want: true},
+		{err: &previewStatusError{StatusCode: 503}, want: false},
+		{err: fmt.Errorf("DNS lookup failed: %w", &net.DNSError{Err: "no such host", IsNotFound: true}), want: true},
+		{err: &net.DNSError{Err: "timeout", IsTimeout: true}, want: false},
+	}
+	for _, tt := range tests {
+		if got := isLinkGoneError(tt.err); got != tt.want {
+			t.Errorf("isLinkGoneError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestChannels_archiveLinks(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	a, err := newLinkArchive(baseDir, nil)
+	if err != nil {
+		t.Fatalf("newLinkArchive() error = %v", err)
+	}
+	getter := &stubPreviewGetter{bodies: map[string]string{"https://blog.example/post": "<p>body</p>"}}
+	a.getFn = getter.get
+	c := &Channels{basedir: baseDir, linkArchive: a}
+
+	c.archiveLinks(context.Background(), []Entry{
+		{Message: "<https://blog.example/post>"},
+		{Message: "see <https://other.example>"},
+		{Message: "<https://missing.example>"},
+	})
+	if !slices.Equal(getter.requested, []string{"https://blog.example/post", "https://missing.example"}) {
+		t.Fatalf("requested = %v, want only link-only messages", getter.requested)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "cache", linkArchiveIndexFileName)); err != nil {
+		t.Fatalf("archive index should be saved: %v", err)
+	}
+}
+
+func TestChannels_archiveLinksInBackground(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	a, err := newLinkArchive(baseDir, nil)
+	if err != nil {
+		t.Fatalf("newLinkArchive() error = %v", err)
+	}
+	release := make(chan struct{})
+	getter := &stubPreviewGetter{bodies: map[string]string{"https://blog.example/post": "<p>body</p>"}}
+	a.getFn = func(ctx context.Context, rawURL string) (*http.Response, error) {
+		<-release
+		return getter.get(ctx, rawURL)
+	}
+	c := &Channels{basedir: baseDir, linkArchive: a}
+
+	// 取得が終わらなくても呼び出し元（Slackのイベント処理）は待たない
+	c.archiveLinksInBackground(context.Background(), []Entry{{Message: "<https://blog.example/post>"}})
+	if _, ok := a.Get("https://blog.example/post"); ok {
+		t.Fatal("page should not be archived before the fetch completes")
+	}
+	close(release)
+	c.archiveWG.Wait()
+	if _, ok := a.Get("https://blog.example/post"); !ok {
+		t.Fatal("page should be archived in the background")
+	}
+}
+
+func TestChannels_archiveLinks_SavesIndexAfterEachPage(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	a, err := newLinkArchive(baseDir, nil)
+	if err != nil {
+		t.Fatalf("newLinkArchive() error = %v", err)
+	}
+	getter := &stubPreviewGetter{bodies: map[string]string{
+		"https://blog.example/1": "<p>one</p>",
+		"https://blog.example/2": "<p>two</p>",
+	}}
+	a.getFn = func(ctx context.Context, rawURL string) (*http.Response, error) {
+		if rawURL == "https://blog.example/2" {
+			// 2件目の取得時には1件目が一覧に保存されている
+			reloaded, err := newLinkArchive(baseDir, nil)
+			if err != nil {
+				t.Fatalf("newLinkArchive() error = %v", err)
+			}
+			if _, ok := reloaded.Get("https://blog.example/1"); !ok {
+				t.Fatal("first page should be saved before fetching the next one")
+			}
+		}
+		return getter.get(ctx, rawURL)
+	}
+	c := &Channels{basedir: baseDir, linkArchive: a}
+
+	c.archiveLinks(context.Background(), []Entry{{Message: "<https://blog.example/1>"}, {Message: "<https://blog.example/2>"}})
+	if _, ok := a.Get("https://blog.example/2"); !ok {
+		t.Fatal("second page should be archived")
+	}
+}
+
+func TestChannels_waitArchives(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	a, err := newLinkArchive(baseDir, nil)
+	if err != nil {
+		t.Fatalf("newLinkArchive() error = %v", err)
+	}
+	release := make(chan struct{})
+	getter := &stubPreviewGetter{bodies: map[string]string{"https://blog.example/post": "<p>body</p>"}}
+	a.getFn = func(ctx context.Context, rawURL string) (*http.Response, error) {
+		<-release
+		return getter.get(ctx, rawURL)
+	}
+	c := &Channels{basedir: baseDir, linkArchive: a}
+
+	c.archiveLinksInBackground(context.Background(), []Entry{{Message: "<https://blog.example/post>"}})
+	if c.waitArchives(10 * time.Millisecond) {
+		t.Fatal("waitArchives() = true before the archive completes")
+	}
+	close(release)
+	if !c.waitArchives(time.Second) {
+		t.Fatal("waitArchives() = false, want true after the archive completes")
+	}
+	reloaded, err := newLinkArchive(baseDir, nil)
+	if err != nil {
+		t.Fatalf("newLinkArchive() error = %v", err)
+	}
+	if _, ok := reloaded.Get("https://blog.example/post"); !ok {
+		t.Fatal("archived page should be saved to the index")
+	}
+}
+
+func TestAttachLinkPreviews_ChecksUnfurledArchivedLinks(t *testing.T) {
+	baseDir := t.TempDir()
+	a, err := newLinkArchive(baseDir, nil)
+	if err != nil {
+		t.Fatalf("newLinkArchive() error = %v", err)
+	}
+	a.entries["https://gone.example/post"] = ArchivedPage{URL: "https://gone.example/post", Dir: filepath.Join(LinkArchiveDir, "ab", "abcd")}
+	a.entries["https://flaky.example/post"] = ArchivedPage{URL: "https://flaky.example/post", Dir: filepath.Join(LinkArchiveDir, "cd", "cdef")}
+	var requested []string
+	a.getFn = func(_ context.Context, rawURL string) (*http.Response, error) {
+		requested = append(requested, rawURL)
+		if rawURL == "https://gone.example/post" {
+			return nil, &previewStatusError{StatusCode: http.StatusGone}
+		}
+		return nil, &previewStatusError{StatusCode: http.StatusServiceUnavailable}
+	}
+	c := &Channels{
+		basedir:     baseDir,
+		linkArchive: a,
+		previewFetcher: func(_ context.Context, rawURL string) (*LinkPreview, error) {
+			t.Fatalf("unfurled link should not be fetched: %s", rawURL)
+			return nil, nil
+		},
+	}
+	entries := func() []Entry {
+		return []Entry{
+			{Message: "<https://gone.example/post>", Unfurls: []LinkPreview{{URL: "https://gone.example/post", Title: "Post"}}},
+			{Message: "<https://flaky.example/post>", Unfurls: []LinkPreview{{URL: "https://flaky.example/post", Title: "Post"}}},
+		}
+	}
+
+	got := c.attachLinkPreviews(context.Background(), entries())
+	if got[0].Archive == nil || !got[0].Archive.IsGone() {
+		t.Fatalf("unfurled gone link should be marked: %+v", got[0].Archive)
+	}
+	// 一時的な失敗では削除とみなさず、次の生成時に確認し直す
+	if got[1].Archive == nil || got[1].Archive.IsGone() {
+		t.Fatalf("temporary failure should not mark the link: %+v", got[1].Archive)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "cache", linkArchiveIndexFileName)); err != nil {
+		t.Fatalf("archive index should be saved: %v", err)
+	}
+
+	c.attachLinkPreviews(context.Background(), entries())
+	want := []string{"https://gone.example/post", "https://flaky.example/post", "https://flaky.example/post"}
+	if !slices.Equal(requested, want) {
+		t.Fatalf("requested = %v, want %v", requested, want)
+	}
+}
+
+func TestCreateHtmlFile_LinksArchiveWhenGone(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	a, err := newLinkArchive(baseDir, nil)
+	if err != nil {
+		t.Fatalf("newLinkArchive() error = %v", err)
+	}
+	a.entries["https://gone.example/post"] = ArchivedPage{
+		URL:       "https://gone.example/post",
+		Dir:       filepath.Join(LinkArchiveDir, "ab", "abcd"),
+		Title:     "Gone Post",
+		FetchedAt: time.Date(2026, 4, 1, 12, 0, 0, 0, time.UTC),
+	}
+	a.entries["https://alive.example/post"] = ArchivedPage{URL: "https://alive.example/post", Dir: filepath.Join(LinkArchiveDir, "cd", "cdef")}
+	c := &Channels{
+		basedir:     baseDir,
+		linkArchive: a,
+		previewFetcher: func(_ context.Context, rawURL string) (*LinkPreview, error) {
+			if rawURL == "https://gone.example/post" {
+				return nil, &previewStatusError{StatusCode: 404}
+			}
+			return &LinkPreview{URL: rawURL, Title: "Alive"}, nil
+		},
+	}
+	jsonl := `{"timestamp":"1775088000.000000","message":"<https://gone.example/post>","channel":{"id":"C1","name":"general"},"files":[]}` + "\n" +
+		`{"timestamp":"1775088001.000000","message":"<https://alive.example/post>","channel":{"id":"C1","name":"general"},"files":[]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	g := &GDrive{
+		htmlDir: &drive.File{Id: "html-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			return nil
+		},
+	}
+
+	if err := c.CreateHtmlFile(context.Background(), "general", g, nil); err != nil {
+		t.Fatalf("CreateHtmlFile() error = %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(baseDir, "html", "general.html"))
+	if err != nil {
+		t.Fatalf("read html: %v", err)
+	}
+	got := string(b)
+	if !strings.Contains(got, `href="../archive/ab/abcd/index.html"`) || !strings.Contains(got, "Gone Post") {
+		t.Fatalf("gone link should point to the archive:\n%s", got)
+	}
+	if strings.Contains(got, "../archive/cd/cdef/index.html") {
+		t.Fatal("alive link should not point to the archive")
+	}
+	if page, _ := a.Get("https://gone.example/post"); !page.IsGone() {
+		t.Fatalf("gone page should be marked: %+v", page)
+	}
+}
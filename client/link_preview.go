@@ -1,6 +1,7 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -10,11 +11,17 @@ import (
 	"net/http"
 	"net/netip"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/slack-go/slack"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
 )
 
 const (
@@ -22,6 +29,8 @@ const (
 	linkPreviewMaxRedirects  = 5
 	linkPreviewMaxBodyBytes  = 1 << 20
 	linkPreviewUserAgentName = "happeninghound-link-preview/1.0"
+
+	defaultLinkPreviewMaxPerMessage = 3
 )
 
 var (
@@ -30,81 +39,237 @@ var (
 
 type linkPreviewFetchFunc func(ctx context.Context, rawURL string) (*LinkPreview, error)
 
+// LinkPreview はリンク先ページのタイトル・説明・画像などのプレビュー情報です。
+// Slackのunfurlとしてエントリに保存するほか、リンクプレビューキャッシュにも保存します。
+// Type・ThumbnailURL・AuthorName・AuthorURL・EmbedURL はoEmbedから取得した場合だけ設定します。
 type LinkPreview struct {
-	URL         string
-	Title       string
-	Description string
-	ImageURL    string
-	SiteName    string
+	URL          string `json:"url"`
+	Title        string `json:"title,omitempty"`
+	Description  string `json:"description,omitempty"`
+	ImageURL     string `json:"image_url,omitempty"`
+	SiteName     string `json:"site_name,omitempty"`
+	Type         string `json:"type,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	AuthorName   string `json:"author_name,omitempty"`
+	AuthorURL    string `json:"author_url,omitempty"`
+	EmbedURL     string `json:"embed_url,omitempty"`
+	// Charset はページを取得した場合に判定した文字コードです（調査用）。
+	Charset string `json:"charset,omitempty"`
 }
 
-func (c *Channels) attachLinkPreviews(ctx context.Context, entries []Entry) []Entry {
-	type cachedPreview struct {
-		preview *LinkPreview
-		err     error
-	}
-	cache := map[string]cachedPreview{}
-	cacheChanged := false
-	now := time.Now().UTC()
+// CardImageURL はカードに表示する画像のURLを返します。ページの画像がない場合はoEmbedのサムネイルを使います。
+func (p LinkPreview) CardImageURL() string {
+	return firstNonEmpty(p.ImageURL, p.ThumbnailURL)
+}
 
-	for i := range entries {
-		if !entries[i].IsLinkOnlyMessage() {
+// IsVideo はoEmbedの種類が動画かを判定する。
+func (p LinkPreview) IsVideo() bool {
+	return p.Type == oembedTypeVideo
+}
+
+// slackUnfurls はメッセージの attachments からSlackが展開したリンク（unfurl）をプレビューとして取り出します。
+// リンク元のURLを持たない attachments（Botが付けたものなど）や、表示する内容がないものは除外します。
+func slackUnfurls(attachments []slack.Attachment) []LinkPreview {
+	var unfurls []LinkPreview
+	for _, a := range attachments {
+		rawURL := firstNonEmpty(a.OriginalURL, a.FromURL)
+		if rawURL == "" {
 			continue
 		}
-		urls := entries[i].LinkURLs()
-		if len(urls) != 1 {
+		preview := LinkPreview{
+			URL:         rawURL,
+			Title:       strings.TrimSpace(a.Title),
+			Description: strings.TrimSpace(a.Text),
+			ImageURL:    firstNonEmpty(a.ImageURL, a.ThumbURL),
+			SiteName:    strings.TrimSpace(a.ServiceName),
+		}
+		if preview.Title == "" && preview.Description == "" && preview.ImageURL == "" {
 			continue
 		}
+		unfurls = append(unfurls, preview)
+	}
+	return unfurls
+}
 
-		cached, ok := cache[urls[0]]
-		if !ok {
+// unfurlFor はエントリに保存されたunfurlのうち rawURL に対応するものを返します。
+func (e Entry) unfurlFor(rawURL string) *LinkPreview {
+	for i := range e.Unfurls {
+		if e.Unfurls[i].URL == rawURL {
+			preview := e.Unfurls[i]
+			return &preview
+		}
+	}
+	return nil
+}
+
+// attachLinkPreviews はエントリ内のリンク（重複を除き最大 previewMaxPerMessage 件）のプレビューを設定します。
+// Slackのunfurlがあるリンクはページを取得せずにunfurlを使います。
+// 取得に失敗したリンクはキャッシュに失敗として記録し、リンク切れとみなしたリンクは DeadLinks に、
+// link_preview_policy で取得しなかったリンクは理由とともに BlockedLinks に設定します。
+func (c *Channels) attachLinkPreviews(ctx context.Context, entries []Entry) []Entry {
+	results := map[string]linkPreviewResult{}
+	cacheChanged := false
+	archiveChanged := false
+	checked := map[string]bool{}
+	now := time.Now().UTC()
+
+	// Slackのunfurlもキャッシュもないリンクだけを集めて、まとめて並行に取得する
+	var pending []string
+	for i := range entries {
+		for _, rawURL := range c.previewTargets(entries[i].LinkURLs()) {
+			if c.previewFetcher == nil || entries[i].unfurlFor(rawURL) != nil {
+				continue
+			}
+			if _, ok := results[rawURL]; ok {
+				continue
+			}
+			// ドメインの制限は設定の変更をすぐ反映するため、キャッシュより先に毎回判定する
+			if err := c.previewPolicy.checkDomain(rawURL); err != nil {
+				log.Printf("link preview取得をスキップ: url=%s err=%v", rawURL, err)
+				results[rawURL] = linkPreviewResult{failure: &linkPreviewFailure{ErrorClass: previewErrorPolicy, Error: err.Error()}}
+				continue
+			}
 			if c.previewCache != nil {
-				preview, hit, changed := c.previewCache.Get(urls[0], now)
+				preview, hit, changed := c.previewCache.Get(rawURL, now)
 				if changed {
 					cacheChanged = true
 				}
-				if hit {
-					cached = cachedPreview{
-						preview: preview,
-						err:     nil,
+				if hit && preview == nil {
+					failure := c.previewCache.Failure(rawURL)
+					// robots.txt の確認を無効にした場合は、robots.txt による記録を使わずに取得し直す
+					if failure == nil || failure.ErrorClass != previewErrorRobots || c.previewPolicy.respectsRobots() {
+						results[rawURL] = linkPreviewResult{failure: failure}
+						continue
 					}
-					cache[urls[0]] = cached
 				}
-			}
-			if cached.preview == nil {
-				preview, err := c.previewFetcher(ctx, urls[0])
-				cached = cachedPreview{
-					preview: preview,
-					err:     err,
+				if hit && preview != nil {
+					results[rawURL] = linkPreviewResult{preview: preview}
+					continue
 				}
-				if c.previewCache != nil && err == nil && preview != nil {
-					if c.previewCache.Set(urls[0], preview, now) {
-						cacheChanged = true
-					}
+			}
+			results[rawURL] = linkPreviewResult{}
+			pending = append(pending, rawURL)
+		}
+	}
+	for rawURL, result := range c.fetchLinkPreviews(ctx, pending) {
+		switch {
+		case result.aborted:
+			// 制限時間切れはリンク先の問題ではないため、失敗として記録しない
+			log.Printf("link preview取得を中断: url=%s err=%v", rawURL, result.err)
+		case result.err == nil:
+			if c.previewCache != nil && c.previewCache.Set(rawURL, result.preview, now) {
+				cacheChanged = true
+			}
+		default:
+			log.Printf("link preview取得をスキップ: url=%s err=%v", rawURL, result.err)
+			if c.previewCache != nil {
+				var changed bool
+				result.failure, changed = c.previewCache.SetFailure(rawURL, result.err, now)
+				if changed {
+					cacheChanged = true
 				}
-				cache[urls[0]] = cached
 			}
 		}
-
-		if cached.err != nil {
-			log.Printf("link preview取得をスキップ: url=%s err=%v", urls[0], cached.err)
-			continue
+		results[rawURL] = result
+		if !result.aborted && c.linkArchive != nil {
+			checked[rawURL] = true
+			if c.linkArchive.SetGone(rawURL, result.err != nil && isLinkGoneError(result.err), now) {
+				archiveChanged = true
+			}
 		}
-		if cached.preview == nil {
-			log.Printf("link preview取得をスキップ: url=%s err=%v", urls[0], errors.New("empty preview"))
-			continue
+	}
+	// unfurlやキャッシュを使ったリンクは取得しないため、アーカイブ済みのリンクは別に削除を確認する
+	if c.linkArchive != nil && c.checkArchivedLinks(ctx, entries, checked, now) {
+		archiveChanged = true
+	}
+
+	for i := range entries {
+		var previews []LinkPreview
+		var deadLinks []DeadLink
+		var blockedLinks []BlockedLink
+		var links []EntryLink
+		for _, rawURL := range c.previewTargets(entries[i].LinkURLs()) {
+			link := EntryLink{URL: rawURL}
+			if preview := entries[i].unfurlFor(rawURL); preview != nil {
+				previews = append(previews, *preview)
+				link.Preview = preview
+			} else if c.previewFetcher != nil {
+				result := results[rawURL]
+				if result.preview != nil {
+					previews = append(previews, *result.preview)
+					link.Preview = result.preview
+				} else if dead := newDeadLink(rawURL, result.failure); dead != nil {
+					deadLinks = append(deadLinks, *dead)
+					link.Dead = true
+				} else if blocked := newBlockedLink(rawURL, result.failure); blocked != nil {
+					blockedLinks = append(blockedLinks, *blocked)
+				}
+			}
+			links = append(links, link)
 		}
-		entries[i].Preview = cached.preview
+		entries[i].Previews = previews
+		entries[i].DeadLinks = deadLinks
+		entries[i].BlockedLinks = blockedLinks
+		entries[i].Links = links
 	}
 	if c.previewCache != nil && cacheChanged {
 		if err := c.previewCache.Save(); err != nil {
 			log.Printf("link previewキャッシュ保存失敗: %v", err)
 		}
 	}
+	if archiveChanged {
+		if err := c.linkArchive.Save(); err != nil {
+			log.Printf("リンクアーカイブ一覧の保存失敗: %v", err)
+		}
+	}
+	return c.attachLinkArchives(entries)
+}
+
+// previewTargets は urls から重複を除き、1メッセージあたりの上限までのURLを返します。
+func (c *Channels) previewTargets(urls []string) []string {
+	limit := c.previewMaxPerMessage
+	if limit <= 0 {
+		limit = defaultLinkPreviewMaxPerMessage
+	}
+	targets := make([]string, 0, min(len(urls), limit))
+	for _, rawURL := range urls {
+		if len(targets) >= limit {
+			break
+		}
+		if !slices.Contains(targets, rawURL) {
+			targets = append(targets, rawURL)
+		}
+	}
+	return targets
+}
+
+// attachLinkArchives はリンクだけのメッセージに、リンク先ページのアーカイブを設定します。
+func (c *Channels) attachLinkArchives(entries []Entry) []Entry {
+	if c.linkArchive == nil {
+		return entries
+	}
+	for i := range entries {
+		if !entries[i].IsLinkOnlyMessage() {
+			continue
+		}
+		for _, rawURL := range entries[i].LinkURLs() {
+			if page, ok := c.linkArchive.Get(rawURL); ok {
+				entries[i].Archive = &page
+				break
+			}
+		}
+	}
 	return entries
 }
 
-func defaultLinkPreviewFetcher(ctx context.Context, rawURL string) (*LinkPreview, error) {
+// getPreviewResource は rawURL を取得します。プライベートアドレスへのアクセスとリダイレクトの回数を制限し、2xx以外はエラーにします。
+func getPreviewResource(ctx context.Context, rawURL string) (*http.Response, error) {
+	return getPreviewResourceChecked(ctx, rawURL, nil)
+}
+
+// getPreviewResourceChecked は getPreviewResource と同じく rawURL を取得し、リダイレクト先ごとに checkRedirect でも判定します。
+// rawURL 自体は checkRedirect で判定しないため、呼び出し元で判定します。
+func getPreviewResourceChecked(ctx context.Context, rawURL string, checkRedirect func(ctx context.Context, rawURL string) error) (*http.Response, error) {
 	parsedURL, err := url.Parse(rawURL)
 	if err != nil {
 		return nil, fmt.Errorf("URL parse failed: %w", err)
@@ -114,14 +279,9 @@ func defaultLinkPreviewFetcher(ctx context.Context, rawURL string) (*LinkPreview
 	}
 
 	client := &http.Client{
-		Timeout:   linkPreviewTimeout,
-		Transport: previewHTTPTransport(),
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) >= linkPreviewMaxRedirects {
-				return errors.New("too many redirects")
-			}
-			return validatePreviewURL(req.Context(), req.URL)
-		},
+		Timeout:       linkPreviewTimeout,
+		Transport:     previewHTTPTransport(),
+		CheckRedirect: previewCheckRedirect(checkRedirect),
 	}
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsedURL.String(), nil)
 	if err != nil {
@@ -133,41 +293,68 @@ func defaultLinkPreviewFetcher(ctx context.Context, rawURL string) (*LinkPreview
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		_ = resp.Body.Close()
+		return nil, &previewStatusError{StatusCode: resp.StatusCode}
 	}
+	return resp, nil
+}
 
-	preview, err := parseLinkPreviewFromHTML(resp.Request.URL, io.LimitReader(resp.Body, linkPreviewMaxBodyBytes))
-	if err != nil {
-		return nil, err
-	}
-	if preview == nil {
-		return nil, errors.New("no preview metadata")
+// previewCheckRedirect はリダイレクトの回数とリダイレクト先のアドレスを制限します。
+// check が nil でない場合は、リダイレクト先ごとに check でも判定します。
+func previewCheckRedirect(check func(ctx context.Context, rawURL string) error) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= linkPreviewMaxRedirects {
+			return errors.New("too many redirects")
+		}
+		if check != nil {
+			if err := check(req.Context(), req.URL.String()); err != nil {
+				return err
+			}
+		}
+		return validatePreviewURL(req.Context(), req.URL)
 	}
-	return preview, nil
+}
+
+// previewStatusError はリンク先が2xx以外のステータスを返したエラーです。
+type previewStatusError struct {
+	StatusCode int
+}
+
+func (e *previewStatusError) Error() string {
+	return fmt.Sprintf("unexpected status: %d", e.StatusCode)
+}
+
+// previewBlockedError はプライベートアドレスなど、プレビューの取得を許可しないURLへのアクセスです。
+type previewBlockedError struct {
+	reason string
+}
+
+func (e *previewBlockedError) Error() string {
+	return e.reason
+}
+
+func previewBlockedf(format string, args ...any) error {
+	return &previewBlockedError{reason: fmt.Sprintf(format, args...)}
 }
 
 func validatePreviewURL(ctx context.Context, u *url.URL) error {
 	if u == nil {
-		return errors.New("nil URL")
+		return previewBlockedf("nil URL")
 	}
 	if u.Scheme != "http" && u.Scheme != "https" {
-		return fmt.Errorf("unsupported scheme: %s", u.Scheme)
+		return previewBlockedf("unsupported scheme: %s", u.Scheme)
 	}
 	host := u.Hostname()
 	if host == "" {
-		return errors.New("empty hostname")
+		return previewBlockedf("empty hostname")
 	}
 	if strings.EqualFold(host, "localhost") {
-		return errors.New("localhost is blocked")
+		return previewBlockedf("localhost is blocked")
 	}
 	if ip := net.ParseIP(host); ip != nil {
 		if isBlockedIP(ip) {
-			return fmt.Errorf("private address is blocked: %s", ip.String())
+			return previewBlockedf("private address is blocked: %s", ip.String())
 		}
 		if err := validatePort(u.Port()); err != nil {
 			return err
@@ -183,7 +370,7 @@ func validatePreviewURL(ctx context.Context, u *url.URL) error {
 	}
 	for _, ip := range ips {
 		if isBlockedIP(ip) {
-			return fmt.Errorf("private address is blocked: %s", ip.String())
+			return previewBlockedf("private address is blocked: %s", ip.String())
 		}
 	}
 	return nil
@@ -210,7 +397,7 @@ func previewHTTPTransport() *http.Transport {
 		}
 		if isBlockedIP(ip) {
 			_ = conn.Close()
-			return nil, fmt.Errorf("private address is blocked: %s", ip.String())
+			return nil, previewBlockedf("private address is blocked: %s", ip.String())
 		}
 		return conn, nil
 	}
@@ -223,7 +410,7 @@ func validatePort(port string) error {
 	}
 	n, err := strconv.Atoi(port)
 	if err != nil || n <= 0 || n > 65535 {
-		return fmt.Errorf("invalid port: %s", port)
+		return previewBlockedf("invalid port: %s", port)
 	}
 	return nil
 }
@@ -243,10 +430,27 @@ func isBlockedIP(ip net.IP) bool {
 	return cgnatPrefix.Contains(addr.Unmap())
 }
 
-func parseLinkPreviewFromHTML(pageURL *url.URL, r io.Reader) (*LinkPreview, error) {
+// decodePreviewBody はページの文字コードを BOM、Content-Type の charset、<meta charset> の順に判定し、
+// UTF-8 に変換した本文と判定した文字コード名を返します。
+// 宣言がなく判定できない場合は、UTF-8 として正しければ UTF-8、そうでなければ windows-1252 として扱います。
+func decodePreviewBody(body []byte, contentType string) (io.Reader, string) {
+	enc, name, certain := charset.DetermineEncoding(body, contentType)
+	if !certain && name == "windows-1252" && utf8.Valid(body) {
+		// 先頭1024バイトがASCIIだけのページは既定値の windows-1252 になるため、本文全体で UTF-8 を確認する
+		return bytes.NewReader(body), "utf-8"
+	}
+	if enc == encoding.Nop || name == "utf-8" {
+		return bytes.NewReader(body), name
+	}
+	return transform.NewReader(bytes.NewReader(body), enc.NewDecoder()), name
+}
+
+// parseLinkPreviewFromHTML はページの title と og:/twitter: の meta からプレビューを作成し、
+// oEmbed（JSON）の link 要素があればその絶対URLも返します。
+func parseLinkPreviewFromHTML(pageURL *url.URL, r io.Reader) (*LinkPreview, string, error) {
 	z := html.NewTokenizer(r)
 	og := map[string]string{}
-	var title string
+	var title, oembedURL string
 
 	for {
 		tt := z.Next()
@@ -254,9 +458,9 @@ func parseLinkPreviewFromHTML(pageURL *url.URL, r io.Reader) (*LinkPreview, erro
 		case html.ErrorToken:
 			err := z.Err()
 			if err == io.EOF {
-				return buildLinkPreview(pageURL, title, og), nil
+				return buildLinkPreview(pageURL, title, og), oembedURL, nil
 			}
-			return nil, fmt.Errorf("HTML parse failed: %w", err)
+			return nil, "", fmt.Errorf("HTML parse failed: %w", err)
 		case html.StartTagToken, html.SelfClosingTagToken:
 			token := z.Token()
 			switch token.Data {
@@ -274,6 +478,10 @@ func parseLinkPreviewFromHTML(pageURL *url.URL, r io.Reader) (*LinkPreview, erro
 				if key != "" && content != "" {
 					og[key] = content
 				}
+			case "link":
+				if oembedURL == "" {
+					oembedURL = oembedLinkHref(pageURL, token.Attr)
+				}
 			case "title":
 				if title == "" && z.Next() == html.TextToken {
 					title = strings.TrimSpace(z.Token().Data)
@@ -283,6 +491,32 @@ func parseLinkPreviewFromHTML(pageURL *url.URL, r io.Reader) (*LinkPreview, erro
 	}
 }
 
+// oembedLinkHref は <link rel="alternate" type="application/json+oembed"> の href を pageURL を基準に解決して返します。
+func oembedLinkHref(pageURL *url.URL, attrs []html.Attribute) string {
+	var rel, typ, href string
+	for _, attr := range attrs {
+		switch strings.ToLower(attr.Key) {
+		case "rel":
+			rel = strings.ToLower(strings.TrimSpace(attr.Val))
+		case "type":
+			typ = strings.ToLower(strings.TrimSpace(attr.Val))
+		case "href":
+			href = strings.TrimSpace(attr.Val)
+		}
+	}
+	if rel != "alternate" || typ != "application/json+oembed" || href == "" {
+		return ""
+	}
+	parsed, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if pageURL != nil {
+		parsed = pageURL.ResolveReference(parsed)
+	}
+	return parsed.String()
+}
+
 func buildLinkPreview(pageURL *url.URL, title string, og map[string]string) *LinkPreview {
 	resolvedTitle := firstNonEmpty(og["og:title"], title)
 	description := firstNonEmpty(og["og:description"], og["description"])
@@ -16,7 +16,7 @@ const (
 	defaultLinkPreviewCacheTTL        = 7 * 24 * time.Hour
 	defaultLinkPreviewCacheMaxEntries = 1000
 	linkPreviewCacheFileName          = "link_preview_cache.json"
-	linkPreviewCacheVersion           = 1
+	linkPreviewCacheVersion           = 3
 )
 
 type linkPreviewCache struct {
@@ -26,18 +26,23 @@ type linkPreviewCache struct {
 
 	mu      sync.Mutex
 	entries map[string]linkPreviewCacheEntry
+	stats   linkPreviewCacheStats
 }
 
 type linkPreviewCacheFile struct {
 	Version int                              `json:"version"`
+	Stats   linkPreviewCacheStats            `json:"stats"`
 	Entries map[string]linkPreviewCacheEntry `json:"entries"`
 }
 
+// linkPreviewCacheEntry はキャッシュの1件です。取得に失敗したURLは Failure を記録し、
+// 失敗が続くほど長くなる期間（リンク切れの場合は ttl）だけ再取得しません。
 type linkPreviewCacheEntry struct {
-	Preview      LinkPreview `json:"preview"`
-	FetchedAt    time.Time   `json:"fetched_at"`
-	ExpiresAt    time.Time   `json:"expires_at"`
-	LastAccessed time.Time   `json:"last_accessed"`
+	Preview      LinkPreview         `json:"preview"`
+	Failure      *linkPreviewFailure `json:"failure,omitempty"`
+	FetchedAt    time.Time           `json:"fetched_at"`
+	ExpiresAt    time.Time           `json:"expires_at"`
+	LastAccessed time.Time           `json:"last_accessed"`
 }
 
 func newLinkPreviewCache(baseDir string, ttl time.Duration, maxEntries int) (*linkPreviewCache, error) {
@@ -57,28 +62,57 @@ func newLinkPreviewCache(baseDir string, ttl time.Duration, maxEntries int) (*li
 	if err := c.load(); err != nil {
 		return nil, err
 	}
+	if c.stats.Since.IsZero() {
+		c.stats.Since = time.Now().UTC()
+	}
 	return c, nil
 }
 
+// Get は rawURL のキャッシュを返します。戻り値はプレビュー、ヒットしたか、キャッシュを変更したかです。
+// 再取得しない期間内の失敗の記録にヒットした場合、プレビューは nil です（内容は Failure で取得します）。
 func (c *linkPreviewCache) Get(rawURL string, now time.Time) (*LinkPreview, bool, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	entry, ok := c.entries[rawURL]
 	if !ok {
+		c.stats.Misses++
 		return nil, false, false
 	}
 	if !entry.ExpiresAt.IsZero() && now.After(entry.ExpiresAt) {
+		c.stats.Misses++
+		// 失敗回数を引き継ぐため、期限切れの失敗の記録は ttl の間だけ残して再取得させる
+		if entry.Failure != nil && !now.After(entry.ExpiresAt.Add(c.ttl)) {
+			return nil, false, false
+		}
 		delete(c.entries, rawURL)
 		return nil, false, true
 	}
 	entry.LastAccessed = now.UTC()
 	c.entries[rawURL] = entry
 
+	if entry.Failure != nil {
+		c.stats.NegativeHits++
+		return nil, true, true
+	}
+	c.stats.Hits++
 	preview := entry.Preview
 	return &preview, true, true
 }
 
+// Failure は rawURL の取得失敗の記録を返します。記録がない場合は nil です。
+func (c *linkPreviewCache) Failure(rawURL string) *linkPreviewFailure {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[rawURL]
+	if !ok || entry.Failure == nil {
+		return nil
+	}
+	failure := *entry.Failure
+	return &failure
+}
+
 func (c *linkPreviewCache) Set(rawURL string, preview *LinkPreview, now time.Time) bool {
 	if preview == nil {
 		return false
@@ -102,6 +136,40 @@ func (c *linkPreviewCache) Set(rawURL string, preview *LinkPreview, now time.Tim
 	return changed
 }
 
+// SetFailure は rawURL の取得失敗を記録し、記録した失敗の情報を返します。
+// 前回も失敗していた場合は失敗回数を引き継ぎ、分類ごとの回数に達したらリンク切れとみなします。
+func (c *linkPreviewCache) SetFailure(rawURL string, fetchErr error, now time.Time) (*linkPreviewFailure, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now = now.UTC()
+	failure := linkPreviewFailure{
+		ErrorClass:    classifyPreviewError(fetchErr),
+		Error:         fetchErr.Error(),
+		Attempts:      1,
+		FirstFailedAt: now,
+		LastFailedAt:  now,
+	}
+	if prev, ok := c.entries[rawURL]; ok && prev.Failure != nil {
+		failure.Attempts = prev.Failure.Attempts + 1
+		failure.FirstFailedAt = prev.Failure.FirstFailedAt
+	}
+	if n, ok := linkPreviewDeadAfter[failure.ErrorClass]; ok && failure.Attempts >= n {
+		failure.Dead = true
+	}
+	c.entries[rawURL] = linkPreviewCacheEntry{
+		Preview:      LinkPreview{URL: rawURL},
+		Failure:      &failure,
+		FetchedAt:    now,
+		ExpiresAt:    now.Add(failure.retryAfter(c.ttl)),
+		LastAccessed: now,
+	}
+	c.pruneToLimitLocked()
+
+	result := failure
+	return &result, true
+}
+
 func (c *linkPreviewCache) Save() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -125,6 +193,12 @@ func (c *linkPreviewCache) load() error {
 	if disk.Entries == nil {
 		return nil
 	}
+	// バージョン1はプレビューのJSONキーが異なるため読み込まず、再取得させる
+	if disk.Version < linkPreviewCacheVersion {
+		log.Printf("古い形式のリンクプレビューキャッシュを破棄: file=%s version=%d", c.filePath, disk.Version)
+		return nil
+	}
+	c.stats = disk.Stats
 
 	now := time.Now().UTC()
 	for rawURL, entry := range disk.Entries {
@@ -137,9 +211,15 @@ func (c *linkPreviewCache) load() error {
 		if entry.LastAccessed.IsZero() {
 			entry.LastAccessed = entry.FetchedAt
 		}
-		if now.After(entry.ExpiresAt) {
+		if now.After(entry.ExpiresAt) && (entry.Failure == nil || now.After(entry.ExpiresAt.Add(c.ttl))) {
 			continue
 		}
+		if f := entry.Failure; f != nil && f.Dead {
+			// 以前の基準でリンク切れとした失敗（メタデータなしなど）は破棄し、取得し直させる
+			if _, ok := linkPreviewDeadAfter[f.ErrorClass]; !ok {
+				continue
+			}
+		}
 		c.entries[rawURL] = entry
 	}
 
@@ -166,6 +246,7 @@ func (c *linkPreviewCache) saveLocked() error {
 	}
 	disk := linkPreviewCacheFile{
 		Version: linkPreviewCacheVersion,
+		Stats:   c.stats,
 		Entries: c.entries,
 	}
 	out, err := json.MarshalIndent(disk, "", "  ")
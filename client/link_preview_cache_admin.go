@@ -0,0 +1,325 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	previewCacheUsage = "usage: /preview-cache [stats] | purge url <URL> | purge domain <domain> | purge older <period> | refresh [channel]"
+	// previewCacheReportOldest は stats で表示する古いエントリの件数です。
+	previewCacheReportOldest = 5
+)
+
+// linkPreviewCacheStats はリンクプレビューキャッシュのヒット・ミスの累計です（Since からの累計）。
+type linkPreviewCacheStats struct {
+	Hits         int64     `json:"hits"`
+	NegativeHits int64     `json:"negative_hits"`
+	Misses       int64     `json:"misses"`
+	Since        time.Time `json:"since"`
+}
+
+// linkPreviewCacheReport は /preview-cache stats で表示するキャッシュの状態です。
+type linkPreviewCacheReport struct {
+	Stats      linkPreviewCacheStats
+	Entries    int
+	Failures   int
+	Dead       int
+	MaxEntries int
+	FileBytes  int64
+	Oldest     []linkPreviewCacheReportItem
+}
+
+type linkPreviewCacheReportItem struct {
+	URL       string
+	FetchedAt time.Time
+	Failure   *linkPreviewFailure
+}
+
+// Report はキャッシュの件数・ファイルサイズ・統計と、取得時刻の古い順に limit 件のエントリを返します。
+func (c *linkPreviewCache) Report(limit int) linkPreviewCacheReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := linkPreviewCacheReport{
+		Stats:      c.stats,
+		Entries:    len(c.entries),
+		MaxEntries: c.maxEntries,
+	}
+	if info, err := os.Stat(c.filePath); err == nil {
+		report.FileBytes = info.Size()
+	}
+	items := make([]linkPreviewCacheReportItem, 0, len(c.entries))
+	for rawURL, entry := range c.entries {
+		if entry.Failure != nil {
+			report.Failures++
+			if entry.Failure.Dead {
+				report.Dead++
+			}
+		}
+		items = append(items, linkPreviewCacheReportItem{URL: rawURL, FetchedAt: entry.FetchedAt, Failure: entry.Failure})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].FetchedAt.Equal(items[j].FetchedAt) {
+			return items[i].FetchedAt.Before(items[j].FetchedAt)
+		}
+		return items[i].URL < items[j].URL
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	report.Oldest = items
+	return report
+}
+
+// String は Slack とCLIに表示する形式でレポートを返します。
+func (r linkPreviewCacheReport) String() string {
+	var b strings.Builder
+	lookups := r.Stats.Hits + r.Stats.NegativeHits + r.Stats.Misses
+	hitRate := 0.0
+	if lookups > 0 {
+		hitRate = float64(r.Stats.Hits+r.Stats.NegativeHits) / float64(lookups) * 100
+	}
+	fmt.Fprintf(&b, "Entries: %d / %d (failures: %d, dead: %d)\n", r.Entries, r.MaxEntries, r.Failures, r.Dead)
+	fmt.Fprintf(&b, "File size: %d bytes\n", r.FileBytes)
+	fmt.Fprintf(&b, "Hits: %d, negative hits: %d, misses: %d (hit rate %.1f%%) since %s UTC",
+		r.Stats.Hits, r.Stats.NegativeHits, r.Stats.Misses, hitRate, r.Stats.Since.UTC().Format("2006-01-02 15:04:05"))
+	if len(r.Oldest) > 0 {
+		b.WriteString("\nOldest entries:")
+	}
+	for _, item := range r.Oldest {
+		status := "ok"
+		if item.Failure != nil {
+			status = fmt.Sprintf("failed: %s x%d", item.Failure.ErrorClass, item.Failure.Attempts)
+			if item.Failure.Dead {
+				status += ", dead"
+			}
+		}
+		fmt.Fprintf(&b, "\n- %s %s (%s)", item.FetchedAt.UTC().Format("2006-01-02 15:04:05"), item.URL, status)
+	}
+	return b.String()
+}
+
+// removeLocked は match に一致するエントリを削除し、削除した件数を返します。
+func (c *linkPreviewCache) removeLocked(match func(rawURL string, entry linkPreviewCacheEntry) bool) int {
+	removed := 0
+	for rawURL, entry := range c.entries {
+		if match(rawURL, entry) {
+			delete(c.entries, rawURL)
+			removed++
+		}
+	}
+	return removed
+}
+
+// PurgeURLs は urls のエントリ（取得失敗の記録を含む）を削除します。
+func (c *linkPreviewCache) PurgeURLs(urls ...string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	targets := make(map[string]struct{}, len(urls))
+	for _, rawURL := range urls {
+		targets[rawURL] = struct{}{}
+	}
+	return c.removeLocked(func(rawURL string, _ linkPreviewCacheEntry) bool {
+		_, ok := targets[rawURL]
+		return ok
+	})
+}
+
+// PurgeDomain は domain とそのサブドメインのURLのエントリを削除します。
+func (c *linkPreviewCache) PurgeDomain(domain string) int {
+	domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), ".")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.removeLocked(func(rawURL string, _ linkPreviewCacheEntry) bool {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return false
+		}
+		host := strings.ToLower(u.Hostname())
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	})
+}
+
+// PurgeFetchedBefore は before より前に取得（または取得に失敗）したエントリを削除します。
+func (c *linkPreviewCache) PurgeFetchedBefore(before time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.removeLocked(func(_ string, entry linkPreviewCacheEntry) bool {
+		return entry.FetchedAt.Before(before)
+	})
+}
+
+// hasFailure は rawURL のエントリがあるか、あれば取得失敗の記録かを返します。
+func (c *linkPreviewCache) hasFailure(rawURL string) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[rawURL]
+	return ok, ok && entry.Failure != nil
+}
+
+// LinkPreviewRefreshResult はチャンネルのリンクプレビューを再取得した結果です。
+type LinkPreviewRefreshResult struct {
+	URLs    int
+	Fetched int
+	Failed  int
+	Skipped int
+}
+
+// RefreshLinkPreviews はチャンネルのリンク（Slackのunfurlがあるリンクを除く）のキャッシュを削除し、プレビューを取得し直します。
+// 制限時間内に取得できなかったリンクは Skipped に数えます。
+func (c *Channels) RefreshLinkPreviews(ctx context.Context, channelName string) (LinkPreviewRefreshResult, error) {
+	if c.previewCache == nil {
+		return LinkPreviewRefreshResult{}, errors.New("link preview cache is disabled")
+	}
+	filePath, err := c.safeJoinUnderBase(c.createChannelFileName(channelName))
+	if err != nil {
+		return LinkPreviewRefreshResult{}, fmt.Errorf("invalid channel path: %w", err)
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return LinkPreviewRefreshResult{}, fmt.Errorf("ファイル %s のオープンに失敗： %w", filePath, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	entries, err := parseEntriesFromJSONL(f)
+	if err != nil {
+		return LinkPreviewRefreshResult{}, err
+	}
+	entries = visibleEntries(entries)
+
+	var urls []string
+	seen := map[string]struct{}{}
+	for _, entry := range entries {
+		for _, rawURL := range c.previewTargets(entry.LinkURLs()) {
+			if _, ok := seen[rawURL]; ok || entry.unfurlFor(rawURL) != nil {
+				continue
+			}
+			seen[rawURL] = struct{}{}
+			urls = append(urls, rawURL)
+		}
+	}
+	c.previewCache.PurgeURLs(urls...)
+	c.attachLinkPreviews(ctx, entries)
+	if err := c.previewCache.Save(); err != nil {
+		return LinkPreviewRefreshResult{}, fmt.Errorf("link previewキャッシュ保存失敗: %w", err)
+	}
+
+	result := LinkPreviewRefreshResult{URLs: len(urls)}
+	for _, rawURL := range urls {
+		switch found, failed := c.previewCache.hasFailure(rawURL); {
+		case !found:
+			result.Skipped++
+		case failed:
+			result.Failed++
+		default:
+			result.Fetched++
+		}
+	}
+	return result, nil
+}
+
+// previewCacheCommand は /preview-cache と CLI の preview-cache サブコマンドを実行し、結果のメッセージを返します。
+// defaultChannel は refresh でチャンネルを省略した場合の対象です。
+func (c *Channels) previewCacheCommand(ctx context.Context, args []string, defaultChannel string) (string, error) {
+	if c.previewCache == nil {
+		return "", errors.New("link preview cache is disabled")
+	}
+	if len(args) == 0 || args[0] == "stats" {
+		if len(args) > 1 {
+			return "", fmt.Errorf("invalid args (%s)", previewCacheUsage)
+		}
+		return c.previewCache.Report(previewCacheReportOldest).String(), nil
+	}
+
+	switch args[0] {
+	case "purge":
+		if len(args) != 3 {
+			return "", fmt.Errorf("invalid args (%s)", previewCacheUsage)
+		}
+		var removed int
+		switch args[1] {
+		case "url":
+			removed = c.previewCache.PurgeURLs(unwrapSlackLinkArg(args[2]))
+		case "domain":
+			domain := unwrapSlackLinkArg(args[2])
+			// Slackがドメインを自動でリンクにした場合は http://example.com の形で届く
+			if u, err := url.Parse(domain); err == nil && u.Host != "" {
+				domain = u.Hostname()
+			}
+			removed = c.previewCache.PurgeDomain(domain)
+		case "older":
+			since, ok, err := parseRelativePeriod(args[2])
+			if err != nil {
+				return "", fmt.Errorf("%w. %s", err, previewCacheUsage)
+			}
+			if !ok {
+				return "", fmt.Errorf("invalid period: %q (e.g. 30d). %s", args[2], previewCacheUsage)
+			}
+			removed = c.previewCache.PurgeFetchedBefore(*since)
+		default:
+			return "", fmt.Errorf("invalid purge target: %q (%s)", args[1], previewCacheUsage)
+		}
+		if err := c.previewCache.Save(); err != nil {
+			return "", fmt.Errorf("link previewキャッシュ保存失敗: %w", err)
+		}
+		return fmt.Sprintf("Purged %d entries", removed), nil
+	case "refresh":
+		if len(args) > 2 {
+			return "", fmt.Errorf("invalid args (%s)", previewCacheUsage)
+		}
+		channelName := defaultChannel
+		if len(args) == 2 {
+			channelName = strings.TrimSuffix(args[1], ".jsonl")
+		}
+		channelName = c.resolveChannelArg(channelName)
+		if err := c.validateStoredChannelName(channelName); err != nil {
+			return "", err
+		}
+		result, err := c.RefreshLinkPreviews(ctx, channelName)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Refreshed link previews in %s: %d links, %d fetched, %d failed, %d skipped",
+			channelName, result.URLs, result.Fetched, result.Failed, result.Skipped), nil
+	default:
+		return "", fmt.Errorf("unknown subcommand: %q (%s)", args[0], previewCacheUsage)
+	}
+}
+
+// unwrapSlackLinkArg はSlackがコマンド引数のURLに付ける `<URL|ラベル>` を外します。
+func unwrapSlackLinkArg(arg string) string {
+	if strings.HasPrefix(arg, "<") && strings.HasSuffix(arg, ">") {
+		return parseSlackLinkToken(arg).URL
+	}
+	return arg
+}
+
+// RunPreviewCache は CLI の preview-cache サブコマンドを実行します。
+// 設定ファイルの base_dir にあるキャッシュを直接操作するため、Botの実行中は /preview-cache を使ってください。
+func RunPreviewCache(ctx context.Context, args []string, w io.Writer) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	channels, err := NewChannels(config)
+	if err != nil {
+		return err
+	}
+	msg, err := channels.previewCacheCommand(ctx, args, "")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, msg)
+	return err
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+)
+
+func TestLinkPreviewCache_ReportCountsHitsAndMisses(t *testing.T) {
+	baseDir := t.TempDir()
+	cache, err := newLinkPreviewCache(baseDir, 24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	base := time.Now().UTC().Truncate(time.Second).Add(-time.Hour)
+	cache.Set("https://new.example", &LinkPreview{URL: "https://new.example"}, base.Add(time.Minute))
+	cache.Set("https://old.example", &LinkPreview{URL: "https://old.example"}, base)
+	for i := range 3 {
+		cache.SetFailure("https://gone.example", &previewStatusError{StatusCode: http.StatusGone}, base.Add(time.Duration(2+i)*time.Minute))
+	}
+
+	cache.Get("https://new.example", base.Add(30*time.Minute))
+	cache.Get("https://gone.example", base.Add(30*time.Minute))
+	cache.Get("https://missing.example", base.Add(30*time.Minute))
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	reloaded, err := newLinkPreviewCache(baseDir, 24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache(reload) error = %v", err)
+	}
+	report := reloaded.Report(2)
+	if report.Stats.Hits != 1 || report.Stats.NegativeHits != 1 || report.Stats.Misses != 1 {
+		t.Fatalf("Stats = %+v, want 1 hit, 1 negative hit, 1 miss", report.Stats)
+	}
+	if report.Entries != 3 || report.Failures != 1 || report.Dead != 1 || report.FileBytes == 0 {
+		t.Fatalf("Report() = %+v", report)
+	}
+	if len(report.Oldest) != 2 || report.Oldest[0].URL != "https://old.example" || report.Oldest[1].URL != "https://new.example" {
+		t.Fatalf("Oldest = %+v, want old then new", report.Oldest)
+	}
+	got := report.String()
+	for _, want := range []string{"Entries: 3 / 10 (failures: 1, dead: 1)", "hit rate 66.7%", "- " + base.Format("2006-01-02 15:04:05") + " https://old.example (ok)"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("String() missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestLinkPreviewCache_Purge(t *testing.T) {
+	cache, err := newLinkPreviewCache(t.TempDir(), 24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	now := time.Now().UTC()
+	for _, rawURL := range []string{"https://example.com/a", "https://blog.example.com/b", "https://notexample.com/c", "https://other.example/d"} {
+		cache.Set(rawURL, &LinkPreview{URL: rawURL}, now)
+	}
+	cache.Set("https://old.example/e", &LinkPreview{URL: "https://old.example/e"}, now.Add(-40*24*time.Hour))
+
+	if got := cache.PurgeDomain("Example.com"); got != 2 {
+		t.Fatalf("PurgeDomain() = %d, want 2", got)
+	}
+	if _, ok := cache.entries["https://notexample.com/c"]; !ok {
+		t.Fatal("PurgeDomain() should not remove a different domain with the same suffix")
+	}
+	if got := cache.PurgeFetchedBefore(now.Add(-30 * 24 * time.Hour)); got != 1 {
+		t.Fatalf("PurgeFetchedBefore() = %d, want 1", got)
+	}
+	if got := cache.PurgeURLs("https://other.example/d", "https://missing.example"); got != 1 {
+		t.Fatalf("PurgeURLs() = %d, want 1", got)
+	}
+	if len(cache.entries) != 1 {
+		t.Fatalf("entries = %v, want only notexample.com", cache.entries)
+	}
+}
+
+func TestPreviewCacheCommand_Purge(t *testing.T) {
+	baseDir := t.TempDir()
+	cache, err := newLinkPreviewCache(baseDir, 24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	cache.Set("https://example.com/a", &LinkPreview{URL: "https://example.com/a"}, time.Now())
+	cache.Set("https://example.com/b", &LinkPreview{URL: "https://example.com/b"}, time.Now())
+	c := &Channels{basedir: baseDir, previewCache: cache}
+
+	msg, err := c.previewCacheCommand(context.Background(), []string{"purge", "url", "<https://example.com/a>"}, "")
+	if err != nil || msg != "Purged 1 entries" {
+		t.Fatalf("purge url = (%q, %v)", msg, err)
+	}
+	msg, err = c.previewCacheCommand(context.Background(), []string{"purge", "domain", "<http://example.com|example.com>"}, "")
+	if err != nil || msg != "Purged 1 entries" {
+		t.Fatalf("purge domain = (%q, %v)", msg, err)
+	}
+	reloaded, err := newLinkPreviewCache(baseDir, 24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache(reload) error = %v", err)
+	}
+	if len(reloaded.entries) != 0 {
+		t.Fatalf("purge should be saved: entries = %v", reloaded.entries)
+	}
+
+	for _, args := range [][]string{{"purge", "older", "soon"}, {"purge", "host", "a"}, {"purge"}, {"stats", "x"}, {"drop"}} {
+		if _, err := c.previewCacheCommand(context.Background(), args, ""); err == nil {
+			t.Fatalf("previewCacheCommand(%v) error = nil, want error", args)
+		}
+	}
+}
+
+func TestRefreshLinkPreviews(t *testing.T) {
+	baseDir := t.TempDir()
+	cache, err := newLinkPreviewCache(baseDir, 24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	now := time.Now().UTC()
+	cache.Set("https://a.example", &LinkPreview{URL: "https://a.example", Title: "stale"}, now)
+	cache.SetFailure("https://b.example", &previewStatusError{StatusCode: http.StatusNotFound}, now)
+	cache.Set("https://other.example", &LinkPreview{URL: "https://other.example", Title: "other channel"}, now)
+
+	var fetched []string
+	c := &Channels{
+		basedir: baseDir,
+		previewFetcher: func(_ context.Context, rawURL string) (*LinkPreview, error) {
+			fetched = append(fetched, rawURL)
+			if rawURL == "https://b.example" {
+				return nil, &previewStatusError{StatusCode: http.StatusNotFound}
+			}
+			return &LinkPreview{URL: rawURL, Title: "fresh"}, nil
+		},
+		previewCache: cache,
+		previewFetch: LinkPreviewFetchConfig{Concurrency: 1},
+	}
+	jsonl := `{"timestamp":"1775088000.000000","message":"<https://a.example>","channel":{"id":"C1","name":"general"},"files":[]}` + "\n" +
+		`{"timestamp":"1775088001.000000","message":"<https://b.example>","channel":{"id":"C1","name":"general"},"files":[]}` + "\n" +
+		`{"timestamp":"1775088002.000000","message":"<https://c.example>","channel":{"id":"C1","name":"general"},"files":[],"unfurls":[{"url":"https://c.example","title":"unfurl"}]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+	msg := executeCommand(context.Background(), slack.SlashCommand{Command: "/preview-cache", Text: "refresh", ChannelName: "general"}, c, nil, baseDir, nil)
+	if !strings.Contains(msg, "Refreshed link previews in general: 2 links, 1 fetched, 1 failed, 0 skipped") {
+		t.Fatalf("executeCommand() = %q", msg)
+	}
+	if strings.Join(fetched, " ") != "https://a.example https://b.example" {
+		t.Fatalf("fetched = %v, want a and b (unfurled link skipped)", fetched)
+	}
+	reloaded, err := newLinkPreviewCache(baseDir, 24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache(reload) error = %v", err)
+	}
+	if got := reloaded.entries["https://a.example"].Preview.Title; got != "fresh" {
+		t.Fatalf("a.example title = %q, want fresh", got)
+	}
+	if f := reloaded.Failure("https://b.example"); f == nil || f.Attempts != 1 {
+		t.Fatalf("b.example failure = %+v, want reset to 1 attempt", f)
+	}
+	if _, ok := reloaded.entries["https://other.example"]; !ok {
+		t.Fatal("links in other channels should be kept")
+	}
+}
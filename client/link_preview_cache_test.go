@@ -124,8 +124,8 @@ func TestAttachLinkPreviews_UsesPersistentCache(t *testing.T) {
 	if calls1 != 1 {
 		t.Fatalf("first fetch calls = %d, want 1", calls1)
 	}
-	if first[0].Preview == nil || first[0].Preview.Title != "cached" {
-		t.Fatalf("first preview = %+v, want cached", first[0].Preview)
+	if len(first[0].Previews) != 1 || first[0].Previews[0].Title != "cached" {
+		t.Fatalf("first previews = %+v, want cached", first[0].Previews)
 	}
 
 	cache2, err := newLinkPreviewCache(baseDir, 24*time.Hour, 10)
@@ -145,7 +145,28 @@ func TestAttachLinkPreviews_UsesPersistentCache(t *testing.T) {
 	if calls2 != 0 {
 		t.Fatalf("second fetch calls = %d, want 0", calls2)
 	}
-	if second[0].Preview == nil || second[0].Preview.Title != "cached" {
-		t.Fatalf("second preview = %+v, want cached", second[0].Preview)
+	if len(second[0].Previews) != 1 || second[0].Previews[0].Title != "cached" {
+		t.Fatalf("second previews = %+v, want cached", second[0].Previews)
+	}
+}
+
+func TestLinkPreviewCache_DiscardsOldVersion(t *testing.T) {
+	baseDir := t.TempDir()
+	cacheDir := filepath.Join(baseDir, "cache")
+	if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	old := `{"version":1,"entries":{"https://example.com":{"preview":{"URL":"https://example.com","Title":"t","ImageURL":"https://example.com/i.png"},"fetched_at":"` +
+		time.Now().UTC().Format(time.RFC3339) + `"}}}`
+	if err := os.WriteFile(filepath.Join(cacheDir, linkPreviewCacheFileName), []byte(old), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	cache, err := newLinkPreviewCache(baseDir, time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	if _, hit, _ := cache.Get("https://example.com", time.Now()); hit {
+		t.Fatal("version 1 entry should be discarded")
 	}
 }
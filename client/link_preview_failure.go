@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// リンクプレビューの取得に失敗した理由の分類です。
+const (
+	previewErrorGone        = "gone"
+	previewErrorClientError = "client_error"
+	previewErrorRateLimited = "rate_limited"
+	previewErrorServerError = "server_error"
+	previewErrorTimeout     = "timeout"
+	previewErrorBlocked     = "blocked"
+	previewErrorNoMetadata  = "no_metadata"
+	previewErrorNetwork     = "network"
+	// previewErrorPolicy と previewErrorRobots は取得の失敗ではなく、設定・robots.txt で取得を見送った記録です。
+	previewErrorPolicy = "policy"
+	previewErrorRobots = "robots"
+)
+
+const (
+	// linkPreviewFailureBaseTTL は1回目の失敗を再取得しない期間です。失敗が続くたびに2倍にします。
+	linkPreviewFailureBaseTTL = time.Hour
+	linkPreviewFailureMaxTTL  = 24 * time.Hour
+)
+
+// linkPreviewDeadAfter は、リンク先がなくなったことを示す分類の失敗を何回続けたらリンク切れとみなすかです。
+// ここにない分類はリンク切れにしません。タイムアウトや5xxなどは一時的な失敗として、
+// メタデータのないページ（PDFや画像への直接のリンクなど）やプライベートアドレスは、ページはあるがプレビューを作れないものとして扱います。
+var linkPreviewDeadAfter = map[string]int{
+	previewErrorGone: 3,
+}
+
+var errNoPreviewMetadata = errors.New("no preview metadata")
+
+// linkPreviewFailure はリンクプレビューキャッシュに記録する取得失敗の情報です。
+type linkPreviewFailure struct {
+	ErrorClass    string    `json:"error_class"`
+	Error         string    `json:"error"`
+	Attempts      int       `json:"attempts"`
+	FirstFailedAt time.Time `json:"first_failed_at"`
+	LastFailedAt  time.Time `json:"last_failed_at"`
+	// Dead はリンク切れとみなしたかです。リンク切れのURLは通常のキャッシュ有効期限まで再取得しません。
+	Dead bool `json:"dead,omitempty"`
+}
+
+// retryAfter は失敗を記録してから再取得するまでの期間を返します。
+func (f linkPreviewFailure) retryAfter(ttl time.Duration) time.Duration {
+	if f.Dead {
+		return ttl
+	}
+	if f.ErrorClass == previewErrorRobots {
+		return min(robotsRulesTTL, ttl)
+	}
+	backoff := linkPreviewFailureBaseTTL
+	for i := 1; i < f.Attempts && backoff < linkPreviewFailureMaxTTL; i++ {
+		backoff *= 2
+	}
+	return min(backoff, linkPreviewFailureMaxTTL, ttl)
+}
+
+// classifyPreviewError はリンクプレビューの取得に失敗したエラーを分類します。
+func classifyPreviewError(err error) string {
+	if errors.Is(err, errNoPreviewMetadata) {
+		return previewErrorNoMetadata
+	}
+	var policyErr *previewPolicyError
+	if errors.As(err, &policyErr) {
+		return policyErr.Class
+	}
+	if isLinkGoneError(err) {
+		return previewErrorGone
+	}
+	var blockedErr *previewBlockedError
+	if errors.As(err, &blockedErr) {
+		return previewErrorBlocked
+	}
+	var statusErr *previewStatusError
+	if errors.As(err, &statusErr) {
+		switch {
+		case statusErr.StatusCode == http.StatusTooManyRequests:
+			return previewErrorRateLimited
+		case statusErr.StatusCode == http.StatusRequestTimeout:
+			return previewErrorTimeout
+		case statusErr.StatusCode >= 400 && statusErr.StatusCode < 500:
+			return previewErrorClientError
+		default:
+			return previewErrorServerError
+		}
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return previewErrorTimeout
+	}
+	return previewErrorNetwork
+}
+
+// DeadLink はリンクプレビューの取得に失敗し続けてリンク切れとみなしたリンクです。
+type DeadLink struct {
+	URL          string
+	ErrorClass   string
+	Attempts     int
+	LastFailedAt time.Time
+}
+
+// LastFailedAt2String は最後に取得に失敗した時刻を返す。
+func (d DeadLink) LastFailedAt2String() string {
+	return d.LastFailedAt.UTC().Format("2006-01-02 15:04:05")
+}
+
+// EntryLink はメッセージ内のリンクと、作成できた場合はそのプレビューです。
+// Dead はリンク切れとみなしたリンクかです。
+type EntryLink struct {
+	URL     string
+	Preview *LinkPreview
+	Dead    bool
+}
+
+// BlockedLink はリンク先はあるものの、プレビューを作成しなかったリンクです。
+// 設定（deny_domains/allow_domains）や robots.txt で取得しなかったリンク、取得を許可しないアドレスのリンク、
+// タイトルや説明のないページへのリンクが該当します。
+type BlockedLink struct {
+	URL    string
+	Reason string
+}
+
+func newBlockedLink(rawURL string, f *linkPreviewFailure) *BlockedLink {
+	if f == nil {
+		return nil
+	}
+	switch f.ErrorClass {
+	case previewErrorPolicy, previewErrorRobots, previewErrorBlocked:
+		return &BlockedLink{URL: rawURL, Reason: f.Error}
+	case previewErrorNoMetadata:
+		return &BlockedLink{URL: rawURL, Reason: "the page has no title or description"}
+	}
+	return nil
+}
+
+func newDeadLink(rawURL string, f *linkPreviewFailure) *DeadLink {
+	if f == nil || !f.Dead {
+		return nil
+	}
+	return &DeadLink{URL: rawURL, ErrorClass: f.ErrorClass, Attempts: f.Attempts, LastFailedAt: f.LastFailedAt}
+}
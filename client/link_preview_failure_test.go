@@ -0,0 +1,294 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"google.golang.org/api/drive/v3"
+)
+
+func TestClassifyPreviewError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "404", err: fmt.Errorf("get: %w", &previewStatusError{StatusCode: http.StatusNotFound}), want: previewErrorGone},
+		{name: "nxdomain", err: &net.DNSError{Err: "no such host", Name: "gone.example", IsNotFound: true}, want: previewErrorGone},
+		{name: "403", err: &previewStatusError{StatusCode: http.StatusForbidden}, want: previewErrorClientError},
+		{name: "429", err: &previewStatusError{StatusCode: http.StatusTooManyRequests}, want: previewErrorRateLimited},
+		{name: "503", err: &previewStatusError{StatusCode: http.StatusServiceUnavailable}, want: previewErrorServerError},
+		{name: "timeout", err: fmt.Errorf("request failed: %w", context.DeadlineExceeded), want: previewErrorTimeout},
+		{name: "blocked", err: previewBlockedf("private address is blocked: %s", "10.0.0.1"), want: previewErrorBlocked},
+		{name: "no metadata", err: errNoPreviewMetadata, want: previewErrorNoMetadata},
+		{name: "other", err: errors.New("connection reset"), want: previewErrorNetwork},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyPreviewError(tt.err); got != tt.want {
+				t.Fatalf("classifyPreviewError(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkPreviewCache_SetFailure_BackoffAndDead(t *testing.T) {
+	cache, err := newLinkPreviewCache(t.TempDir(), 7*24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	rawURL := "https://gone.example/page"
+	notFound := &previewStatusError{StatusCode: http.StatusNotFound}
+	base := time.Date(2026, 4, 9, 10, 0, 0, 0, time.UTC)
+
+	failure, _ := cache.SetFailure(rawURL, notFound, base)
+	if failure.ErrorClass != previewErrorGone || failure.Attempts != 1 || failure.Dead {
+		t.Fatalf("first failure = %+v", failure)
+	}
+	if got := cache.entries[rawURL].ExpiresAt; !got.Equal(base.Add(time.Hour)) {
+		t.Fatalf("first ExpiresAt = %v, want +1h", got)
+	}
+	if preview, hit, _ := cache.Get(rawURL, base.Add(30*time.Minute)); !hit || preview != nil {
+		t.Fatalf("Get() within backoff = (%v, %v), want cached failure", preview, hit)
+	}
+	// バックオフ期間が過ぎたら再取得させるが、失敗回数は残す
+	if _, hit, _ := cache.Get(rawURL, base.Add(2*time.Hour)); hit {
+		t.Fatal("Get() after backoff hit = true, want false")
+	}
+
+	failure, _ = cache.SetFailure(rawURL, notFound, base.Add(2*time.Hour))
+	if failure.Attempts != 2 || failure.Dead {
+		t.Fatalf("second failure = %+v", failure)
+	}
+	if got := cache.entries[rawURL].ExpiresAt; !got.Equal(base.Add(4 * time.Hour)) {
+		t.Fatalf("second ExpiresAt = %v, want +2h backoff", got)
+	}
+
+	failure, _ = cache.SetFailure(rawURL, notFound, base.Add(5*time.Hour))
+	if failure.Attempts != 3 || !failure.Dead || !failure.FirstFailedAt.Equal(base) {
+		t.Fatalf("third failure = %+v, want dead", failure)
+	}
+	if got := cache.entries[rawURL].ExpiresAt; !got.Equal(base.Add(5*time.Hour + 7*24*time.Hour)) {
+		t.Fatalf("dead ExpiresAt = %v, want cache ttl", got)
+	}
+
+	cache.Set(rawURL, &LinkPreview{URL: rawURL, Title: "back"}, base.Add(8*24*time.Hour))
+	if cache.Failure(rawURL) != nil {
+		t.Fatal("Failure() after success should be nil")
+	}
+}
+
+func TestLinkPreviewCache_SetFailure_TransientNeverDead(t *testing.T) {
+	cache, err := newLinkPreviewCache(t.TempDir(), 7*24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	now := time.Date(2026, 4, 9, 10, 0, 0, 0, time.UTC)
+	// 一時的な失敗や、ページはあるがプレビューを作れない失敗は何度続いてもリンク切れにしない
+	for _, fetchErr := range []error{
+		fmt.Errorf("request failed: %w", context.DeadlineExceeded),
+		errNoPreviewMetadata,
+		previewBlockedf("private address is blocked: %s", "10.0.0.1"),
+		&previewStatusError{StatusCode: http.StatusForbidden},
+	} {
+		var failure *linkPreviewFailure
+		for range 10 {
+			failure, _ = cache.SetFailure("https://slow.example", fetchErr, now)
+		}
+		if failure.Dead {
+			t.Fatalf("failure = %+v, want not dead", failure)
+		}
+		cache.PurgeURLs("https://slow.example")
+	}
+	var failure *linkPreviewFailure
+	for range 10 {
+		failure, _ = cache.SetFailure("https://slow.example", fmt.Errorf("request failed: %w", context.DeadlineExceeded), now)
+	}
+	if failure.Dead || failure.Attempts != 10 {
+		t.Fatalf("failure = %+v, want 10 attempts and not dead", failure)
+	}
+	if got := cache.entries["https://slow.example"].ExpiresAt; !got.Equal(now.Add(linkPreviewFailureMaxTTL)) {
+		t.Fatalf("ExpiresAt = %v, want capped backoff", got)
+	}
+}
+
+func TestLinkPreviewCache_FailurePersists(t *testing.T) {
+	baseDir := t.TempDir()
+	cache, err := newLinkPreviewCache(baseDir, 7*24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	cache.SetFailure("https://a.example", errNoPreviewMetadata, time.Now())
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	reloaded, err := newLinkPreviewCache(baseDir, 7*24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache(reload) error = %v", err)
+	}
+	failure := reloaded.Failure("https://a.example")
+	if failure == nil || failure.ErrorClass != previewErrorNoMetadata || failure.Error != "no preview metadata" || failure.Attempts != 1 {
+		t.Fatalf("Failure() = %+v, want persisted no_metadata failure", failure)
+	}
+}
+
+func TestAttachLinkPreviews_NegativeCache(t *testing.T) {
+	cache, err := newLinkPreviewCache(t.TempDir(), 7*24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	calls := 0
+	c := &Channels{
+		previewFetcher: func(_ context.Context, rawURL string) (*LinkPreview, error) {
+			calls++
+			return nil, &previewStatusError{StatusCode: http.StatusNotFound}
+		},
+		previewCache: cache,
+	}
+	entries := []Entry{{Message: "<https://gone.example>"}}
+
+	first := c.attachLinkPreviews(context.Background(), entries)
+	second := c.attachLinkPreviews(context.Background(), entries)
+	if calls != 1 {
+		t.Fatalf("fetch calls = %d, want 1 (failure should be cached)", calls)
+	}
+	if len(first[0].Previews) != 0 || len(first[0].DeadLinks) != 0 || len(second[0].DeadLinks) != 0 {
+		t.Fatalf("entries = %+v / %+v, want no previews and no dead links yet", first[0], second[0])
+	}
+
+	// 既に2回失敗していて、バックオフ期間が過ぎたリンクは再取得してリンク切れとみなす
+	past := time.Now().Add(-3 * time.Hour)
+	cache.entries = map[string]linkPreviewCacheEntry{}
+	cache.SetFailure("https://gone.example", &previewStatusError{StatusCode: http.StatusNotFound}, past.Add(-2*time.Hour))
+	cache.SetFailure("https://gone.example", &previewStatusError{StatusCode: http.StatusNotFound}, past)
+
+	third := c.attachLinkPreviews(context.Background(), entries)
+	if calls != 2 {
+		t.Fatalf("fetch calls = %d, want 2", calls)
+	}
+	if len(third[0].DeadLinks) != 1 || third[0].DeadLinks[0].ErrorClass != previewErrorGone || third[0].DeadLinks[0].Attempts != 3 {
+		t.Fatalf("DeadLinks = %+v, want gone link", third[0].DeadLinks)
+	}
+	fourth := c.attachLinkPreviews(context.Background(), entries)
+	if calls != 2 || len(fourth[0].DeadLinks) != 1 {
+		t.Fatalf("calls = %d, DeadLinks = %+v, want dead link from cache", calls, fourth[0].DeadLinks)
+	}
+}
+
+func TestAttachLinkPreviews_DeadlineIsNotRecordedAsFailure(t *testing.T) {
+	cache, err := newLinkPreviewCache(t.TempDir(), 7*24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := &Channels{
+		previewFetcher: func(ctx context.Context, rawURL string) (*LinkPreview, error) {
+			return nil, ctx.Err()
+		},
+		previewCache: cache,
+	}
+
+	c.attachLinkPreviews(ctx, []Entry{{Message: "<https://slow.example>"}})
+	if f := cache.Failure("https://slow.example"); f != nil {
+		t.Fatalf("Failure() = %+v, want nil for aborted fetch", f)
+	}
+}
+
+func TestCreateHtmlFile_RendersDeadLink(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	cache, err := newLinkPreviewCache(baseDir, 7*24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	now := time.Now()
+	notFound := &previewStatusError{StatusCode: http.StatusNotFound}
+	for i := range 3 {
+		cache.SetFailure("https://gone.example", notFound, now.Add(time.Duration(i)*time.Second))
+	}
+	cache.SetFailure("https://blocked.example", previewBlockedf("localhost is blocked"), now)
+	cache.SetFailure("https://file.example/a.pdf", errNoPreviewMetadata, now)
+	c := &Channels{
+		basedir: baseDir,
+		previewFetcher: func(_ context.Context, rawURL string) (*LinkPreview, error) {
+			t.Fatalf("cached failure should not be fetched: %s", rawURL)
+			return nil, nil
+		},
+		previewCache: cache,
+	}
+	jsonl := `{"timestamp":"1775088000.000000","message":"<https://gone.example> <https://blocked.example> <https://file.example/a.pdf>","channel":{"id":"C1","name":"general"},"files":[]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	g := &GDrive{
+		htmlDir: &drive.File{Id: "html-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			return nil
+		},
+	}
+
+	if err := c.CreateHtmlFile(context.Background(), "general", g, nil); err != nil {
+		t.Fatalf("CreateHtmlFile() error = %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(baseDir, "html", "general.html"))
+	if err != nil {
+		t.Fatalf("read html: %v", err)
+	}
+	if !strings.Contains(string(b), `Dead link: <a href="https://gone.example"`) {
+		t.Fatalf("CreateHtmlFile() should flag the dead link:\n%s", b)
+	}
+	for _, want := range []string{
+		`https://blocked.example</a>: localhost is blocked`,
+		`https://file.example/a.pdf</a>: the page has no title or description`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Fatalf("CreateHtmlFile() should show %q as no preview:\n%s", want, b)
+		}
+	}
+	if strings.Count(string(b), "Dead link:") != 1 {
+		t.Fatalf("CreateHtmlFile() should flag only the gone link as dead:\n%s", b)
+	}
+}
+
+func TestLinkPreviewCache_DropsFailuresDeadUnderOldRules(t *testing.T) {
+	baseDir := t.TempDir()
+	cache, err := newLinkPreviewCache(baseDir, 7*24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	now := time.Now()
+	cache.SetFailure("https://file.example/a.pdf", errNoPreviewMetadata, now)
+	cache.entries["https://file.example/a.pdf"].Failure.Dead = true
+	for i := range 3 {
+		cache.SetFailure("https://gone.example", &previewStatusError{StatusCode: http.StatusGone}, now.Add(time.Duration(i)*time.Second))
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	reloaded, err := newLinkPreviewCache(baseDir, 7*24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache(reload) error = %v", err)
+	}
+	if f := reloaded.Failure("https://file.example/a.pdf"); f != nil {
+		t.Fatalf("Failure(no metadata) = %+v, want dropped", f)
+	}
+	if f := reloaded.Failure("https://gone.example"); f == nil || !f.Dead {
+		t.Fatalf("Failure(gone) = %+v, want dead", f)
+	}
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LinkPreviewPolicyConfig はリンクプレビュー（とリンク先のアーカイブ）でページを取得するドメインの制限です。
+type LinkPreviewPolicyConfig struct {
+	// DenyDomains は取得しないドメインです（サブドメインを含む）。AllowDomains より優先します。
+	DenyDomains []string `json:"deny_domains"`
+	// AllowDomains を設定すると、これらのドメイン（サブドメインを含む）だけを取得します。
+	AllowDomains []string `json:"allow_domains"`
+	// RespectRobotsTxt が true の場合、robots.txt で禁止されたページを取得しません。
+	RespectRobotsTxt bool `json:"respect_robots_txt"`
+}
+
+func (c LinkPreviewPolicyConfig) validate() []string {
+	var errs []string
+	lists := []struct {
+		key     string
+		domains []string
+	}{
+		{key: "deny_domains", domains: c.DenyDomains},
+		{key: "allow_domains", domains: c.AllowDomains},
+	}
+	for _, list := range lists {
+		for i, domain := range list.domains {
+			if normalizePolicyDomain(domain) == "" || strings.ContainsAny(strings.TrimSpace(domain), "/:@ ") {
+				errs = append(errs, fmt.Sprintf("link_preview_policy.%s[%d] must be a domain name: %q.", list.key, i, domain))
+			}
+		}
+	}
+	return errs
+}
+
+// previewPolicy は LinkPreviewPolicyConfig に従って、リンク先を取得してよいかを判定します。
+// リンク先のURLだけでなく、リダイレクト先やページから見つけたoEmbed・画像のURLにも適用します。
+// nil の場合は全て許可します。
+type previewPolicy struct {
+	deny   []string
+	allow  []string
+	robots *robotsCache
+}
+
+func newPreviewPolicy(config LinkPreviewPolicyConfig, robots *robotsCache) *previewPolicy {
+	p := &previewPolicy{robots: robots}
+	for _, domain := range config.DenyDomains {
+		p.deny = append(p.deny, normalizePolicyDomain(domain))
+	}
+	for _, domain := range config.AllowDomains {
+		p.allow = append(p.allow, normalizePolicyDomain(domain))
+	}
+	return p
+}
+
+// previewPolicyError は設定や robots.txt によってリンク先の取得を見送ったことを表します。
+type previewPolicyError struct {
+	Class  string
+	Reason string
+}
+
+func (e *previewPolicyError) Error() string {
+	return e.Reason
+}
+
+// checkDomain は rawURL のホストを deny_domains と allow_domains で判定します。
+func (p *previewPolicy) checkDomain(rawURL string) error {
+	if p == nil {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for _, domain := range p.deny {
+		if policyDomainMatch(host, domain) {
+			return &previewPolicyError{Class: previewErrorPolicy, Reason: fmt.Sprintf("%s is in link_preview_policy.deny_domains", domain)}
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, domain := range p.allow {
+		if policyDomainMatch(host, domain) {
+			return nil
+		}
+	}
+	return &previewPolicyError{Class: previewErrorPolicy, Reason: fmt.Sprintf("%s is not in link_preview_policy.allow_domains", host)}
+}
+
+// checkRobots は respect_robots_txt が有効な場合に rawURL を robots.txt で判定します。
+func (p *previewPolicy) checkRobots(ctx context.Context, rawURL string, now time.Time) error {
+	if p == nil || p.robots == nil {
+		return nil
+	}
+	return p.robots.Check(ctx, rawURL, now)
+}
+
+// check は rawURL をドメインの制限と、respect_robots_txt が有効な場合は robots.txt で判定します。
+func (p *previewPolicy) check(ctx context.Context, rawURL string, now time.Time) error {
+	if err := p.checkDomain(rawURL); err != nil {
+		return err
+	}
+	return p.checkRobots(ctx, rawURL, now)
+}
+
+// getResource は getPreviewResource と同じく rawURL を取得し、リダイレクト先にもドメインの制限と robots.txt を適用します。
+func (p *previewPolicy) getResource(ctx context.Context, rawURL string) (*http.Response, error) {
+	return getPreviewResourceChecked(ctx, rawURL, func(ctx context.Context, next string) error {
+		return p.check(ctx, next, time.Now())
+	})
+}
+
+// getRobotsResource は robots.txt の取得用で、リダイレクト先にはドメインの制限だけを適用します。
+// robots.txt の取得中に robots.txt を確認しないためです。
+func (p *previewPolicy) getRobotsResource(ctx context.Context, rawURL string) (*http.Response, error) {
+	return getPreviewResourceChecked(ctx, rawURL, func(_ context.Context, next string) error {
+		return p.checkDomain(next)
+	})
+}
+
+// respectsRobots は robots.txt で判定するかを返します。
+func (p *previewPolicy) respectsRobots() bool {
+	return p != nil && p.robots != nil
+}
+
+func normalizePolicyDomain(domain string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+func policyDomainMatch(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
@@ -0,0 +1,266 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"google.golang.org/api/drive/v3"
+)
+
+func TestPreviewPolicy_CheckDomain(t *testing.T) {
+	policy := newPreviewPolicy(LinkPreviewPolicyConfig{
+		DenyDomains:  []string{"intra.example.com", ".Paywall.example"},
+		AllowDomains: []string{"example.com", "paywall.example"},
+	}, nil)
+	tests := []struct {
+		rawURL string
+		want   string
+	}{
+		{rawURL: "https://www.example.com/a", want: ""},
+		{rawURL: "https://wiki.intra.example.com/a", want: "intra.example.com is in link_preview_policy.deny_domains"},
+		{rawURL: "https://news.paywall.example/a", want: "paywall.example is in link_preview_policy.deny_domains"},
+		{rawURL: "https://other.example/a", want: "other.example is not in link_preview_policy.allow_domains"},
+		{rawURL: "https://notexample.com/a", want: "notexample.com is not in link_preview_policy.allow_domains"},
+	}
+	for _, tt := range tests {
+		err := policy.checkDomain(tt.rawURL)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("checkDomain(%q) = %q, want %q", tt.rawURL, got, tt.want)
+		}
+	}
+
+	var disabled *previewPolicy
+	if err := disabled.checkDomain("https://anything.example"); err != nil {
+		t.Fatalf("nil policy checkDomain() error = %v", err)
+	}
+}
+
+func TestConfig_validate_LinkPreviewPolicy(t *testing.T) {
+	c := Config{AppToken: "xapp-1", BotToken: "xoxb-1", BaseDir: "/tmp", AuthorID: "U1"}
+	c.LinkPreviewPolicy = LinkPreviewPolicyConfig{DenyDomains: []string{"intra.example.com"}, AllowDomains: []string{"example.com"}}
+	if err := c.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+	c.LinkPreviewPolicy = LinkPreviewPolicyConfig{DenyDomains: []string{"https://intra.example.com/"}, AllowDomains: []string{" "}}
+	err := c.validate()
+	if err == nil || !strings.Contains(err.Error(), "link_preview_policy.deny_domains[0]") || !strings.Contains(err.Error(), "link_preview_policy.allow_domains[0]") {
+		t.Fatalf("validate() error = %v, want policy errors", err)
+	}
+}
+
+func TestAttachLinkPreviews_DeniedDomainIsNotFetched(t *testing.T) {
+	cache, err := newLinkPreviewCache(t.TempDir(), 24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	// 拒否リストに追加する前にキャッシュしたプレビューも使わない
+	cache.Set("https://intra.example.com/wiki", &LinkPreview{URL: "https://intra.example.com/wiki", Title: "cached"}, time.Now())
+	var fetched []string
+	c := &Channels{
+		previewFetcher: func(_ context.Context, rawURL string) (*LinkPreview, error) {
+			fetched = append(fetched, rawURL)
+			return &LinkPreview{URL: rawURL, Title: "ok"}, nil
+		},
+		previewCache:  cache,
+		previewPolicy: newPreviewPolicy(LinkPreviewPolicyConfig{DenyDomains: []string{"intra.example.com"}}, nil),
+	}
+
+	got := c.attachLinkPreviews(context.Background(), []Entry{{Message: "<https://intra.example.com/wiki> <https://public.example>"}})
+	if strings.Join(fetched, " ") != "https://public.example" {
+		t.Fatalf("fetched = %v, want only the public link", fetched)
+	}
+	if len(got[0].Previews) != 1 || got[0].Previews[0].URL != "https://public.example" {
+		t.Fatalf("Previews = %+v", got[0].Previews)
+	}
+	want := BlockedLink{URL: "https://intra.example.com/wiki", Reason: "intra.example.com is in link_preview_policy.deny_domains"}
+	if len(got[0].BlockedLinks) != 1 || got[0].BlockedLinks[0] != want {
+		t.Fatalf("BlockedLinks = %+v, want %+v", got[0].BlockedLinks, want)
+	}
+	// Markdownのリンク一覧にはプレビューを作成しなかったリンクも含める
+	if links := got[0].Links; len(links) != 2 || links[0].URL != "https://intra.example.com/wiki" || links[0].Preview != nil || links[1].Preview == nil {
+		t.Fatalf("Links = %+v, want both links", links)
+	}
+}
+
+func TestLinkPreviewFetcher_Fetch_RespectsRobotsTxt(t *testing.T) {
+	robots, err := newRobotsCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("newRobotsCache() error = %v", err)
+	}
+	getter := &stubPreviewGetter{bodies: map[string]string{
+		"https://blog.example/robots.txt": "User-agent: happeninghound-link-preview\nDisallow: /drafts/\n",
+		"https://blog.example/posts/1":    `<html><head><title>Post</title></head></html>`,
+	}}
+	robots.getFn = getter.get
+	f := newLinkPreviewFetcher(nil, newPreviewPolicy(LinkPreviewPolicyConfig{RespectRobotsTxt: true}, robots))
+	f.getFn = getter.get
+
+	if _, err := f.Fetch(context.Background(), "https://blog.example/drafts/1"); err == nil || classifyPreviewError(err) != previewErrorRobots {
+		t.Fatalf("Fetch(drafts) error = %v, want robots disallow", err)
+	}
+	got, err := f.Fetch(context.Background(), "https://blog.example/posts/1")
+	if err != nil || got.Title != "Post" {
+		t.Fatalf("Fetch(posts) = (%+v, %v), want Post", got, err)
+	}
+	for _, requested := range getter.requested {
+		if requested == "https://blog.example/drafts/1" {
+			t.Fatalf("disallowed page was requested: %v", getter.requested)
+		}
+	}
+}
+
+func TestCreateHtmlFile_RendersRobotsDecision(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	cache, err := newLinkPreviewCache(baseDir, 24*time.Hour, 10)
+	if err != nil {
+		t.Fatalf("newLinkPreviewCache() error = %v", err)
+	}
+	robots, err := newRobotsCache(baseDir)
+	if err != nil {
+		t.Fatalf("newRobotsCache() error = %v", err)
+	}
+	robots.getFn = func(_ context.Context, rawURL string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("User-agent: *\nDisallow: /\n")), Request: req}, nil
+	}
+	policy := newPreviewPolicy(LinkPreviewPolicyConfig{RespectRobotsTxt: true}, robots)
+	fetcher := newLinkPreviewFetcher(nil, policy)
+	fetcher.getFn = func(_ context.Context, rawURL string) (*http.Response, error) {
+		t.Fatalf("page should not be fetched: %s", rawURL)
+		return nil, nil
+	}
+	c := &Channels{basedir: baseDir, previewFetcher: fetcher.Fetch, previewCache: cache, previewPolicy: policy}
+	jsonl := `{"timestamp":"1775088000.000000","message":"<https://nobots.example/page>","channel":{"id":"C1","name":"general"},"files":[]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	g := &GDrive{
+		htmlDir: &drive.File{Id: "html-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			return nil
+		},
+	}
+
+	if err := c.CreateHtmlFile(context.Background(), "general", g, nil); err != nil {
+		t.Fatalf("CreateHtmlFile() error = %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(baseDir, "html", "general.html"))
+	if err != nil {
+		t.Fatalf("read html: %v", err)
+	}
+	if !strings.Contains(string(b), `https://nobots.example/page</a>: disallowed by robots.txt (Disallow: /)`) {
+		t.Fatalf("CreateHtmlFile() should show the robots.txt decision:\n%s", b)
+	}
+	if f := cache.Failure("https://nobots.example/page"); f == nil || f.ErrorClass != previewErrorRobots || f.Dead {
+		t.Fatalf("Failure() = %+v, want recorded robots decision", f)
+	}
+}
+
+func TestPreviewCheckRedirect_AppliesPolicyToEveryHop(t *testing.T) {
+	robots, err := newRobotsCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("newRobotsCache() error = %v", err)
+	}
+	robots.getFn = (&stubPreviewGetter{bodies: map[string]string{
+		"https://blog.example/robots.txt": "User-agent: *\nDisallow: /drafts/\n",
+	}}).get
+	policy := newPreviewPolicy(LinkPreviewPolicyConfig{DenyDomains: []string{"intra.example.com"}, RespectRobotsTxt: true}, robots)
+	checkRedirect := previewCheckRedirect(func(ctx context.Context, rawURL string) error {
+		return policy.check(ctx, rawURL, time.Now())
+	})
+	tests := []struct {
+		target string
+		class  string
+	}{
+		{target: "https://wiki.intra.example.com/page", class: previewErrorPolicy},
+		{target: "https://blog.example/drafts/1", class: previewErrorRobots},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, tt.target, nil)
+		if err != nil {
+			t.Fatalf("NewRequest() error = %v", err)
+		}
+		if err := checkRedirect(req, []*http.Request{{}}); err == nil || classifyPreviewError(err) != tt.class {
+			t.Errorf("redirect to %s error = %v, want %s", tt.target, err, tt.class)
+		}
+	}
+}
+
+func TestLinkPreviewFetcher_Fetch_AppliesPolicyToDiscoveredOEmbed(t *testing.T) {
+	getter := &stubPreviewGetter{bodies: map[string]string{
+		"https://blog.example/posts/1": `<html><head><title>Post</title>
+<link rel="alternate" type="application/json+oembed" href="https://oembed.intra.example.com/oembed?url=post">
+</head></html>`,
+		"https://oembed.intra.example.com/oembed?url=post": testVideoOEmbed,
+	}}
+	f := newLinkPreviewFetcher(nil, newPreviewPolicy(LinkPreviewPolicyConfig{DenyDomains: []string{"intra.example.com"}}, nil))
+	f.getFn = getter.get
+
+	got, err := f.Fetch(context.Background(), "https://blog.example/posts/1")
+	if err != nil || got.Title != "Post" || got.EmbedURL != "" {
+		t.Fatalf("Fetch() = (%+v, %v), want the page preview without oEmbed", got, err)
+	}
+	if !slices.Equal(getter.requested, []string{"https://blog.example/posts/1"}) {
+		t.Fatalf("requested = %v, want only the page", getter.requested)
+	}
+}
+
+func TestLinkArchive_Archive_AppliesPolicyToImage(t *testing.T) {
+	baseDir := t.TempDir()
+	a, err := newLinkArchive(baseDir, newPreviewPolicy(LinkPreviewPolicyConfig{DenyDomains: []string{"tracker.example"}}, nil))
+	if err != nil {
+		t.Fatalf("newLinkArchive() error = %v", err)
+	}
+	getter := &stubPreviewGetter{
+		bodies: map[string]string{
+			"https://blog.example/post":             `<html><head><title>Post</title><meta property="og:image" content="https://img.tracker.example/cover.png"></head><body><p>body</p></body></html>`,
+			"https://img.tracker.example/cover.png": "png-bytes",
+		},
+		contentTypes: map[string]string{"https://img.tracker.example/cover.png": "image/png"},
+	}
+	a.getFn = getter.get
+
+	page, err := a.Archive(context.Background(), "https://blog.example/post", time.Now())
+	if err != nil {
+		t.Fatalf("Archive() error = %v", err)
+	}
+	if page.ImagePath != "" || !slices.Equal(getter.requested, []string{"https://blog.example/post"}) {
+		t.Fatalf("Archive() = %+v, requested = %v, want the image skipped", page, getter.requested)
+	}
+}
+
+func TestLinkPreviewFetcher_Fetch_AppliesPolicyToProviderEndpoint(t *testing.T) {
+	getter := &stubPreviewGetter{bodies: map[string]string{
+		"https://youtu.be/abc": `<html><head><title>Video</title></head></html>`,
+	}}
+	f := newLinkPreviewFetcher(nil, newPreviewPolicy(LinkPreviewPolicyConfig{AllowDomains: []string{"youtu.be"}}, nil))
+	f.getFn = getter.get
+
+	got, err := f.Fetch(context.Background(), "https://youtu.be/abc")
+	if err != nil || got.Title != "Video" {
+		t.Fatalf("Fetch() = (%+v, %v), want the page preview", got, err)
+	}
+	if !slices.Equal(getter.requested, []string{"https://youtu.be/abc"}) {
+		t.Fatalf("requested = %v, want only the page (youtube.com is not allowed)", getter.requested)
+	}
+}
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLinkPreviewConcurrency        = 8
+	defaultLinkPreviewPerHostConcurrency = 2
+	defaultLinkPreviewPerHostIntervalMS  = 500
+	defaultLinkPreviewTimeoutSeconds     = 60
+)
+
+// LinkPreviewFetchConfig はHTML・Markdown生成時にリンク先ページを並行して取得する際の制限です。
+// 0 または未指定の項目はデフォルト値を使います。
+type LinkPreviewFetchConfig struct {
+	// Concurrency は同時に取得するリンクの最大数です。
+	Concurrency int `json:"concurrency"`
+	// PerHostConcurrency は同じホストに同時に接続する最大数です。
+	PerHostConcurrency int `json:"per_host_concurrency"`
+	// PerHostIntervalMS は同じホストへのリクエストを開始する最小間隔（ミリ秒）です。
+	PerHostIntervalMS int `json:"per_host_interval_ms"`
+	// TimeoutSeconds は1回の生成でリンク先の取得にかける時間の上限（秒）です。
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+func (c LinkPreviewFetchConfig) withDefaults() LinkPreviewFetchConfig {
+	if c.Concurrency <= 0 {
+		c.Concurrency = defaultLinkPreviewConcurrency
+	}
+	if c.PerHostConcurrency <= 0 {
+		c.PerHostConcurrency = defaultLinkPreviewPerHostConcurrency
+	}
+	if c.PerHostIntervalMS <= 0 {
+		c.PerHostIntervalMS = defaultLinkPreviewPerHostIntervalMS
+	}
+	if c.TimeoutSeconds <= 0 {
+		c.TimeoutSeconds = defaultLinkPreviewTimeoutSeconds
+	}
+	return c
+}
+
+func (c LinkPreviewFetchConfig) validate() []string {
+	var errs []string
+	if c.Concurrency < 0 {
+		errs = append(errs, "link_preview_fetch.concurrency must be >= 0.")
+	}
+	if c.PerHostConcurrency < 0 {
+		errs = append(errs, "link_preview_fetch.per_host_concurrency must be >= 0.")
+	}
+	if c.PerHostIntervalMS < 0 {
+		errs = append(errs, "link_preview_fetch.per_host_interval_ms must be >= 0.")
+	}
+	if c.TimeoutSeconds < 0 {
+		errs = append(errs, "link_preview_fetch.timeout_seconds must be >= 0.")
+	}
+	return errs
+}
+
+// linkPreviewResult はリンクプレビューの取得結果です。
+// aborted は制限時間やキャンセルで取得を打ち切ったことを、failure はキャッシュに記録した取得失敗を表します。
+type linkPreviewResult struct {
+	preview *LinkPreview
+	err     error
+	aborted bool
+	failure *linkPreviewFailure
+}
+
+// fetchLinkPreviews は urls のプレビューを previewFetch の制限の範囲で並行して取得します。
+// 制限時間を過ぎた場合、取得中・未取得のURLはコンテキストのエラーになります。
+func (c *Channels) fetchLinkPreviews(ctx context.Context, urls []string) map[string]linkPreviewResult {
+	results := make(map[string]linkPreviewResult, len(urls))
+	if len(urls) == 0 {
+		return results
+	}
+	cfg := c.previewFetch.withDefaults()
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	limiter := newHostLimiter(cfg.PerHostConcurrency, time.Duration(cfg.PerHostIntervalMS)*time.Millisecond)
+	jobs := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for range min(cfg.Concurrency, len(urls)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rawURL := range jobs {
+				result := c.previewInflight.do(ctx, rawURL, func() linkPreviewResult {
+					return c.fetchLinkPreviewLimited(ctx, limiter, rawURL)
+				})
+				mu.Lock()
+				results[rawURL] = result
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, rawURL := range urls {
+		jobs <- rawURL
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+func (c *Channels) fetchLinkPreviewLimited(ctx context.Context, limiter *hostLimiter, rawURL string) linkPreviewResult {
+	if err := ctx.Err(); err != nil {
+		return linkPreviewResult{err: err, aborted: true}
+	}
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		host = strings.ToLower(u.Hostname())
+	}
+	release, err := limiter.acquire(ctx, host)
+	if err != nil {
+		return linkPreviewResult{err: err, aborted: true}
+	}
+	defer release()
+	preview, err := c.previewFetcher(ctx, rawURL)
+	if err == nil && preview == nil {
+		err = errNoPreviewMetadata
+	}
+	return linkPreviewResult{preview: preview, err: err, aborted: err != nil && ctx.Err() != nil}
+}
+
+// hostLimiter はホストごとの同時接続数とリクエスト開始間隔を制限します。
+type hostLimiter struct {
+	perHost  int
+	interval time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimitState
+}
+
+type hostLimitState struct {
+	sem  chan struct{}
+	next time.Time
+}
+
+func newHostLimiter(perHost int, interval time.Duration) *hostLimiter {
+	return &hostLimiter{perHost: perHost, interval: interval, hosts: map[string]*hostLimitState{}}
+}
+
+// acquire は host への接続枠と開始時刻を確保するまで待ち、枠を返す関数を返します。
+func (l *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	l.mu.Lock()
+	state, ok := l.hosts[host]
+	if !ok {
+		state = &hostLimitState{sem: make(chan struct{}, l.perHost)}
+		l.hosts[host] = state
+	}
+	l.mu.Unlock()
+
+	select {
+	case state.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-state.sem }
+
+	l.mu.Lock()
+	start := time.Now()
+	if state.next.After(start) {
+		start = state.next
+	}
+	state.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// inflightPreviews は同じURLの取得が同時に走らないよう、取得中の結果を共有します。
+// 複数のHTML生成が同時に実行された場合も、同じURLは1回だけ取得します。
+type inflightPreviews struct {
+	mu    sync.Mutex
+	calls map[string]*inflightPreview
+}
+
+type inflightPreview struct {
+	done   chan struct{}
+	result linkPreviewResult
+}
+
+// do は rawURL を取得中の呼び出しがあればその結果を待ち、なければ fetch で取得します。
+// 待っている間に ctx が終わった場合は打ち切り、共有した結果が取得元の制限時間切れで打ち切られていた場合は取得し直します。
+func (g *inflightPreviews) do(ctx context.Context, rawURL string, fetch func() linkPreviewResult) linkPreviewResult {
+	for {
+		g.mu.Lock()
+		call, ok := g.calls[rawURL]
+		if !ok {
+			break
+		}
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+			if call.result.aborted && ctx.Err() == nil {
+				continue
+			}
+			return call.result
+		case <-ctx.Done():
+			return linkPreviewResult{err: ctx.Err(), aborted: true}
+		}
+	}
+	if g.calls == nil {
+		g.calls = map[string]*inflightPreview{}
+	}
+	call := &inflightPreview{done: make(chan struct{})}
+	g.calls[rawURL] = call
+	g.mu.Unlock()
+
+	call.result = fetch()
+	close(call.done)
+
+	g.mu.Lock()
+	delete(g.calls, rawURL)
+	g.mu.Unlock()
+	return call.result
+}
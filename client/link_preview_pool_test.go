@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrencyRecorder はホストごとと全体の同時実行数の最大値を記録します。
+type concurrencyRecorder struct {
+	mu         sync.Mutex
+	active     int
+	maxActive  int
+	perHost    map[string]int
+	maxPerHost map[string]int
+	started    map[string][]time.Time
+	calls      int
+}
+
+func newConcurrencyRecorder() *concurrencyRecorder {
+	return &concurrencyRecorder{perHost: map[string]int{}, maxPerHost: map[string]int{}, started: map[string][]time.Time{}}
+}
+
+func (r *concurrencyRecorder) fetch(delay time.Duration) linkPreviewFetchFunc {
+	return func(_ context.Context, rawURL string) (*LinkPreview, error) {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		r.mu.Lock()
+		r.calls++
+		r.active++
+		r.perHost[u.Host]++
+		r.maxActive = max(r.maxActive, r.active)
+		r.maxPerHost[u.Host] = max(r.maxPerHost[u.Host], r.perHost[u.Host])
+		r.started[u.Host] = append(r.started[u.Host], time.Now())
+		r.mu.Unlock()
+
+		time.Sleep(delay)
+
+		r.mu.Lock()
+		r.active--
+		r.perHost[u.Host]--
+		r.mu.Unlock()
+		return &LinkPreview{URL: rawURL, Title: "title " + rawURL}, nil
+	}
+}
+
+func TestAttachLinkPreviews_FetchesConcurrentlyWithinLimits(t *testing.T) {
+	rec := newConcurrencyRecorder()
+	c := &Channels{
+		previewFetcher: rec.fetch(30 * time.Millisecond),
+		previewFetch:   LinkPreviewFetchConfig{Concurrency: 4, PerHostConcurrency: 2, PerHostIntervalMS: 1},
+	}
+	var entries []Entry
+	for i := range 6 {
+		entries = append(entries, Entry{Message: fmt.Sprintf("<https://a.example/%d> <https://b.example/%d>", i, i)})
+	}
+	// 同じURLを含むメッセージは1回だけ取得する
+	entries = append(entries, Entry{Message: "<https://a.example/0>"})
+
+	got := c.attachLinkPreviews(context.Background(), entries)
+
+	if rec.calls != 12 {
+		t.Fatalf("fetch calls = %d, want 12", rec.calls)
+	}
+	if rec.maxActive > 4 || rec.maxActive < 2 {
+		t.Fatalf("max concurrent fetches = %d, want 2..4", rec.maxActive)
+	}
+	for host, n := range rec.maxPerHost {
+		if n > 2 {
+			t.Fatalf("max concurrent fetches for %s = %d, want <= 2", host, n)
+		}
+	}
+	for i, e := range got {
+		if len(e.Previews) == 0 || !strings.HasPrefix(e.Previews[0].Title, "title https://a.example/") {
+			t.Fatalf("entries[%d].Previews = %+v", i, e.Previews)
+		}
+	}
+	if got[0].Previews[1].URL != "https://b.example/0" {
+		t.Fatalf("previews order = %+v, want message order", got[0].Previews)
+	}
+}
+
+func TestAttachLinkPreviews_PerHostInterval(t *testing.T) {
+	rec := newConcurrencyRecorder()
+	c := &Channels{
+		previewFetcher: rec.fetch(0),
+		previewFetch:   LinkPreviewFetchConfig{Concurrency: 3, PerHostConcurrency: 3, PerHostIntervalMS: 50},
+	}
+	entries := []Entry{{Message: "<https://a.example/1> <https://a.example/2> <https://a.example/3>"}}
+
+	c.attachLinkPreviews(context.Background(), entries)
+
+	started := rec.started["a.example"]
+	if len(started) != 3 {
+		t.Fatalf("started = %v, want 3 requests", started)
+	}
+	if gap := started[2].Sub(started[0]); gap < 90*time.Millisecond {
+		t.Fatalf("first to last request = %v, want >= 100ms apart", gap)
+	}
+}
+
+func TestAttachLinkPreviews_StopsAtDeadline(t *testing.T) {
+	c := &Channels{
+		previewFetcher: func(ctx context.Context, rawURL string) (*LinkPreview, error) {
+			if rawURL == "https://fast.example/" {
+				return &LinkPreview{URL: rawURL, Title: "fast"}, nil
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		previewFetch: LinkPreviewFetchConfig{TimeoutSeconds: 1},
+	}
+	entries := []Entry{{Message: "<https://slow.example/>"}, {Message: "<https://fast.example/>"}}
+
+	start := time.Now()
+	got := c.attachLinkPreviews(context.Background(), entries)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("attachLinkPreviews() took %v, want about 1s", elapsed)
+	}
+	if len(got[0].Previews) != 0 {
+		t.Fatalf("slow previews = %+v, want none", got[0].Previews)
+	}
+	if len(got[1].Previews) != 1 || got[1].Previews[0].Title != "fast" {
+		t.Fatalf("fast previews = %+v, want fast", got[1].Previews)
+	}
+}
+
+func TestInflightPreviews_SharesConcurrentFetch(t *testing.T) {
+	var g inflightPreviews
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fetch := func() linkPreviewResult {
+		calls.Add(1)
+		<-release
+		return linkPreviewResult{preview: &LinkPreview{Title: "shared"}}
+	}
+
+	var wg sync.WaitGroup
+	results := make([]linkPreviewResult, 3)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = g.do(context.Background(), "https://example.com", fetch)
+		}()
+	}
+	// 全員が取得中の呼び出しに合流するまで待ってから結果を返す
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("fetch calls = %d, want 1", calls.Load())
+	}
+	for i, r := range results {
+		if r.preview == nil || r.preview.Title != "shared" {
+			t.Fatalf("results[%d] = %+v, want shared", i, r)
+		}
+	}
+	if len(g.calls) != 0 {
+		t.Fatalf("calls = %v, want empty after fetch", g.calls)
+	}
+}
+
+func TestConfig_validate_LinkPreviewFetch(t *testing.T) {
+	c := Config{AppToken: "xapp-1", BotToken: "xoxb-1", BaseDir: "/tmp", AuthorID: "U1"}
+	c.LinkPreviewFetch = LinkPreviewFetchConfig{Concurrency: -1, TimeoutSeconds: -1}
+	err := c.validate()
+	if err == nil || !strings.Contains(err.Error(), "link_preview_fetch.concurrency") || !strings.Contains(err.Error(), "link_preview_fetch.timeout_seconds") {
+		t.Fatalf("validate() error = %v, want link_preview_fetch errors", err)
+	}
+}
+
+func TestInflightPreviews_WaiterHonorsOwnContextAndRetriesAborted(t *testing.T) {
+	var g inflightPreviews
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go g.do(context.Background(), "https://example.com", func() linkPreviewResult {
+		close(started)
+		<-release
+		return linkPreviewResult{err: context.DeadlineExceeded, aborted: true}
+	})
+	<-started
+
+	// 待っている側の制限時間が先に切れた場合は、取得元を待たずに打ち切る
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if got := g.do(ctx, "https://example.com", func() linkPreviewResult {
+		t.Fatal("fetch should not run while another call is in flight")
+		return linkPreviewResult{}
+	}); !got.aborted || !errors.Is(got.err, context.DeadlineExceeded) {
+		t.Fatalf("do(expired ctx) = %+v, want aborted", got)
+	}
+
+	// 取得元が自分の制限時間切れで打ち切った結果は使わず、取得し直す
+	done := make(chan linkPreviewResult)
+	go func() {
+		done <- g.do(context.Background(), "https://example.com", func() linkPreviewResult {
+			return linkPreviewResult{preview: &LinkPreview{Title: "retried"}}
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if got := <-done; got.aborted || got.preview == nil || got.preview.Title != "retried" {
+		t.Fatalf("do(after aborted) = %+v, want retried fetch", got)
+	}
+}
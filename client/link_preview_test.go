@@ -4,8 +4,17 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"google.golang.org/api/drive/v3"
 )
 
 func TestAttachLinkPreviews(t *testing.T) {
@@ -15,33 +24,46 @@ func TestAttachLinkPreviews(t *testing.T) {
 			calls++
 			return &LinkPreview{
 				URL:   rawURL,
-				Title: "preview-title",
+				Title: "preview-title " + rawURL,
 			}, nil
 		},
+		previewMaxPerMessage: 2,
 	}
 
 	entries := []Entry{
 		{Message: "<https://example.com>"},
 		{Message: "<https://example.com|same>"},
-		{Message: "text <https://example.com>"},
-		{Message: "<https://a.example> <https://b.example>"},
+		{Message: "great read <https://example.com> and <https://b.example>"},
+		{Message: "<https://a.example> <https://a.example> <https://b.example> <https://c.example>"},
+		{Message: "no links"},
 	}
 
 	got := ch.attachLinkPreviews(context.Background(), entries)
-	if got[0].Preview == nil || got[0].Preview.Title != "preview-title" {
-		t.Fatalf("entry[0] preview not set: %+v", got[0].Preview)
-	}
-	if got[1].Preview == nil || got[1].Preview.Title != "preview-title" {
-		t.Fatalf("entry[1] preview not set: %+v", got[1].Preview)
+	titles := func(previews []LinkPreview) []string {
+		var out []string
+		for _, p := range previews {
+			out = append(out, p.Title)
+		}
+		return out
 	}
-	if got[2].Preview != nil {
-		t.Fatalf("entry[2] preview should be nil: %+v", got[2].Preview)
+	tests := []struct {
+		index int
+		want  []string
+	}{
+		{index: 0, want: []string{"preview-title https://example.com"}},
+		{index: 1, want: []string{"preview-title https://example.com"}},
+		{index: 2, want: []string{"preview-title https://example.com", "preview-title https://b.example"}},
+		// 重複を除いて上限（2件）まで
+		{index: 3, want: []string{"preview-title https://a.example", "preview-title https://b.example"}},
+		{index: 4, want: nil},
 	}
-	if got[3].Preview != nil {
-		t.Fatalf("entry[3] preview should be nil: %+v", got[3].Preview)
+	for _, tt := range tests {
+		if g := titles(got[tt.index].Previews); !slices.Equal(g, tt.want) {
+			t.Errorf("entry[%d] previews = %v, want %v", tt.index, g, tt.want)
+		}
 	}
-	if calls != 1 {
-		t.Fatalf("preview fetcher calls = %d, want 1", calls)
+	if calls != 3 {
+		t.Fatalf("preview fetcher calls = %d, want 3", calls)
 	}
 }
 
@@ -64,13 +86,16 @@ func TestParseLinkPreviewFromHTML(t *testing.T) {
 <body></body>
 </html>`
 
-	preview, err := parseLinkPreviewFromHTML(pageURL, strings.NewReader(html))
+	preview, oembedURL, err := parseLinkPreviewFromHTML(pageURL, strings.NewReader(html))
 	if err != nil {
 		t.Fatalf("parseLinkPreviewFromHTML() error = %v", err)
 	}
 	if preview == nil {
 		t.Fatal("preview is nil")
 	}
+	if oembedURL != "" {
+		t.Errorf("oembedURL = %q, want empty", oembedURL)
+	}
 	if preview.Title != "OG Title" {
 		t.Errorf("Title = %q, want %q", preview.Title, "OG Title")
 	}
@@ -156,3 +181,157 @@ func TestEntry_LinkURLs(t *testing.T) {
 		t.Fatalf("LinkURLs() = %v, want %v", got, want)
 	}
 }
+
+func TestSlackUnfurls(t *testing.T) {
+	attachments := []slack.Attachment{
+		{
+			FromURL:     "https://example.com/article",
+			OriginalURL: "https://example.com/article",
+			Title:       "Article",
+			Text:        "summary",
+			ThumbURL:    "https://example.com/thumb.png",
+			ServiceName: "Example",
+		},
+		// Botが付けた attachments はリンク元を持たない
+		{Title: "bot attachment", Text: "not an unfurl"},
+		// 表示する内容がない
+		{FromURL: "https://example.com/empty"},
+	}
+
+	got := slackUnfurls(attachments)
+	want := []LinkPreview{{
+		URL:         "https://example.com/article",
+		Title:       "Article",
+		Description: "summary",
+		ImageURL:    "https://example.com/thumb.png",
+		SiteName:    "Example",
+	}}
+	if !slices.Equal(got, want) {
+		t.Fatalf("slackUnfurls() = %+v, want %+v", got, want)
+	}
+}
+
+func TestAttachLinkPreviews_PrefersSlackUnfurls(t *testing.T) {
+	var fetched []string
+	ch := &Channels{
+		previewFetcher: func(_ context.Context, rawURL string) (*LinkPreview, error) {
+			fetched = append(fetched, rawURL)
+			return &LinkPreview{URL: rawURL, Title: "fetched"}, nil
+		},
+	}
+	unfurl := LinkPreview{URL: "https://example.com", Title: "from-slack", SiteName: "Example"}
+
+	entries := []Entry{
+		{Message: "<https://example.com>", Unfurls: []LinkPreview{unfurl}},
+		{Message: "see <https://example.com|this>", Unfurls: []LinkPreview{unfurl}},
+		{Message: "<https://other.example>", Unfurls: []LinkPreview{unfurl}},
+	}
+
+	got := ch.attachLinkPreviews(context.Background(), entries)
+	for i, want := range []string{"from-slack", "from-slack", "fetched"} {
+		if len(got[i].Previews) != 1 || got[i].Previews[0].Title != want {
+			t.Fatalf("entry[%d] previews = %+v, want %s", i, got[i].Previews, want)
+		}
+	}
+	if !slices.Equal(fetched, []string{"https://other.example"}) {
+		t.Fatalf("fetched = %v, want only the link without unfurl", fetched)
+	}
+}
+
+func encodeTestText(t *testing.T, enc encoding.Encoding, s string) string {
+	t.Helper()
+	b, err := enc.NewEncoder().String(s)
+	if err != nil {
+		t.Fatalf("encode %q: %v", s, err)
+	}
+	return b
+}
+
+func TestDecodePreviewBody(t *testing.T) {
+	title := "日本語のタイトル"
+	longHead := "<html><head><!--" + strings.Repeat("x", 2048) + "-->"
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		wantCharset string
+	}{
+		{
+			name:        "content-type shift_jis",
+			body:        "<html><head><title>" + encodeTestText(t, japanese.ShiftJIS, title) + "</title></head></html>",
+			contentType: "text/html; charset=Shift_JIS",
+			wantCharset: "shift_jis",
+		},
+		{
+			name:        "meta euc-jp",
+			body:        `<html><head><meta charset="EUC-JP"><title>` + encodeTestText(t, japanese.EUCJP, title) + "</title></head></html>",
+			contentType: "text/html",
+			wantCharset: "euc-jp",
+		},
+		{
+			name:        "meta http-equiv shift_jis",
+			body:        `<html><head><meta http-equiv="Content-Type" content="text/html; charset=x-sjis"><title>` + encodeTestText(t, japanese.ShiftJIS, title) + "</title></head></html>",
+			wantCharset: "shift_jis",
+		},
+		{
+			name:        "utf-8 bom",
+			body:        "\xef\xbb\xbf<html><head><title>" + title + "</title></head></html>",
+			contentType: "text/html; charset=Shift_JIS",
+			wantCharset: "utf-8",
+		},
+		{
+			name:        "undeclared utf-8 after long head",
+			body:        longHead + "<title>" + title + "</title></head></html>",
+			wantCharset: "utf-8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, charsetName := decodePreviewBody([]byte(tt.body), tt.contentType)
+			if charsetName != tt.wantCharset {
+				t.Fatalf("charset = %q, want %q", charsetName, tt.wantCharset)
+			}
+			pageURL, _ := url.Parse("https://example.jp/")
+			preview, _, err := parseLinkPreviewFromHTML(pageURL, r)
+			if err != nil {
+				t.Fatalf("parseLinkPreviewFromHTML() error = %v", err)
+			}
+			if preview == nil || preview.Title != title {
+				t.Fatalf("preview = %+v, want title %q", preview, title)
+			}
+		})
+	}
+}
+
+func TestCreateHtmlFile_RendersPreviewCardStrip(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	jsonl := `{"timestamp":"1775088000.000000","message":"great read <https://a.example> and <https://b.example>","channel":{"id":"C1","name":"general"},"files":[],"unfurls":[` +
+		`{"url":"https://a.example","title":"Card A"},{"url":"https://b.example","title":"Card B"}]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	g := &GDrive{
+		htmlDir: &drive.File{Id: "html-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			return nil
+		},
+	}
+
+	if err := c.CreateHtmlFile(context.Background(), "general", g, nil); err != nil {
+		t.Fatalf("CreateHtmlFile() error = %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(baseDir, "html", "general.html"))
+	if err != nil {
+		t.Fatalf("read html: %v", err)
+	}
+	got := string(b)
+	if strings.Count(got, `class="w-72 shrink-0"`) != 2 || !strings.Contains(got, "Card A") || !strings.Contains(got, "Card B") {
+		t.Fatalf("CreateHtmlFile() should render both cards in a strip:\n%s", got)
+	}
+}
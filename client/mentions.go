@@ -0,0 +1,189 @@
+package client
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+type slackNameClient interface {
+	userInfoGetter
+	GetConversationInfoContext(ctx context.Context, input *slack.GetConversationInfoInput) (*slack.Channel, error)
+	GetUserGroupsContext(ctx context.Context, options ...slack.GetUserGroupsOption) ([]slack.UserGroup, error)
+}
+
+type slackMentionKind int
+
+const (
+	slackUserMention slackMentionKind = iota
+	slackChannelMention
+	slackUserGroupMention
+)
+
+type slackMention struct {
+	kind slackMentionKind
+	id   string
+}
+
+// Slack形式のメンショントークン(<@U123>, <#C123|name>, <!subteam^S123>)を抽出する。
+var slackMentionRe = regexp.MustCompile(`<(@|#|!subteam\^)([A-Z0-9]+)(?:\|[^<>]*)?>`)
+
+// parseSlackMentions はメッセージ中のユーザー・チャンネル・ユーザーグループのメンションを重複なく返す。
+func parseSlackMentions(message string) []slackMention {
+	matches := slackMentionRe.FindAllStringSubmatch(message, -1)
+	mentions := make([]slackMention, 0, len(matches))
+	seen := make(map[string]bool)
+	for _, m := range matches {
+		if seen[m[2]] {
+			continue
+		}
+		seen[m[2]] = true
+		kind := slackUserMention
+		switch m[1] {
+		case "#":
+			kind = slackChannelMention
+		case "!subteam^":
+			kind = slackUserGroupMention
+		}
+		mentions = append(mentions, slackMention{kind: kind, id: m[2]})
+	}
+	return mentions
+}
+
+// slackMentionText はメンショントークンを @名前 / #チャンネル名 の表示に変換する。メンションでない場合は空文字を返す。
+// 名前が解決できない場合はトークンのラベル、ラベルもなければIDを表示する。
+func slackMentionText(target, label string, names map[string]string) string {
+	switch {
+	case strings.HasPrefix(target, "@"):
+		id := strings.TrimPrefix(target, "@")
+		return "@" + firstNonEmpty(names[id], strings.TrimPrefix(label, "@"), id)
+	case strings.HasPrefix(target, "#"):
+		id := strings.TrimPrefix(target, "#")
+		return "#" + firstNonEmpty(names[id], strings.TrimPrefix(label, "#"), id)
+	case strings.HasPrefix(target, "!subteam^"):
+		id := strings.TrimPrefix(target, "!subteam^")
+		return "@" + firstNonEmpty(names[id], strings.TrimPrefix(label, "@"), id)
+	case target == "!here" || target == "!channel" || target == "!everyone":
+		return "@" + strings.TrimPrefix(target, "!")
+	}
+	return ""
+}
+
+// resolveMentionNames はメッセージ中のメンションの名前を名前キャッシュとSlack APIで解決し、IDから名前への対応を返します。
+// 取得に失敗した名前は期限切れのキャッシュで代替し、それもなければ対応に含めません。
+func (c *Channels) resolveMentionNames(ctx context.Context, client slackNameClient, message string) map[string]string {
+	mentions := parseSlackMentions(message)
+	if len(mentions) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	names := make(map[string]string)
+	var userGroups map[string]string
+	changed := false
+	for _, m := range mentions {
+		cached, found, fresh := c.cachedName(m.id, now)
+		if fresh {
+			names[m.id] = cached
+			continue
+		}
+
+		name := ""
+		switch m.kind {
+		case slackUserMention:
+			name = resolveUserDisplayName(ctx, client, m.id)
+		case slackChannelMention:
+			name = resolveChannelName(ctx, client, m.id)
+		case slackUserGroupMention:
+			if userGroups == nil {
+				userGroups = fetchUserGroupHandles(ctx, client)
+			}
+			name = userGroups[m.id]
+		}
+		if name == "" {
+			if found {
+				names[m.id] = cached
+			}
+			continue
+		}
+		names[m.id] = name
+		if c.nameCache != nil && c.nameCache.Set(m.id, name, now) {
+			changed = true
+		}
+	}
+	if changed {
+		if err := c.nameCache.Save(); err != nil {
+			log.Printf("名前キャッシュ保存失敗: %v", err)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return names
+}
+
+// fillMentionNames は名前を持たないメンション（旧形式のエントリなど）を名前キャッシュから補完します。
+func (c *Channels) fillMentionNames(entries []Entry) []Entry {
+	if c.nameCache == nil {
+		return entries
+	}
+	for i := range entries {
+		entries[i].Mentions = c.cachedMentionNames(entries[i].Message, entries[i].Mentions)
+		for j := range entries[i].Revisions {
+			rev := &entries[i].Revisions[j]
+			rev.Mentions = c.cachedMentionNames(rev.Message, rev.Mentions)
+		}
+	}
+	return entries
+}
+
+func (c *Channels) cachedMentionNames(message string, names map[string]string) map[string]string {
+	now := time.Now()
+	for _, m := range parseSlackMentions(message) {
+		if names[m.id] != "" {
+			continue
+		}
+		if name, found, _ := c.cachedName(m.id, now); found {
+			if names == nil {
+				names = make(map[string]string)
+			}
+			names[m.id] = name
+		}
+	}
+	return names
+}
+
+func (c *Channels) cachedName(id string, now time.Time) (string, bool, bool) {
+	if c.nameCache == nil {
+		return "", false, false
+	}
+	return c.nameCache.Get(id, now)
+}
+
+// resolveChannelName はチャンネル名を取得します。取得できない場合は空文字を返します。
+func resolveChannelName(ctx context.Context, client slackNameClient, channelID string) string {
+	channel, err := client.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{ChannelID: channelID})
+	if err != nil {
+		log.Printf("チャンネル情報取得をスキップ: channel=%s err=%v", channelID, err)
+		return ""
+	}
+	return channel.Name
+}
+
+// fetchUserGroupHandles はユーザーグループIDからハンドル名への対応を取得します。取得できない場合は空の対応を返します。
+func fetchUserGroupHandles(ctx context.Context, client slackNameClient) map[string]string {
+	handles := make(map[string]string)
+	groups, err := client.GetUserGroupsContext(ctx)
+	if err != nil {
+		log.Printf("ユーザーグループ取得をスキップ: err=%v", err)
+		return handles
+	}
+	for _, group := range groups {
+		handles[group.ID] = firstNonEmpty(group.Handle, group.Name)
+	}
+	return handles
+}
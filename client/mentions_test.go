@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/api/drive/v3"
+)
+
+type stubSlackNameClient struct {
+	users      map[string]*slack.User
+	channels   map[string]string
+	groups     []slack.UserGroup
+	userCalls  int
+	groupCalls int
+}
+
+func (s *stubSlackNameClient) GetUserInfoContext(_ context.Context, user string) (*slack.User, error) {
+	s.userCalls++
+	if u, ok := s.users[user]; ok {
+		return u, nil
+	}
+	return nil, errors.New("user_not_found")
+}
+
+func (s *stubSlackNameClient) GetConversationInfoContext(_ context.Context, input *slack.GetConversationInfoInput) (*slack.Channel, error) {
+	name, ok := s.channels[input.ChannelID]
+	if !ok {
+		return nil, errors.New("channel_not_found")
+	}
+	ch := &slack.Channel{}
+	ch.Name = name
+	return ch, nil
+}
+
+func (s *stubSlackNameClient) GetUserGroupsContext(_ context.Context, _ ...slack.GetUserGroupsOption) ([]slack.UserGroup, error) {
+	s.groupCalls++
+	return s.groups, nil
+}
+
+func TestMrkdwnToHTML_Mentions(t *testing.T) {
+	names := map[string]string{"U1": "alice", "C1": "general", "S1": "devs"}
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "user", message: "hi <@U1>", want: "hi @alice"},
+		{name: "user with label", message: "<@U9|bob>", want: "@bob"},
+		{name: "unknown user", message: "<@U9>", want: "@U9"},
+		{name: "channel prefers resolved name", message: "<#C1|old-name>", want: "#general"},
+		{name: "channel label", message: "<#C9|random>", want: "#random"},
+		{name: "usergroup", message: "<!subteam^S1>", want: "@devs"},
+		{name: "usergroup label", message: "<!subteam^S9|@ops>", want: "@ops"},
+		{name: "here", message: "<!here> *<!channel|@channel>*", want: "@here <strong>@channel</strong>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(mrkdwnToHTML(tt.message, names, nil)); got != tt.want {
+				t.Fatalf("mrkdwnToHTML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := mrkdwnToMarkdown("<@U1> in <#C1>", names, nil); got != "@alice in #general" {
+		t.Fatalf("mrkdwnToMarkdown() = %q", got)
+	}
+}
+
+func TestChannels_resolveMentionNames(t *testing.T) {
+	baseDir := t.TempDir()
+	cache, err := newSlackNameCache(baseDir, time.Hour)
+	if err != nil {
+		t.Fatalf("newSlackNameCache() error = %v", err)
+	}
+	c := &Channels{basedir: baseDir, nameCache: cache}
+	client := &stubSlackNameClient{
+		users:    map[string]*slack.User{"U1": {Name: "alice"}},
+		channels: map[string]string{"C1": "general"},
+		groups:   []slack.UserGroup{{ID: "S1", Handle: "devs"}, {ID: "S2", Name: "Ops"}},
+	}
+
+	got := c.resolveMentionNames(context.Background(), client, "<@U1> <@U1> <#C1|general> <!subteam^S1> <!subteam^S2> <@U404>")
+	want := map[string]string{"U1": "alice", "C1": "general", "S1": "devs", "S2": "Ops"}
+	if len(got) != len(want) {
+		t.Fatalf("resolveMentionNames() = %v, want %v", got, want)
+	}
+	for id, name := range want {
+		if got[id] != name {
+			t.Fatalf("resolveMentionNames()[%s] = %q, want %q", id, got[id], name)
+		}
+	}
+	if client.userCalls != 2 || client.groupCalls != 1 {
+		t.Fatalf("userCalls = %d, groupCalls = %d, want 2, 1", client.userCalls, client.groupCalls)
+	}
+
+	// 永続化したキャッシュは再起動後も使われ、APIは呼ばれない
+	reloaded, err := newSlackNameCache(baseDir, time.Hour)
+	if err != nil {
+		t.Fatalf("newSlackNameCache(reload) error = %v", err)
+	}
+	c.nameCache = reloaded
+	client.userCalls = 0
+	if got := c.resolveMentionNames(context.Background(), client, "<@U1>"); got["U1"] != "alice" || client.userCalls != 0 {
+		t.Fatalf("resolveMentionNames(cached) = %v, userCalls = %d", got, client.userCalls)
+	}
+	if got := c.resolveMentionNames(context.Background(), client, "no mention"); got != nil {
+		t.Fatalf("resolveMentionNames(no mention) = %v, want nil", got)
+	}
+}
+
+func TestSlackNameCache_StaleEntryUsedWhenLookupFails(t *testing.T) {
+	baseDir := t.TempDir()
+	cache, err := newSlackNameCache(baseDir, time.Hour)
+	if err != nil {
+		t.Fatalf("newSlackNameCache() error = %v", err)
+	}
+	cache.Set("U1", "alice", time.Now().Add(-2*time.Hour))
+	if _, found, fresh := cache.Get("U1", time.Now()); !found || fresh {
+		t.Fatalf("Get() found = %v, fresh = %v, want true, false", found, fresh)
+	}
+
+	c := &Channels{basedir: baseDir, nameCache: cache}
+	got := c.resolveMentionNames(context.Background(), &stubSlackNameClient{}, "<@U1>")
+	if got["U1"] != "alice" {
+		t.Fatalf("resolveMentionNames() = %v, want stale name", got)
+	}
+}
+
+func TestChannels_CreateMarkdownZip_RendersMentions(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	cache, err := newSlackNameCache(baseDir, time.Hour)
+	if err != nil {
+		t.Fatalf("newSlackNameCache() error = %v", err)
+	}
+	cache.Set("U2", "bob", time.Now())
+	c := &Channels{basedir: baseDir, nameCache: cache}
+	jsonl := `{"timestamp":"1775001600.000001","message":"thanks <@U1>","mentions":{"U1":"alice"},"channel":{"id":"C1","name":"general"},"files":[]}
+{"timestamp":"1775001600.000002","message":"legacy <@U2> <#C9|random>","channel":{"id":"C1","name":"general"},"files":[]}
+`
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+
+	result, err := c.CreateMarkdownZip(context.Background(), "general", nil)
+	if err != nil {
+		t.Fatalf("CreateMarkdownZip() error = %v", err)
+	}
+	md := readZipEntry(t, result.ZipPath, "index.md")
+	for _, want := range []string{"thanks @alice", "legacy @bob #random"} {
+		if !strings.Contains(md, want) {
+			t.Fatalf("index.md does not contain %q:\n%s", want, md)
+		}
+	}
+
+	g := &GDrive{
+		htmlDir: &drive.File{Id: "html-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			return nil
+		},
+	}
+	if err := c.CreateHtmlFile(context.Background(), "general", g, nil); err != nil {
+		t.Fatalf("CreateHtmlFile() error = %v", err)
+	}
+	html, err := os.ReadFile(filepath.Join(baseDir, HtmlDir, "general.html"))
+	if err != nil {
+		t.Fatalf("read html: %v", err)
+	}
+	if !strings.Contains(string(html), "thanks @alice") || !strings.Contains(string(html), "legacy @bob #random") {
+		t.Fatalf("html does not contain resolved mentions:\n%s", html)
+	}
+}
@@ -0,0 +1,437 @@
+package client
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Slackのmrkdwn記法をHTMLとCommonMarkへ変換する。
+// Slackは本文中の & < > を &amp; &lt; &gt; にエスケープして送るため、
+// <...> はリンクやメンションのトークンとして扱い、それ以外の実体参照は出力前に復元する。
+
+type mrkdwnBlockKind int
+
+const (
+	mrkdwnParagraph mrkdwnBlockKind = iota
+	mrkdwnCodeBlock
+	mrkdwnQuote
+	mrkdwnBulletList
+	mrkdwnOrderedList
+)
+
+type mrkdwnBlock struct {
+	kind  mrkdwnBlockKind
+	lines []string
+	lang  string
+}
+
+var (
+	mrkdwnCodeLangRe   = regexp.MustCompile(`^[A-Za-z0-9_+#.-]+$`)
+	mrkdwnBulletRe     = regexp.MustCompile(`^\s*[•◦▪\-] (.*)$`)
+	mrkdwnOrderedRe    = regexp.MustCompile(`^\s*\d+[.)] (.*)$`)
+	mrkdwnTokenRe      = regexp.MustCompile(`^<([^<>\s][^<>\n]*)>`)
+	mrkdwnEmojiRe      = regexp.MustCompile(`^:([a-z0-9_+'-]+):`)
+	slackEntityReplace = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">")
+	markdownEntityRe   = regexp.MustCompile(`&([A-Za-z#])`)
+	markdownOrderedRe  = regexp.MustCompile(`^(\d+)([.)])`)
+)
+
+// mrkdwnRenderer はインライン記法の変換設定です。names はメンションのID（ユーザー・チャンネル・ユーザーグループ）から名前への対応、
+// emoji はワークスペースのカスタム絵文字です。
+type mrkdwnRenderer struct {
+	html  bool
+	names map[string]string
+	emoji map[string]customEmoji
+}
+
+// mrkdwnToHTML はmrkdwnのメッセージをHTMLへ変換する。
+func mrkdwnToHTML(message string, names map[string]string, emoji map[string]customEmoji) template.HTML {
+	r := mrkdwnRenderer{html: true, names: names, emoji: emoji}
+	var b strings.Builder
+	for _, block := range parseMrkdwnBlocks(message) {
+		switch block.kind {
+		case mrkdwnCodeBlock:
+			if block.lang != "" {
+				_, _ = fmt.Fprintf(&b, "<pre><code class=\"language-%s\">", template.HTMLEscapeString(block.lang))
+			} else {
+				b.WriteString("<pre><code>")
+			}
+			b.WriteString(template.HTMLEscapeString(strings.Join(block.lines, "\n")))
+			b.WriteString("</code></pre>")
+		case mrkdwnQuote:
+			b.WriteString("<blockquote>")
+			b.WriteString(r.joinInline(block.lines, "<br>"))
+			b.WriteString("</blockquote>")
+		case mrkdwnBulletList, mrkdwnOrderedList:
+			tag := "ul"
+			if block.kind == mrkdwnOrderedList {
+				tag = "ol"
+			}
+			_, _ = fmt.Fprintf(&b, "<%s>", tag)
+			for _, line := range block.lines {
+				_, _ = fmt.Fprintf(&b, "<li>%s</li>", r.inline(line))
+			}
+			_, _ = fmt.Fprintf(&b, "</%s>", tag)
+		default:
+			b.WriteString(r.joinInline(block.lines, "<br>"))
+		}
+	}
+	return template.HTML(b.String())
+}
+
+// mrkdwnToMarkdown はmrkdwnのメッセージをCommonMarkへ変換する。
+func mrkdwnToMarkdown(message string, names map[string]string, emoji map[string]customEmoji) string {
+	r := mrkdwnRenderer{names: names, emoji: emoji}
+	blocks := parseMrkdwnBlocks(message)
+	parts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		var b strings.Builder
+		switch block.kind {
+		case mrkdwnCodeBlock:
+			content := strings.Join(block.lines, "\n")
+			fence := markdownFence(content)
+			b.WriteString(fence)
+			b.WriteString(block.lang)
+			b.WriteString("\n")
+			b.WriteString(content)
+			b.WriteString("\n")
+			b.WriteString(fence)
+		case mrkdwnQuote:
+			for i, line := range block.lines {
+				if i > 0 {
+					b.WriteString("\\\n")
+				}
+				b.WriteString(">")
+				if line != "" {
+					b.WriteString(" ")
+					b.WriteString(r.inline(line))
+				}
+			}
+		case mrkdwnBulletList:
+			for i, line := range block.lines {
+				if i > 0 {
+					b.WriteString("\n")
+				}
+				b.WriteString("- ")
+				b.WriteString(r.inline(line))
+			}
+		case mrkdwnOrderedList:
+			for i, line := range block.lines {
+				if i > 0 {
+					b.WriteString("\n")
+				}
+				_, _ = fmt.Fprintf(&b, "%d. %s", i+1, r.inline(line))
+			}
+		default:
+			for i, line := range block.lines {
+				if i > 0 {
+					if block.lines[i-1] != "" && line != "" {
+						b.WriteString("\\")
+					}
+					b.WriteString("\n")
+				}
+				b.WriteString(r.inline(line))
+			}
+		}
+		parts = append(parts, b.String())
+	}
+
+	var out strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			// フェンスは段落を中断できるが、引用やリストの直後の段落は継続行とみなされるため空行で区切る
+			if blocks[i-1].kind == mrkdwnCodeBlock || blocks[i].kind == mrkdwnCodeBlock {
+				out.WriteString("\n")
+			} else {
+				out.WriteString("\n\n")
+			}
+		}
+		out.WriteString(part)
+	}
+	return out.String()
+}
+
+func (r mrkdwnRenderer) joinInline(lines []string, sep string) string {
+	rendered := make([]string, 0, len(lines))
+	for _, line := range lines {
+		rendered = append(rendered, r.inline(line))
+	}
+	return strings.Join(rendered, sep)
+}
+
+// parseMrkdwnBlocks はメッセージをコードブロック・引用・リスト・段落のブロックに分割する。
+func parseMrkdwnBlocks(message string) []mrkdwnBlock {
+	blocks := make([]mrkdwnBlock, 0)
+	rest := message
+	for rest != "" {
+		start := strings.Index(rest, "```")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(rest[start+3:], "```")
+		if end < 0 {
+			break
+		}
+		blocks = append(blocks, parseMrkdwnLines(strings.TrimSuffix(rest[:start], "\n"))...)
+		blocks = append(blocks, newMrkdwnCodeBlock(rest[start+3:start+3+end]))
+		rest = strings.TrimPrefix(rest[start+3+end+3:], "\n")
+	}
+	return append(blocks, parseMrkdwnLines(rest)...)
+}
+
+func newMrkdwnCodeBlock(raw string) mrkdwnBlock {
+	content := slackEntityReplace.Replace(strings.Trim(raw, "\n"))
+	block := mrkdwnBlock{kind: mrkdwnCodeBlock}
+	if first, remaining, ok := strings.Cut(content, "\n"); ok && mrkdwnCodeLangRe.MatchString(first) {
+		block.lang = first
+		content = remaining
+	}
+	block.lines = strings.Split(content, "\n")
+	return block
+}
+
+func parseMrkdwnLines(text string) []mrkdwnBlock {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	blocks := make([]mrkdwnBlock, 0)
+	for _, line := range strings.Split(text, "\n") {
+		kind, content := classifyMrkdwnLine(line)
+		if n := len(blocks); n > 0 && blocks[n-1].kind == kind {
+			blocks[n-1].lines = append(blocks[n-1].lines, content)
+			continue
+		}
+		blocks = append(blocks, mrkdwnBlock{kind: kind, lines: []string{content}})
+	}
+
+	trimmed := blocks[:0]
+	for _, block := range blocks {
+		if block.kind == mrkdwnParagraph {
+			block.lines = trimEmptyLines(block.lines)
+			if len(block.lines) == 0 {
+				continue
+			}
+		}
+		trimmed = append(trimmed, block)
+	}
+	return trimmed
+}
+
+func classifyMrkdwnLine(line string) (mrkdwnBlockKind, string) {
+	// Slackは引用の > も &gt; にエスケープして送るため、生の > は引用とみなさない
+	if quoted, ok := strings.CutPrefix(line, "&gt;"); ok {
+		return mrkdwnQuote, strings.TrimPrefix(quoted, " ")
+	}
+	if m := mrkdwnBulletRe.FindStringSubmatch(line); m != nil {
+		return mrkdwnBulletList, m[1]
+	}
+	if m := mrkdwnOrderedRe.FindStringSubmatch(line); m != nil {
+		return mrkdwnOrderedList, m[1]
+	}
+	return mrkdwnParagraph, line
+}
+
+func trimEmptyLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// inline は1行分のインライン記法（太字・斜体・取り消し線・インラインコード・<...>トークン・絵文字）を変換する。
+func (r mrkdwnRenderer) inline(text string) string {
+	var b strings.Builder
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() == 0 {
+			return
+		}
+		decoded := slackEntityReplace.Replace(literal.String())
+		if r.html {
+			b.WriteString(template.HTMLEscapeString(decoded))
+		} else {
+			b.WriteString(escapeMarkdownText(decoded, b.Len() == 0))
+		}
+		literal.Reset()
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch c {
+		case '`':
+			if end := strings.IndexByte(text[i+1:], '`'); end > 0 {
+				flush()
+				code := slackEntityReplace.Replace(text[i+1 : i+1+end])
+				if r.html {
+					_, _ = fmt.Fprintf(&b, "<code>%s</code>", template.HTMLEscapeString(code))
+				} else {
+					_, _ = fmt.Fprintf(&b, "`%s`", code)
+				}
+				i += end + 2
+				continue
+			}
+		case '<':
+			if m := mrkdwnTokenRe.FindStringSubmatch(text[i:]); m != nil {
+				flush()
+				b.WriteString(r.token(m[1]))
+				i += len(m[0])
+				continue
+			}
+		case ':':
+			if m := mrkdwnEmojiRe.FindStringSubmatch(text[i:]); m != nil {
+				if e, ok := r.emojiText(m[1]); ok {
+					flush()
+					b.WriteString(e)
+					i += len(m[0])
+					continue
+				}
+			}
+		case '*', '_', '~':
+			if end, ok := findMrkdwnClosing(text, i); ok {
+				flush()
+				inner := r.inline(text[i+1 : end])
+				b.WriteString(wrapMrkdwnFormat(c, inner, r.html))
+				i = end + 1
+				continue
+			}
+		}
+		literal.WriteByte(c)
+		i++
+	}
+	flush()
+	return b.String()
+}
+
+// findMrkdwnClosing は text[open] の書式記号に対応する閉じ記号の位置を返す。
+// Slackと同様に、開き記号の直前と閉じ記号の直後が英数字でなく、内側が空白で始まり・終わらない場合だけ書式として扱う。
+func findMrkdwnClosing(text string, open int) (int, bool) {
+	marker := text[open]
+	if open > 0 {
+		prev, _ := utf8.DecodeLastRuneInString(text[:open])
+		if isMrkdwnWordRune(prev) {
+			return 0, false
+		}
+	}
+	if open+1 >= len(text) || text[open+1] == ' ' || text[open+1] == marker {
+		return 0, false
+	}
+	for i := open + 2; i < len(text); i++ {
+		if text[i] != marker || text[i-1] == ' ' {
+			continue
+		}
+		if i+1 < len(text) {
+			next, _ := utf8.DecodeRuneInString(text[i+1:])
+			if isMrkdwnWordRune(next) {
+				continue
+			}
+		}
+		return i, true
+	}
+	return 0, false
+}
+
+func isMrkdwnWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func wrapMrkdwnFormat(marker byte, inner string, html bool) string {
+	switch marker {
+	case '*':
+		if html {
+			return "<strong>" + inner + "</strong>"
+		}
+		return "**" + inner + "**"
+	case '_':
+		if html {
+			return "<em>" + inner + "</em>"
+		}
+		return "*" + inner + "*"
+	default:
+		if html {
+			return "<del>" + inner + "</del>"
+		}
+		return "~~" + inner + "~~"
+	}
+}
+
+// token は <...> トークンの中身を変換する。メンションは @名前 / #チャンネル名 に、それ以外のトークンは記号を外した文字列として出力する。
+func (r mrkdwnRenderer) token(content string) string {
+	target, label, _ := strings.Cut(content, "|")
+	if isSlackLinkTarget(target) {
+		text := slackEntityReplace.Replace(label)
+		if strings.TrimSpace(text) == "" {
+			text = target
+		}
+		href := slackEntityReplace.Replace(target)
+		if r.html {
+			return fmt.Sprintf(
+				"<a href=\"%s\" target=\"_blank\" rel=\"noopener noreferrer\">%s</a>",
+				template.HTMLEscapeString(href),
+				template.HTMLEscapeString(text),
+			)
+		}
+		return fmt.Sprintf("[%s](<%s>)", escapeMarkdownText(text, false), strings.NewReplacer("<", "%3C", ">", "%3E").Replace(href))
+	}
+
+	text := slackEntityReplace.Replace(firstNonEmpty(slackMentionText(target, label, r.names), label, target))
+	if r.html {
+		return template.HTMLEscapeString(text)
+	}
+	return escapeMarkdownText(text, false)
+}
+
+func isSlackLinkTarget(target string) bool {
+	for _, scheme := range []string{"http://", "https://", "mailto:"} {
+		if strings.HasPrefix(target, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "~", `\~`,
+	"[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "|", `\|`,
+)
+
+// escapeMarkdownText はCommonMarkとして解釈される記号をバックスラッシュでエスケープする。
+func escapeMarkdownText(text string, lineStart bool) string {
+	escaped := markdownEscaper.Replace(text)
+	escaped = markdownEntityRe.ReplaceAllString(escaped, `\&$1`)
+	if lineStart {
+		if len(escaped) > 0 && strings.ContainsRune("#+-=", rune(escaped[0])) {
+			escaped = `\` + escaped
+		} else if m := markdownOrderedRe.FindStringSubmatchIndex(escaped); m != nil {
+			escaped = escaped[:m[3]] + `\` + escaped[m[3]:]
+		}
+	}
+	return escaped
+}
+
+func maxBacktickRun(text string) int {
+	maxTicks := 0
+	current := 0
+	for _, r := range text {
+		if r == '`' {
+			current++
+			if current > maxTicks {
+				maxTicks = current
+			}
+			continue
+		}
+		current = 0
+	}
+	return maxTicks
+}
+
+// markdownFence はコードブロック内のバッククォートと衝突しない長さのフェンスを返す。
+func markdownFence(content string) string {
+	return strings.Repeat("`", max(3, maxBacktickRun(content)+1))
+}
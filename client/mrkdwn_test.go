@@ -0,0 +1,60 @@
+package client
+
+import (
+	"testing"
+)
+
+func TestMrkdwnToHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "plain", message: "hello", want: "hello"},
+		{name: "bold italic strike", message: "*bold* _italic_ ~strike~", want: "<strong>bold</strong> <em>italic</em> <del>strike</del>"},
+		{name: "nested format", message: "*bold _italic_*", want: "<strong>bold <em>italic</em></strong>"},
+		{name: "intraword underscore", message: "snake_case_name", want: "snake_case_name"},
+		{name: "unclosed marker", message: "2 * 3 = 6", want: "2 * 3 = 6"},
+		{name: "inline code", message: "run `a *b* <c>`", want: "run <code>a *b* &lt;c&gt;</code>"},
+		{name: "code block", message: "before\n```go\nx := 1 &amp;&amp; y\n```\nafter", want: "before<pre><code class=\"language-go\">x := 1 &amp;&amp; y</code></pre>after"},
+		{name: "quote", message: "&gt; quoted\n&gt; *line*", want: "<blockquote>quoted<br><strong>line</strong></blockquote>"},
+		{name: "bullet list", message: "• one\n• two", want: "<ul><li>one</li><li>two</li></ul>"},
+		{name: "ordered list", message: "1. one\n2. two", want: "<ol><li>one</li><li>two</li></ol>"},
+		{name: "entities not double escaped", message: "a &amp; b &lt;tag&gt;", want: "a &amp; b &lt;tag&gt;"},
+		{name: "link", message: "<https://example.com?a=1&amp;b=2|*site*>", want: "<a href=\"https://example.com?a=1&amp;b=2\" target=\"_blank\" rel=\"noopener noreferrer\">*site*</a>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(mrkdwnToHTML(tt.message, nil, nil)); got != tt.want {
+				t.Fatalf("mrkdwnToHTML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMrkdwnToMarkdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "plain lines", message: "line1\nline2", want: "line1\\\nline2"},
+		{name: "bold italic strike", message: "*bold* _italic_ ~strike~", want: "**bold** *italic* ~~strike~~"},
+		{name: "intraword underscore", message: "snake_case_name", want: "snake\\_case\\_name"},
+		{name: "markdown chars escaped", message: "# not heading [x]", want: "\\# not heading \\[x\\]"},
+		{name: "inline code", message: "run `a_b`", want: "run `a_b`"},
+		{name: "code block keeps raw text", message: "```\n*not bold* &lt;x&gt;\n```", want: "```\n*not bold* <x>\n```"},
+		{name: "quote then paragraph", message: "&gt; quoted\nafter", want: "> quoted\n\nafter"},
+		{name: "bullet list", message: "- one\n- _two_", want: "- one\n- *two*"},
+		{name: "ordered list", message: "1) one\n2) two", want: "1. one\n2. two"},
+		{name: "entities decoded", message: "a &amp; b &lt; c", want: "a & b \\< c"},
+		{name: "link", message: "<https://example.com|site>", want: "[site](<https://example.com>)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mrkdwnToMarkdown(tt.message, nil, nil); got != tt.want {
+				t.Fatalf("mrkdwnToMarkdown() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
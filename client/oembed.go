@@ -0,0 +1,292 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	oembedTypePhoto = "photo"
+	oembedTypeVideo = "video"
+	oembedTypeRich  = "rich"
+
+	oembedMaxBodyBytes = 64 << 10
+)
+
+// OEmbedProvider はoEmbedのエンドポイントと、そのエンドポイントを使うURLのパターン（`*`はワイルドカード）です。
+type OEmbedProvider struct {
+	Name     string   `json:"name"`
+	Schemes  []string `json:"schemes"`
+	Endpoint string   `json:"endpoint"`
+}
+
+// defaultOEmbedProviders はページに oEmbed の link 要素がなくても oEmbed を使う組み込みのプロバイダーです。
+var defaultOEmbedProviders = []OEmbedProvider{
+	{
+		Name: "YouTube",
+		Schemes: []string{
+			"https://www.youtube.com/watch*",
+			"https://youtube.com/watch*",
+			"https://m.youtube.com/watch*",
+			"https://www.youtube.com/shorts/*",
+			"https://www.youtube.com/playlist*",
+			"https://youtu.be/*",
+		},
+		Endpoint: "https://www.youtube.com/oembed",
+	},
+	{
+		Name:     "Vimeo",
+		Schemes:  []string{"https://vimeo.com/*"},
+		Endpoint: "https://vimeo.com/api/oembed.json",
+	},
+	{
+		Name:     "Spotify",
+		Schemes:  []string{"https://open.spotify.com/*"},
+		Endpoint: "https://open.spotify.com/oembed",
+	},
+	{
+		Name:     "Speaker Deck",
+		Schemes:  []string{"https://speakerdeck.com/*"},
+		Endpoint: "https://speakerdeck.com/oembed.json",
+	},
+}
+
+type oembedProvider struct {
+	name     string
+	schemes  []*regexp.Regexp
+	endpoint string
+}
+
+// oembedResponse はoEmbedのJSONレスポンスのうち、プレビューに使う項目です。
+type oembedResponse struct {
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	AuthorURL    string `json:"author_url"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	URL          string `json:"url"`
+	HTML         string `json:"html"`
+}
+
+// linkPreviewFetcher はリンク先ページのメタデータとoEmbedからプレビューを作成します。
+type linkPreviewFetcher struct {
+	providers []oembedProvider
+	// policy はページとページから見つけたoEmbed、それらのリダイレクト先を取得する前にドメインの制限と robots.txt を確認するために使います。
+	policy *previewPolicy
+	getFn  func(ctx context.Context, rawURL string) (*http.Response, error)
+}
+
+// newLinkPreviewFetcher は providers を組み込みのプロバイダーより優先して使う fetcher を作成します。
+func newLinkPreviewFetcher(providers []OEmbedProvider, policy *previewPolicy) *linkPreviewFetcher {
+	f := &linkPreviewFetcher{policy: policy, getFn: policy.getResource}
+	for _, p := range append(append([]OEmbedProvider{}, providers...), defaultOEmbedProviders...) {
+		compiled := oembedProvider{name: p.Name, endpoint: p.Endpoint}
+		for _, scheme := range p.Schemes {
+			compiled.schemes = append(compiled.schemes, compileOEmbedScheme(scheme))
+		}
+		f.providers = append(f.providers, compiled)
+	}
+	return f
+}
+
+func compileOEmbedScheme(scheme string) *regexp.Regexp {
+	pattern := strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSpace(scheme)), `\*`, `.*`)
+	return regexp.MustCompile(`^` + pattern + `$`)
+}
+
+// validateOEmbedProvider は設定されたプロバイダーのエンドポイントとパターンを検証します。
+func validateOEmbedProvider(p OEmbedProvider) error {
+	u, err := url.Parse(p.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint must be an http(s) URL: %q", p.Endpoint)
+	}
+	if len(p.Schemes) == 0 {
+		return errors.New("schemes must be set")
+	}
+	for _, scheme := range p.Schemes {
+		if strings.TrimSpace(scheme) == "" {
+			return errors.New("schemes must not contain an empty pattern")
+		}
+	}
+	return nil
+}
+
+// endpointFor は rawURL に一致するプロバイダーのoEmbedリクエストURLを返します。
+func (f *linkPreviewFetcher) endpointFor(rawURL string) string {
+	for _, p := range f.providers {
+		for _, scheme := range p.schemes {
+			if !scheme.MatchString(rawURL) {
+				continue
+			}
+			endpoint, err := url.Parse(p.endpoint)
+			if err != nil {
+				return ""
+			}
+			q := endpoint.Query()
+			q.Set("url", rawURL)
+			q.Set("format", "json")
+			endpoint.RawQuery = q.Encode()
+			return endpoint.String()
+		}
+	}
+	return ""
+}
+
+// Fetch は rawURL のプレビューを取得します。
+// プロバイダーに一致するURLはoEmbedを優先し、失敗した場合はページを取得します（プロバイダーのエンドポイントは
+// ドメインの制限だけで判定し、robots.txt は確認しません）。ページに oEmbed の link 要素がある場合は、そのoEmbedでプレビューを補完します。
+// ページとページから見つけたoEmbedは、ドメインの制限と robots.txt で判定してから取得します。
+func (f *linkPreviewFetcher) Fetch(ctx context.Context, rawURL string) (*LinkPreview, error) {
+	if endpoint := f.endpointFor(rawURL); endpoint != "" {
+		oembed, err := f.fetchProviderOEmbed(ctx, endpoint)
+		if err == nil {
+			return mergeOEmbed(nil, oembed, rawURL, endpoint), nil
+		}
+		log.Printf("oEmbed取得をスキップ: url=%s err=%v", rawURL, err)
+	}
+
+	if err := f.policy.check(ctx, rawURL, time.Now()); err != nil {
+		return nil, err
+	}
+	resp, err := f.getFn(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, linkPreviewMaxBodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %w", err)
+	}
+	decoded, charsetName := decodePreviewBody(body, resp.Header.Get("Content-Type"))
+	preview, oembedURL, err := parseLinkPreviewFromHTML(resp.Request.URL, decoded)
+	if err != nil {
+		return nil, err
+	}
+	if oembedURL != "" {
+		oembed, err := f.fetchDiscoveredOEmbed(ctx, oembedURL)
+		if err != nil {
+			log.Printf("oEmbed取得をスキップ: url=%s err=%v", oembedURL, err)
+		} else {
+			preview = mergeOEmbed(preview, oembed, resp.Request.URL.String(), oembedURL)
+		}
+	}
+	if preview == nil {
+		return nil, errNoPreviewMetadata
+	}
+	preview.Charset = charsetName
+	return preview, nil
+}
+
+// fetchProviderOEmbed はプロバイダーのoEmbedを、ドメインの制限で判定してから取得します。
+func (f *linkPreviewFetcher) fetchProviderOEmbed(ctx context.Context, endpoint string) (*oembedResponse, error) {
+	if err := f.policy.checkDomain(endpoint); err != nil {
+		return nil, err
+	}
+	return f.fetchOEmbed(ctx, endpoint)
+}
+
+// fetchDiscoveredOEmbed はページの link 要素で見つけたoEmbedを、ドメインの制限と robots.txt で判定してから取得します。
+func (f *linkPreviewFetcher) fetchDiscoveredOEmbed(ctx context.Context, endpoint string) (*oembedResponse, error) {
+	if err := f.policy.check(ctx, endpoint, time.Now()); err != nil {
+		return nil, err
+	}
+	return f.fetchOEmbed(ctx, endpoint)
+}
+
+func (f *linkPreviewFetcher) fetchOEmbed(ctx context.Context, endpoint string) (*oembedResponse, error) {
+	resp, err := f.getFn(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var oembed oembedResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oembedMaxBodyBytes)).Decode(&oembed); err != nil {
+		return nil, fmt.Errorf("oEmbed parse failed: %w", err)
+	}
+	return &oembed, nil
+}
+
+// mergeOEmbed はページのメタデータから作った preview に oEmbed の種類・サムネイル・投稿者・埋め込みURLを追加します。
+// preview が nil の場合は oEmbed だけからプレビューを作成します。endpoint は oEmbed を取得したURLです。
+func mergeOEmbed(preview *LinkPreview, oembed *oembedResponse, pageURL, endpoint string) *LinkPreview {
+	if preview == nil {
+		preview = &LinkPreview{URL: pageURL}
+	}
+	preview.Type = strings.ToLower(strings.TrimSpace(oembed.Type))
+	preview.Title = firstNonEmpty(preview.Title, oembed.Title)
+	preview.SiteName = firstNonEmpty(preview.SiteName, oembed.ProviderName)
+	preview.AuthorName = strings.TrimSpace(oembed.AuthorName)
+	preview.AuthorURL = httpURLOrEmpty(oembed.AuthorURL)
+	preview.ThumbnailURL = httpURLOrEmpty(oembed.ThumbnailURL)
+	if preview.Type == oembedTypePhoto && preview.ImageURL == "" {
+		preview.ImageURL = httpURLOrEmpty(oembed.URL)
+	}
+	if preview.Type == oembedTypeVideo || preview.Type == oembedTypeRich {
+		preview.EmbedURL = oembedIframeSrc(oembed.HTML, endpoint)
+	}
+	return preview
+}
+
+// oembedIframeSrc はoEmbedの html に含まれる最初の iframe の src を返します。
+// 任意のHTMLは出力せず、oEmbed のエンドポイント endpoint と同じドメイン（サブドメインを含む）のHTTPSの iframe だけをビューアで埋め込みます。
+func oembedIframeSrc(fragment, endpoint string) string {
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			if token.Data != "iframe" {
+				continue
+			}
+			for _, attr := range token.Attr {
+				if strings.ToLower(attr.Key) != "src" {
+					continue
+				}
+				u, err := url.Parse(strings.TrimSpace(attr.Val))
+				if err != nil || u.Scheme != "https" || u.Host == "" || !oembedEmbedHostAllowed(u.Hostname(), endpoint) {
+					return ""
+				}
+				return u.String()
+			}
+			return ""
+		}
+	}
+}
+
+// oembedEmbedHostAllowed は host が oEmbed のエンドポイントと同じドメイン（先頭の www. を除いたドメインのサブドメインを含む）かを判定します。
+func oembedEmbedHostAllowed(host, endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	domain := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	return policyDomainMatch(strings.ToLower(host), domain)
+}
+
+func httpURLOrEmpty(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
@@ -0,0 +1,236 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"golang.org/x/text/encoding/japanese"
+	"google.golang.org/api/drive/v3"
+)
+
+// stubPreviewGetter は URL ごとに固定のレスポンスを返し、取得したURLを記録します。
+type stubPreviewGetter struct {
+	bodies       map[string]string
+	contentTypes map[string]string
+	requested    []string
+}
+
+func (s *stubPreviewGetter) get(_ context.Context, rawURL string) (*http.Response, error) {
+	s.requested = append(s.requested, rawURL)
+	body, ok := s.bodies[rawURL]
+	if !ok {
+		return nil, fmt.Errorf("unexpected status: %d", http.StatusNotFound)
+	}
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if contentType, ok := s.contentTypes[rawURL]; ok {
+		header.Set("Content-Type", contentType)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+}
+
+const testVideoOEmbed = `{"type":"video","title":"Talk","author_name":"Speaker","author_url":"https://www.youtube.com/@speaker",` +
+	`"provider_name":"YouTube","thumbnail_url":"https://i.ytimg.com/vi/abc/hqdefault.jpg",` +
+	`"html":"<iframe width=\"200\" height=\"113\" src=\"https://www.youtube.com/embed/abc?feature=oembed\"></iframe>"}`
+
+func TestLinkPreviewFetcher_Fetch_ProviderOEmbed(t *testing.T) {
+	getter := &stubPreviewGetter{bodies: map[string]string{
+		"https://www.youtube.com/oembed?format=json&url=https%3A%2F%2Fwww.youtube.com%2Fwatch%3Fv%3Dabc": testVideoOEmbed,
+	}}
+	f := newLinkPreviewFetcher(nil, nil)
+	f.getFn = getter.get
+
+	got, err := f.Fetch(context.Background(), "https://www.youtube.com/watch?v=abc")
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	want := LinkPreview{
+		URL:          "https://www.youtube.com/watch?v=abc",
+		Title:        "Talk",
+		SiteName:     "YouTube",
+		Type:         "video",
+		ThumbnailURL: "https://i.ytimg.com/vi/abc/hqdefault.jpg",
+		AuthorName:   "Speaker",
+		AuthorURL:    "https://www.youtube.com/@speaker",
+		EmbedURL:     "https://www.youtube.com/embed/abc?feature=oembed",
+	}
+	if *got != want {
+		t.Fatalf("Fetch() = %+v, want %+v", *got, want)
+	}
+	if len(getter.requested) != 1 {
+		t.Fatalf("requested = %v, want only the oEmbed endpoint", getter.requested)
+	}
+}
+
+func TestLinkPreviewFetcher_Fetch_DiscoversOEmbed(t *testing.T) {
+	page := `<html><head><title>Deck</title>
+<meta property="og:description" content="slides">
+<link rel="alternate" type="application/json+oembed" href="/oembed?url=deck">
+</head></html>`
+	getter := &stubPreviewGetter{bodies: map[string]string{
+		"https://slides.example/deck":            page,
+		"https://slides.example/oembed?url=deck": `{"type":"rich","author_name":"Alice","html":"<iframe src=\"https://slides.example/embed/deck\"></iframe>"}`,
+	}}
+	f := newLinkPreviewFetcher(nil, nil)
+	f.getFn = getter.get
+
+	got, err := f.Fetch(context.Background(), "https://slides.example/deck")
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if got.Title != "Deck" || got.Description != "slides" || got.Type != "rich" || got.AuthorName != "Alice" ||
+		got.EmbedURL != "https://slides.example/embed/deck" {
+		t.Fatalf("Fetch() = %+v", got)
+	}
+}
+
+func TestLinkPreviewFetcher_Fetch_IgnoresEmbedOnOtherHost(t *testing.T) {
+	page := `<html><head><title>Deck</title>
+<link rel="alternate" type="application/json+oembed" href="/oembed?url=deck">
+</head></html>`
+	getter := &stubPreviewGetter{bodies: map[string]string{
+		"https://slides.example/deck":            page,
+		"https://slides.example/oembed?url=deck": `{"type":"rich","html":"<iframe src=\"https://tracker.example/embed/deck\"></iframe>"}`,
+	}}
+	f := newLinkPreviewFetcher(nil, nil)
+	f.getFn = getter.get
+
+	got, err := f.Fetch(context.Background(), "https://slides.example/deck")
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if got.Title != "Deck" || got.EmbedURL != "" {
+		t.Fatalf("Fetch() = %+v, want no embed from another host", got)
+	}
+}
+
+func TestLinkPreviewFetcher_Fetch_ConfiguredProviderFallsBackToPage(t *testing.T) {
+	getter := &stubPreviewGetter{bodies: map[string]string{
+		"https://media.example/v/1": `<html><head><meta property="og:title" content="Page Title"></head></html>`,
+	}}
+	f := newLinkPreviewFetcher([]OEmbedProvider{{
+		Name:     "Media",
+		Schemes:  []string{"https://media.example/v/*"},
+		Endpoint: "https://media.example/oembed",
+	}}, nil)
+	f.getFn = getter.get
+
+	got, err := f.Fetch(context.Background(), "https://media.example/v/1")
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if got.Title != "Page Title" || got.Type != "" {
+		t.Fatalf("Fetch() = %+v, want page preview", got)
+	}
+	wantRequested := []string{"https://media.example/oembed?format=json&url=https%3A%2F%2Fmedia.example%2Fv%2F1", "https://media.example/v/1"}
+	if strings.Join(getter.requested, " ") != strings.Join(wantRequested, " ") {
+		t.Fatalf("requested = %v, want %v", getter.requested, wantRequested)
+	}
+}
+
+func TestOEmbedIframeSrc(t *testing.T) {
+	tests := []struct {
+		fragment string
+		want     string
+	}{
+		{fragment: `<iframe src="https://player.example/embed/1"></iframe>`, want: "https://player.example/embed/1"},
+		{fragment: `<blockquote>quote</blockquote><iframe src="http://player.example/embed/1"></iframe>`, want: ""},
+		{fragment: `<iframe src="javascript:alert(1)"></iframe>`, want: ""},
+		{fragment: `<script src="https://player.example/widget.js"></script>`, want: ""},
+		{fragment: `<iframe src="https://evil.example/embed/1"></iframe>`, want: ""},
+	}
+	for _, tt := range tests {
+		if got := oembedIframeSrc(tt.fragment, "https://www.player.example/oembed"); got != tt.want {
+			t.Errorf("oembedIframeSrc(%q) = %q, want %q", tt.fragment, got, tt.want)
+		}
+	}
+}
+
+func TestConfig_validate_OEmbedProviders(t *testing.T) {
+	c := Config{AppToken: "xapp-1", BotToken: "xoxb-1", BaseDir: "/tmp", AuthorID: "U1"}
+	c.LinkPreviewOEmbedProviders = []OEmbedProvider{{Schemes: []string{"https://a.example/*"}, Endpoint: "https://a.example/oembed"}}
+	if err := c.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+	c.LinkPreviewOEmbedProviders = []OEmbedProvider{{Schemes: []string{"https://a.example/*"}, Endpoint: "ftp://a.example"}, {Endpoint: "https://b.example"}}
+	err := c.validate()
+	if err == nil || !strings.Contains(err.Error(), "link_preview_oembed_providers[0]") || !strings.Contains(err.Error(), "link_preview_oembed_providers[1]") {
+		t.Fatalf("validate() error = %v, want provider errors", err)
+	}
+}
+
+func TestCreateHtmlFile_RendersOEmbedCard(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	jsonl := `{"timestamp":"1775088000.000000","message":"<https://www.youtube.com/watch?v=abc>","channel":{"id":"C1","name":"general"},"files":[],"unfurls":[` +
+		`{"url":"https://www.youtube.com/watch?v=abc","title":"Talk","type":"video","author_name":"Speaker","embed_url":"https://www.youtube.com/embed/abc"}]}` + "\n" +
+		`{"timestamp":"1775088001.000000","message":"<https://vimeo.com/1>","channel":{"id":"C1","name":"general"},"files":[],"unfurls":[` +
+		`{"url":"https://vimeo.com/1","title":"Clip","type":"video","thumbnail_url":"https://i.vimeocdn.com/1.jpg"}]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	g := &GDrive{
+		htmlDir: &drive.File{Id: "html-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			return nil
+		},
+	}
+
+	if err := c.CreateHtmlFile(context.Background(), "general", g, nil); err != nil {
+		t.Fatalf("CreateHtmlFile() error = %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(baseDir, "html", "general.html"))
+	if err != nil {
+		t.Fatalf("read html: %v", err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		`<iframe src="https://www.youtube.com/embed/abc" title="Talk" class="aspect-video mb-2 w-full rounded"`,
+		`by Speaker`,
+		`<img src="https://i.vimeocdn.com/1.jpg"`,
+		`▶`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("CreateHtmlFile() output missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestLinkPreviewFetcher_Fetch_RecordsCharset(t *testing.T) {
+	sjis, err := japanese.ShiftJIS.NewEncoder().String("<html><head><title>ニュース</title></head></html>")
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	f := newLinkPreviewFetcher(nil, nil)
+	f.getFn = func(_ context.Context, rawURL string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		header := http.Header{"Content-Type": []string{"text/html; charset=Shift_JIS"}}
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(sjis)), Request: req}, nil
+	}
+
+	got, err := f.Fetch(context.Background(), "https://news.example.jp/1")
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if got.Title != "ニュース" || got.Charset != "shift_jis" {
+		t.Fatalf("Fetch() = %+v, want decoded title and charset", got)
+	}
+}
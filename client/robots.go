@@ -0,0 +1,286 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	robotsCacheFileName = "robots_cache.json"
+	robotsCacheVersion  = 1
+	// robotsRulesTTL は取得した robots.txt のルールを使い続ける期間です。
+	robotsRulesTTL = 24 * time.Hour
+	// robotsUnreachableTTL は robots.txt を取得できなかったホストへの取得を控える期間です。
+	robotsUnreachableTTL = time.Hour
+	robotsMaxBodyBytes   = 512 << 10
+
+	robotsStatusOK          = "ok"
+	robotsStatusMissing     = "missing"
+	robotsStatusUnreachable = "unreachable"
+)
+
+// robotsRule は robots.txt の Allow/Disallow の1行です。
+type robotsRule struct {
+	Allow bool   `json:"allow"`
+	Path  string `json:"path"`
+}
+
+func (r robotsRule) String() string {
+	if r.Allow {
+		return "Allow: " + r.Path
+	}
+	return "Disallow: " + r.Path
+}
+
+type robotsCacheEntry struct {
+	Status    string       `json:"status"`
+	Rules     []robotsRule `json:"rules,omitempty"`
+	FetchedAt time.Time    `json:"fetched_at"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+type robotsCacheFile struct {
+	Version int                         `json:"version"`
+	Entries map[string]robotsCacheEntry `json:"entries"`
+}
+
+// robotsCache はホストごとに、リンクプレビューのUser-Agentに適用される robots.txt のルールを
+// cache/robots_cache.json にキャッシュします。
+type robotsCache struct {
+	filePath string
+	getFn    func(ctx context.Context, rawURL string) (*http.Response, error)
+
+	mu      sync.Mutex
+	entries map[string]robotsCacheEntry
+}
+
+func newRobotsCache(baseDir string) (*robotsCache, error) {
+	c := &robotsCache{
+		filePath: filepath.Join(baseDir, "cache", robotsCacheFileName),
+		getFn:    getPreviewResource,
+		entries:  map[string]robotsCacheEntry{},
+	}
+	b, err := os.ReadFile(c.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return c, nil
+		}
+		return nil, fmt.Errorf("robots.txtキャッシュ読込失敗: %w", err)
+	}
+	var disk robotsCacheFile
+	if err := json.Unmarshal(b, &disk); err != nil {
+		brokenPath := fmt.Sprintf("%s.broken.%s", c.filePath, time.Now().UTC().Format("20060102150405"))
+		if renameErr := os.Rename(c.filePath, brokenPath); renameErr != nil {
+			log.Printf("破損キャッシュの退避失敗: file=%s err=%v", c.filePath, renameErr)
+		} else {
+			log.Printf("破損したrobots.txtキャッシュを退避: src=%s dst=%s err=%v", c.filePath, brokenPath, err)
+		}
+		return c, nil
+	}
+	now := time.Now()
+	for origin, entry := range disk.Entries {
+		if now.After(entry.ExpiresAt) {
+			continue
+		}
+		c.entries[origin] = entry
+	}
+	return c, nil
+}
+
+// Check は rawURL をリンクプレビューのUser-Agentで取得してよいかを robots.txt で判定します。
+// 取得が禁止されている場合は、判定に使ったルールを含む *previewPolicyError を返します。
+func (c *robotsCache) Check(ctx context.Context, rawURL string, now time.Time) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	if u.EscapedPath() == "/robots.txt" {
+		return nil
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	entry, err := c.entry(ctx, origin, now)
+	if err != nil {
+		return err
+	}
+	switch entry.Status {
+	case robotsStatusUnreachable:
+		return &previewPolicyError{Class: previewErrorRobots, Reason: "robots.txt is unreachable"}
+	case robotsStatusMissing:
+		return nil
+	}
+	target := u.EscapedPath()
+	if target == "" {
+		target = "/"
+	}
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+	if rule, ok := matchRobotsRules(entry.Rules, target); ok && !rule.Allow {
+		return &previewPolicyError{Class: previewErrorRobots, Reason: fmt.Sprintf("disallowed by robots.txt (%s)", rule)}
+	}
+	return nil
+}
+
+func (c *robotsCache) entry(ctx context.Context, origin string, now time.Time) (robotsCacheEntry, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[origin]
+	c.mu.Unlock()
+	if ok && now.Before(entry.ExpiresAt) {
+		return entry, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return robotsCacheEntry{}, err
+	}
+
+	entry = c.fetch(ctx, origin, now.UTC())
+	if ctx.Err() != nil {
+		// 制限時間切れで取得できなかった場合は記録しない
+		return robotsCacheEntry{}, ctx.Err()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[origin] = entry
+	if err := c.saveLocked(); err != nil {
+		log.Printf("robots.txtキャッシュ保存失敗: %v", err)
+	}
+	return entry, nil
+}
+
+// fetch は origin の robots.txt を取得します。
+// 4xx は robots.txt がないものとして全て許可し、5xx や接続できない場合はしばらく取得を控えます。
+func (c *robotsCache) fetch(ctx context.Context, origin string, now time.Time) robotsCacheEntry {
+	resp, err := c.getFn(ctx, origin+"/robots.txt")
+	if err != nil {
+		var statusErr *previewStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode >= 400 && statusErr.StatusCode < 500 {
+			return robotsCacheEntry{Status: robotsStatusMissing, FetchedAt: now, ExpiresAt: now.Add(robotsRulesTTL)}
+		}
+		log.Printf("robots.txt取得失敗: origin=%s err=%v", origin, err)
+		return robotsCacheEntry{Status: robotsStatusUnreachable, FetchedAt: now, ExpiresAt: now.Add(robotsUnreachableTTL)}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	rules := parseRobotsTxt(io.LimitReader(resp.Body, robotsMaxBodyBytes), robotsProductToken())
+	return robotsCacheEntry{Status: robotsStatusOK, Rules: rules, FetchedAt: now, ExpiresAt: now.Add(robotsRulesTTL)}
+}
+
+func (c *robotsCache) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(c.filePath), os.ModePerm); err != nil {
+		return fmt.Errorf("robots.txtキャッシュディレクトリ作成失敗: %w", err)
+	}
+	out, err := json.MarshalIndent(robotsCacheFile{Version: robotsCacheVersion, Entries: c.entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("robots.txtキャッシュJSON化失敗: %w", err)
+	}
+	return writeFileAtomic(c.filePath, out)
+}
+
+// robotsProductToken は robots.txt の User-agent と照合するプロダクトトークンです。
+func robotsProductToken() string {
+	token, _, _ := strings.Cut(linkPreviewUserAgentName, "/")
+	return strings.ToLower(token)
+}
+
+// parseRobotsTxt は robots.txt から productToken に適用されるルールを返します（RFC 9309）。
+// productToken に一致するグループがない場合は `User-agent: *` のグループを使います。
+func parseRobotsTxt(r io.Reader, productToken string) []robotsRule {
+	var matched, wildcard []robotsRule
+	foundMatched := false
+	var agents []string
+	inRules := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if inRules {
+				agents = nil
+				inRules = false
+			}
+			agent := strings.ToLower(value)
+			agents = append(agents, agent)
+			if agent == productToken {
+				foundMatched = true
+			}
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue
+			}
+			rule := robotsRule{Allow: key == "allow", Path: value}
+			for _, agent := range agents {
+				switch agent {
+				case productToken:
+					matched = append(matched, rule)
+				case "*":
+					wildcard = append(wildcard, rule)
+				}
+			}
+		}
+	}
+	if foundMatched {
+		return matched
+	}
+	return wildcard
+}
+
+// matchRobotsRules は target に一致するルールのうち最も長いもの（同じ長さなら Allow）を返します。
+func matchRobotsRules(rules []robotsRule, target string) (robotsRule, bool) {
+	var best robotsRule
+	found := false
+	for _, rule := range rules {
+		if !robotsPatternMatch(rule.Path, target) {
+			continue
+		}
+		if !found || len(rule.Path) > len(best.Path) || (len(rule.Path) == len(best.Path) && rule.Allow) {
+			best = rule
+			found = true
+		}
+	}
+	return best, found
+}
+
+// robotsPatternMatch は `*`（任意の文字列）と末尾の `$`（パスの終わり）を含むパターンで前方一致を判定します。
+func robotsPatternMatch(pattern, target string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(target, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for _, part := range parts[1:] {
+		i := strings.Index(target[pos:], part)
+		if i < 0 {
+			return false
+		}
+		pos += i + len(part)
+	}
+	if !anchored {
+		return true
+	}
+	if len(parts) > 1 {
+		return strings.HasSuffix(target, parts[len(parts)-1])
+	}
+	return pos == len(target)
+}
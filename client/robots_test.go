@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRobotsTxt = `# comment
+User-agent: *
+Disallow: /private
+Disallow: /*.pdf$
+
+User-agent: otherbot
+User-agent: Happeninghound-Link-Preview
+Disallow: /drafts/
+Allow: /drafts/public
+Disallow: /search?q=
+`
+
+func TestParseRobotsTxt(t *testing.T) {
+	rules := parseRobotsTxt(strings.NewReader(testRobotsTxt), robotsProductToken())
+	want := []robotsRule{{Path: "/drafts/"}, {Allow: true, Path: "/drafts/public"}, {Path: "/search?q="}}
+	if len(rules) != len(want) {
+		t.Fatalf("parseRobotsTxt() = %+v, want %+v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Fatalf("parseRobotsTxt()[%d] = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+
+	wildcard := parseRobotsTxt(strings.NewReader(testRobotsTxt), "somebot")
+	if len(wildcard) != 2 || wildcard[0].Path != "/private" {
+		t.Fatalf("parseRobotsTxt(somebot) = %+v, want the * group", wildcard)
+	}
+}
+
+func TestMatchRobotsRules(t *testing.T) {
+	rules := []robotsRule{{Path: "/drafts/"}, {Allow: true, Path: "/drafts/public"}, {Path: "/*.pdf$"}, {Allow: true, Path: "/"}}
+	tests := []struct {
+		target    string
+		wantAllow bool
+	}{
+		{target: "/drafts/1", wantAllow: false},
+		{target: "/drafts/public/1", wantAllow: true},
+		{target: "/files/a.pdf", wantAllow: false},
+		{target: "/files/a.pdf?download=1", wantAllow: true},
+		{target: "/index.html", wantAllow: true},
+	}
+	for _, tt := range tests {
+		rule, ok := matchRobotsRules(rules, tt.target)
+		if !ok || rule.Allow != tt.wantAllow {
+			t.Errorf("matchRobotsRules(%q) = (%+v, %v), want allow=%v", tt.target, rule, ok, tt.wantAllow)
+		}
+	}
+}
+
+func TestRobotsCache_Check(t *testing.T) {
+	baseDir := t.TempDir()
+	cache, err := newRobotsCache(baseDir)
+	if err != nil {
+		t.Fatalf("newRobotsCache() error = %v", err)
+	}
+	getter := &stubPreviewGetter{bodies: map[string]string{"https://blog.example/robots.txt": testRobotsTxt}}
+	cache.getFn = func(ctx context.Context, rawURL string) (*http.Response, error) {
+		switch rawURL {
+		case "https://nofile.example/robots.txt":
+			getter.requested = append(getter.requested, rawURL)
+			return nil, &previewStatusError{StatusCode: http.StatusNotFound}
+		case "https://down.example/robots.txt":
+			getter.requested = append(getter.requested, rawURL)
+			return nil, &previewStatusError{StatusCode: http.StatusServiceUnavailable}
+		}
+		return getter.get(ctx, rawURL)
+	}
+	now := time.Now()
+
+	err = cache.Check(context.Background(), "https://blog.example/drafts/1", now)
+	if err == nil || classifyPreviewError(err) != previewErrorRobots || err.Error() != "disallowed by robots.txt (Disallow: /drafts/)" {
+		t.Fatalf("Check(drafts) error = %v, want robots disallow", err)
+	}
+	if err := cache.Check(context.Background(), "https://blog.example/drafts/public", now); err != nil {
+		t.Fatalf("Check(public) error = %v, want nil", err)
+	}
+	if err := cache.Check(context.Background(), "https://nofile.example/a", now); err != nil {
+		t.Fatalf("Check(missing robots.txt) error = %v, want nil", err)
+	}
+	if err := cache.Check(context.Background(), "https://down.example/a", now); err == nil || err.Error() != "robots.txt is unreachable" {
+		t.Fatalf("Check(unreachable) error = %v", err)
+	}
+	if len(getter.requested) != 3 {
+		t.Fatalf("requested = %v, want robots.txt fetched once per host", getter.requested)
+	}
+
+	// 保存したルールは再起動後も使い、期限内は取得し直さない
+	reloaded, err := newRobotsCache(baseDir)
+	if err != nil {
+		t.Fatalf("newRobotsCache(reload) error = %v", err)
+	}
+	reloaded.getFn = func(_ context.Context, rawURL string) (*http.Response, error) {
+		t.Fatalf("robots.txt should be cached: %s", rawURL)
+		return nil, nil
+	}
+	if err := reloaded.Check(context.Background(), "https://blog.example/drafts/2", now); err == nil {
+		t.Fatal("Check() after reload error = nil, want robots disallow")
+	}
+	if entry := reloaded.entries["https://down.example"]; !entry.ExpiresAt.Equal(entry.FetchedAt.Add(robotsUnreachableTTL)) {
+		t.Fatalf("unreachable entry = %+v, want short TTL", entry)
+	}
+}
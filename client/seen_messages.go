@@ -0,0 +1,261 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSeenMessageLimit = 10000
+	seenMessageFileName     = "seen_messages.jsonl"
+	// seenMessageLegacyFileName は全件を1つのJSONで保存していた旧形式のファイルです。
+	seenMessageLegacyFileName = "seen_messages.json"
+)
+
+// seenMessageSet は取り込み済みメッセージの「チャンネルID/ts」を base_dir 配下に保存し、
+// Socket Modeの再送や再接続時のリプレイで同じメッセージを二重に記録しないようにします。
+// 件数が上限を超えた場合は古いものから削除します。
+// ファイルには登録・取り消しを1行ずつ追記し、行数が上限の2倍を超えた時点で現在の一覧に書き直します。
+type seenMessageSet struct {
+	filePath string
+	limit    int
+
+	mu    sync.Mutex
+	keys  []string
+	index map[string]bool
+	// lines はファイルの行数です。
+	lines int
+}
+
+// seenMessageRecord はファイルの1行です。Forget が true の行は登録の取り消しです。
+type seenMessageRecord struct {
+	Key    string `json:"key"`
+	Forget bool   `json:"forget,omitempty"`
+}
+
+type seenMessageLegacyFile struct {
+	Version int      `json:"version"`
+	Keys    []string `json:"keys"`
+}
+
+func newSeenMessageSet(baseDir string, limit int) (*seenMessageSet, error) {
+	if limit <= 0 {
+		limit = defaultSeenMessageLimit
+	}
+	s := &seenMessageSet{
+		filePath: filepath.Join(baseDir, "cache", seenMessageFileName),
+		limit:    limit,
+		keys:     make([]string, 0),
+		index:    map[string]bool{},
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func seenMessageKey(channelID, timestamp string) string {
+	return channelID + "/" + timestamp
+}
+
+// MarkIfNew は未登録の場合に登録して true を返します。登録済み（重複）の場合は false を返します。
+func (s *seenMessageSet) MarkIfNew(channelID, timestamp string) bool {
+	key := seenMessageKey(channelID, timestamp)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.index[key] {
+		return false
+	}
+	s.index[key] = true
+	s.keys = append(s.keys, key)
+	s.pruneToLimitLocked()
+	if err := s.appendLocked(seenMessageRecord{Key: key}); err != nil {
+		log.Printf("取り込み済みメッセージ一覧の保存失敗: %v", err)
+	}
+	return true
+}
+
+// Forget は登録を取り消します。取り込みに失敗したメッセージを再送で取り込めるようにするために使います。
+func (s *seenMessageSet) Forget(channelID, timestamp string) {
+	key := seenMessageKey(channelID, timestamp)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.index[key] {
+		return
+	}
+	delete(s.index, key)
+	for i, k := range s.keys {
+		if k == key {
+			s.keys = append(s.keys[:i], s.keys[i+1:]...)
+			break
+		}
+	}
+	if err := s.appendLocked(seenMessageRecord{Key: key, Forget: true}); err != nil {
+		log.Printf("取り込み済みメッセージ一覧の保存失敗: %v", err)
+	}
+}
+
+func (s *seenMessageSet) load() error {
+	f, err := os.Open(s.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s.migrateLegacyFile()
+		}
+		return fmt.Errorf("取り込み済みメッセージ一覧読込失敗: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s.lines++
+		var record seenMessageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Key == "" {
+			// 追記の途中で終了した場合などの壊れた行は読み飛ばす
+			log.Printf("取り込み済みメッセージ一覧の不正な行をスキップ: file=%s line=%d", s.filePath, s.lines)
+			continue
+		}
+		s.applyLocked(record)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("取り込み済みメッセージ一覧読込失敗: %w", err)
+	}
+	s.pruneToLimitLocked()
+	return nil
+}
+
+func (s *seenMessageSet) applyLocked(record seenMessageRecord) {
+	if record.Forget {
+		if !s.index[record.Key] {
+			return
+		}
+		delete(s.index, record.Key)
+		for i, k := range s.keys {
+			if k == record.Key {
+				s.keys = append(s.keys[:i], s.keys[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+	if s.index[record.Key] {
+		return
+	}
+	s.index[record.Key] = true
+	s.keys = append(s.keys, record.Key)
+}
+
+// migrateLegacyFile は旧形式の seen_messages.json を読み込み、新しい形式で書き直します。
+func (s *seenMessageSet) migrateLegacyFile() error {
+	legacyPath := filepath.Join(filepath.Dir(s.filePath), seenMessageLegacyFileName)
+	b, err := os.ReadFile(legacyPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("取り込み済みメッセージ一覧読込失敗: %w", err)
+	}
+
+	var disk seenMessageLegacyFile
+	if err := json.Unmarshal(b, &disk); err != nil {
+		s.handleCorruptedFile(legacyPath, err)
+		return nil
+	}
+	for _, key := range disk.Keys {
+		s.applyLocked(seenMessageRecord{Key: key})
+	}
+	s.pruneToLimitLocked()
+	if err := s.compactLocked(); err != nil {
+		return err
+	}
+	if err := os.Remove(legacyPath); err != nil {
+		log.Printf("旧形式の取り込み済みメッセージ一覧の削除失敗: file=%s err=%v", legacyPath, err)
+	}
+	return nil
+}
+
+func (s *seenMessageSet) handleCorruptedFile(filePath string, parseErr error) {
+	brokenPath := fmt.Sprintf("%s.broken.%s", filePath, time.Now().UTC().Format("20060102150405"))
+	if err := os.Rename(filePath, brokenPath); err != nil {
+		log.Printf("破損ファイルの退避失敗: file=%s err=%v", filePath, err)
+		return
+	}
+	log.Printf("破損した取り込み済みメッセージ一覧を退避: src=%s dst=%s err=%v", filePath, brokenPath, parseErr)
+}
+
+// appendLocked は record をファイルに1行追記します。
+// 取り消しや上限超過で不要になった行が溜まった場合は、現在の一覧で書き直します。
+func (s *seenMessageSet) appendLocked(record seenMessageRecord) error {
+	if s.lines+1 > 2*s.limit {
+		return s.compactLocked()
+	}
+	if err := os.MkdirAll(filepath.Dir(s.filePath), os.ModePerm); err != nil {
+		return fmt.Errorf("取り込み済みメッセージ一覧ディレクトリ作成失敗: %w", err)
+	}
+	out, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("取り込み済みメッセージ一覧JSON化失敗: %w", err)
+	}
+	f, err := os.OpenFile(s.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("ファイル %s のオープンに失敗： %w", s.filePath, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if _, err := fmt.Fprintf(f, "%s\n", out); err != nil {
+		return fmt.Errorf("ファイル %s への追記に失敗： %w", s.filePath, err)
+	}
+	s.lines++
+	return nil
+}
+
+// compactLocked は現在の一覧だけでファイルを書き直します。
+func (s *seenMessageSet) compactLocked() error {
+	if err := os.MkdirAll(filepath.Dir(s.filePath), os.ModePerm); err != nil {
+		return fmt.Errorf("取り込み済みメッセージ一覧ディレクトリ作成失敗: %w", err)
+	}
+	lines := make([]string, 0, len(s.keys))
+	for _, key := range s.keys {
+		out, err := json.Marshal(seenMessageRecord{Key: key})
+		if err != nil {
+			return fmt.Errorf("取り込み済みメッセージ一覧JSON化失敗: %w", err)
+		}
+		lines = append(lines, string(out))
+	}
+	if len(lines) == 0 {
+		if err := os.Remove(s.filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("ファイル %s の削除に失敗： %w", s.filePath, err)
+		}
+		s.lines = 0
+		return nil
+	}
+	if err := writeLines(s.filePath, lines); err != nil {
+		return err
+	}
+	s.lines = len(lines)
+	return nil
+}
+
+func (s *seenMessageSet) pruneToLimitLocked() {
+	if len(s.keys) <= s.limit {
+		return
+	}
+	removeCount := len(s.keys) - s.limit
+	for _, key := range s.keys[:removeCount] {
+		delete(s.index, key)
+	}
+	s.keys = append([]string(nil), s.keys[removeCount:]...)
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSeenMessageSet_MarkIfNew(t *testing.T) {
+	baseDir := t.TempDir()
+	s, err := newSeenMessageSet(baseDir, 2)
+	if err != nil {
+		t.Fatalf("newSeenMessageSet() error = %v", err)
+	}
+	if !s.MarkIfNew("C1", "1.0") {
+		t.Fatal("MarkIfNew() first = false, want true")
+	}
+	if s.MarkIfNew("C1", "1.0") {
+		t.Fatal("MarkIfNew() redelivery = true, want false")
+	}
+	if !s.MarkIfNew("C2", "1.0") {
+		t.Fatal("MarkIfNew() other channel = false, want true")
+	}
+
+	s.Forget("C2", "1.0")
+	if !s.MarkIfNew("C2", "1.0") {
+		t.Fatal("MarkIfNew() after Forget = false, want true")
+	}
+
+	// 上限を超えると古いものから消える
+	if !s.MarkIfNew("C1", "2.0") {
+		t.Fatal("MarkIfNew() new = false, want true")
+	}
+	reloaded, err := newSeenMessageSet(baseDir, 2)
+	if err != nil {
+		t.Fatalf("newSeenMessageSet(reload) error = %v", err)
+	}
+	if reloaded.MarkIfNew("C2", "1.0") || reloaded.MarkIfNew("C1", "2.0") {
+		t.Fatal("reloaded set should keep the newest keys")
+	}
+	if !reloaded.MarkIfNew("C1", "1.0") {
+		t.Fatal("oldest key should have been pruned")
+	}
+}
+
+func TestSeenMessageSet_AppendsAndCompacts(t *testing.T) {
+	baseDir := t.TempDir()
+	s, err := newSeenMessageSet(baseDir, 3)
+	if err != nil {
+		t.Fatalf("newSeenMessageSet() error = %v", err)
+	}
+	readLines := func() []string {
+		b, err := os.ReadFile(filepath.Join(baseDir, "cache", seenMessageFileName))
+		if err != nil {
+			t.Fatalf("read seen messages: %v", err)
+		}
+		return strings.Split(strings.TrimSpace(string(b)), "\n")
+	}
+
+	s.MarkIfNew("C1", "1.0")
+	s.MarkIfNew("C1", "2.0")
+	s.Forget("C1", "2.0")
+	if got := readLines(); len(got) != 3 || got[2] != `{"key":"C1/2.0","forget":true}` {
+		t.Fatalf("lines = %v, want one appended line per change", got)
+	}
+
+	// 行数が上限の2倍を超えると現在の一覧で書き直す
+	for _, ts := range []string{"3.0", "4.0", "5.0", "6.0"} {
+		s.MarkIfNew("C1", ts)
+	}
+	if got := readLines(); strings.Join(got, ",") != `{"key":"C1/4.0"},{"key":"C1/5.0"},{"key":"C1/6.0"}` {
+		t.Fatalf("lines after compaction = %v", got)
+	}
+	reloaded, err := newSeenMessageSet(baseDir, 3)
+	if err != nil {
+		t.Fatalf("newSeenMessageSet(reload) error = %v", err)
+	}
+	if reloaded.MarkIfNew("C1", "6.0") || !reloaded.MarkIfNew("C1", "2.0") {
+		t.Fatal("reloaded set should keep marks and forgets")
+	}
+}
+
+func TestSeenMessageSet_MigratesLegacyFile(t *testing.T) {
+	baseDir := t.TempDir()
+	legacyPath := filepath.Join(baseDir, "cache", seenMessageLegacyFileName)
+	if err := os.MkdirAll(filepath.Dir(legacyPath), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(legacyPath, []byte(`{"version":1,"keys":["C1/1.0","C1/2.0"]}`), 0644); err != nil {
+		t.Fatalf("write legacy file: %v", err)
+	}
+
+	s, err := newSeenMessageSet(baseDir, 10)
+	if err != nil {
+		t.Fatalf("newSeenMessageSet() error = %v", err)
+	}
+	if s.MarkIfNew("C1", "2.0") {
+		t.Fatal("legacy keys should be loaded")
+	}
+	if _, err := os.Stat(legacyPath); !os.IsNotExist(err) {
+		t.Fatalf("legacy file should be removed: %v", err)
+	}
+	reloaded, err := newSeenMessageSet(baseDir, 10)
+	if err != nil {
+		t.Fatalf("newSeenMessageSet(reload) error = %v", err)
+	}
+	if reloaded.MarkIfNew("C1", "1.0") {
+		t.Fatal("migrated keys should be saved in the new file")
+	}
+}
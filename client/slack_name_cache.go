@@ -0,0 +1,147 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSlackNameCacheTTL = 24 * time.Hour
+	slackNameCacheFileName   = "slack_name_cache.json"
+	slackNameCacheVersion    = 1
+)
+
+// slackNameCache はユーザー・チャンネル・ユーザーグループのIDから名前への対応を base_dir 配下に保存するキャッシュです。
+// 有効期限切れのエントリも、Slack APIで名前を取得できなかった場合の代替として保持します。
+type slackNameCache struct {
+	filePath string
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]slackNameCacheEntry
+}
+
+type slackNameCacheFile struct {
+	Version int                            `json:"version"`
+	Entries map[string]slackNameCacheEntry `json:"entries"`
+}
+
+type slackNameCacheEntry struct {
+	Name      string    `json:"name"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+func newSlackNameCache(baseDir string, ttl time.Duration) (*slackNameCache, error) {
+	if ttl <= 0 {
+		ttl = defaultSlackNameCacheTTL
+	}
+
+	c := &slackNameCache{
+		filePath: filepath.Join(baseDir, "cache", slackNameCacheFileName),
+		ttl:      ttl,
+		entries:  map[string]slackNameCacheEntry{},
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get は id の名前を返します。2つ目の戻り値はエントリの有無、3つ目は有効期限内かどうかです。
+func (c *slackNameCache) Get(id string, now time.Time) (string, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok {
+		return "", false, false
+	}
+	return entry.Name, true, !now.After(entry.FetchedAt.Add(c.ttl))
+}
+
+func (c *slackNameCache) Set(id, name string, now time.Time) bool {
+	if id == "" || name == "" {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[id] = slackNameCacheEntry{
+		Name:      name,
+		FetchedAt: now.UTC(),
+	}
+	return true
+}
+
+func (c *slackNameCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.saveLocked()
+}
+
+func (c *slackNameCache) load() error {
+	b, err := os.ReadFile(c.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("名前キャッシュ読込失敗: %w", err)
+	}
+
+	var disk slackNameCacheFile
+	if err := json.Unmarshal(b, &disk); err != nil {
+		c.handleCorruptedCacheFile(err)
+		return nil
+	}
+	for id, entry := range disk.Entries {
+		if entry.Name == "" {
+			continue
+		}
+		c.entries[id] = entry
+	}
+	return nil
+}
+
+func (c *slackNameCache) handleCorruptedCacheFile(parseErr error) {
+	brokenPath := fmt.Sprintf("%s.broken.%s", c.filePath, time.Now().UTC().Format("20060102150405"))
+	if err := os.Rename(c.filePath, brokenPath); err != nil {
+		log.Printf("破損キャッシュの退避失敗: file=%s err=%v", c.filePath, err)
+		return
+	}
+	log.Printf("破損した名前キャッシュを退避: src=%s dst=%s err=%v", c.filePath, brokenPath, parseErr)
+}
+
+func (c *slackNameCache) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(c.filePath), os.ModePerm); err != nil {
+		return fmt.Errorf("名前キャッシュディレクトリ作成失敗: %w", err)
+	}
+	disk := slackNameCacheFile{
+		Version: slackNameCacheVersion,
+		Entries: c.entries,
+	}
+	out, err := json.MarshalIndent(disk, "", "  ")
+	if err != nil {
+		return fmt.Errorf("名前キャッシュJSON化失敗: %w", err)
+	}
+
+	tmpPath := c.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, out, 0644); err != nil {
+		return fmt.Errorf("名前キャッシュ一時保存失敗: %w", err)
+	}
+	if err := os.Rename(tmpPath, c.filePath); err != nil {
+		if removeErr := os.Remove(c.filePath); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			return fmt.Errorf("名前キャッシュ置換前の既存ファイル削除失敗: %w", removeErr)
+		}
+		if retryErr := os.Rename(tmpPath, c.filePath); retryErr != nil {
+			return fmt.Errorf("名前キャッシュ置換失敗: %w", retryErr)
+		}
+	}
+	return nil
+}
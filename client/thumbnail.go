@@ -0,0 +1,179 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ThumbnailDir は添付画像の縮小版を images/ と同じ構成で保存する base_dir 配下のディレクトリです。
+	ThumbnailDir       = "thumbs"
+	thumbnailWidth     = 320
+	mediumImageWidth   = 960
+	jpegVariantQuality = 82
+	// maxVariantSourcePixels を超える画像は縮小版を作らずに元画像をそのまま使います。
+	maxVariantSourcePixels = 50_000_000
+)
+
+// imageVariantWidths は作成する縮小版の幅です。元画像より小さいものだけを作成します。
+var imageVariantWidths = []int{thumbnailWidth, mediumImageWidth}
+
+// ImageVariant は添付画像の縮小版です。Path は base_dir からの相対パスです。
+type ImageVariant struct {
+	Path   string `json:"path"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// errImageVariantSkipped は縮小版を作らない画像（アニメーションGIFや大きすぎる画像）を表します。
+var errImageVariantSkipped = errors.New("image variant skipped")
+
+// createImageVariants は添付画像の縮小版を thumbs/<チャンネル名>/ に作成し、元画像のサイズと縮小版を file に設定します。
+// JPEGはJPEG、PNGとGIFはPNGで保存します。アニメーションGIFは縮小版を作りません。
+func (c *Channels) createImageVariants(file *Attachment) error {
+	srcPath, err := c.safeJoinUnderBase(file.Path)
+	if err != nil {
+		return err
+	}
+	src, format, err := decodeVariantSource(srcPath)
+	if err != nil {
+		return err
+	}
+	bounds := src.Bounds()
+	file.Width, file.Height = bounds.Dx(), bounds.Dy()
+
+	ext := ".png"
+	if format == "jpeg" {
+		ext = ".jpg"
+	}
+	base := strings.TrimSuffix(filepath.Base(file.Path), filepath.Ext(file.Path))
+	dir := filepath.Join(ThumbnailDir, filepath.Base(filepath.Dir(file.Path)))
+	variants := make([]ImageVariant, 0, len(imageVariantWidths))
+	for _, width := range imageVariantWidths {
+		if width >= file.Width {
+			break
+		}
+		resized := resizeImage(src, width)
+		rel := filepath.Join(dir, fmt.Sprintf("%s_w%d%s", base, width, ext))
+		if err := c.writeVariant(rel, resized, format); err != nil {
+			return err
+		}
+		variants = append(variants, ImageVariant{Path: rel, Width: width, Height: resized.Bounds().Dy()})
+	}
+	file.Variants = variants
+	return nil
+}
+
+func decodeVariantSource(srcPath string) (image.Image, string, error) {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	config, format, err := image.DecodeConfig(f)
+	if errors.Is(err, image.ErrFormat) {
+		// WebPなど標準ライブラリで扱えない形式は元画像をそのまま使う
+		return nil, "", fmt.Errorf("%w: %v", errImageVariantSkipped, err)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("画像形式の判定に失敗: %w", err)
+	}
+	if config.Width*config.Height > maxVariantSourcePixels {
+		return nil, "", fmt.Errorf("%w: %dx%d", errImageVariantSkipped, config.Width, config.Height)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	if format == "gif" {
+		g, err := gif.DecodeAll(f)
+		if err != nil {
+			return nil, "", fmt.Errorf("GIFのデコードに失敗: %w", err)
+		}
+		if len(g.Image) != 1 {
+			return nil, "", fmt.Errorf("%w: animated gif", errImageVariantSkipped)
+		}
+		return g.Image[0], format, nil
+	}
+	img, format, err := image.Decode(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("画像のデコードに失敗: %w", err)
+	}
+	return img, format, nil
+}
+
+func (c *Channels) writeVariant(rel string, img image.Image, format string) error {
+	dstPath, err := c.safeJoinUnderBase(rel)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), os.ModePerm); err != nil {
+		return fmt.Errorf("縮小版ディレクトリの作成に失敗: %w", err)
+	}
+	tmpPath := dstPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("縮小版の作成に失敗: %w", err)
+	}
+	if format == "jpeg" {
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: jpegVariantQuality})
+	} else {
+		err = png.Encode(out, img)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("縮小版のエンコードに失敗: %w", err)
+	}
+	return os.Rename(tmpPath, dstPath)
+}
+
+// resizeImage は縦横比を保って幅 width に縮小します。縮小元の画素を面積平均（ボックスフィルタ）で求めます。
+func resizeImage(src image.Image, width int) *image.RGBA {
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	sw, sh := b.Dx(), b.Dy()
+	height := max(1, (sh*width+sw/2)/sw)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * sh / height
+		y1 := max((y+1)*sh/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := x * sw / width
+			x1 := max((x+1)*sw/width, x0+1)
+			var r, g, bl, a, n int
+			for sy := y0; sy < y1; sy++ {
+				i := rgba.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(rgba.Pix[i])
+					g += int(rgba.Pix[i+1])
+					bl += int(rgba.Pix[i+2])
+					a += int(rgba.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+			o := dst.PixOffset(x, y)
+			dst.Pix[o] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(bl / n)
+			dst.Pix[o+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
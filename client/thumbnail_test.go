@@ -0,0 +1,254 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/api/drive/v3"
+)
+
+func writeTestImage(t *testing.T, baseDir, rel string, width, height int, format string) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	p := filepath.Join(baseDir, rel)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(f, img, nil)
+	case "png":
+		err = png.Encode(f, img)
+	case "gif":
+		frame := image.NewPaletted(img.Bounds(), []color.Color{color.Black, color.White})
+		err = gif.EncodeAll(f, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}})
+	}
+	if err != nil {
+		t.Fatalf("encode image: %v", err)
+	}
+}
+
+func TestChannels_createImageVariants(t *testing.T) {
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+
+	tests := []struct {
+		name         string
+		rel          string
+		width        int
+		height       int
+		format       string
+		wantVariants []ImageVariant
+		wantFormat   string
+		wantSkipped  bool
+	}{
+		{
+			name:   "large jpeg",
+			rel:    filepath.Join("images", "general", "1.0_0.jpg"),
+			width:  1200,
+			height: 600,
+			format: "jpeg",
+			wantVariants: []ImageVariant{
+				{Path: filepath.Join(ThumbnailDir, "general", "1.0_0_w320.jpg"), Width: 320, Height: 160},
+				{Path: filepath.Join(ThumbnailDir, "general", "1.0_0_w960.jpg"), Width: 960, Height: 480},
+			},
+			wantFormat: "jpeg",
+		},
+		{
+			name:   "medium png",
+			rel:    filepath.Join("images", "general", "1.0_1.png"),
+			width:  500,
+			height: 250,
+			format: "png",
+			wantVariants: []ImageVariant{
+				{Path: filepath.Join(ThumbnailDir, "general", "1.0_1_w320.png"), Width: 320, Height: 160},
+			},
+			wantFormat: "png",
+		},
+		{
+			name:         "small png",
+			rel:          filepath.Join("images", "general", "1.0_2.png"),
+			width:        200,
+			height:       100,
+			format:       "png",
+			wantVariants: []ImageVariant{},
+		},
+		{
+			name:        "animated gif",
+			rel:         filepath.Join("images", "general", "1.0_3.gif"),
+			width:       800,
+			height:      400,
+			format:      "gif",
+			wantSkipped: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeTestImage(t, baseDir, tt.rel, tt.width, tt.height, tt.format)
+			file := Attachment{Path: tt.rel}
+			err := c.createImageVariants(&file)
+			if tt.wantSkipped {
+				if !errors.Is(err, errImageVariantSkipped) || file.Variants != nil {
+					t.Fatalf("createImageVariants() err = %v, variants = %v, want skipped", err, file.Variants)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("createImageVariants() error = %v", err)
+			}
+			if file.Width != tt.width || file.Height != tt.height {
+				t.Fatalf("size = %dx%d, want %dx%d", file.Width, file.Height, tt.width, tt.height)
+			}
+			if !reflect.DeepEqual(file.Variants, tt.wantVariants) {
+				t.Fatalf("Variants = %+v, want %+v", file.Variants, tt.wantVariants)
+			}
+			for _, v := range file.Variants {
+				f, err := os.Open(filepath.Join(baseDir, v.Path))
+				if err != nil {
+					t.Fatalf("open variant: %v", err)
+				}
+				config, format, err := image.DecodeConfig(f)
+				_ = f.Close()
+				if err != nil || format != tt.wantFormat || config.Width != v.Width || config.Height != v.Height {
+					t.Fatalf("variant %s = %s %dx%d (err=%v)", v.Path, format, config.Width, config.Height, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadSingleImageFile_UploadsVariants(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	channels := &Channels{basedir: baseDir}
+	source := filepath.Join(t.TempDir(), "source.png")
+	writeTestImage(t, filepath.Dir(source), filepath.Base(source), 1000, 500, "png")
+	data, err := os.ReadFile(source)
+	if err != nil {
+		t.Fatalf("read source: %v", err)
+	}
+
+	var thumbs []string
+	gdrive := &GDrive{
+		createBlobFileFn: func(ctx context.Context, name, parent, filepath string) error {
+			return nil
+		},
+		createThumbFileFn: func(ctx context.Context, name, parent, filepath string) error {
+			thumbs = append(thumbs, parent+"/"+name)
+			return nil
+		},
+	}
+	file := slack.File{ID: "F1", Name: "chart.png", Mimetype: "image/png", Filetype: "png", URLPrivateDownload: "https://example.com/1"}
+
+	got, err := downloadSingleImageFile(context.Background(), stubFileContextGetter{body: string(data)}, "general", channels, file, "1.0", 0, gdrive)
+	if err != nil {
+		t.Fatalf("downloadSingleImageFile() error = %v", err)
+	}
+	if got.Width != 1000 || got.Height != 500 || len(got.Variants) != 2 {
+		t.Fatalf("attachment = %+v", got)
+	}
+	prefix := got.Hash[:2] + "/" + got.Hash
+	if !slices.Equal(thumbs, []string{prefix + "_w320.png", prefix + "_w960.png"}) {
+		t.Fatalf("uploaded thumbnails = %v", thumbs)
+	}
+}
+
+func TestCreateHtmlFile_RendersResponsiveImages(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	jsonl := `{"timestamp":"1775088000.000000","message":"photo","channel":{"id":"C1","name":"general"},"files":[` +
+		`{"path":"images/general/1775088000.000000_0.jpg","name":"cat.jpg","mimetype":"image/jpeg","width":1200,"height":600,"variants":[` +
+		`{"path":"thumbs/general/1775088000.000000_0_w320.jpg","width":320,"height":160},` +
+		`{"path":"thumbs/general/1775088000.000000_0_w960.jpg","width":960,"height":480}]}]}` + "\n"
+	if err := os.WriteFile(filepath.Join(baseDir, "general.jsonl"), []byte(jsonl), 0644); err != nil {
+		t.Fatalf("write jsonl: %v", err)
+	}
+	g := &GDrive{
+		htmlDir: &drive.File{Id: "html-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return nil, nil
+		},
+		createFileFn: func(ctx context.Context, name, parent, filePath string) error {
+			return nil
+		},
+	}
+
+	if err := c.CreateHtmlFile(context.Background(), "general", g, nil); err != nil {
+		t.Fatalf("CreateHtmlFile() error = %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(baseDir, "html", "general.html"))
+	if err != nil {
+		t.Fatalf("read html: %v", err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		`<a href="../images/general/1775088000.000000_0.jpg" target="_blank"`,
+		`<img src="../thumbs/general/1775088000.000000_0_w960.jpg" srcset="../thumbs/general/1775088000.000000_0_w320.jpg 320w, ../thumbs/general/1775088000.000000_0_w960.jpg 960w, ../images/general/1775088000.000000_0.jpg 1200w"`,
+		`loading="lazy"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("CreateHtmlFile() output missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestChannels_deleteAttachmentFiles_RemovesVariants(t *testing.T) {
+	baseDir := t.TempDir()
+	c := &Channels{basedir: baseDir}
+	file := Attachment{
+		Path:     filepath.Join("images", "general", "1.0_0.png"),
+		Variants: []ImageVariant{{Path: filepath.Join(ThumbnailDir, "general", "1.0_0_w320.png"), Width: 320}},
+	}
+	for _, rel := range []string{file.Path, file.Variants[0].Path} {
+		writeTestImage(t, baseDir, rel, 4, 4, "png")
+	}
+	var imageDeletes, thumbDeletes []string
+	g := &GDrive{
+		deleteImageFileFn: func(ctx context.Context, name, parent string) error {
+			imageDeletes = append(imageDeletes, name)
+			return nil
+		},
+		deleteThumbFileFn: func(ctx context.Context, name, parent string) error {
+			thumbDeletes = append(thumbDeletes, name)
+			return nil
+		},
+	}
+
+	if warnings := c.deleteAttachmentFiles(context.Background(), []Attachment{file}, g); len(warnings) != 0 {
+		t.Fatalf("deleteAttachmentFiles() warnings = %v", warnings)
+	}
+	if !slices.Equal(imageDeletes, []string{"1.0_0.png"}) || !slices.Equal(thumbDeletes, []string{"1.0_0_w320.png"}) {
+		t.Fatalf("drive deletes = %v, %v", imageDeletes, thumbDeletes)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, file.Variants[0].Path)); !os.IsNotExist(err) {
+		t.Fatalf("variant should be removed: %v", err)
+	}
+}
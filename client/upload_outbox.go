@@ -0,0 +1,303 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	uploadOutboxDir         = "outbox"
+	uploadOutboxFileName    = "uploads.json"
+	uploadOutboxVersion     = 1
+	uploadRetryBaseInterval = 10 * time.Second
+	uploadRetryMaxInterval  = 30 * time.Minute
+	// uploadWorkerIdleInterval は待機中のアップロードがない場合の確認間隔です。
+	uploadWorkerIdleInterval = time.Minute
+)
+
+type uploadKind string
+
+const (
+	uploadKindFile      uploadKind = "file"
+	uploadKindImage     uploadKind = "image"
+	uploadKindThumbnail uploadKind = "thumbnail"
+	uploadKindBlob      uploadKind = "blob"
+	uploadKindHTML      uploadKind = "html"
+)
+
+// uploadItem はGoogle Driveへのアップロード待ちの1件です。
+// 同じアップロード先（Kind, Name, Parent）は1件にまとめ、アップロード時点のローカルファイルの内容を送ります。
+type uploadItem struct {
+	Kind        uploadKind `json:"kind"`
+	Name        string     `json:"name"`
+	Parent      string     `json:"parent,omitempty"`
+	Path        string     `json:"path"`
+	EnqueuedAt  time.Time  `json:"enqueued_at"`
+	Attempts    int        `json:"attempts"`
+	NextAttempt time.Time  `json:"next_attempt"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+func (i uploadItem) sameTarget(kind uploadKind, name, parent string) bool {
+	return i.Kind == kind && i.Name == name && i.Parent == parent
+}
+
+type uploadOutboxFile struct {
+	Version int          `json:"version"`
+	Items   []uploadItem `json:"items"`
+}
+
+// uploadOutbox はGoogle Driveへのアップロードを base_dir 配下に永続化するキューです。
+// 失敗したアップロードは指数バックオフで再試行し、再起動後も引き継ぎます。
+type uploadOutbox struct {
+	filePath string
+
+	mu     sync.Mutex
+	items  []uploadItem
+	notify chan struct{}
+}
+
+func newUploadOutbox(baseDir string) (*uploadOutbox, error) {
+	o := &uploadOutbox{
+		filePath: filepath.Join(baseDir, uploadOutboxDir, uploadOutboxFileName),
+		items:    make([]uploadItem, 0),
+		notify:   make(chan struct{}, 1),
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Enqueue はアップロードを登録します。同じアップロード先が待機中の場合は置き換え、すぐに再試行させます。
+func (o *uploadOutbox) Enqueue(kind uploadKind, name, parent, path string, now time.Time) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	item := uploadItem{
+		Kind:        kind,
+		Name:        name,
+		Parent:      parent,
+		Path:        path,
+		EnqueuedAt:  now.UTC(),
+		NextAttempt: now.UTC(),
+	}
+	replaced := false
+	for i := range o.items {
+		if o.items[i].sameTarget(kind, name, parent) {
+			o.items[i] = item
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		o.items = append(o.items, item)
+	}
+	if err := o.saveLocked(); err != nil {
+		return err
+	}
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Remove は待機中のアップロードを取り消します。
+func (o *uploadOutbox) Remove(kind uploadKind, name, parent string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i := range o.items {
+		if o.items[i].sameTarget(kind, name, parent) {
+			o.items = append(o.items[:i], o.items[i+1:]...)
+			if err := o.saveLocked(); err != nil {
+				log.Printf("アップロードキュー保存失敗: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// RenameChannel はチャンネル名の変更に合わせて、待機中のアップロードを新しい名前とローカルパスに付け替えます。
+// 変更前の名前のJSONLとHTMLは移行後に改めて登録されるため取り消します。
+func (o *uploadOutbox) RenameChannel(oldName, newName string, now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	changed := false
+	items := make([]uploadItem, 0, len(o.items))
+	for _, item := range o.items {
+		switch {
+		case item.Kind == uploadKindFile && item.Name == oldName+".jsonl",
+			item.Kind == uploadKindHTML && item.Name == oldName+".html":
+			changed = true
+			continue
+		case (item.Kind == uploadKindImage || item.Kind == uploadKindThumbnail) && item.Parent == oldName:
+			item.Parent = newName
+			if dir := filepath.Dir(item.Path); filepath.Base(dir) == oldName {
+				item.Path = filepath.Join(filepath.Dir(dir), newName, filepath.Base(item.Path))
+			}
+			item.NextAttempt = now.UTC()
+			changed = true
+		}
+		items = append(items, item)
+	}
+	if !changed {
+		return
+	}
+	o.items = items
+	if err := o.saveLocked(); err != nil {
+		log.Printf("アップロードキュー保存失敗: %v", err)
+	}
+}
+
+// Pending は待機中のアップロード件数を返します。
+func (o *uploadOutbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.items)
+}
+
+// processDue は再試行時刻を過ぎたアップロードを順に実行します。
+// 成功またはローカルファイルが存在しないものはキューから削除し、失敗したものは次の再試行時刻を設定します。
+// 次に再試行すべき時刻を返します（待機中がなければゼロ値）。
+func (o *uploadOutbox) processDue(ctx context.Context, now time.Time, upload func(ctx context.Context, item uploadItem) error) time.Time {
+	for _, item := range o.dueItems(now) {
+		if ctx.Err() != nil {
+			break
+		}
+		err := upload(ctx, item)
+		if errors.Is(err, os.ErrNotExist) {
+			log.Printf("ローカルファイルがないためアップロードを破棄: kind=%s name=%s path=%s", item.Kind, item.Name, item.Path)
+			err = nil
+		}
+		o.finish(item, err, now)
+	}
+	return o.nextAttempt()
+}
+
+func (o *uploadOutbox) dueItems(now time.Time) []uploadItem {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	due := make([]uploadItem, 0)
+	for _, item := range o.items {
+		if !now.Before(item.NextAttempt) {
+			due = append(due, item)
+		}
+	}
+	return due
+}
+
+func (o *uploadOutbox) finish(item uploadItem, uploadErr error, now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i := range o.items {
+		current := o.items[i]
+		if !current.sameTarget(item.Kind, item.Name, item.Parent) {
+			continue
+		}
+		// アップロード中に再登録された場合は新しい登録を残す
+		if !current.EnqueuedAt.Equal(item.EnqueuedAt) {
+			return
+		}
+		if uploadErr == nil {
+			o.items = append(o.items[:i], o.items[i+1:]...)
+		} else {
+			current.Attempts++
+			current.LastError = uploadErr.Error()
+			current.NextAttempt = now.UTC().Add(uploadRetryInterval(current.Attempts))
+			o.items[i] = current
+			log.Printf("Google Driveへのアップロード失敗(再試行予定): kind=%s name=%s attempts=%d next=%s err=%v",
+				current.Kind, current.Name, current.Attempts, current.NextAttempt.Format(time.RFC3339), uploadErr)
+		}
+		if err := o.saveLocked(); err != nil {
+			log.Printf("アップロードキュー保存失敗: %v", err)
+		}
+		return
+	}
+}
+
+func (o *uploadOutbox) nextAttempt() time.Time {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var next time.Time
+	for _, item := range o.items {
+		if next.IsZero() || item.NextAttempt.Before(next) {
+			next = item.NextAttempt
+		}
+	}
+	return next
+}
+
+// uploadRetryInterval は attempts 回失敗した後の再試行までの間隔です。
+func uploadRetryInterval(attempts int) time.Duration {
+	interval := uploadRetryBaseInterval
+	for i := 1; i < attempts; i++ {
+		interval *= 2
+		if interval >= uploadRetryMaxInterval {
+			return uploadRetryMaxInterval
+		}
+	}
+	return interval
+}
+
+// run はキューを監視し、再試行時刻になったアップロードを実行し続けます。ctx が終了すると戻ります。
+func (o *uploadOutbox) run(ctx context.Context, upload func(ctx context.Context, item uploadItem) error) {
+	for {
+		next := o.processDue(ctx, time.Now(), upload)
+		wait := uploadWorkerIdleInterval
+		if !next.IsZero() {
+			wait = min(max(time.Until(next), 0), uploadWorkerIdleInterval)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-o.notify:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (o *uploadOutbox) load() error {
+	b, err := os.ReadFile(o.filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("アップロードキュー読込失敗: %w", err)
+	}
+	var disk uploadOutboxFile
+	if err := json.Unmarshal(b, &disk); err != nil {
+		return fmt.Errorf("アップロードキューのパースに失敗: %w", err)
+	}
+	o.items = append(o.items, disk.Items...)
+	return nil
+}
+
+func (o *uploadOutbox) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(o.filePath), os.ModePerm); err != nil {
+		return fmt.Errorf("アップロードキューディレクトリ作成失敗: %w", err)
+	}
+	out, err := json.MarshalIndent(uploadOutboxFile{
+		Version: uploadOutboxVersion,
+		Items:   o.items,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("アップロードキューJSON化失敗: %w", err)
+	}
+	return writeLines(o.filePath, []string{string(out)})
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"google.golang.org/api/drive/v3"
+)
+
+func TestGDrive_UploadFileWithOutboxRetriesAndSurvivesRestart(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	localPath := filepath.Join(baseDir, "general.jsonl")
+	if err := os.WriteFile(localPath, []byte("{}\n"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	outbox, err := newUploadOutbox(baseDir)
+	if err != nil {
+		t.Fatalf("newUploadOutbox() error = %v", err)
+	}
+	uploadErr := errors.New("drive unavailable")
+	uploads := 0
+	g := &GDrive{
+		targetDir: &drive.File{Id: "target-dir-id"},
+		getTargetFileFn: func(ctx context.Context, filename, dirid string) (*drive.File, error) {
+			return &drive.File{Id: "file-id"}, nil
+		},
+		updateFileFn: func(ctx context.Context, name, id, filePath string) error {
+			uploads++
+			return uploadErr
+		},
+		outbox: outbox,
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := g.UploadFile(context.Background(), "general.jsonl", localPath); err != nil {
+			t.Fatalf("UploadFile() error = %v", err)
+		}
+	}
+	if uploads != 0 || outbox.Pending() != 1 {
+		t.Fatalf("uploads = %d, pending = %d, want 0, 1", uploads, outbox.Pending())
+	}
+
+	now := time.Now()
+	next := outbox.processDue(context.Background(), now, g.uploadNow)
+	if uploads != 1 || outbox.Pending() != 1 {
+		t.Fatalf("uploads = %d, pending = %d, want 1, 1", uploads, outbox.Pending())
+	}
+	if !next.Equal(now.UTC().Add(uploadRetryBaseInterval)) {
+		t.Fatalf("next attempt = %v, want %v", next, now.UTC().Add(uploadRetryBaseInterval))
+	}
+	// 再試行時刻前は実行しない
+	outbox.processDue(context.Background(), now.Add(time.Second), g.uploadNow)
+	if uploads != 1 {
+		t.Fatalf("uploads = %d, want 1 before retry time", uploads)
+	}
+
+	restarted, err := newUploadOutbox(baseDir)
+	if err != nil {
+		t.Fatalf("newUploadOutbox(restart) error = %v", err)
+	}
+	if restarted.Pending() != 1 || restarted.items[0].Attempts != 1 || restarted.items[0].LastError == "" {
+		t.Fatalf("restarted items = %+v", restarted.items)
+	}
+	g.outbox = restarted
+	uploadErr = nil
+	if next := restarted.processDue(context.Background(), now.Add(uploadRetryBaseInterval), g.uploadNow); !next.IsZero() {
+		t.Fatalf("next attempt = %v, want zero", next)
+	}
+	if uploads != 2 || restarted.Pending() != 0 {
+		t.Fatalf("uploads = %d, pending = %d, want 2, 0", uploads, restarted.Pending())
+	}
+}
+
+func TestUploadOutbox_DropsMissingLocalFileAndDeletedImage(t *testing.T) {
+	tracer = otel.GetTracerProvider().Tracer("client-test")
+
+	baseDir := t.TempDir()
+	outbox, err := newUploadOutbox(baseDir)
+	if err != nil {
+		t.Fatalf("newUploadOutbox() error = %v", err)
+	}
+	imageUploads := 0
+	g := &GDrive{
+		createImageFileFn: func(ctx context.Context, name, parent, filepath string) error {
+			imageUploads++
+			return nil
+		},
+		deleteImageFileFn: func(ctx context.Context, name, parent string) error {
+			return nil
+		},
+		outbox: outbox,
+	}
+	if err := g.CreateImageFile(context.Background(), "missing.png", "general", filepath.Join(baseDir, "missing.png")); err != nil {
+		t.Fatalf("CreateImageFile() error = %v", err)
+	}
+	if err := g.CreateImageFile(context.Background(), "deleted.png", "general", filepath.Join(baseDir, "deleted.png")); err != nil {
+		t.Fatalf("CreateImageFile() error = %v", err)
+	}
+	if err := g.DeleteImageFile(context.Background(), "deleted.png", "general"); err != nil {
+		t.Fatalf("DeleteImageFile() error = %v", err)
+	}
+	if outbox.Pending() != 1 {
+		t.Fatalf("pending = %d, want 1 after delete", outbox.Pending())
+	}
+
+	outbox.processDue(context.Background(), time.Now(), g.uploadNow)
+	if imageUploads != 0 || outbox.Pending() != 0 {
+		t.Fatalf("imageUploads = %d, pending = %d, want 0, 0", imageUploads, outbox.Pending())
+	}
+}
+
+func TestUploadRetryInterval(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: 10 * time.Second},
+		{attempts: 2, want: 20 * time.Second},
+		{attempts: 4, want: 80 * time.Second},
+		{attempts: 20, want: uploadRetryMaxInterval},
+	}
+	for _, tt := range tests {
+		if got := uploadRetryInterval(tt.attempts); got != tt.want {
+			t.Fatalf("uploadRetryInterval(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -15,6 +15,9 @@ func main() {
 	}
 }
 
-func run(_ []string) error {
+func run(args []string) error {
+	if len(args) > 1 && args[1] == "preview-cache" {
+		return client.RunPreviewCache(context.Background(), args[2:], os.Stdout)
+	}
 	return client.Run(context.Background())
 }